@@ -2,20 +2,132 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Environment  string
-	DatabasePath string
-	Port         string
+	Environment          string
+	DatabasePath         string
+	Port                 string
+	DocsDir              string
+	ErrorCodesPath       string
+	DeviceOfflineTimeout time.Duration
+	// 遥测原始采样保留天数，超期后汇总为分钟级数据
+	TelemetryRetentionDays int
+	// 分钟级汇总数据保留天数
+	TelemetryRollupRetentionDays int
+	// 日志上传使用的本地对象存储
+	StorageDir      string
+	StorageListen   string
+	StorageEndpoint string
+	StorageBucket   string
+	StorageRegion   string
+	// 航线文件存储目录
+	WaylineDir string
+	// 设备访问后端的地址，用于生成航线文件下载链接
+	PublicBaseURL string
+	// DRC 模式下机场连接的MQTT服务器，为空时使用默认MQTT配置
+	DRCBrokerAddress  string
+	DRCBrokerUsername string
+	DRCBrokerPassword string
+	DRCBrokerTLS      bool
+	// 设备 config 请求返回的 DJI 应用授权与 NTP 服务器
+	DJIAppID      string
+	DJIAppKey     string
+	DJIAppLicense string
+	NTPServerHost string
+	NTPServerPort int
+	// 后端MQTT消费使用的共享订阅分组，为空时不使用共享订阅；多个副本以同一分组分摊 MQTTSharedTopics 中的主题
+	MQTTSharedGroup  string
+	MQTTSharedTopics []string
+	// 内置MQTT broker，MQTT配置 protocol 为 embedded 时后端在进程内连接
+	EmbeddedBrokerEnabled bool
+	EmbeddedBrokerListen  string
+	// WebSocket代理每个浏览器的发送队列长度、队列满时的策略与可丢弃消息的主题过滤器
+	WSProxyQueueSize       int
+	WSProxyDropPolicy      string
+	WSProxyDroppableTopics []string
+	// WebSocket代理上游MQTT连接断开后的自动重连及退避间隔
+	WSProxyReconnect            bool
+	WSProxyReconnectMinInterval time.Duration
+	WSProxyReconnectMaxInterval time.Duration
+	// WebSocket代理上游使用持久会话及其消息存储目录，以及每个主题过滤器缓存的消息数
+	WSProxyPersistentSession bool
+	WSProxyStoreDir          string
+	WSProxyBufferSize        int
+	// WebSocket代理要求浏览器以代理账号认证，并按账号角色的规则检查订阅与发布
+	WSProxyAuth bool
+	// 代理账号、访问规则管理接口的令牌，启用 WSProxyAuth 时未配置则这些接口不可用
+	WSProxyAdminToken string
+	// MQTT抓包文件存储目录
+	CaptureDir string
 }
 
 func Load() *Config {
-	return &Config{
-		Environment:  getEnv("ENV", "development"),
-		DatabasePath: getEnv("DATABASE_PATH", "./data/backend.db"),
-		Port:         getEnv("PORT", "18080"),
+	cfg := &Config{
+		Environment:          getEnv("ENV", "development"),
+		DatabasePath:         getEnv("DATABASE_PATH", "./data/backend.db"),
+		Port:                 getEnv("PORT", "18080"),
+		DocsDir:              getEnv("DOCS_DIR", "../public/docs"),
+		ErrorCodesPath:       getEnv("ERROR_CODES_PATH", ""),
+		DeviceOfflineTimeout: getEnvSeconds("DEVICE_OFFLINE_TIMEOUT", 60),
+
+		TelemetryRetentionDays:       getEnvInt("TELEMETRY_RETENTION_DAYS", 7),
+		TelemetryRollupRetentionDays: getEnvInt("TELEMETRY_ROLLUP_RETENTION_DAYS", 90),
+
+		StorageDir:      getEnv("STORAGE_DIR", "./data/storage"),
+		StorageListen:   getEnv("STORAGE_LISTEN", ":18090"),
+		StorageEndpoint: getEnv("STORAGE_ENDPOINT", "http://127.0.0.1:18090"),
+		StorageBucket:   getEnv("STORAGE_BUCKET", "drone-logs"),
+		StorageRegion:   getEnv("STORAGE_REGION", "us-east-1"),
+
+		WaylineDir:    getEnv("WAYLINE_DIR", "./data/waylines"),
+		PublicBaseURL: getEnv("PUBLIC_BASE_URL", "http://127.0.0.1:18080"),
+
+		DRCBrokerAddress:  getEnv("DRC_BROKER_ADDRESS", ""),
+		DRCBrokerUsername: getEnv("DRC_BROKER_USERNAME", ""),
+		DRCBrokerPassword: getEnv("DRC_BROKER_PASSWORD", ""),
+		DRCBrokerTLS:      getEnv("DRC_BROKER_TLS", "false") == "true",
+
+		DJIAppID:      getEnv("DJI_APP_ID", ""),
+		DJIAppKey:     getEnv("DJI_APP_KEY", ""),
+		DJIAppLicense: getEnv("DJI_APP_LICENSE", ""),
+		NTPServerHost: getEnv("NTP_SERVER_HOST", "ntp.aliyun.com"),
+		NTPServerPort: getEnvInt("NTP_SERVER_PORT", 123),
+
+		MQTTSharedGroup:  getEnv("MQTT_SHARED_GROUP", ""),
+		MQTTSharedTopics: getEnvList("MQTT_SHARED_TOPICS", "thing/product/{sn}/osd"),
+
+		EmbeddedBrokerEnabled: getEnv("EMBEDDED_BROKER_ENABLED", "false") == "true",
+		EmbeddedBrokerListen:  getEnv("EMBEDDED_BROKER_LISTEN", ":1883"),
+
+		WSProxyQueueSize:       getEnvInt("WS_PROXY_QUEUE_SIZE", 256),
+		WSProxyDropPolicy:      getEnv("WS_PROXY_DROP_POLICY", "drop_oldest"),
+		WSProxyDroppableTopics: getEnvList("WS_PROXY_DROPPABLE_TOPICS", "thing/product/+/osd"),
+
+		WSProxyReconnect:            getEnv("WS_PROXY_RECONNECT", "true") == "true",
+		WSProxyReconnectMinInterval: getEnvSeconds("WS_PROXY_RECONNECT_MIN_INTERVAL", 1),
+		WSProxyReconnectMaxInterval: getEnvSeconds("WS_PROXY_RECONNECT_MAX_INTERVAL", 60),
+
+		WSProxyPersistentSession: getEnv("WS_PROXY_PERSISTENT_SESSION", "false") == "true",
+		WSProxyStoreDir:          getEnv("WS_PROXY_STORE_DIR", "./data/mqtt-store"),
+		WSProxyBufferSize:        getEnvInt("WS_PROXY_BUFFER_SIZE", 200),
+
+		WSProxyAuth:       getEnv("WS_PROXY_AUTH", "false") == "true",
+		WSProxyAdminToken: getEnv("WS_PROXY_ADMIN_TOKEN", ""),
+
+		CaptureDir: getEnv("CAPTURE_DIR", "./data/captures"),
+	}
+
+	// 错误码文件默认位于文档目录
+	if cfg.ErrorCodesPath == "" {
+		cfg.ErrorCodesPath = filepath.Join(cfg.DocsDir, "dji-error-codes.md")
 	}
+
+	return cfg
 }
 
 func getEnv(key, defaultValue string) string {
@@ -24,3 +136,34 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvInt 读取正整数配置
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// getEnvSeconds 读取以秒为单位的时长配置
+func getEnvSeconds(key string, defaultSeconds int) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return time.Duration(defaultSeconds) * time.Second
+}
+
+// getEnvList 读取逗号分隔的配置
+func getEnvList(key, defaultValue string) []string {
+	items := []string{}
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -21,8 +21,8 @@ func InitDB(dbPath string) (*DB, error) {
 		return nil, err
 	}
 
-	// 打开数据库连接
-	db, err := sql.Open("sqlite", dbPath)
+	// 打开数据库连接，设置忙等待避免后台任务并发写入时直接返回 SQLITE_BUSY
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, err
 	}
@@ -75,6 +75,400 @@ func createTables(db *sql.DB) error {
 	CREATE INDEX IF NOT EXISTS idx_devices_airport_sn ON devices(airport_sn);
 	`
 
+	// 创建设备遥测快照表
+	createDeviceSnapshotsTable := `
+	CREATE TABLE IF NOT EXISTS device_snapshots (
+		sn TEXT PRIMARY KEY,
+		gateway TEXT DEFAULT '',
+		kind TEXT NOT NULL DEFAULT 'unknown',
+		osd TEXT,
+		state TEXT,
+		osd_updated_at INTEGER,
+		state_updated_at INTEGER,
+		updated_at INTEGER NOT NULL
+	);
+	`
+
+	// 创建遥测历史表（原始采样与分钟级汇总）
+	createTelemetryTables := `
+	CREATE TABLE IF NOT EXISTS telemetry_samples (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		sn TEXT NOT NULL,
+		ts INTEGER NOT NULL,
+		mode_code INTEGER,
+		latitude REAL,
+		longitude REAL,
+		height REAL,
+		elevation REAL,
+		horizontal_speed REAL,
+		vertical_speed REAL,
+		battery_percent REAL,
+		attitude_pitch REAL,
+		attitude_roll REAL,
+		attitude_head REAL
+	);
+	CREATE INDEX IF NOT EXISTS idx_telemetry_samples_sn_ts ON telemetry_samples(sn, ts);
+	CREATE INDEX IF NOT EXISTS idx_telemetry_samples_ts ON telemetry_samples(ts);
+
+	CREATE TABLE IF NOT EXISTS telemetry_rollups (
+		sn TEXT NOT NULL,
+		ts INTEGER NOT NULL,
+		samples INTEGER NOT NULL,
+		mode_code INTEGER,
+		latitude REAL,
+		longitude REAL,
+		height REAL,
+		elevation REAL,
+		horizontal_speed REAL,
+		vertical_speed REAL,
+		battery_percent REAL,
+		attitude_pitch REAL,
+		attitude_roll REAL,
+		attitude_head REAL,
+		PRIMARY KEY (sn, ts)
+	);
+	`
+
+	// 创建飞行记录表
+	createFlightsTable := `
+	CREATE TABLE IF NOT EXISTS flights (
+		id TEXT PRIMARY KEY,
+		aircraft_sn TEXT NOT NULL,
+		dock_sn TEXT DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'in_progress',
+		end_reason TEXT DEFAULT '',
+		start_time INTEGER NOT NULL,
+		end_time INTEGER,
+		duration INTEGER DEFAULT 0,
+		distance REAL DEFAULT 0,
+		max_altitude REAL DEFAULT 0,
+		start_battery INTEGER DEFAULT 0,
+		end_battery INTEGER DEFAULT 0,
+		battery_used INTEGER DEFAULT 0,
+		home_latitude REAL,
+		home_longitude REAL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_flights_aircraft_sn ON flights(aircraft_sn);
+	CREATE INDEX IF NOT EXISTS idx_flights_dock_sn ON flights(dock_sn);
+	CREATE INDEX IF NOT EXISTS idx_flights_start_time ON flights(start_time);
+	CREATE INDEX IF NOT EXISTS idx_flights_status ON flights(status);
+	`
+
+	// 创建HMS健康告警表
+	createHmsAlarmsTable := `
+	CREATE TABLE IF NOT EXISTS hms_alarms (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		sn TEXT NOT NULL,
+		alarm_key TEXT NOT NULL,
+		code TEXT NOT NULL,
+		device_type TEXT DEFAULT '',
+		level INTEGER DEFAULT 0,
+		module INTEGER DEFAULT 0,
+		in_the_sky INTEGER DEFAULT 0,
+		component_index INTEGER DEFAULT 0,
+		sensor_index INTEGER DEFAULT 0,
+		message_zh TEXT DEFAULT '',
+		message_en TEXT DEFAULT '',
+		active INTEGER NOT NULL DEFAULT 1,
+		first_seen INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		cleared_at INTEGER,
+		acknowledged INTEGER NOT NULL DEFAULT 0,
+		acknowledged_by TEXT DEFAULT '',
+		acknowledged_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_hms_alarms_sn ON hms_alarms(sn);
+	CREATE INDEX IF NOT EXISTS idx_hms_alarms_active ON hms_alarms(active);
+	CREATE INDEX IF NOT EXISTS idx_hms_alarms_last_seen ON hms_alarms(last_seen);
+	`
+
+	// 创建设备服务调用任务表
+	createServiceJobsTable := `
+	CREATE TABLE IF NOT EXISTS service_jobs (
+		id TEXT PRIMARY KEY,
+		sn TEXT NOT NULL,
+		method TEXT NOT NULL,
+		tid TEXT NOT NULL,
+		bid TEXT NOT NULL,
+		status TEXT NOT NULL,
+		result INTEGER,
+		error_message TEXT DEFAULT '',
+		progress INTEGER DEFAULT 0,
+		request TEXT,
+		reply TEXT,
+		output TEXT,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_service_jobs_sn ON service_jobs(sn);
+	CREATE INDEX IF NOT EXISTS idx_service_jobs_bid ON service_jobs(bid);
+	CREATE INDEX IF NOT EXISTS idx_service_jobs_created_at ON service_jobs(created_at);
+	`
+
+	// 创建固件包与升级任务表
+	createUpgradeTables := `
+	CREATE TABLE IF NOT EXISTS firmware_packages (
+		id TEXT PRIMARY KEY,
+		file_name TEXT NOT NULL,
+		product_version TEXT NOT NULL,
+		device_type TEXT NOT NULL,
+		device_model TEXT DEFAULT '',
+		file_url TEXT NOT NULL,
+		file_size INTEGER DEFAULT 0,
+		md5 TEXT DEFAULT '',
+		firmware_upgrade_type INTEGER DEFAULT 3,
+		release_note TEXT DEFAULT '',
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS upgrade_jobs (
+		id TEXT PRIMARY KEY,
+		device_sn TEXT NOT NULL,
+		gateway_sn TEXT NOT NULL,
+		package_id TEXT NOT NULL,
+		product_version TEXT NOT NULL,
+		firmware_upgrade_type INTEGER DEFAULT 3,
+		status TEXT NOT NULL,
+		tid TEXT DEFAULT '',
+		bid TEXT DEFAULT '',
+		progress INTEGER DEFAULT 0,
+		current_step TEXT DEFAULT '',
+		result INTEGER,
+		error_message TEXT DEFAULT '',
+		operator TEXT DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		finished_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_upgrade_jobs_device_sn ON upgrade_jobs(device_sn);
+	CREATE INDEX IF NOT EXISTS idx_upgrade_jobs_status ON upgrade_jobs(status);
+	CREATE INDEX IF NOT EXISTS idx_upgrade_jobs_bid ON upgrade_jobs(bid);
+	`
+
+	// 创建日志上传记录与日志归档表
+	createLogTables := `
+	CREATE TABLE IF NOT EXISTS log_uploads (
+		id TEXT PRIMARY KEY,
+		gateway_sn TEXT NOT NULL,
+		tid TEXT DEFAULT '',
+		bid TEXT DEFAULT '',
+		status TEXT NOT NULL,
+		result INTEGER,
+		error_message TEXT DEFAULT '',
+		operator TEXT DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		finished_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_log_uploads_gateway_sn ON log_uploads(gateway_sn);
+	CREATE INDEX IF NOT EXISTS idx_log_uploads_bid ON log_uploads(bid);
+	CREATE TABLE IF NOT EXISTS log_files (
+		id TEXT PRIMARY KEY,
+		upload_id TEXT NOT NULL,
+		device_sn TEXT DEFAULT '',
+		module TEXT NOT NULL,
+		boot_indexes TEXT DEFAULT '[]',
+		object_key TEXT NOT NULL UNIQUE,
+		size INTEGER DEFAULT 0,
+		fingerprint TEXT DEFAULT '',
+		progress INTEGER DEFAULT 0,
+		status TEXT NOT NULL,
+		stored_at INTEGER,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_log_files_upload_id ON log_files(upload_id);
+	`
+
+	// 创建航线与航线任务表
+	createWaylineTables := `
+	CREATE TABLE IF NOT EXISTS waylines (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		file_name TEXT NOT NULL,
+		file_size INTEGER NOT NULL,
+		md5 TEXT NOT NULL,
+		template_type TEXT DEFAULT '',
+		drone_model_key TEXT DEFAULT '',
+		payload_model_keys TEXT DEFAULT '[]',
+		waypoint_count INTEGER DEFAULT 0,
+		distance REAL DEFAULT 0,
+		duration REAL DEFAULT 0,
+		min_height REAL DEFAULT 0,
+		max_height REAL DEFAULT 0,
+		height_mode TEXT DEFAULT '',
+		finish_action TEXT DEFAULT '',
+		waypoints TEXT DEFAULT '[]',
+		operator TEXT DEFAULT '',
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS flight_tasks (
+		id TEXT PRIMARY KEY,
+		wayline_id TEXT NOT NULL,
+		dock_sn TEXT NOT NULL,
+		task_type INTEGER DEFAULT 0,
+		execute_time INTEGER NOT NULL,
+		rth_altitude INTEGER DEFAULT 100,
+		out_of_control_action INTEGER DEFAULT 0,
+		exit_wayline_when_rc_lost INTEGER DEFAULT 0,
+		status TEXT NOT NULL,
+		tid TEXT DEFAULT '',
+		bid TEXT DEFAULT '',
+		progress INTEGER DEFAULT 0,
+		current_waypoint_index INTEGER DEFAULT 0,
+		media_count INTEGER DEFAULT 0,
+		result INTEGER,
+		error_message TEXT DEFAULT '',
+		operator TEXT DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		finished_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_flight_tasks_dock_sn ON flight_tasks(dock_sn);
+	CREATE INDEX IF NOT EXISTS idx_flight_tasks_status ON flight_tasks(status);
+	`
+
+	// 创建巡检计划与执行记录表
+	createPatrolTables := `
+	CREATE TABLE IF NOT EXISTS patrol_plans (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		wayline_id TEXT NOT NULL,
+		dock_sn TEXT NOT NULL,
+		schedule_type TEXT NOT NULL,
+		cron_expr TEXT DEFAULT '',
+		interval_minutes INTEGER DEFAULT 0,
+		window_start TEXT DEFAULT '',
+		window_end TEXT DEFAULT '',
+		max_wind_speed REAL,
+		min_battery INTEGER,
+		allow_rain INTEGER DEFAULT 0,
+		min_temperature REAL,
+		max_temperature REAL,
+		rth_altitude INTEGER DEFAULT 100,
+		enabled INTEGER DEFAULT 1,
+		next_run_at INTEGER,
+		last_run_at INTEGER,
+		operator TEXT DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_patrol_plans_dock_sn ON patrol_plans(dock_sn);
+	CREATE TABLE IF NOT EXISTS patrol_runs (
+		id TEXT PRIMARY KEY,
+		plan_id TEXT NOT NULL,
+		dock_sn TEXT NOT NULL,
+		wayline_id TEXT NOT NULL,
+		trigger_type TEXT NOT NULL,
+		scheduled_at INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		flight_task_id TEXT DEFAULT '',
+		reason TEXT DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		finished_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_patrol_runs_plan_id ON patrol_runs(plan_id);
+	CREATE INDEX IF NOT EXISTS idx_patrol_runs_status ON patrol_runs(status);
+	`
+
+	// 创建远程调试指令记录表，下发后的结果与进度从 service_jobs 关联读取
+	createRemoteDebugTable := `
+	CREATE TABLE IF NOT EXISTS remote_debug_commands (
+		id TEXT PRIMARY KEY,
+		dock_sn TEXT NOT NULL,
+		method TEXT NOT NULL,
+		params TEXT,
+		operator TEXT NOT NULL,
+		status TEXT NOT NULL,
+		error_message TEXT DEFAULT '',
+		job_id TEXT DEFAULT '',
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_remote_debug_commands_dock_sn ON remote_debug_commands(dock_sn, created_at);
+	`
+
+	// 设备属性设置记录，desired 为期望值，reported 为核对时的上报值
+	createPropertySettingsTable := `
+	CREATE TABLE IF NOT EXISTS property_settings (
+		id TEXT PRIMARY KEY,
+		sn TEXT NOT NULL,
+		property TEXT NOT NULL,
+		desired TEXT NOT NULL,
+		reported TEXT,
+		status TEXT NOT NULL,
+		result INTEGER,
+		error_message TEXT DEFAULT '',
+		operator TEXT DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		verified_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_property_settings_sn ON property_settings(sn, property, status);
+	`
+
+	// 内置MQTT broker账号，password_hash 为 bcrypt 摘要
+	createBrokerUsersTable := `
+	CREATE TABLE IF NOT EXISTS broker_users (
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL,
+		sn TEXT DEFAULT '',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	`
+
+	// WebSocket代理账号与按角色的主题访问规则，devices 为分配的设备SN（JSON数组）
+	createProxyACLTables := `
+	CREATE TABLE IF NOT EXISTS proxy_users (
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL,
+		devices TEXT DEFAULT '[]',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS proxy_acl_rules (
+		id TEXT PRIMARY KEY,
+		role TEXT NOT NULL,
+		topic TEXT NOT NULL,
+		subscribe TEXT DEFAULT '',
+		publish TEXT DEFAULT '',
+		priority INTEGER DEFAULT 0,
+		description TEXT DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_proxy_acl_rules_role ON proxy_acl_rules(role, priority);
+	`
+
+	// MQTT抓包记录，文件保存在抓包目录，topics 为录制的主题过滤器（JSON数组）
+	createCapturesTable := `
+	CREATE TABLE IF NOT EXISTS captures (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		format TEXT NOT NULL,
+		source TEXT NOT NULL,
+		topics TEXT DEFAULT '[]',
+		include_outgoing INTEGER DEFAULT 0,
+		status TEXT NOT NULL,
+		messages INTEGER DEFAULT 0,
+		dropped INTEGER DEFAULT 0,
+		file_size INTEGER DEFAULT 0,
+		first_at INTEGER,
+		last_at INTEGER,
+		error_message TEXT DEFAULT '',
+		operator TEXT DEFAULT '',
+		created_at INTEGER NOT NULL,
+		finished_at INTEGER
+	);
+	`
+
 	// 执行创建表语句
 	if _, err := db.Exec(createMQTTProfilesTable); err != nil {
 		return err
@@ -84,6 +478,62 @@ func createTables(db *sql.DB) error {
 		return err
 	}
 
+	if _, err := db.Exec(createDeviceSnapshotsTable); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createTelemetryTables); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createFlightsTable); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createHmsAlarmsTable); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createServiceJobsTable); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createUpgradeTables); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createLogTables); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createWaylineTables); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createPatrolTables); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createRemoteDebugTable); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createPropertySettingsTable); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createBrokerUsersTable); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createProxyACLTables); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(createCapturesTable); err != nil {
+		return err
+	}
+
 	// 检查并添加 airport_sn 字段到现有表
 	if err := addAirportSnColumnIfNotExists(db); err != nil {
 		log.Printf("Airport SN column migration failed: %v", err)
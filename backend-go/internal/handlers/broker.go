@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取内置MQTT broker状态
+func (h *Handlers) GetEmbeddedBrokerStatus(c *gin.Context) {
+	response, err := h.embeddedBroker.GetStatus()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取内置broker账号列表
+func (h *Handlers) GetBrokerUsers(c *gin.Context) {
+	response, err := h.embeddedBroker.GetUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 创建内置broker账号
+func (h *Handlers) CreateBrokerUser(c *gin.Context) {
+	var payload models.BrokerUserPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.embeddedBroker.CreateUser(&payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 更新内置broker账号
+func (h *Handlers) UpdateBrokerUser(c *gin.Context) {
+	var payload models.BrokerUserPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.embeddedBroker.UpdateUser(c.Param("user_id"), &payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 删除内置broker账号
+func (h *Handlers) DeleteBrokerUser(c *gin.Context) {
+	response, err := h.embeddedBroker.DeleteUser(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
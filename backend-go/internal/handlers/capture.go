@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+
+	"drone-patrol-backend/internal/models"
+	"drone-patrol-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取抓包列表
+func (h *Handlers) GetCaptures(c *gin.Context) {
+	response, err := h.captureService.GetCaptures()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 开始录制抓包
+func (h *Handlers) StartCapture(c *gin.Context) {
+	var payload models.CaptureRecordPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.captureService.StartRecording(&payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 结束录制抓包
+func (h *Handlers) StopCapture(c *gin.Context) {
+	response, err := h.captureService.StopRecording(c.Param("capture_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 导入抓包文件
+func (h *Handlers) ImportCapture(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+	if fileHeader.Size > services.CaptureMaxFileSize {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "抓包文件过大",
+		})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "读取抓包文件失败: " + err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	response, err := h.captureService.ImportCapture(c.PostForm("name"), fileHeader.Filename, c.PostForm("operator"), file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取抓包详情
+func (h *Handlers) GetCapture(c *gin.Context) {
+	response, err := h.captureService.GetCapture(c.Param("capture_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 下载抓包文件
+func (h *Handlers) DownloadCapture(c *gin.Context) {
+	path, name, response, err := h.captureService.GetCaptureFile(c.Param("capture_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	if response != nil {
+		c.JSON(http.StatusNotFound, response)
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Code:    1,
+			Message: "抓包文件不存在",
+		})
+		return
+	}
+	c.FileAttachment(path, name)
+}
+
+// 删除抓包
+func (h *Handlers) DeleteCapture(c *gin.Context) {
+	response, err := h.captureService.DeleteCapture(c.Param("capture_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 开始回放抓包
+func (h *Handlers) StartCaptureReplay(c *gin.Context) {
+	var payload models.CaptureReplayPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.captureService.StartReplay(c.Param("capture_id"), &payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取回放列表
+func (h *Handlers) GetCaptureReplays(c *gin.Context) {
+	response, err := h.captureService.GetReplays()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取回放状态
+func (h *Handlers) GetCaptureReplay(c *gin.Context) {
+	response, err := h.captureService.GetReplay(c.Param("replay_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 单步回放
+func (h *Handlers) StepCaptureReplay(c *gin.Context) {
+	var payload models.CaptureReplayStepPayload
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Code:    1,
+				Message: "参数错误: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	response, err := h.captureService.StepReplay(c.Param("replay_id"), payload.Count)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 停止回放
+func (h *Handlers) StopCaptureReplay(c *gin.Context) {
+	response, err := h.captureService.StopReplay(c.Param("replay_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
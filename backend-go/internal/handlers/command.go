@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serviceMethodPattern DJI服务方法名
+var serviceMethodPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// 调用设备服务
+func (h *Handlers) CallDeviceService(c *gin.Context) {
+	var query models.ServiceCallQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	method := c.Param("method")
+	if !serviceMethodPattern.MatchString(method) {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: 无效的服务方法 " + method,
+		})
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	// 请求体即服务的 data 字段，需为JSON对象
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: 请求体必须为JSON对象",
+		})
+		return
+	}
+
+	response, err := h.commandService.CallService(deviceSNParam(c), method, body, &query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取服务任务列表
+func (h *Handlers) GetServiceJobs(c *gin.Context) {
+	var query models.ServiceJobQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.commandService.GetJobs(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取服务任务详情
+func (h *Handlers) GetServiceJob(c *gin.Context) {
+	response, err := h.commandService.GetJob(c.Param("job_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DrcWebSocketHandler 建立DRC指令飞行会话，连接断开后自动退出DRC模式
+func (h *Handlers) DrcWebSocketHandler(c *gin.Context) {
+	var query models.DrcEnterQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("DRC WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	h.drcService.Serve(conn, c.Param("sn"), &query)
+}
+
+// 获取DRC会话列表
+func (h *Handlers) GetDrcSessions(c *gin.Context) {
+	response, err := h.drcService.GetSessions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 强制释放DRC会话
+func (h *Handlers) ReleaseDrcSession(c *gin.Context) {
+	response, err := h.drcService.ReleaseSession(c.Param("sn"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
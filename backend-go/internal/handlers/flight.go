@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取飞行记录列表
+func (h *Handlers) GetFlights(c *gin.Context) {
+	var query models.FlightQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	from, err := parseTimeParam(query.From)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: from " + err.Error(),
+		})
+		return
+	}
+	to, err := parseTimeParam(query.To)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: to " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.flightService.GetFlights(&query, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取飞行记录详情
+func (h *Handlers) GetFlight(c *gin.Context) {
+	response, err := h.flightService.GetFlight(c.Param("flight_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
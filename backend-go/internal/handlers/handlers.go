@@ -5,12 +5,27 @@ import (
 )
 
 type Handlers struct {
-	deviceService    *services.DeviceService
-	mqttService      *services.MQTTService
-	redisService     *services.RedisService
-	errorCodeService *services.ErrorCodeService
-	MQTTProxy        *services.MQTTProxyService
-	cameraService    *services.CameraService
+	deviceService      *services.DeviceService
+	mqttService        *services.MQTTService
+	redisService       *services.RedisService
+	errorCodeService   *services.ErrorCodeService
+	MQTTProxy          *services.MQTTProxyService
+	cameraService      *services.CameraService
+	ingestService      *services.IngestService
+	telemetryService   *services.TelemetryService
+	flightService      *services.FlightService
+	hmsService         *services.HmsService
+	commandService     *services.CommandService
+	upgradeService     *services.UpgradeService
+	logService         *services.LogService
+	waylineService     *services.WaylineService
+	patrolService      *services.PatrolService
+	drcService         *services.DrcService
+	remoteDebugService *services.RemoteDebugService
+	propertyService    *services.PropertyService
+	embeddedBroker     *services.EmbeddedBroker
+	proxyACLService    *services.ProxyACLService
+	captureService     *services.CaptureService
 }
 
 func NewHandlers(
@@ -20,13 +35,43 @@ func NewHandlers(
 	errorCodeService *services.ErrorCodeService,
 	mqttProxy *services.MQTTProxyService,
 	cameraService *services.CameraService,
+	ingestService *services.IngestService,
+	telemetryService *services.TelemetryService,
+	flightService *services.FlightService,
+	hmsService *services.HmsService,
+	commandService *services.CommandService,
+	upgradeService *services.UpgradeService,
+	logService *services.LogService,
+	waylineService *services.WaylineService,
+	patrolService *services.PatrolService,
+	drcService *services.DrcService,
+	remoteDebugService *services.RemoteDebugService,
+	propertyService *services.PropertyService,
+	embeddedBroker *services.EmbeddedBroker,
+	proxyACLService *services.ProxyACLService,
+	captureService *services.CaptureService,
 ) *Handlers {
 	return &Handlers{
-		deviceService:    deviceService,
-		mqttService:      mqttService,
-		redisService:     redisService,
-		errorCodeService: errorCodeService,
-		MQTTProxy:        mqttProxy,
-		cameraService:    cameraService,
+		deviceService:      deviceService,
+		mqttService:        mqttService,
+		redisService:       redisService,
+		errorCodeService:   errorCodeService,
+		MQTTProxy:          mqttProxy,
+		cameraService:      cameraService,
+		ingestService:      ingestService,
+		telemetryService:   telemetryService,
+		flightService:      flightService,
+		hmsService:         hmsService,
+		commandService:     commandService,
+		upgradeService:     upgradeService,
+		logService:         logService,
+		waylineService:     waylineService,
+		patrolService:      patrolService,
+		drcService:         drcService,
+		remoteDebugService: remoteDebugService,
+		propertyService:    propertyService,
+		embeddedBroker:     embeddedBroker,
+		proxyACLService:    proxyACLService,
+		captureService:     captureService,
 	}
 }
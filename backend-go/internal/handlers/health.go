@@ -21,7 +21,26 @@ func (h *Handlers) Health(c *gin.Context) {
 
 // 获取错误码列表
 func (h *Handlers) GetErrorCodes(c *gin.Context) {
-	response, err := h.errorCodeService.GetErrorCodes()
+	var query models.ErrorCodeQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.errorCodeService.GetErrorCodes(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 按错误码获取详情
+func (h *Handlers) GetErrorCode(c *gin.Context) {
+	response, err := h.errorCodeService.GetErrorCode(c.Param("code"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, response)
 		return
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取HMS告警列表
+func (h *Handlers) GetHmsAlarms(c *gin.Context) {
+	var query models.HmsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.hmsService.GetAlarms(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 确认HMS告警
+func (h *Handlers) AcknowledgeHmsAlarm(c *gin.Context) {
+	alarmID, err := strconv.ParseInt(c.Param("alarm_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: 无效的告警ID",
+		})
+		return
+	}
+
+	var payload models.HmsAckPayload
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Code:    1,
+				Message: "参数错误: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	response, err := h.hmsService.AcknowledgeAlarm(alarmID, payload.User)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
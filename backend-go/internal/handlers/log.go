@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取设备日志文件列表
+func (h *Handlers) GetDeviceLogFiles(c *gin.Context) {
+	var query models.LogFileListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.logService.ListLogFiles(deviceSNParam(c), &query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 发起设备日志上传
+func (h *Handlers) CreateDeviceLogUpload(c *gin.Context) {
+	var payload models.LogUploadPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.logService.StartUpload(deviceSNParam(c), &payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取日志上传列表
+func (h *Handlers) GetLogUploads(c *gin.Context) {
+	var query models.LogUploadQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.logService.GetUploads(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取日志上传详情
+func (h *Handlers) GetLogUpload(c *gin.Context) {
+	response, err := h.logService.GetUpload(c.Param("upload_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 下载日志归档
+func (h *Handlers) DownloadLogFile(c *gin.Context) {
+	path, name, response, err := h.logService.GetFileDownload(c.Param("file_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	if response != nil {
+		c.JSON(http.StatusNotFound, response)
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Code:    1,
+			Message: "日志文件不存在",
+		})
+		return
+	}
+	c.FileAttachment(path, name)
+}
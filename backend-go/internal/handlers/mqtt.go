@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"drone-patrol-backend/internal/models"
+	"drone-patrol-backend/internal/services"
+	"io"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -107,3 +109,67 @@ func (h *Handlers) TestMQTTConnection(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, response)
 }
+
+// 上传MQTT配置的CA证书、客户端证书与私钥
+func (h *Handlers) UploadMQTTCertificates(c *gin.Context) {
+	certificates := map[string]string{}
+	for field, key := range services.MQTTCertificateFields {
+		fileHeader, err := c.FormFile(field)
+		if err != nil {
+			continue
+		}
+		if fileHeader.Size > services.MQTTCertificateMaxSize {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Code:    1,
+				Message: "证书文件过大: " + field,
+			})
+			return
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Code:    1,
+				Message: "读取证书文件失败: " + err.Error(),
+			})
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(file, services.MQTTCertificateMaxSize))
+		file.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Code:    1,
+				Message: "读取证书文件失败: " + err.Error(),
+			})
+			return
+		}
+		certificates[key] = string(data)
+	}
+
+	response, err := h.mqttService.UploadCertificates(c.Param("pid"), certificates)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取WebSocket代理共享的上游MQTT连接
+func (h *Handlers) GetMQTTProxyUpstreams(c *gin.Context) {
+	response, err := h.MQTTProxy.GetUpstreams()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取WebSocket代理发送队列与丢弃统计
+func (h *Handlers) GetMQTTProxyStats(c *gin.Context) {
+	response, err := h.MQTTProxy.GetStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取巡检计划列表
+func (h *Handlers) GetPatrolPlans(c *gin.Context) {
+	var query models.PatrolPlanQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.patrolService.GetPlans(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 创建巡检计划
+func (h *Handlers) CreatePatrolPlan(c *gin.Context) {
+	var payload models.PatrolPlanPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.patrolService.CreatePlan(&payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取巡检计划详情
+func (h *Handlers) GetPatrolPlan(c *gin.Context) {
+	response, err := h.patrolService.GetPlan(c.Param("plan_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 更新巡检计划
+func (h *Handlers) UpdatePatrolPlan(c *gin.Context) {
+	var payload models.PatrolPlanPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.patrolService.UpdatePlan(c.Param("plan_id"), &payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 删除巡检计划
+func (h *Handlers) DeletePatrolPlan(c *gin.Context) {
+	response, err := h.patrolService.DeletePlan(c.Param("plan_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 立即执行巡检计划
+func (h *Handlers) TriggerPatrolPlan(c *gin.Context) {
+	response, err := h.patrolService.TriggerPlan(c.Param("plan_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取巡检执行记录
+func (h *Handlers) GetPatrolRuns(c *gin.Context) {
+	var query models.PatrolRunQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.patrolService.GetRuns(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 设置设备属性
+func (h *Handlers) SetDeviceProperties(c *gin.Context) {
+	var payload models.PropertySetPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.propertyService.SetProperties(deviceSNParam(c), &payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取设备期望属性及核对状态
+func (h *Handlers) GetDeviceProperties(c *gin.Context) {
+	response, err := h.propertyService.GetDeviceProperties(deviceSNParam(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取属性设置记录
+func (h *Handlers) GetPropertySettings(c *gin.Context) {
+	var query models.PropertySettingQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.propertyService.GetSettings(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
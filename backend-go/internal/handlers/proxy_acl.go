@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireProxyAdmin 管理代理账号、访问规则与内置broker账号，以及抓包回放、强制释放DRC会话等接口要求 Authorization: Bearer <WS_PROXY_ADMIN_TOKEN>
+func (h *Handlers) requireProxyAdmin(c *gin.Context) {
+	if !h.proxyACLService.AdminRequired() {
+		c.Next()
+		return
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !h.proxyACLService.CheckAdminToken(token) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, models.APIResponse{
+			Code:    1,
+			Message: "需要管理令牌",
+		})
+		return
+	}
+	c.Next()
+}
+
+// 获取WebSocket代理账号列表
+func (h *Handlers) GetProxyUsers(c *gin.Context) {
+	response, err := h.proxyACLService.GetUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 创建WebSocket代理账号
+func (h *Handlers) CreateProxyUser(c *gin.Context) {
+	var payload models.ProxyUserPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.proxyACLService.CreateUser(&payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 更新WebSocket代理账号
+func (h *Handlers) UpdateProxyUser(c *gin.Context) {
+	var payload models.ProxyUserPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.proxyACLService.UpdateUser(c.Param("user_id"), &payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 删除WebSocket代理账号
+func (h *Handlers) DeleteProxyUser(c *gin.Context) {
+	response, err := h.proxyACLService.DeleteUser(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取WebSocket代理访问规则
+func (h *Handlers) GetProxyACLRules(c *gin.Context) {
+	response, err := h.proxyACLService.GetRules(c.Query("role"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 创建WebSocket代理访问规则
+func (h *Handlers) CreateProxyACLRule(c *gin.Context) {
+	var payload models.ProxyACLRulePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.proxyACLService.CreateRule(&payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 更新WebSocket代理访问规则
+func (h *Handlers) UpdateProxyACLRule(c *gin.Context) {
+	var payload models.ProxyACLRulePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.proxyACLService.UpdateRule(c.Param("rule_id"), &payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 删除WebSocket代理访问规则
+func (h *Handlers) DeleteProxyACLRule(c *gin.Context) {
+	response, err := h.proxyACLService.DeleteRule(c.Param("rule_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 检查代理账号对主题的订阅或发布权限
+func (h *Handlers) CheckProxyACL(c *gin.Context) {
+	var req models.ProxyACLCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.proxyACLService.CheckAccess(&req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
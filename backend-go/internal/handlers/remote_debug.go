@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取支持的远程调试指令
+func (h *Handlers) GetRemoteDebugMethods(c *gin.Context) {
+	response, err := h.remoteDebugService.GetMethods()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 执行机场远程调试指令
+func (h *Handlers) ExecuteRemoteDebug(c *gin.Context) {
+	var payload models.RemoteDebugPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.remoteDebugService.Execute(deviceSNParam(c), c.Param("method"), &payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取远程调试指令记录
+func (h *Handlers) GetRemoteDebugCommands(c *gin.Context) {
+	var query models.RemoteDebugCommandQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.remoteDebugService.GetCommands(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取远程调试指令详情
+func (h *Handlers) GetRemoteDebugCommand(c *gin.Context) {
+	response, err := h.remoteDebugService.GetCommand(c.Param("command_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
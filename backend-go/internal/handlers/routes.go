@@ -8,10 +8,12 @@ func (h *Handlers) RegisterRoutes(r *gin.Engine) {
 	// 健康检查和工具API
 	r.GET("/api/health", h.Health)
 	r.GET("/api/error-codes", h.GetErrorCodes)
+	r.GET("/api/error-codes/:code", h.GetErrorCode)
 	r.GET("/api/network/ping", h.Ping)
 
 	// WebSocket支持
 	r.GET("/ws/mqtt", h.WebSocketHandler)
+	r.GET("/ws/drc/:sn", h.DrcWebSocketHandler)
 
 	// MQTT配置管理API
 	mqtt := r.Group("/api/mqtt")
@@ -22,7 +24,38 @@ func (h *Handlers) RegisterRoutes(r *gin.Engine) {
 		mqtt.PUT("/profiles/:pid", h.UpdateMQTTProfile)
 		mqtt.DELETE("/profiles/:pid", h.DeleteMQTTProfile)
 		mqtt.POST("/profiles/:pid/default", h.SetDefaultMQTTProfile)
+		mqtt.POST("/profiles/:pid/certificates", h.UploadMQTTCertificates)
 		mqtt.POST("/test", h.TestMQTTConnection)
+
+		// 内置MQTT broker
+		mqtt.GET("/broker", h.GetEmbeddedBrokerStatus)
+
+		// 内置broker账号可获得管理员角色绕过按设备的主题限制，需要管理令牌
+		brokerAdmin := mqtt.Group("/broker/users", h.requireProxyAdmin)
+		{
+			brokerAdmin.GET("", h.GetBrokerUsers)
+			brokerAdmin.POST("", h.CreateBrokerUser)
+			brokerAdmin.PUT("/:user_id", h.UpdateBrokerUser)
+			brokerAdmin.DELETE("/:user_id", h.DeleteBrokerUser)
+		}
+
+		// WebSocket代理
+		mqtt.GET("/proxy/upstreams", h.GetMQTTProxyUpstreams)
+		mqtt.GET("/proxy/stats", h.GetMQTTProxyStats)
+
+		// WebSocket代理访问控制，需要管理令牌
+		proxyAdmin := mqtt.Group("/proxy", h.requireProxyAdmin)
+		{
+			proxyAdmin.GET("/users", h.GetProxyUsers)
+			proxyAdmin.POST("/users", h.CreateProxyUser)
+			proxyAdmin.PUT("/users/:user_id", h.UpdateProxyUser)
+			proxyAdmin.DELETE("/users/:user_id", h.DeleteProxyUser)
+			proxyAdmin.GET("/acl", h.GetProxyACLRules)
+			proxyAdmin.POST("/acl", h.CreateProxyACLRule)
+			proxyAdmin.PUT("/acl/:rule_id", h.UpdateProxyACLRule)
+			proxyAdmin.DELETE("/acl/:rule_id", h.DeleteProxyACLRule)
+			proxyAdmin.POST("/acl/check", h.CheckProxyACL)
+		}
 	}
 
 	// 设备管理API
@@ -37,6 +70,132 @@ func (h *Handlers) RegisterRoutes(r *gin.Engine) {
 		devices.POST("/:device_id/set-gateway", h.SetGatewayDevice)
 		devices.DELETE("/clear", h.ClearAllDevices)
 		devices.DELETE("/remove-defaults", h.RemoveDefaultDevices)
+		devices.GET("/:device_id/latest", h.GetDeviceLatestTelemetry)
+		devices.GET("/:device_id/telemetry", h.GetDeviceTelemetryHistory)
+		devices.POST("/:device_id/services/:method", h.CallDeviceService)
+		devices.GET("/:device_id/logs", h.GetDeviceLogFiles)
+		devices.POST("/:device_id/logs/uploads", h.CreateDeviceLogUpload)
+		devices.POST("/:device_id/debug/:method", h.ExecuteRemoteDebug)
+		devices.GET("/:device_id/properties", h.GetDeviceProperties)
+		devices.PUT("/:device_id/properties", h.SetDeviceProperties)
+	}
+
+	// 设备遥测API
+	telemetry := r.Group("/api/telemetry")
+	{
+		telemetry.GET("/latest", h.GetLatestTelemetry)
+	}
+
+	// 飞行记录API
+	flights := r.Group("/api/flights")
+	{
+		flights.GET("", h.GetFlights)
+		flights.GET("/:flight_id", h.GetFlight)
+	}
+
+	// HMS健康告警API
+	hms := r.Group("/api/hms")
+	{
+		hms.GET("", h.GetHmsAlarms)
+		hms.POST("/:alarm_id/ack", h.AcknowledgeHmsAlarm)
+	}
+
+	// 设备服务任务API
+	serviceJobs := r.Group("/api/service-jobs")
+	{
+		serviceJobs.GET("", h.GetServiceJobs)
+		serviceJobs.GET("/:job_id", h.GetServiceJob)
+	}
+
+	// 固件升级API
+	firmware := r.Group("/api/firmware-packages")
+	{
+		firmware.GET("", h.GetFirmwarePackages)
+		firmware.POST("", h.CreateFirmwarePackage)
+		firmware.DELETE("/:package_id", h.DeleteFirmwarePackage)
+	}
+
+	upgrades := r.Group("/api/upgrades")
+	{
+		upgrades.GET("", h.GetUpgradeJobs)
+		upgrades.POST("", h.CreateUpgradeJobs)
+		upgrades.GET("/:job_id", h.GetUpgradeJob)
+	}
+
+	// 远程日志API
+	logUploads := r.Group("/api/log-uploads")
+	{
+		logUploads.GET("", h.GetLogUploads)
+		logUploads.GET("/:upload_id", h.GetLogUpload)
+	}
+	r.GET("/api/log-files/:file_id/download", h.DownloadLogFile)
+
+	// 航线管理API
+	waylines := r.Group("/api/waylines")
+	{
+		waylines.GET("", h.GetWaylines)
+		waylines.POST("", h.CreateWayline)
+		waylines.GET("/:wayline_id", h.GetWayline)
+		waylines.DELETE("/:wayline_id", h.DeleteWayline)
+		waylines.GET("/:wayline_id/file", h.DownloadWaylineFile)
+	}
+
+	// 航线任务API
+	flightTasks := r.Group("/api/flight-tasks")
+	{
+		flightTasks.GET("", h.GetFlightTasks)
+		flightTasks.POST("", h.CreateFlightTask)
+		flightTasks.GET("/:task_id", h.GetFlightTask)
+		flightTasks.POST("/:task_id/cancel", h.CancelFlightTask)
+	}
+
+	// 巡检计划API
+	patrolPlans := r.Group("/api/patrol-plans")
+	{
+		patrolPlans.GET("", h.GetPatrolPlans)
+		patrolPlans.POST("", h.CreatePatrolPlan)
+		patrolPlans.GET("/:plan_id", h.GetPatrolPlan)
+		patrolPlans.PUT("/:plan_id", h.UpdatePatrolPlan)
+		patrolPlans.DELETE("/:plan_id", h.DeletePatrolPlan)
+		patrolPlans.POST("/:plan_id/run", h.TriggerPatrolPlan)
+	}
+	r.GET("/api/patrol-runs", h.GetPatrolRuns)
+
+	// 远程调试API
+	remoteDebug := r.Group("/api/remote-debug")
+	{
+		remoteDebug.GET("/methods", h.GetRemoteDebugMethods)
+		remoteDebug.GET("/commands", h.GetRemoteDebugCommands)
+		remoteDebug.GET("/commands/:command_id", h.GetRemoteDebugCommand)
+	}
+
+	// 设备属性设置API
+	r.GET("/api/property-settings", h.GetPropertySettings)
+
+	// DRC指令飞行API
+	drcSessions := r.Group("/api/drc/sessions")
+	{
+		drcSessions.GET("", h.GetDrcSessions)
+		// 强制释放会夺走当前操作员的控制权，需要管理令牌
+		drcSessions.DELETE("/:sn", h.requireProxyAdmin, h.ReleaseDrcSession)
+	}
+
+	// MQTT抓包与回放API
+	captures := r.Group("/api/captures")
+	{
+		captures.GET("", h.GetCaptures)
+		captures.POST("", h.StartCapture)
+		captures.POST("/import", h.ImportCapture)
+		captures.GET("/replays", h.GetCaptureReplays)
+		captures.GET("/replays/:replay_id", h.GetCaptureReplay)
+		captures.POST("/replays/:replay_id/step", h.StepCaptureReplay)
+		captures.POST("/replays/:replay_id/stop", h.StopCaptureReplay)
+		captures.GET("/:capture_id", h.GetCapture)
+		captures.DELETE("/:capture_id", h.DeleteCapture)
+		captures.POST("/:capture_id/stop", h.StopCapture)
+		captures.GET("/:capture_id/file", h.DownloadCapture)
+		// 回放可向任意主题发布，绕过代理访问控制，需要管理令牌
+		captures.POST("/:capture_id/replays", h.requireProxyAdmin, h.StartCaptureReplay)
 	}
 
 	// 摄像头管理API
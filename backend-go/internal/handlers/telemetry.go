@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deviceSNParam 读取路径中的设备SN
+// gin 要求同一路径段的通配符名称一致，因此设备相关路由统一使用 :device_id 作为参数名
+func deviceSNParam(c *gin.Context) string {
+	return c.Param("device_id")
+}
+
+// 获取所有设备最新遥测
+func (h *Handlers) GetLatestTelemetry(c *gin.Context) {
+	response, err := h.ingestService.GetLatestTelemetry()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取单个设备最新遥测
+func (h *Handlers) GetDeviceLatestTelemetry(c *gin.Context) {
+	response, err := h.ingestService.GetDeviceLatestTelemetry(deviceSNParam(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 查询设备遥测历史
+func (h *Handlers) GetDeviceTelemetryHistory(c *gin.Context) {
+	var query models.TelemetryQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	from, err := parseTimeParam(query.From)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: from " + err.Error(),
+		})
+		return
+	}
+	to, err := parseTimeParam(query.To)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: to " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.telemetryService.GetTelemetryHistory(deviceSNParam(c), from, to, query.Fields, query.MaxPoints)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// parseTimeParam 解析时间参数，支持毫秒/秒时间戳与RFC3339，为空时返回0
+func parseTimeParam(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// 小于 1e12 视为秒级时间戳
+		if n < 1e12 {
+			return n * 1000, nil
+		}
+		return n, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("时间格式错误: %s", value)
+	}
+	return t.UnixMilli(), nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取固件包列表
+func (h *Handlers) GetFirmwarePackages(c *gin.Context) {
+	response, err := h.upgradeService.GetPackages()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 创建固件包
+func (h *Handlers) CreateFirmwarePackage(c *gin.Context) {
+	var payload models.FirmwarePackagePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.upgradeService.CreatePackage(&payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 删除固件包
+func (h *Handlers) DeleteFirmwarePackage(c *gin.Context) {
+	response, err := h.upgradeService.DeletePackage(c.Param("package_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 创建升级任务
+func (h *Handlers) CreateUpgradeJobs(c *gin.Context) {
+	var payload models.UpgradeJobPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.upgradeService.CreateJobs(&payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取升级任务列表
+func (h *Handlers) GetUpgradeJobs(c *gin.Context) {
+	var query models.UpgradeJobQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.upgradeService.GetJobs(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取升级任务详情
+func (h *Handlers) GetUpgradeJob(c *gin.Context) {
+	response, err := h.upgradeService.GetJob(c.Param("job_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"os"
+
+	"drone-patrol-backend/internal/models"
+	"drone-patrol-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取航线列表
+func (h *Handlers) GetWaylines(c *gin.Context) {
+	var query models.WaylineQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.waylineService.GetWaylines(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 上传航线文件
+func (h *Handlers) CreateWayline(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+	if fileHeader.Size > services.WaylineMaxFileSize {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "航线文件过大",
+		})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "读取航线文件失败: " + err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, services.WaylineMaxFileSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "读取航线文件失败: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.waylineService.CreateWayline(c.PostForm("name"), fileHeader.Filename, c.PostForm("operator"), data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取航线详情
+func (h *Handlers) GetWayline(c *gin.Context) {
+	response, err := h.waylineService.GetWayline(c.Param("wayline_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 删除航线
+func (h *Handlers) DeleteWayline(c *gin.Context) {
+	response, err := h.waylineService.DeleteWayline(c.Param("wayline_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 下载航线文件，供机场通过 flighttask_prepare 中的地址获取
+func (h *Handlers) DownloadWaylineFile(c *gin.Context) {
+	path, name, response, err := h.waylineService.GetWaylineFile(c.Param("wayline_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	if response != nil {
+		c.JSON(http.StatusNotFound, response)
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Code:    1,
+			Message: "航线文件不存在",
+		})
+		return
+	}
+	c.FileAttachment(path, name)
+}
+
+// 创建航线任务
+func (h *Handlers) CreateFlightTask(c *gin.Context) {
+	var payload models.FlightTaskPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.waylineService.CreateTask(&payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取航线任务列表
+func (h *Handlers) GetFlightTasks(c *gin.Context) {
+	var query models.FlightTaskQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Code:    1,
+			Message: "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := h.waylineService.GetTasks(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 获取航线任务详情
+func (h *Handlers) GetFlightTask(c *gin.Context) {
+	response, err := h.waylineService.GetTask(c.Param("task_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// 取消航线任务
+func (h *Handlers) CancelFlightTask(c *gin.Context) {
+	response, err := h.waylineService.CancelTask(c.Param("task_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -47,9 +47,7 @@ func (h *Handlers) WebSocketHandler(c *gin.Context) {
 		"timestamp": time.Now().Format(time.RFC3339),
 	}
 
-	if err := conn.WriteJSON(welcomeMsg); err != nil {
-		log.Printf("Failed to send welcome message: %v", err)
-	}
+	h.MQTTProxy.Send(clientID, welcomeMsg)
 
 	// 处理WebSocket消息
 	for {
@@ -66,7 +64,11 @@ func (h *Handlers) WebSocketHandler(c *gin.Context) {
 				continue
 			}
 
-			log.Printf("Received WebSocket message: %+v", wsMessage)
+			logged := wsMessage
+			if logged.Password != "" {
+				logged.Password = "******"
+			}
+			log.Printf("Received WebSocket message: %+v", logged)
 
 			// 处理消息
 			if err := h.MQTTProxy.HandleWebSocketMessage(clientID, wsMessage); err != nil {
@@ -77,9 +79,7 @@ func (h *Handlers) WebSocketHandler(c *gin.Context) {
 					Type:    "error",
 					Payload: err.Error(),
 				}
-				if err := conn.WriteJSON(errorMsg); err != nil {
-					log.Printf("Failed to send error message: %v", err)
-				}
+				h.MQTTProxy.Send(clientID, errorMsg)
 			}
 		}
 	}
@@ -0,0 +1,199 @@
+package models
+
+import "encoding/json"
+
+// DJI Cloud API 通用消息信封
+type DJIMessage struct {
+	TID       string          `json:"tid"`
+	BID       string          `json:"bid"`
+	Timestamp int64           `json:"timestamp"`
+	Gateway   string          `json:"gateway,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	NeedReply int             `json:"need_reply,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	// drc/up 与 drc/down 消息使用 seq 标识顺序
+	Seq int64 `json:"seq,omitempty"`
+}
+
+// OSD 设备类型
+const (
+	OSDKindDock     = "dock"
+	OSDKindAircraft = "aircraft"
+	OSDKindUnknown  = "unknown"
+)
+
+// 机场 OSD
+type DockOSD struct {
+	ModeCode               int                `json:"mode_code"`
+	CoverState             int                `json:"cover_state"`
+	PutterState            int                `json:"putter_state"`
+	DroneInDock            int                `json:"drone_in_dock"`
+	DroneChargeState       DroneChargeState   `json:"drone_charge_state"`
+	EmergencyStopState     int                `json:"emergency_stop_state"`
+	SupplementLightState   int                `json:"supplement_light_state"`
+	AlarmState             int                `json:"alarm_state"`
+	Latitude               float64            `json:"latitude"`
+	Longitude              float64            `json:"longitude"`
+	Height                 float64            `json:"height"`
+	Heading                float64            `json:"heading"`
+	HomePositionIsValid    int                `json:"home_position_is_valid"`
+	EnvironmentTemperature float64            `json:"environment_temperature"`
+	Temperature            float64            `json:"temperature"`
+	Humidity               float64            `json:"humidity"`
+	Rainfall               int                `json:"rainfall"`
+	WindSpeed              float64            `json:"wind_speed"`
+	NetworkState           NetworkState       `json:"network_state"`
+	SubDevice              *DockSubDevice     `json:"sub_device,omitempty"`
+	JobNumber              int                `json:"job_number"`
+	AccTime                int64              `json:"acc_time"`
+	ActivationTime         int64              `json:"activation_time"`
+	FlighttaskStepCode     int                `json:"flighttask_step_code"`
+	ElectricSupplyVoltage  int                `json:"electric_supply_voltage"`
+	WorkingVoltage         int                `json:"working_voltage"`
+	WorkingCurrent         float64            `json:"working_current"`
+	BackupBattery          BackupBattery      `json:"backup_battery"`
+	MediaFileDetail        MediaFileDetail    `json:"media_file_detail"`
+	Storage                StorageState       `json:"storage"`
+	FirmwareVersion        string             `json:"firmware_version"`
+	AirConditioner         AirConditionerInfo `json:"air_conditioner"`
+	DRCState               int                `json:"drc_state"`
+}
+
+// 飞行器 OSD
+type AircraftOSD struct {
+	ModeCode            int             `json:"mode_code"`
+	InTheSky            *int            `json:"in_the_sky,omitempty"`
+	Latitude            float64         `json:"latitude"`
+	Longitude           float64         `json:"longitude"`
+	Height              float64         `json:"height"`
+	Elevation           float64         `json:"elevation"`
+	HorizontalSpeed     float64         `json:"horizontal_speed"`
+	VerticalSpeed       float64         `json:"vertical_speed"`
+	AttitudePitch       float64         `json:"attitude_pitch"`
+	AttitudeRoll        float64         `json:"attitude_roll"`
+	AttitudeHead        float64         `json:"attitude_head"`
+	WindSpeed           float64         `json:"wind_speed"`
+	WindDirection       int             `json:"wind_direction"`
+	HomeDistance        float64         `json:"home_distance"`
+	TotalFlightDistance float64         `json:"total_flight_distance"`
+	TotalFlightTime     float64         `json:"total_flight_time"`
+	Gear                int             `json:"gear"`
+	HeightLimit         int             `json:"height_limit"`
+	DistanceLimitStatus DistanceLimit   `json:"distance_limit_status"`
+	NightLightsState    int             `json:"night_lights_state"`
+	Battery             AircraftBattery `json:"battery"`
+	PositionState       PositionState   `json:"position_state"`
+	Storage             StorageState    `json:"storage"`
+	FirmwareVersion     string          `json:"firmware_version"`
+}
+
+type DroneChargeState struct {
+	State           int `json:"state"`
+	CapacityPercent int `json:"capacity_percent"`
+}
+
+type NetworkState struct {
+	Type    int     `json:"type"`
+	Quality int     `json:"quality"`
+	Rate    float64 `json:"rate"`
+}
+
+type DockSubDevice struct {
+	DeviceSN           string `json:"device_sn"`
+	DeviceModelKey     string `json:"device_model_key"`
+	DeviceOnlineStatus int    `json:"device_online_status"`
+	DevicePaired       int    `json:"device_paired"`
+}
+
+type BackupBattery struct {
+	Voltage     int     `json:"voltage"`
+	Temperature float64 `json:"temperature"`
+	Switch      int     `json:"switch"`
+}
+
+type MediaFileDetail struct {
+	RemainUpload int `json:"remain_upload"`
+}
+
+type StorageState struct {
+	Total int64 `json:"total"`
+	Used  int64 `json:"used"`
+}
+
+type AirConditionerInfo struct {
+	AirConditionerState int `json:"air_conditioner_state"`
+	SwitchTime          int `json:"switch_time"`
+}
+
+type DistanceLimit struct {
+	State         int `json:"state"`
+	DistanceLimit int `json:"distance_limit"`
+}
+
+type AircraftBattery struct {
+	CapacityPercent  int `json:"capacity_percent"`
+	RemainFlightTime int `json:"remain_flight_time"`
+	ReturnHomePower  int `json:"return_home_power"`
+	LandingPower     int `json:"landing_power"`
+}
+
+type PositionState struct {
+	IsFixed   int `json:"is_fixed"`
+	Quality   int `json:"quality"`
+	GPSNumber int `json:"gps_number"`
+	RTKNumber int `json:"rtk_number"`
+}
+
+// 设备最新遥测快照
+type DeviceSnapshot struct {
+	SN             string                 `json:"sn"`
+	Gateway        string                 `json:"gateway"`
+	Kind           string                 `json:"kind"`
+	Dock           *DockOSD               `json:"dock,omitempty"`
+	Aircraft       *AircraftOSD           `json:"aircraft,omitempty"`
+	OSD            json.RawMessage        `json:"osd,omitempty"`
+	State          map[string]interface{} `json:"state,omitempty"`
+	OSDUpdatedAt   int64                  `json:"osdUpdatedAt"`
+	StateUpdatedAt int64                  `json:"stateUpdatedAt"`
+	UpdatedAt      int64                  `json:"updatedAt"`
+}
+
+// 拓扑更新（sys/product/{gateway_sn}/status, method: update_topo）
+type TopoUpdate struct {
+	Domain       interface{}     `json:"domain"`
+	Type         int             `json:"type"`
+	SubType      int             `json:"sub_type"`
+	DeviceSecret string          `json:"device_secret"`
+	Nonce        string          `json:"nonce"`
+	ThingVersion string          `json:"thing_version"`
+	SubDevices   []TopoSubDevice `json:"sub_devices"`
+}
+
+type TopoSubDevice struct {
+	SN           string      `json:"sn"`
+	Domain       interface{} `json:"domain"`
+	Type         int         `json:"type"`
+	SubType      int         `json:"sub_type"`
+	Index        string      `json:"index"`
+	DeviceSecret string      `json:"device_secret"`
+	Nonce        string      `json:"nonce"`
+	ThingVersion string      `json:"thing_version"`
+}
+
+// HMS 事件（thing/product/{gateway_sn}/events, method: hms）
+type HmsEventData struct {
+	List []HmsEventItem `json:"list"`
+}
+
+type HmsEventItem struct {
+	Level      int    `json:"level"`
+	Module     int    `json:"module"`
+	InTheSky   int    `json:"in_the_sky"`
+	Code       string `json:"code"`
+	DeviceType string `json:"device_type"`
+	Imminent   int    `json:"imminent"`
+	Args       struct {
+		ComponentIndex int `json:"component_index"`
+		SensorIndex    int `json:"sensor_index"`
+	} `json:"args"`
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // 通用响应结构
 type APIResponse struct {
@@ -55,12 +58,652 @@ type DeviceUpdatePayload struct {
 	AirportSN *string `json:"airport_sn,omitempty"`
 }
 
+// 遥测历史采样
+type TelemetrySample struct {
+	SN              string  `json:"sn"`
+	Timestamp       int64   `json:"timestamp"`
+	ModeCode        int     `json:"mode_code"`
+	Latitude        float64 `json:"latitude"`
+	Longitude       float64 `json:"longitude"`
+	Height          float64 `json:"height"`
+	Elevation       float64 `json:"elevation"`
+	HorizontalSpeed float64 `json:"horizontal_speed"`
+	VerticalSpeed   float64 `json:"vertical_speed"`
+	Battery         float64 `json:"battery"`
+	AttitudePitch   float64 `json:"attitude_pitch"`
+	AttitudeRoll    float64 `json:"attitude_roll"`
+	AttitudeHead    float64 `json:"attitude_head"`
+}
+
+type TelemetryQuery struct {
+	From      string `form:"from"`
+	To        string `form:"to"`
+	Fields    string `form:"fields"`
+	MaxPoints int    `form:"max_points"`
+}
+
+// 飞行记录
+type Flight struct {
+	ID            string   `json:"id"`
+	AircraftSN    string   `json:"aircraft_sn"`
+	DockSN        string   `json:"dock_sn"`
+	Status        string   `json:"status"`
+	EndReason     string   `json:"end_reason,omitempty"`
+	StartTime     int64    `json:"start_time"`
+	EndTime       *int64   `json:"end_time"`
+	Duration      int64    `json:"duration"`
+	Distance      float64  `json:"distance"`
+	MaxAltitude   float64  `json:"max_altitude"`
+	StartBattery  int      `json:"start_battery"`
+	EndBattery    int      `json:"end_battery"`
+	BatteryUsed   int      `json:"battery_used"`
+	HomeLatitude  *float64 `json:"home_latitude"`
+	HomeLongitude *float64 `json:"home_longitude"`
+	CreatedAt     int64    `json:"createdAt"`
+	UpdatedAt     int64    `json:"updatedAt"`
+}
+
+type FlightQuery struct {
+	SN       string `form:"sn"`
+	DockSN   string `form:"dock_sn"`
+	Status   string `form:"status"`
+	From     string `form:"from"`
+	To       string `form:"to"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+// HMS健康告警
+type HmsAlarm struct {
+	ID             int64  `json:"id"`
+	SN             string `json:"sn"`
+	Code           string `json:"code"`
+	DeviceType     string `json:"device_type"`
+	Level          int    `json:"level"`
+	Module         int    `json:"module"`
+	InTheSky       int    `json:"in_the_sky"`
+	ComponentIndex int    `json:"component_index"`
+	SensorIndex    int    `json:"sensor_index"`
+	MessageZh      string `json:"message_zh"`
+	MessageEn      string `json:"message_en"`
+	Active         bool   `json:"active"`
+	FirstSeen      int64  `json:"first_seen"`
+	LastSeen       int64  `json:"last_seen"`
+	ClearedAt      *int64 `json:"cleared_at"`
+	Acknowledged   bool   `json:"acknowledged"`
+	AcknowledgedBy string `json:"acknowledged_by"`
+	AcknowledgedAt *int64 `json:"acknowledged_at"`
+}
+
+type HmsQuery struct {
+	SN       string `form:"sn"`
+	Level    *int   `form:"level"`
+	Active   *bool  `form:"active"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+type HmsAckPayload struct {
+	User string `json:"user"`
+}
+
+// 设备服务调用
+type ServiceReply struct {
+	TID          string          `json:"tid"`
+	BID          string          `json:"bid"`
+	Method       string          `json:"method"`
+	Result       int             `json:"result"`
+	ErrorMessage string          `json:"error_message,omitempty"`
+	Output       json.RawMessage `json:"output,omitempty"`
+	Data         json.RawMessage `json:"data,omitempty"`
+}
+
+type ServiceJob struct {
+	ID           string          `json:"id"`
+	SN           string          `json:"sn"`
+	Method       string          `json:"method"`
+	TID          string          `json:"tid"`
+	BID          string          `json:"bid"`
+	Status       string          `json:"status"`
+	Result       *int            `json:"result"`
+	ErrorMessage string          `json:"error_message"`
+	Progress     int             `json:"progress"`
+	Request      json.RawMessage `json:"request,omitempty"`
+	Reply        json.RawMessage `json:"reply,omitempty"`
+	Output       json.RawMessage `json:"output,omitempty"`
+	CreatedAt    int64           `json:"created_at"`
+	UpdatedAt    int64           `json:"updated_at"`
+}
+
+type ServiceCallQuery struct {
+	Timeout int   `form:"timeout"`
+	Async   *bool `form:"async"`
+}
+
+type ServiceJobQuery struct {
+	SN       string `form:"sn"`
+	Method   string `form:"method"`
+	Status   string `form:"status"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+// 固件升级
+type FirmwarePackage struct {
+	ID                  string `json:"id"`
+	FileName            string `json:"file_name"`
+	ProductVersion      string `json:"product_version"`
+	DeviceType          string `json:"device_type"`
+	DeviceModel         string `json:"device_model"`
+	FileURL             string `json:"file_url"`
+	FileSize            int64  `json:"file_size"`
+	MD5                 string `json:"md5"`
+	FirmwareUpgradeType int    `json:"firmware_upgrade_type"`
+	ReleaseNote         string `json:"release_note"`
+	CreatedAt           int64  `json:"created_at"`
+}
+
+type FirmwarePackagePayload struct {
+	FileName            string `json:"file_name" binding:"required"`
+	ProductVersion      string `json:"product_version" binding:"required"`
+	DeviceType          string `json:"device_type" binding:"required,oneof=aircraft airport"`
+	DeviceModel         string `json:"device_model"`
+	FileURL             string `json:"file_url" binding:"required"`
+	FileSize            int64  `json:"file_size"`
+	MD5                 string `json:"md5"`
+	FirmwareUpgradeType int    `json:"firmware_upgrade_type"`
+	ReleaseNote         string `json:"release_note"`
+}
+
+type UpgradeJob struct {
+	ID                  string `json:"id"`
+	DeviceSN            string `json:"device_sn"`
+	GatewaySN           string `json:"gateway_sn"`
+	PackageID           string `json:"package_id"`
+	ProductVersion      string `json:"product_version"`
+	FirmwareUpgradeType int    `json:"firmware_upgrade_type"`
+	Status              string `json:"status"`
+	TID                 string `json:"tid"`
+	BID                 string `json:"bid"`
+	Progress            int    `json:"progress"`
+	CurrentStep         string `json:"current_step"`
+	Result              *int   `json:"result"`
+	ErrorMessage        string `json:"error_message"`
+	Operator            string `json:"operator"`
+	CreatedAt           int64  `json:"created_at"`
+	UpdatedAt           int64  `json:"updated_at"`
+	FinishedAt          *int64 `json:"finished_at"`
+}
+
+type UpgradeJobPayload struct {
+	PackageID           string   `json:"package_id" binding:"required"`
+	DeviceSNs           []string `json:"device_sns" binding:"required,min=1"`
+	FirmwareUpgradeType int      `json:"firmware_upgrade_type"`
+	Operator            string   `json:"operator"`
+}
+
+type UpgradeJobQuery struct {
+	SN       string `form:"sn"`
+	Status   string `form:"status"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+// 远程日志
+type StorageCredentials struct {
+	AccessKeyID     string `json:"access_key_id"`
+	AccessKeySecret string `json:"access_key_secret"`
+	Expire          int64  `json:"expire"`
+	SecurityToken   string `json:"security_token"`
+}
+
+type LogUpload struct {
+	ID           string    `json:"id"`
+	GatewaySN    string    `json:"gateway_sn"`
+	TID          string    `json:"tid"`
+	BID          string    `json:"bid"`
+	Status       string    `json:"status"`
+	Result       *int      `json:"result"`
+	ErrorMessage string    `json:"error_message"`
+	Operator     string    `json:"operator"`
+	CreatedAt    int64     `json:"created_at"`
+	UpdatedAt    int64     `json:"updated_at"`
+	FinishedAt   *int64    `json:"finished_at"`
+	Files        []LogFile `json:"files,omitempty"`
+}
+
+type LogFile struct {
+	ID          string `json:"id"`
+	UploadID    string `json:"upload_id"`
+	DeviceSN    string `json:"device_sn"`
+	Module      string `json:"module"`
+	BootIndexes []int  `json:"boot_indexes"`
+	ObjectKey   string `json:"object_key"`
+	Size        int64  `json:"size"`
+	Fingerprint string `json:"fingerprint"`
+	Progress    int    `json:"progress"`
+	Status      string `json:"status"`
+	StoredAt    *int64 `json:"stored_at"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+type LogUploadPayload struct {
+	Files []struct {
+		Module      string `json:"module" binding:"required"`
+		BootIndexes []int  `json:"boot_indexes" binding:"required,min=1"`
+	} `json:"files" binding:"required,min=1,dive"`
+	Operator string `json:"operator"`
+}
+
+type LogFileListQuery struct {
+	ModuleList string `form:"module_list"`
+}
+
+type LogUploadQuery struct {
+	SN       string `form:"sn"`
+	Status   string `form:"status"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+// 航线任务
+type WaylineWaypoint struct {
+	Index     int     `json:"index"`
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+	Height    float64 `json:"height"`
+	Speed     float64 `json:"speed,omitempty"`
+}
+
+type Wayline struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	FileName         string            `json:"file_name"`
+	FileSize         int64             `json:"file_size"`
+	MD5              string            `json:"md5"`
+	TemplateType     string            `json:"template_type"`
+	DroneModelKey    string            `json:"drone_model_key"`
+	PayloadModelKeys []string          `json:"payload_model_keys"`
+	WaypointCount    int               `json:"waypoint_count"`
+	Distance         float64           `json:"distance"`
+	Duration         float64           `json:"duration"`
+	MinHeight        float64           `json:"min_height"`
+	MaxHeight        float64           `json:"max_height"`
+	HeightMode       string            `json:"height_mode"`
+	FinishAction     string            `json:"finish_action"`
+	Waypoints        []WaylineWaypoint `json:"waypoints,omitempty"`
+	Operator         string            `json:"operator"`
+	CreatedAt        int64             `json:"created_at"`
+}
+
+type FlightTask struct {
+	ID                    string `json:"id"`
+	WaylineID             string `json:"wayline_id"`
+	DockSN                string `json:"dock_sn"`
+	TaskType              int    `json:"task_type"`
+	ExecuteTime           int64  `json:"execute_time"`
+	RthAltitude           int    `json:"rth_altitude"`
+	OutOfControlAction    int    `json:"out_of_control_action"`
+	ExitWaylineWhenRcLost int    `json:"exit_wayline_when_rc_lost"`
+	Status                string `json:"status"`
+	TID                   string `json:"tid"`
+	BID                   string `json:"bid"`
+	Progress              int    `json:"progress"`
+	CurrentWaypointIndex  int    `json:"current_waypoint_index"`
+	MediaCount            int    `json:"media_count"`
+	Result                *int   `json:"result"`
+	ErrorMessage          string `json:"error_message"`
+	Operator              string `json:"operator"`
+	CreatedAt             int64  `json:"created_at"`
+	UpdatedAt             int64  `json:"updated_at"`
+	FinishedAt            *int64 `json:"finished_at"`
+}
+
+type WaylineQuery struct {
+	Q        string `form:"q"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+type FlightTaskPayload struct {
+	WaylineID             string `json:"wayline_id" binding:"required"`
+	DockSN                string `json:"dock_sn" binding:"required"`
+	TaskType              int    `json:"task_type" binding:"oneof=0 1"`
+	ExecuteTime           int64  `json:"execute_time"`
+	RthAltitude           int    `json:"rth_altitude" binding:"omitempty,min=20,max=1500"`
+	OutOfControlAction    int    `json:"out_of_control_action" binding:"oneof=0 1 2"`
+	ExitWaylineWhenRcLost int    `json:"exit_wayline_when_rc_lost" binding:"oneof=0 1"`
+	Operator              string `json:"operator"`
+}
+
+type FlightTaskQuery struct {
+	SN        string `form:"sn"`
+	WaylineID string `form:"wayline_id"`
+	Status    string `form:"status"`
+	Page      int    `form:"page"`
+	PageSize  int    `form:"page_size"`
+}
+
+// 巡检计划
+type PatrolPlan struct {
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	WaylineID       string   `json:"wayline_id"`
+	DockSN          string   `json:"dock_sn"`
+	ScheduleType    string   `json:"schedule_type"`
+	CronExpr        string   `json:"cron_expr"`
+	IntervalMinutes int      `json:"interval_minutes"`
+	WindowStart     string   `json:"window_start"`
+	WindowEnd       string   `json:"window_end"`
+	MaxWindSpeed    *float64 `json:"max_wind_speed"`
+	MinBattery      *int     `json:"min_battery"`
+	AllowRain       bool     `json:"allow_rain"`
+	MinTemperature  *float64 `json:"min_temperature"`
+	MaxTemperature  *float64 `json:"max_temperature"`
+	RthAltitude     int      `json:"rth_altitude"`
+	Enabled         bool     `json:"enabled"`
+	NextRunAt       *int64   `json:"next_run_at"`
+	LastRunAt       *int64   `json:"last_run_at"`
+	Operator        string   `json:"operator"`
+	CreatedAt       int64    `json:"created_at"`
+	UpdatedAt       int64    `json:"updated_at"`
+}
+
+type PatrolPlanPayload struct {
+	Name            string   `json:"name" binding:"required"`
+	WaylineID       string   `json:"wayline_id" binding:"required"`
+	DockSN          string   `json:"dock_sn" binding:"required"`
+	ScheduleType    string   `json:"schedule_type" binding:"required,oneof=cron interval"`
+	CronExpr        string   `json:"cron_expr"`
+	IntervalMinutes int      `json:"interval_minutes" binding:"omitempty,min=1"`
+	WindowStart     string   `json:"window_start"`
+	WindowEnd       string   `json:"window_end"`
+	MaxWindSpeed    *float64 `json:"max_wind_speed" binding:"omitempty,min=0"`
+	MinBattery      *int     `json:"min_battery" binding:"omitempty,min=0,max=100"`
+	AllowRain       bool     `json:"allow_rain"`
+	MinTemperature  *float64 `json:"min_temperature"`
+	MaxTemperature  *float64 `json:"max_temperature"`
+	RthAltitude     int      `json:"rth_altitude" binding:"omitempty,min=20,max=1500"`
+	Enabled         *bool    `json:"enabled"`
+	Operator        string   `json:"operator"`
+}
+
+type PatrolPlanQuery struct {
+	SN       string `form:"sn"`
+	Enabled  *bool  `form:"enabled"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+type PatrolRun struct {
+	ID           string `json:"id"`
+	PlanID       string `json:"plan_id"`
+	DockSN       string `json:"dock_sn"`
+	WaylineID    string `json:"wayline_id"`
+	Trigger      string `json:"trigger"`
+	ScheduledAt  int64  `json:"scheduled_at"`
+	Status       string `json:"status"`
+	FlightTaskID string `json:"flight_task_id"`
+	Reason       string `json:"reason"`
+	CreatedAt    int64  `json:"created_at"`
+	UpdatedAt    int64  `json:"updated_at"`
+	FinishedAt   *int64 `json:"finished_at"`
+}
+
+type PatrolRunQuery struct {
+	PlanID   string `form:"plan_id"`
+	SN       string `form:"sn"`
+	Status   string `form:"status"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+// 远程调试相关
+type RemoteDebugMethod struct {
+	Method           string `json:"method"`
+	Label            string `json:"label"`
+	RequireDebugMode bool   `json:"require_debug_mode"`
+	Actions          []int  `json:"actions,omitempty"`
+}
+
+type RemoteDebugPayload struct {
+	Operator string `json:"operator" binding:"required"`
+	Action   *int   `json:"action"`
+}
+
+type RemoteDebugCommand struct {
+	ID           string          `json:"id"`
+	DockSN       string          `json:"dock_sn"`
+	Method       string          `json:"method"`
+	Params       json.RawMessage `json:"params,omitempty"`
+	Operator     string          `json:"operator"`
+	Status       string          `json:"status"`
+	Result       *int            `json:"result"`
+	ErrorMessage string          `json:"error_message"`
+	Progress     int             `json:"progress"`
+	JobID        string          `json:"job_id"`
+	CreatedAt    int64           `json:"created_at"`
+	UpdatedAt    int64           `json:"updated_at"`
+}
+
+type RemoteDebugCommandQuery struct {
+	SN       string `form:"sn"`
+	Method   string `form:"method"`
+	Operator string `form:"operator"`
+	Status   string `form:"status"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+// 设备属性设置相关
+type PropertySetPayload struct {
+	Properties map[string]interface{} `json:"properties" binding:"required"`
+	Operator   string                 `json:"operator"`
+}
+
+type PropertySetting struct {
+	ID           string          `json:"id"`
+	SN           string          `json:"sn"`
+	Property     string          `json:"property"`
+	Desired      json.RawMessage `json:"desired"`
+	Reported     json.RawMessage `json:"reported,omitempty"`
+	Status       string          `json:"status"`
+	Result       *int            `json:"result"`
+	ErrorMessage string          `json:"error_message"`
+	Operator     string          `json:"operator"`
+	CreatedAt    int64           `json:"created_at"`
+	UpdatedAt    int64           `json:"updated_at"`
+	VerifiedAt   *int64          `json:"verified_at"`
+}
+
+type PropertySettingQuery struct {
+	SN       string `form:"sn"`
+	Property string `form:"property"`
+	Status   string `form:"status"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
+}
+
+// 内置MQTT broker相关
+type BrokerUser struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	SN        string `json:"sn"`
+	Enabled   bool   `json:"enabled"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+type BrokerUserPayload struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+	SN       string `json:"sn"`
+	Enabled  *bool  `json:"enabled"`
+}
+
+// WebSocket代理账号，Devices 为分配的设备SN，规则中的 {sn} 按这些设备及其子设备展开
+type ProxyUser struct {
+	ID        string   `json:"id"`
+	Username  string   `json:"username"`
+	Role      string   `json:"role"`
+	Devices   []string `json:"devices"`
+	Enabled   bool     `json:"enabled"`
+	CreatedAt int64    `json:"created_at"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+type ProxyUserPayload struct {
+	Username string   `json:"username" binding:"required"`
+	Password string   `json:"password"`
+	Role     string   `json:"role" binding:"required"`
+	Devices  []string `json:"devices"`
+	Enabled  *bool    `json:"enabled"`
+}
+
+// WebSocket代理主题访问规则，Subscribe/Publish 为 allow、deny 或空（不适用）
+type ProxyACLRule struct {
+	ID          string `json:"id"`
+	Role        string `json:"role"`
+	Topic       string `json:"topic"`
+	Subscribe   string `json:"subscribe"`
+	Publish     string `json:"publish"`
+	Priority    int    `json:"priority"`
+	Description string `json:"description"`
+	CreatedAt   int64  `json:"created_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+type ProxyACLRulePayload struct {
+	Role        string `json:"role" binding:"required"`
+	Topic       string `json:"topic" binding:"required"`
+	Subscribe   string `json:"subscribe"`
+	Publish     string `json:"publish"`
+	Priority    int    `json:"priority"`
+	Description string `json:"description"`
+}
+
+type ProxyACLCheckRequest struct {
+	Username string `json:"username" binding:"required"`
+	Action   string `json:"action" binding:"required"`
+	Topic    string `json:"topic" binding:"required"`
+}
+
+// MQTT抓包，Source 为 recorded（后端连接录制）或 imported（上传）
+type Capture struct {
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	Format          string   `json:"format"`
+	Source          string   `json:"source"`
+	Topics          []string `json:"topics"`
+	IncludeOutgoing bool     `json:"include_outgoing"`
+	Status          string   `json:"status"`
+	Messages        int64    `json:"messages"`
+	Dropped         int64    `json:"dropped"`
+	FileSize        int64    `json:"file_size"`
+	FirstAt         *int64   `json:"first_at"`
+	LastAt          *int64   `json:"last_at"`
+	ErrorMessage    string   `json:"error_message"`
+	Operator        string   `json:"operator"`
+	CreatedAt       int64    `json:"created_at"`
+	FinishedAt      *int64   `json:"finished_at"`
+}
+
+// 开始录制，MaxDuration 为秒数，MaxDuration/MaxMessages 为0时不限制
+type CaptureRecordPayload struct {
+	Name            string   `json:"name"`
+	Topics          []string `json:"topics" binding:"required,min=1"`
+	Format          string   `json:"format"`
+	IncludeOutgoing bool     `json:"include_outgoing"`
+	MaxDuration     int      `json:"max_duration"`
+	MaxMessages     int64    `json:"max_messages"`
+	Operator        string   `json:"operator"`
+}
+
+// 回放抓包，Target 为 broker 或 proxy，Speed 为倍速，Mode 为 realtime 或 step
+type CaptureReplayPayload struct {
+	Target          string   `json:"target" binding:"required"`
+	ProfileID       string   `json:"profile_id"`
+	Upstream        string   `json:"upstream"`
+	Speed           float64  `json:"speed"`
+	Mode            string   `json:"mode"`
+	Topics          []string `json:"topics"`
+	IncludeOutgoing bool     `json:"include_outgoing"`
+}
+
+type CaptureReplay struct {
+	ID              string   `json:"id"`
+	CaptureID       string   `json:"capture_id"`
+	Target          string   `json:"target"`
+	Broker          string   `json:"broker,omitempty"`
+	Upstream        string   `json:"upstream,omitempty"`
+	Speed           float64  `json:"speed"`
+	Mode            string   `json:"mode"`
+	Topics          []string `json:"topics"`
+	IncludeOutgoing bool     `json:"include_outgoing"`
+	Status          string   `json:"status"`
+	Total           int64    `json:"total"`
+	Position        int64    `json:"position"`
+	Published       int64    `json:"published"`
+	Pending         int      `json:"pending"`
+	ErrorMessage    string   `json:"error_message"`
+	StartedAt       int64    `json:"started_at"`
+	FinishedAt      *int64   `json:"finished_at"`
+}
+
+type CaptureReplayStepPayload struct {
+	Count int `json:"count"`
+}
+
+// DRC 指令飞行相关
+type DrcSession struct {
+	SN         string `json:"sn"`
+	Operator   string `json:"operator"`
+	RemoteAddr string `json:"remote_addr"`
+	Seq        int64  `json:"seq"`
+	LinkLost   bool   `json:"link_lost"`
+	StartedAt  int64  `json:"started_at"`
+	LastUpAt   *int64 `json:"last_up_at"`
+}
+
+type DrcEnterQuery struct {
+	Operator     string `form:"operator" binding:"required"`
+	OSDFrequency int    `form:"osd_frequency" binding:"omitempty,min=1,max=30"`
+	HSIFrequency int    `form:"hsi_frequency" binding:"omitempty,min=1,max=30"`
+}
+
+// DrcSocketMessage DRC WebSocket 下行给前端的消息
+type DrcSocketMessage struct {
+	Type      string          `json:"type"`
+	Method    string          `json:"method,omitempty"`
+	Seq       int64           `json:"seq,omitempty"`
+	Timestamp int64           `json:"timestamp"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Message   string          `json:"message,omitempty"`
+}
+
 // 错误码相关
 type ErrorCode struct {
-	Code        string `json:"code"`
-	Description string `json:"description"`
-	Category    string `json:"category"`
-	Severity    string `json:"severity"`
+	Code          string `json:"code"`
+	Description   string `json:"description"`
+	Category      string `json:"category"`
+	CategoryLabel string `json:"category_label"`
+	Severity      string `json:"severity"`
+}
+
+type ErrorCodeCategory struct {
+	Value    string   `json:"value"`
+	Label    string   `json:"label"`
+	Prefixes []string `json:"prefixes"`
+}
+
+type ErrorCodeQuery struct {
+	Q        string `form:"q"`
+	Category string `form:"category"`
+	Severity string `form:"severity"`
+	Page     int    `form:"page"`
+	PageSize int    `form:"page_size"`
 }
 
 // Redis相关
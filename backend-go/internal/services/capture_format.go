@@ -0,0 +1,325 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// 抓包文件格式
+const (
+	// 每行一条JSON记录，便于直接查看与 grep
+	CaptureFormatJSONL = "jsonl"
+	// 主题字典与变长整数编码的紧凑格式，适合长时间录制 osd
+	CaptureFormatBinary = "binary"
+)
+
+// 二进制抓包文件头
+var captureBinaryMagic = []byte("DPCAP\x01")
+
+// 二进制记录 flags：低2位为QoS
+const (
+	captureFlagRetain   = 1 << 2
+	captureFlagOutgoing = 1 << 3
+)
+
+// 单条记录的主题与消息体上限，超出视为文件损坏
+const (
+	captureMaxTopicLen   = 64 * 1024
+	captureMaxPayloadLen = 64 * 1024 * 1024
+)
+
+// CaptureRecord 抓包文件中的一条消息，Timestamp 为收到或发布时的毫秒时间戳
+type CaptureRecord struct {
+	Timestamp int64
+	Topic     string
+	QoS       byte
+	Retain    bool
+	Outgoing  bool
+	Payload   []byte
+}
+
+// captureJSONRecord JSONL 格式的一行，消息体不是合法UTF-8时以 base64 保存
+type captureJSONRecord struct {
+	Timestamp     int64  `json:"ts"`
+	Topic         string `json:"topic"`
+	QoS           byte   `json:"qos"`
+	Retain        bool   `json:"retain,omitempty"`
+	Outgoing      bool   `json:"outgoing,omitempty"`
+	Payload       string `json:"payload,omitempty"`
+	PayloadBase64 string `json:"payload_base64,omitempty"`
+}
+
+// captureWriter 顺序写入抓包记录
+type captureWriter interface {
+	Write(record *CaptureRecord) error
+	Flush() error
+	Close() error
+}
+
+// captureReader 顺序读取抓包记录，读完时返回 io.EOF
+type captureReader interface {
+	Next() (*CaptureRecord, error)
+	Close() error
+}
+
+// createCaptureFile 按格式创建抓包文件
+func createCaptureFile(path, format string) (captureWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewWriter(file)
+
+	switch format {
+	case CaptureFormatJSONL:
+		return &jsonlCaptureWriter{file: file, buffered: buffered, encoder: json.NewEncoder(buffered)}, nil
+	case CaptureFormatBinary:
+		if _, err := buffered.Write(captureBinaryMagic); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &binaryCaptureWriter{file: file, buffered: buffered, topics: make(map[string]uint64)}, nil
+	default:
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("不支持的抓包格式: %s", format)
+	}
+}
+
+// openCaptureFile 打开抓包文件，按文件头识别格式
+func openCaptureFile(path string) (captureReader, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	buffered := bufio.NewReaderSize(file, 64*1024)
+
+	header, err := buffered.Peek(len(captureBinaryMagic))
+	if err == nil && bytes.Equal(header, captureBinaryMagic) {
+		buffered.Discard(len(captureBinaryMagic))
+		return &binaryCaptureReader{file: file, buffered: buffered}, CaptureFormatBinary, nil
+	}
+
+	scanner := bufio.NewScanner(buffered)
+	scanner.Buffer(make([]byte, 64*1024), captureMaxPayloadLen*2)
+	return &jsonlCaptureReader{file: file, scanner: scanner}, CaptureFormatJSONL, nil
+}
+
+type jsonlCaptureWriter struct {
+	file     *os.File
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+func (w *jsonlCaptureWriter) Write(record *CaptureRecord) error {
+	line := captureJSONRecord{
+		Timestamp: record.Timestamp,
+		Topic:     record.Topic,
+		QoS:       record.QoS,
+		Retain:    record.Retain,
+		Outgoing:  record.Outgoing,
+	}
+	if utf8.Valid(record.Payload) {
+		line.Payload = string(record.Payload)
+	} else {
+		line.PayloadBase64 = base64.StdEncoding.EncodeToString(record.Payload)
+	}
+	return w.encoder.Encode(&line)
+}
+
+func (w *jsonlCaptureWriter) Flush() error {
+	return w.buffered.Flush()
+}
+
+func (w *jsonlCaptureWriter) Close() error {
+	if err := w.buffered.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+type jsonlCaptureReader struct {
+	file    *os.File
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *jsonlCaptureReader) Next() (*CaptureRecord, error) {
+	for r.scanner.Scan() {
+		r.line++
+		data := bytes.TrimSpace(r.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var line captureJSONRecord
+		if err := json.Unmarshal(data, &line); err != nil {
+			return nil, fmt.Errorf("第%d行解析失败: %v", r.line, err)
+		}
+		if line.Topic == "" {
+			return nil, fmt.Errorf("第%d行缺少topic", r.line)
+		}
+		record := &CaptureRecord{
+			Timestamp: line.Timestamp,
+			Topic:     line.Topic,
+			QoS:       line.QoS,
+			Retain:    line.Retain,
+			Outgoing:  line.Outgoing,
+			Payload:   []byte(line.Payload),
+		}
+		if line.PayloadBase64 != "" {
+			payload, err := base64.StdEncoding.DecodeString(line.PayloadBase64)
+			if err != nil {
+				return nil, fmt.Errorf("第%d行 payload_base64 解码失败: %v", r.line, err)
+			}
+			record.Payload = payload
+		}
+		return record, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (r *jsonlCaptureReader) Close() error {
+	return r.file.Close()
+}
+
+// binaryCaptureWriter 每条记录依次为：与上一条的时间差（varint 毫秒）、flags、主题编号（uvarint，
+// 等于已有主题数时随后写入新主题的长度与内容）、消息体长度（uvarint）与消息体
+type binaryCaptureWriter struct {
+	file     *os.File
+	buffered *bufio.Writer
+	topics   map[string]uint64
+	last     int64
+	scratch  [binary.MaxVarintLen64]byte
+}
+
+func (w *binaryCaptureWriter) Write(record *CaptureRecord) error {
+	w.buffered.Write(w.scratch[:binary.PutVarint(w.scratch[:], record.Timestamp-w.last)])
+	w.last = record.Timestamp
+
+	flags := record.QoS & 0x03
+	if record.Retain {
+		flags |= captureFlagRetain
+	}
+	if record.Outgoing {
+		flags |= captureFlagOutgoing
+	}
+	w.buffered.WriteByte(flags)
+
+	index, ok := w.topics[record.Topic]
+	if !ok {
+		index = uint64(len(w.topics))
+		w.topics[record.Topic] = index
+	}
+	w.buffered.Write(w.scratch[:binary.PutUvarint(w.scratch[:], index)])
+	if !ok {
+		w.buffered.Write(w.scratch[:binary.PutUvarint(w.scratch[:], uint64(len(record.Topic)))])
+		w.buffered.WriteString(record.Topic)
+	}
+
+	w.buffered.Write(w.scratch[:binary.PutUvarint(w.scratch[:], uint64(len(record.Payload)))])
+	_, err := w.buffered.Write(record.Payload)
+	return err
+}
+
+func (w *binaryCaptureWriter) Flush() error {
+	return w.buffered.Flush()
+}
+
+func (w *binaryCaptureWriter) Close() error {
+	if err := w.buffered.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+type binaryCaptureReader struct {
+	file     *os.File
+	buffered *bufio.Reader
+	topics   []string
+	last     int64
+}
+
+func (r *binaryCaptureReader) Next() (*CaptureRecord, error) {
+	delta, err := binary.ReadVarint(r.buffered)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, r.corrupted(err)
+	}
+	flags, err := r.buffered.ReadByte()
+	if err != nil {
+		return nil, r.corrupted(err)
+	}
+
+	index, err := binary.ReadUvarint(r.buffered)
+	if err != nil {
+		return nil, r.corrupted(err)
+	}
+	switch {
+	case index == uint64(len(r.topics)):
+		topic, err := r.readBytes(captureMaxTopicLen)
+		if err != nil {
+			return nil, err
+		}
+		r.topics = append(r.topics, string(topic))
+	case index > uint64(len(r.topics)):
+		return nil, r.corrupted(fmt.Errorf("主题编号 %d 超出范围", index))
+	}
+
+	payload, err := r.readBytes(captureMaxPayloadLen)
+	if err != nil {
+		return nil, err
+	}
+
+	r.last += delta
+	return &CaptureRecord{
+		Timestamp: r.last,
+		Topic:     r.topics[index],
+		QoS:       flags & 0x03,
+		Retain:    flags&captureFlagRetain != 0,
+		Outgoing:  flags&captureFlagOutgoing != 0,
+		Payload:   payload,
+	}, nil
+}
+
+// readBytes 读取带 uvarint 长度前缀的字节串
+func (r *binaryCaptureReader) readBytes(limit uint64) ([]byte, error) {
+	length, err := binary.ReadUvarint(r.buffered)
+	if err != nil {
+		return nil, r.corrupted(err)
+	}
+	if length > limit {
+		return nil, r.corrupted(fmt.Errorf("长度 %d 超出上限", length))
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.buffered, data); err != nil {
+		return nil, r.corrupted(err)
+	}
+	return data, nil
+}
+
+// corrupted 文件截断或损坏，录制中断时最后一条记录可能不完整
+func (r *binaryCaptureReader) corrupted(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("抓包文件损坏: %v", err)
+}
+
+func (r *binaryCaptureReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,481 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// 回放目标
+const (
+	// 通过MQTT配置连接broker重新发布
+	CaptureReplayTargetBroker = "broker"
+	// 直接注入WebSocket代理的分发流程，不经过broker
+	CaptureReplayTargetProxy = "proxy"
+)
+
+// 回放节奏
+const (
+	// 按录制时的时间间隔除以倍速发布
+	CaptureReplayModeRealtime = "realtime"
+	// 每次 step 请求发布指定条数
+	CaptureReplayModeStep = "step"
+)
+
+// 回放状态
+const (
+	CaptureReplayStatusRunning   = "running"
+	CaptureReplayStatusCompleted = "completed"
+	CaptureReplayStatusStopped   = "stopped"
+	CaptureReplayStatusFailed    = "failed"
+)
+
+// 内存中保留的已结束回放数
+const captureReplayHistory = 50
+
+// captureReplay 进行中或已结束的回放
+type captureReplay struct {
+	info    models.CaptureReplay
+	client  BrokerClient
+	credits int
+
+	notify   chan struct{}
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	mutex    sync.Mutex
+}
+
+// stop 通知回放协程结束
+func (r *captureReplay) stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+}
+
+// snapshot 返回回放状态副本
+func (r *captureReplay) snapshot() models.CaptureReplay {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	info := r.info
+	info.Pending = r.credits
+	return info
+}
+
+// 开始回放抓包，broker 目标使用 profile_id 指定的MQTT配置（默认使用默认配置），
+// 默认不回放后端自身发布的消息，避免向真实设备重复下发指令
+func (s *CaptureService) StartReplay(captureID string, payload *models.CaptureReplayPayload) (*models.APIResponse, error) {
+	capture, err := s.getCapture(captureID)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "抓包不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取抓包失败: %v", err),
+		}, err
+	}
+	if capture.Status == CaptureStatusRecording {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "抓包正在录制，请先结束录制",
+		}, nil
+	}
+
+	if payload.Mode == "" {
+		payload.Mode = CaptureReplayModeRealtime
+	}
+	if payload.Mode != CaptureReplayModeRealtime && payload.Mode != CaptureReplayModeStep {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "不支持的回放模式: " + payload.Mode,
+		}, nil
+	}
+	if payload.Speed == 0 {
+		payload.Speed = 1
+	}
+	if payload.Speed < 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "speed 必须大于0",
+		}, nil
+	}
+	for _, filter := range payload.Topics {
+		if !validMQTTFilter(filter) {
+			return &models.APIResponse{
+				Code:    1,
+				Message: "无效的主题过滤器: " + filter,
+			}, nil
+		}
+	}
+	if payload.Topics == nil {
+		payload.Topics = []string{}
+	}
+
+	replay := &captureReplay{
+		info: models.CaptureReplay{
+			ID:              uuid.New().String(),
+			CaptureID:       capture.ID,
+			Target:          payload.Target,
+			Speed:           payload.Speed,
+			Mode:            payload.Mode,
+			Topics:          payload.Topics,
+			IncludeOutgoing: payload.IncludeOutgoing,
+			Status:          CaptureReplayStatusRunning,
+			Total:           capture.Messages,
+			StartedAt:       time.Now().UnixMilli(),
+		},
+		notify: make(chan struct{}, 1),
+		stopCh: make(chan struct{}),
+	}
+
+	switch payload.Target {
+	case CaptureReplayTargetProxy:
+		if len(s.mqttProxy.matchUpstreams(payload.Upstream)) == 0 {
+			return &models.APIResponse{
+				Code:    1,
+				Message: "没有可注入的WebSocket代理上游连接",
+			}, nil
+		}
+		replay.info.Upstream = payload.Upstream
+	case CaptureReplayTargetBroker:
+		client, broker, msg, err := s.replayClient(payload.ProfileID, replay.info.ID)
+		if msg != "" {
+			return &models.APIResponse{
+				Code:    1,
+				Message: msg,
+			}, err
+		}
+		replay.client = client
+		replay.info.Broker = broker
+	default:
+		return &models.APIResponse{
+			Code:    1,
+			Message: "不支持的回放目标: " + payload.Target,
+		}, nil
+	}
+
+	reader, _, err := openCaptureFile(s.filePath(capture.ID, capture.Format))
+	if err != nil {
+		if replay.client != nil {
+			replay.client.Disconnect()
+		}
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("打开抓包文件失败: %v", err),
+		}, err
+	}
+
+	s.mutex.Lock()
+	s.pruneReplays()
+	s.replays[replay.info.ID] = replay
+	s.mutex.Unlock()
+
+	go s.replay(replay, reader)
+
+	log.Printf("开始回放抓包 %s -> %s, mode: %s, speed: %g", capture.Name, payload.Target, payload.Mode, payload.Speed)
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "开始回放",
+		Data:    replay.snapshot(),
+	}, nil
+}
+
+// replayClient 按MQTT配置建立回放使用的连接，失败时返回错误说明
+func (s *CaptureService) replayClient(profileID, replayID string) (BrokerClient, string, string, error) {
+	var profile *models.MQTTProfile
+	var err error
+	if profileID != "" {
+		profile, err = s.mqttService.FindProfile(profileID)
+	} else {
+		profile, err = s.mqttService.GetDefaultProfile()
+	}
+	if err == sql.ErrNoRows {
+		return nil, "", "MQTT配置不存在", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Sprintf("获取MQTT配置失败: %v", err), err
+	}
+
+	conn := brokerConnectionFromConfig(profile.Config)
+	conn.ClientID = "replay_" + replayID[:8]
+	client, err := newBrokerClient(conn, s.embeddedBroker, BrokerClientOptions{
+		CleanSession:   true,
+		ConnectTimeout: 10 * time.Second,
+		KeepAlive:      30 * time.Second,
+	})
+	if err != nil {
+		return nil, "", fmt.Sprintf("MQTT配置错误: %v", err), nil
+	}
+	if err := client.Connect(); err != nil {
+		return nil, "", fmt.Sprintf("连接MQTT服务器失败: %v", err), nil
+	}
+	return client, client.Server(), "", nil
+}
+
+// pruneReplays 只保留最近结束的回放，调用方需持有 mutex
+func (s *CaptureService) pruneReplays() {
+	finished := []models.CaptureReplay{}
+	for _, replay := range s.replays {
+		if info := replay.snapshot(); info.FinishedAt != nil {
+			finished = append(finished, info)
+		}
+	}
+	if len(finished) < captureReplayHistory {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return *finished[i].FinishedAt < *finished[j].FinishedAt
+	})
+	for _, info := range finished[:len(finished)-captureReplayHistory+1] {
+		delete(s.replays, info.ID)
+	}
+}
+
+// replay 顺序读取抓包并发布，realtime 模式按录制间隔除以倍速等待，step 模式等待 step 请求
+func (s *CaptureService) replay(replay *captureReplay, reader captureReader) {
+	defer reader.Close()
+
+	status, errorMessage := CaptureReplayStatusCompleted, ""
+	var previous int64
+	started := false
+
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			status, errorMessage = CaptureReplayStatusFailed, err.Error()
+			break
+		}
+
+		replay.mutex.Lock()
+		replay.info.Position++
+		replay.mutex.Unlock()
+		if !replay.selects(record) {
+			continue
+		}
+
+		if replay.info.Mode == CaptureReplayModeStep {
+			if !replay.waitStep() {
+				status = CaptureReplayStatusStopped
+				break
+			}
+		} else if started {
+			delay := time.Duration(float64(record.Timestamp-previous) / replay.info.Speed * float64(time.Millisecond))
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				select {
+				case <-timer.C:
+				case <-replay.stopCh:
+					timer.Stop()
+				}
+			}
+		}
+		previous, started = record.Timestamp, true
+
+		select {
+		case <-replay.stopCh:
+			status = CaptureReplayStatusStopped
+		default:
+		}
+		if status == CaptureReplayStatusStopped {
+			break
+		}
+
+		if err := s.publishReplay(replay, record); err != nil {
+			status, errorMessage = CaptureReplayStatusFailed, err.Error()
+			break
+		}
+		replay.mutex.Lock()
+		replay.info.Published++
+		replay.mutex.Unlock()
+	}
+
+	if replay.client != nil {
+		replay.client.Disconnect()
+	}
+
+	now := time.Now().UnixMilli()
+	replay.mutex.Lock()
+	replay.info.Status = status
+	replay.info.ErrorMessage = errorMessage
+	replay.info.FinishedAt = &now
+	replay.credits = 0
+	published := replay.info.Published
+	replay.mutex.Unlock()
+
+	log.Printf("抓包回放 %s 结束: %s, 已发布 %d 条 %s", replay.info.ID, status, published, errorMessage)
+}
+
+// selects 按主题过滤器与方向筛选回放的消息
+func (r *captureReplay) selects(record *CaptureRecord) bool {
+	if record.Outgoing && !r.info.IncludeOutgoing {
+		return false
+	}
+	if len(r.info.Topics) == 0 {
+		return true
+	}
+	for _, filter := range r.info.Topics {
+		if mqttTopicMatches(filter, record.Topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitStep 等待一条 step 额度，回放被停止时返回 false
+func (r *captureReplay) waitStep() bool {
+	for {
+		r.mutex.Lock()
+		if r.credits > 0 {
+			r.credits--
+			r.mutex.Unlock()
+			return true
+		}
+		r.mutex.Unlock()
+
+		select {
+		case <-r.notify:
+		case <-r.stopCh:
+			return false
+		}
+	}
+}
+
+// publishReplay 发布到broker或注入WebSocket代理
+func (s *CaptureService) publishReplay(replay *captureReplay, record *CaptureRecord) error {
+	msg := &BrokerMessage{
+		Topic:    record.Topic,
+		Payload:  record.Payload,
+		QoS:      record.QoS,
+		Retained: record.Retain,
+	}
+	if replay.client != nil {
+		return replay.client.Publish(msg)
+	}
+	s.mqttProxy.InjectMessage(replay.info.Upstream, msg)
+	return nil
+}
+
+// 获取回放列表
+func (s *CaptureService) GetReplays() (*models.APIResponse, error) {
+	s.mutex.Lock()
+	replays := make([]models.CaptureReplay, 0, len(s.replays))
+	for _, replay := range s.replays {
+		replays = append(replays, replay.snapshot())
+	}
+	s.mutex.Unlock()
+
+	sort.Slice(replays, func(i, j int) bool {
+		return replays[i].StartedAt > replays[j].StartedAt
+	})
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    replays,
+	}, nil
+}
+
+// 获取回放状态
+func (s *CaptureService) GetReplay(id string) (*models.APIResponse, error) {
+	replay, ok := s.findReplay(id)
+	if !ok {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "回放不存在",
+		}, nil
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    replay.snapshot(),
+	}, nil
+}
+
+// findReplay 按ID查找回放
+func (s *CaptureService) findReplay(id string) (*captureReplay, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	replay, ok := s.replays[id]
+	return replay, ok
+}
+
+// step 模式下继续发布 count 条消息
+func (s *CaptureService) StepReplay(id string, count int) (*models.APIResponse, error) {
+	replay, ok := s.findReplay(id)
+	if !ok {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "回放不存在",
+		}, nil
+	}
+	if count <= 0 {
+		count = 1
+	}
+
+	replay.mutex.Lock()
+	mode, status := replay.info.Mode, replay.info.Status
+	if mode == CaptureReplayModeStep && status == CaptureReplayStatusRunning {
+		replay.credits += count
+	}
+	replay.mutex.Unlock()
+
+	if mode != CaptureReplayModeStep {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "只有 step 模式的回放可以单步发布",
+		}, nil
+	}
+	if status != CaptureReplayStatusRunning {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "回放已结束",
+		}, nil
+	}
+
+	select {
+	case replay.notify <- struct{}{}:
+	default:
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    replay.snapshot(),
+	}, nil
+}
+
+// 停止回放
+func (s *CaptureService) StopReplay(id string) (*models.APIResponse, error) {
+	replay, ok := s.findReplay(id)
+	if !ok {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "回放不存在",
+		}, nil
+	}
+
+	replay.stop()
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "回放已停止",
+	}, nil
+}
@@ -0,0 +1,676 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// 抓包状态
+const (
+	CaptureStatusRecording = "recording"
+	CaptureStatusCompleted = "completed"
+	CaptureStatusFailed    = "failed"
+	// 录制期间后端重启，文件保留已写入的记录
+	CaptureStatusInterrupted = "interrupted"
+)
+
+// 抓包来源
+const (
+	CaptureSourceRecorded = "recorded"
+	CaptureSourceImported = "imported"
+)
+
+// 上传抓包文件的大小上限
+const CaptureMaxFileSize = 512 * 1024 * 1024
+
+const (
+	// 录制队列长度，写入跟不上时丢弃新消息并计数
+	captureQueueSize = 4096
+	// 录制文件落盘与进度更新的间隔
+	captureFlushInterval = time.Second
+)
+
+// captureRecording 进行中的录制，消息由后端MQTT连接的旁路放入队列，单独的协程写入文件
+type captureRecording struct {
+	id              string
+	filters         []string
+	includeOutgoing bool
+	maxMessages     int64
+	writer          captureWriter
+
+	queue    chan *CaptureRecord
+	messages int64
+	dropped  int64
+	firstAt  int64
+	lastAt   int64
+	tap      int
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// stop 通知录制协程结束
+func (r *captureRecording) stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+}
+
+// CaptureService 录制后端MQTT连接的消息到抓包文件，并回放到broker或WebSocket代理
+type CaptureService struct {
+	db             *database.DB
+	ingestService  *IngestService
+	mqttService    *MQTTService
+	embeddedBroker *EmbeddedBroker
+	mqttProxy      *MQTTProxyService
+	dir            string
+
+	recordings map[string]*captureRecording
+	replays    map[string]*captureReplay
+	mutex      sync.Mutex
+}
+
+// NewCaptureService 创建抓包服务，抓包文件保存在 dir
+func NewCaptureService(db *database.DB, ingestService *IngestService, mqttService *MQTTService, embeddedBroker *EmbeddedBroker,
+	mqttProxy *MQTTProxyService, dir string) *CaptureService {
+	return &CaptureService{
+		db:             db,
+		ingestService:  ingestService,
+		mqttService:    mqttService,
+		embeddedBroker: embeddedBroker,
+		mqttProxy:      mqttProxy,
+		dir:            dir,
+		recordings:     make(map[string]*captureRecording),
+		replays:        make(map[string]*captureReplay),
+	}
+}
+
+// Start 将上次进程未结束的录制标记为中断
+func (s *CaptureService) Start() {
+	rows, err := s.db.Query("SELECT id, format FROM captures WHERE status = ?", CaptureStatusRecording)
+	if err != nil {
+		log.Printf("查询未结束的录制失败: %v", err)
+		return
+	}
+	interrupted := map[string]string{}
+	for rows.Next() {
+		var id, format string
+		if err := rows.Scan(&id, &format); err == nil {
+			interrupted[id] = format
+		}
+	}
+	rows.Close()
+
+	now := time.Now().UnixMilli()
+	for id, format := range interrupted {
+		var size int64
+		if info, err := os.Stat(s.filePath(id, format)); err == nil {
+			size = info.Size()
+		}
+		if _, err := s.db.Exec("UPDATE captures SET status = ?, file_size = ?, error_message = ?, finished_at = ? WHERE id = ?",
+			CaptureStatusInterrupted, size, "录制期间后端重启", now, id); err != nil {
+			log.Printf("更新录制状态失败 %s: %v", id, err)
+		}
+	}
+}
+
+// Stop 结束所有录制与回放
+func (s *CaptureService) Stop() {
+	s.mutex.Lock()
+	recordings := make([]*captureRecording, 0, len(s.recordings))
+	for _, recording := range s.recordings {
+		recordings = append(recordings, recording)
+	}
+	replays := make([]*captureReplay, 0, len(s.replays))
+	for _, replay := range s.replays {
+		replays = append(replays, replay)
+	}
+	s.mutex.Unlock()
+
+	for _, replay := range replays {
+		replay.stop()
+	}
+	for _, recording := range recordings {
+		recording.stop()
+		<-recording.done
+	}
+}
+
+// filePath 抓包文件路径
+func (s *CaptureService) filePath(id, format string) string {
+	return filepath.Join(s.dir, id+captureFileExt(format))
+}
+
+// captureFileExt 抓包格式对应的扩展名
+func captureFileExt(format string) string {
+	if format == CaptureFormatBinary {
+		return ".dpcap"
+	}
+	return ".jsonl"
+}
+
+// 开始录制后端MQTT连接上匹配主题过滤器的消息
+func (s *CaptureService) StartRecording(payload *models.CaptureRecordPayload) (*models.APIResponse, error) {
+	if payload.Format == "" {
+		payload.Format = CaptureFormatJSONL
+	}
+	if payload.Format != CaptureFormatJSONL && payload.Format != CaptureFormatBinary {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "不支持的抓包格式: " + payload.Format,
+		}, nil
+	}
+	for _, filter := range payload.Topics {
+		if !validMQTTFilter(filter) {
+			return &models.APIResponse{
+				Code:    1,
+				Message: "无效的主题过滤器: " + filter,
+			}, nil
+		}
+	}
+	if payload.MaxDuration < 0 || payload.MaxMessages < 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "max_duration 与 max_messages 不能为负数",
+		}, nil
+	}
+
+	id := uuid.New().String()
+	now := time.Now()
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		name = "capture-" + now.Format("20060102-150405")
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建抓包目录失败: %v", err),
+		}, err
+	}
+	writer, err := createCaptureFile(s.filePath(id, payload.Format), payload.Format)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建抓包文件失败: %v", err),
+		}, err
+	}
+
+	topics, _ := json.Marshal(payload.Topics)
+	_, err = s.db.Exec(`INSERT INTO captures (id, name, format, source, topics, include_outgoing, status, operator, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, name, payload.Format, CaptureSourceRecorded, string(topics), payload.IncludeOutgoing, CaptureStatusRecording,
+		payload.Operator, now.UnixMilli())
+	if err != nil {
+		writer.Close()
+		os.Remove(s.filePath(id, payload.Format))
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建抓包记录失败: %v", err),
+		}, err
+	}
+
+	recording := &captureRecording{
+		id:              id,
+		filters:         payload.Topics,
+		includeOutgoing: payload.IncludeOutgoing,
+		maxMessages:     payload.MaxMessages,
+		writer:          writer,
+		queue:           make(chan *CaptureRecord, captureQueueSize),
+		stopCh:          make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+
+	s.mutex.Lock()
+	s.recordings[id] = recording
+	s.mutex.Unlock()
+
+	recording.tap = s.ingestService.AddTap(func(msg *BrokerMessage, outgoing bool) {
+		s.capture(recording, msg, outgoing)
+	})
+	go s.record(recording, time.Duration(payload.MaxDuration)*time.Second)
+
+	log.Printf("开始录制MQTT消息 %s: %v", name, payload.Topics)
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "开始录制",
+		Data:    map[string]string{"id": id},
+	}, nil
+}
+
+// capture 在MQTT回调中筛选消息放入录制队列，队列满时丢弃
+func (s *CaptureService) capture(recording *captureRecording, msg *BrokerMessage, outgoing bool) {
+	if outgoing && !recording.includeOutgoing {
+		return
+	}
+	matched := false
+	for _, filter := range recording.filters {
+		if mqttTopicMatches(filter, msg.Topic) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return
+	}
+
+	record := &CaptureRecord{
+		Timestamp: time.Now().UnixMilli(),
+		Topic:     msg.Topic,
+		QoS:       msg.QoS,
+		Retain:    msg.Retained,
+		Outgoing:  outgoing,
+		Payload:   msg.Payload,
+	}
+	select {
+	case recording.queue <- record:
+	default:
+		atomic.AddInt64(&recording.dropped, 1)
+	}
+}
+
+// record 写入队列中的消息，定期落盘并更新进度，达到时长或条数上限时结束
+func (s *CaptureService) record(recording *captureRecording, maxDuration time.Duration) {
+	defer close(recording.done)
+
+	var deadline <-chan time.Time
+	if maxDuration > 0 {
+		timer := time.NewTimer(maxDuration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	flushTicker := time.NewTicker(captureFlushInterval)
+	defer flushTicker.Stop()
+
+	var writeErr error
+	write := func(record *CaptureRecord) {
+		if writeErr != nil {
+			return
+		}
+		if writeErr = recording.writer.Write(record); writeErr != nil {
+			return
+		}
+		if atomic.AddInt64(&recording.messages, 1) == 1 {
+			atomic.StoreInt64(&recording.firstAt, record.Timestamp)
+		}
+		atomic.StoreInt64(&recording.lastAt, record.Timestamp)
+	}
+	full := func() bool {
+		return recording.maxMessages > 0 && atomic.LoadInt64(&recording.messages) >= recording.maxMessages
+	}
+
+	saved := int64(0)
+loop:
+	for writeErr == nil && !full() {
+		select {
+		case record := <-recording.queue:
+			write(record)
+		case <-flushTicker.C:
+			messages := atomic.LoadInt64(&recording.messages)
+			if messages == saved {
+				continue
+			}
+			if writeErr = recording.writer.Flush(); writeErr == nil {
+				s.saveProgress(recording, CaptureStatusRecording, "")
+				saved = messages
+			}
+		case <-deadline:
+			break loop
+		case <-recording.stopCh:
+			break loop
+		}
+	}
+
+	// 停止旁路后写入队列中剩余的消息
+	s.ingestService.RemoveTap(recording.tap)
+	for drained := false; !drained && writeErr == nil && !full(); {
+		select {
+		case record := <-recording.queue:
+			write(record)
+		default:
+			drained = true
+		}
+	}
+
+	if err := recording.writer.Close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+
+	s.mutex.Lock()
+	delete(s.recordings, recording.id)
+	s.mutex.Unlock()
+
+	if writeErr != nil {
+		log.Printf("录制MQTT消息失败 %s: %v", recording.id, writeErr)
+		s.saveProgress(recording, CaptureStatusFailed, writeErr.Error())
+		return
+	}
+	log.Printf("结束录制MQTT消息 %s: %d 条", recording.id, atomic.LoadInt64(&recording.messages))
+	s.saveProgress(recording, CaptureStatusCompleted, "")
+}
+
+// saveProgress 更新抓包的消息数、文件大小与状态，结束时写入完成时间
+func (s *CaptureService) saveProgress(recording *captureRecording, status, errorMessage string) {
+	var format string
+	if err := s.db.QueryRow("SELECT format FROM captures WHERE id = ?", recording.id).Scan(&format); err != nil {
+		log.Printf("查询抓包记录失败 %s: %v", recording.id, err)
+		return
+	}
+	var size int64
+	if info, err := os.Stat(s.filePath(recording.id, format)); err == nil {
+		size = info.Size()
+	}
+
+	var firstAt, lastAt, finishedAt interface{}
+	if atomic.LoadInt64(&recording.messages) > 0 {
+		firstAt = atomic.LoadInt64(&recording.firstAt)
+		lastAt = atomic.LoadInt64(&recording.lastAt)
+	}
+	if status != CaptureStatusRecording {
+		finishedAt = time.Now().UnixMilli()
+	}
+
+	_, err := s.db.Exec(`UPDATE captures SET status = ?, messages = ?, dropped = ?, file_size = ?, first_at = ?, last_at = ?,
+		error_message = ?, finished_at = ? WHERE id = ?`,
+		status, atomic.LoadInt64(&recording.messages), atomic.LoadInt64(&recording.dropped), size, firstAt, lastAt,
+		errorMessage, finishedAt, recording.id)
+	if err != nil {
+		log.Printf("更新抓包记录失败 %s: %v", recording.id, err)
+	}
+}
+
+// 结束录制，等待队列中的消息写入文件
+func (s *CaptureService) StopRecording(id string) (*models.APIResponse, error) {
+	s.mutex.Lock()
+	recording, ok := s.recordings[id]
+	s.mutex.Unlock()
+	if !ok {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "录制不存在或已结束",
+		}, nil
+	}
+
+	recording.stop()
+	<-recording.done
+	return s.GetCapture(id)
+}
+
+// scanCapture 读取一行抓包记录，进行中的录制使用内存中的计数
+func (s *CaptureService) scanCapture(scanner interface{ Scan(...interface{}) error }) (models.Capture, error) {
+	var capture models.Capture
+	var topics string
+	var firstAt, lastAt, finishedAt sql.NullInt64
+	err := scanner.Scan(&capture.ID, &capture.Name, &capture.Format, &capture.Source, &topics, &capture.IncludeOutgoing,
+		&capture.Status, &capture.Messages, &capture.Dropped, &capture.FileSize, &firstAt, &lastAt, &capture.ErrorMessage,
+		&capture.Operator, &capture.CreatedAt, &finishedAt)
+	if err != nil {
+		return capture, err
+	}
+	capture.Topics = []string{}
+	json.Unmarshal([]byte(topics), &capture.Topics)
+	if firstAt.Valid {
+		capture.FirstAt = &firstAt.Int64
+	}
+	if lastAt.Valid {
+		capture.LastAt = &lastAt.Int64
+	}
+	if finishedAt.Valid {
+		capture.FinishedAt = &finishedAt.Int64
+	}
+
+	s.mutex.Lock()
+	recording, ok := s.recordings[capture.ID]
+	s.mutex.Unlock()
+	if ok {
+		capture.Messages = atomic.LoadInt64(&recording.messages)
+		capture.Dropped = atomic.LoadInt64(&recording.dropped)
+	}
+	return capture, nil
+}
+
+const captureColumns = `id, name, format, source, topics, include_outgoing, status, messages, dropped, file_size,
+	first_at, last_at, error_message, operator, created_at, finished_at`
+
+// 获取抓包列表
+func (s *CaptureService) GetCaptures() (*models.APIResponse, error) {
+	rows, err := s.db.Query("SELECT " + captureColumns + " FROM captures ORDER BY created_at DESC")
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取抓包列表失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	captures := []models.Capture{}
+	for rows.Next() {
+		capture, err := s.scanCapture(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描抓包记录失败: %v", err),
+			}, err
+		}
+		captures = append(captures, capture)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    captures,
+	}, nil
+}
+
+// getCapture 按ID查询抓包
+func (s *CaptureService) getCapture(id string) (models.Capture, error) {
+	return s.scanCapture(s.db.QueryRow("SELECT "+captureColumns+" FROM captures WHERE id = ?", id))
+}
+
+// 获取抓包详情
+func (s *CaptureService) GetCapture(id string) (*models.APIResponse, error) {
+	capture, err := s.getCapture(id)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "抓包不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取抓包失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    capture,
+	}, nil
+}
+
+// 获取抓包文件路径与下载文件名
+func (s *CaptureService) GetCaptureFile(id string) (string, string, *models.APIResponse, error) {
+	capture, err := s.getCapture(id)
+	if err == sql.ErrNoRows {
+		return "", "", &models.APIResponse{
+			Code:    1,
+			Message: "抓包不存在",
+		}, nil
+	}
+	if err != nil {
+		return "", "", &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取抓包失败: %v", err),
+		}, err
+	}
+	return s.filePath(capture.ID, capture.Format), capture.Name + captureFileExt(capture.Format), nil, nil
+}
+
+// 导入现场导出的抓包文件，逐条校验并统计消息数与时间范围
+func (s *CaptureService) ImportCapture(name, fileName, operator string, reader io.Reader) (*models.APIResponse, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建抓包目录失败: %v", err),
+		}, err
+	}
+
+	id := uuid.New().String()
+	tmpPath := filepath.Join(s.dir, id+".tmp")
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("保存抓包文件失败: %v", err),
+		}, err
+	}
+	size, err := io.Copy(file, io.LimitReader(reader, CaptureMaxFileSize+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("保存抓包文件失败: %v", err),
+		}, err
+	}
+	if size > CaptureMaxFileSize {
+		os.Remove(tmpPath)
+		return &models.APIResponse{
+			Code:    1,
+			Message: "抓包文件过大",
+		}, nil
+	}
+
+	format, messages, firstAt, lastAt, err := scanCaptureFile(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return &models.APIResponse{
+			Code:    1,
+			Message: "抓包文件校验失败: " + err.Error(),
+		}, nil
+	}
+	if err := os.Rename(tmpPath, s.filePath(id, format)); err != nil {
+		os.Remove(tmpPath)
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("保存抓包文件失败: %v", err),
+		}, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	}
+	var first, last interface{}
+	if messages > 0 {
+		first, last = firstAt, lastAt
+	}
+	now := time.Now().UnixMilli()
+	_, err = s.db.Exec(`INSERT INTO captures (id, name, format, source, status, messages, file_size, first_at, last_at, operator,
+		created_at, finished_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, name, format, CaptureSourceImported, CaptureStatusCompleted, messages, size, first, last, operator, now, now)
+	if err != nil {
+		os.Remove(s.filePath(id, format))
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("保存抓包记录失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "抓包导入成功",
+		Data: map[string]interface{}{
+			"id":       id,
+			"format":   format,
+			"messages": messages,
+		},
+	}, nil
+}
+
+// scanCaptureFile 读取整个抓包文件，返回格式、消息数与首尾时间戳
+func scanCaptureFile(path string) (string, int64, int64, int64, error) {
+	reader, format, err := openCaptureFile(path)
+	if err != nil {
+		return "", 0, 0, 0, err
+	}
+	defer reader.Close()
+
+	var messages, firstAt, lastAt int64
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return format, messages, firstAt, lastAt, nil
+		}
+		if err != nil {
+			return "", 0, 0, 0, err
+		}
+		if messages == 0 {
+			firstAt = record.Timestamp
+		}
+		lastAt = record.Timestamp
+		messages++
+	}
+}
+
+// 删除抓包及其文件，录制中的抓包需先结束录制
+func (s *CaptureService) DeleteCapture(id string) (*models.APIResponse, error) {
+	s.mutex.Lock()
+	_, recording := s.recordings[id]
+	s.mutex.Unlock()
+	if recording {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "抓包正在录制，请先结束录制",
+		}, nil
+	}
+
+	capture, err := s.getCapture(id)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "抓包不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取抓包失败: %v", err),
+		}, err
+	}
+
+	if _, err := s.db.Exec("DELETE FROM captures WHERE id = ?", id); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("删除抓包失败: %v", err),
+		}, err
+	}
+	if err := os.Remove(s.filePath(capture.ID, capture.Format)); err != nil && !os.IsNotExist(err) {
+		log.Printf("删除抓包文件失败 %s: %v", id, err)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "抓包删除成功",
+	}, nil
+}
@@ -0,0 +1,644 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	TopicServices      = "thing/product/{sn}/services"
+	TopicServicesReply = "thing/product/{sn}/services_reply"
+)
+
+const (
+	ServiceJobSent       = "sent"
+	ServiceJobInProgress = "in_progress"
+	ServiceJobSucceeded  = "succeeded"
+	ServiceJobFailed     = "failed"
+	ServiceJobTimeout    = "timeout"
+)
+
+const (
+	commandDefaultTimeout  = 10 * time.Second
+	commandMaxTimeout      = 60 * time.Second
+	commandCheckInterval   = 10 * time.Second
+	commandReplyTimeout    = 30 * time.Second
+	commandProgressTimeout = 2 * time.Hour
+)
+
+// ErrServiceReplyTimeout 等待 services_reply 超时
+var ErrServiceReplyTimeout = errors.New("等待设备回复超时")
+
+// longRunningMethods 回复后通过 events 上报进度的服务，默认以任务方式异步执行
+var longRunningMethods = map[string]bool{
+	"ota_create":         true,
+	"fileupload_start":   true,
+	"flighttask_execute": true,
+	"return_home":        true,
+	"takeoff_to_point":   true,
+	"fly_to_point":       true,
+	"device_reboot":      true,
+	"drone_open":         true,
+	"drone_close":        true,
+	"cover_open":         true,
+	"cover_close":        true,
+	"cover_force_close":  true,
+	"putter_open":        true,
+	"putter_close":       true,
+	"charge_open":        true,
+	"charge_close":       true,
+	"drone_format":       true,
+	"device_format":      true,
+}
+
+// IsLongRunningMethod 判断服务是否需要异步跟踪进度
+func IsLongRunningMethod(method string) bool {
+	return longRunningMethods[method]
+}
+
+// trackedJob 进行中的服务任务
+type trackedJob struct {
+	id        string
+	status    string
+	updatedAt time.Time
+}
+
+// CommandService 通过后端MQTT连接调用设备 services，并按 tid/bid 关联回复与进度事件
+type CommandService struct {
+	db            *database.DB
+	ingestService *IngestService
+
+	waiters map[string]chan *models.DJIMessage
+	jobs    map[string]*trackedJob
+	mutex   sync.Mutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewCommandService 创建设备服务调用服务
+func NewCommandService(db *database.DB, ingestService *IngestService) *CommandService {
+	s := &CommandService{
+		db:            db,
+		ingestService: ingestService,
+		waiters:       make(map[string]chan *models.DJIMessage),
+		jobs:          make(map[string]*trackedJob),
+		stopCh:        make(chan struct{}),
+	}
+
+	ingestService.RegisterHandler(TopicServicesReply, 1, s.handleReply)
+	ingestService.RegisterHandler(TopicEvents, 1, s.handleEvent)
+
+	return s
+}
+
+// Start 恢复未结束的任务并启动超时检测
+func (s *CommandService) Start() {
+	rows, err := s.db.Query("SELECT id, bid, status, updated_at FROM service_jobs WHERE status IN (?, ?)",
+		ServiceJobSent, ServiceJobInProgress)
+	if err != nil {
+		log.Printf("加载服务任务失败: %v", err)
+	} else {
+		s.mutex.Lock()
+		for rows.Next() {
+			var id, bid, status string
+			var updatedAt int64
+			if err := rows.Scan(&id, &bid, &status, &updatedAt); err != nil {
+				log.Printf("加载服务任务失败: %v", err)
+				break
+			}
+			s.jobs[bid] = &trackedJob{id: id, status: status, updatedAt: time.UnixMilli(updatedAt)}
+		}
+		s.mutex.Unlock()
+		rows.Close()
+	}
+
+	go s.run()
+}
+
+// Stop 停止超时检测
+func (s *CommandService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+func (s *CommandService) run() {
+	ticker := time.NewTicker(commandCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.checkTimeouts()
+		}
+	}
+}
+
+// NewServiceMessage 构造 services 请求信封
+func NewServiceMessage(method string, data json.RawMessage) *models.DJIMessage {
+	if len(data) == 0 {
+		data = json.RawMessage("{}")
+	}
+	return &models.DJIMessage{
+		TID:       uuid.New().String(),
+		BID:       uuid.New().String(),
+		Timestamp: time.Now().UnixMilli(),
+		Method:    method,
+		Data:      data,
+	}
+}
+
+// Call 下发服务并同步等待 services_reply
+func (s *CommandService) Call(sn, method string, data json.RawMessage, timeout time.Duration) (*models.ServiceReply, error) {
+	return s.Send(sn, NewServiceMessage(method, data), timeout)
+}
+
+// Send 下发已构造的服务消息并同步等待 services_reply，调用方可预先记录 bid 以关联后续进度事件
+func (s *CommandService) Send(sn string, msg *models.DJIMessage, timeout time.Duration) (*models.ServiceReply, error) {
+	if timeout <= 0 {
+		timeout = commandDefaultTimeout
+	}
+
+	replyCh := make(chan *models.DJIMessage, 1)
+
+	s.mutex.Lock()
+	s.waiters[msg.TID] = replyCh
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.waiters, msg.TID)
+		s.mutex.Unlock()
+	}()
+
+	topic := strings.Replace(TopicServices, "{sn}", sn, 1)
+	if err := s.ingestService.Publish(topic, 1, msg); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replyCh:
+		return parseServiceReply(reply), nil
+	case <-time.After(timeout):
+		return nil, ErrServiceReplyTimeout
+	case <-s.stopCh:
+		return nil, fmt.Errorf("服务已停止")
+	}
+}
+
+// Submit 以任务方式下发服务，回复与进度事件异步更新任务状态
+func (s *CommandService) Submit(sn, method string, data json.RawMessage) (*models.ServiceJob, error) {
+	msg := NewServiceMessage(method, data)
+	now := time.Now()
+
+	job := &models.ServiceJob{
+		ID:        uuid.New().String(),
+		SN:        sn,
+		Method:    method,
+		TID:       msg.TID,
+		BID:       msg.BID,
+		Status:    ServiceJobSent,
+		Request:   msg.Data,
+		CreatedAt: now.UnixMilli(),
+		UpdatedAt: now.UnixMilli(),
+	}
+
+	_, err := s.db.Exec(`INSERT INTO service_jobs (id, sn, method, tid, bid, status, request, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		job.ID, job.SN, job.Method, job.TID, job.BID, job.Status, string(job.Request), job.CreatedAt, job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	s.jobs[job.BID] = &trackedJob{id: job.ID, status: job.Status, updatedAt: now}
+	s.mutex.Unlock()
+
+	topic := strings.Replace(TopicServices, "{sn}", sn, 1)
+	if err := s.ingestService.Publish(topic, 1, msg); err != nil {
+		s.finishJob(job.BID, ServiceJobFailed, nil, fmt.Sprintf("下发失败: %v", err))
+		job.Status = ServiceJobFailed
+		job.ErrorMessage = fmt.Sprintf("下发失败: %v", err)
+		return job, err
+	}
+
+	return job, nil
+}
+
+// handleReply 将 services_reply 交给等待方，并更新对应任务
+func (s *CommandService) handleReply(sn string, msg *models.DJIMessage) {
+	s.mutex.Lock()
+	replyCh, waiting := s.waiters[msg.TID]
+	job, tracked := s.jobs[msg.BID]
+	s.mutex.Unlock()
+
+	if waiting {
+		select {
+		case replyCh <- msg:
+		default:
+		}
+	}
+
+	if !tracked {
+		return
+	}
+
+	reply := parseServiceReply(msg)
+	status := ServiceJobInProgress
+	if reply.Result != 0 {
+		status = ServiceJobFailed
+	} else if !IsLongRunningMethod(msg.Method) {
+		status = ServiceJobSucceeded
+	}
+
+	now := time.Now()
+	_, err := s.db.Exec(`UPDATE service_jobs SET status = ?, result = ?, error_message = ?, reply = ?, output = ?, updated_at = ?
+		WHERE id = ?`, status, reply.Result, reply.ErrorMessage, string(msg.Data), nullableJSON(reply.Output), now.UnixMilli(), job.id)
+	if err != nil {
+		log.Printf("更新服务任务失败 %s: %v", job.id, err)
+	}
+
+	s.mutex.Lock()
+	if status == ServiceJobInProgress {
+		job.status = status
+		job.updatedAt = now
+	} else {
+		delete(s.jobs, msg.BID)
+	}
+	s.mutex.Unlock()
+}
+
+// serviceProgressEvent 进度事件 data，ota_progress、fileupload_progress、flighttask_progress 等格式一致
+type serviceProgressEvent struct {
+	Result       int             `json:"result"`
+	ErrorMessage string          `json:"error_message"`
+	Output       json.RawMessage `json:"output"`
+}
+
+type serviceProgressOutput struct {
+	Status   string `json:"status"`
+	Progress struct {
+		Percent int `json:"percent"`
+	} `json:"progress"`
+}
+
+// handleEvent 按 bid 将进度事件关联到任务
+func (s *CommandService) handleEvent(sn string, msg *models.DJIMessage) {
+	s.mutex.Lock()
+	job, tracked := s.jobs[msg.BID]
+	s.mutex.Unlock()
+	if !tracked {
+		return
+	}
+
+	var event serviceProgressEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return
+	}
+	var output serviceProgressOutput
+	json.Unmarshal(event.Output, &output)
+
+	status := progressJobStatus(event.Result, output.Status)
+	now := time.Now()
+
+	_, err := s.db.Exec(`UPDATE service_jobs SET status = ?, result = ?, error_message = ?, progress = ?, output = ?, updated_at = ?
+		WHERE id = ?`, status, event.Result, event.ErrorMessage, output.Progress.Percent, nullableJSON(event.Output), now.UnixMilli(), job.id)
+	if err != nil {
+		log.Printf("更新服务任务进度失败 %s: %v", job.id, err)
+	}
+
+	s.mutex.Lock()
+	if status == ServiceJobInProgress {
+		job.status = status
+		job.updatedAt = now
+	} else {
+		delete(s.jobs, msg.BID)
+	}
+	s.mutex.Unlock()
+}
+
+// progressJobStatus 将进度事件中的 output.status 映射为任务状态
+func progressJobStatus(result int, status string) string {
+	if result != 0 {
+		return ServiceJobFailed
+	}
+	switch status {
+	case "ok":
+		return ServiceJobSucceeded
+	case "failed", "canceled", "rejected", "timeout":
+		return ServiceJobFailed
+	default:
+		return ServiceJobInProgress
+	}
+}
+
+// checkTimeouts 将长时间无回复或无进度的任务标记为超时
+func (s *CommandService) checkTimeouts() {
+	now := time.Now()
+
+	s.mutex.Lock()
+	var expired []string
+	for bid, job := range s.jobs {
+		limit := commandProgressTimeout
+		if job.status == ServiceJobSent {
+			limit = commandReplyTimeout
+		}
+		if now.Sub(job.updatedAt) > limit {
+			expired = append(expired, bid)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, bid := range expired {
+		s.finishJob(bid, ServiceJobTimeout, nil, "等待设备回复超时")
+	}
+}
+
+// finishJob 结束任务并停止跟踪
+func (s *CommandService) finishJob(bid, status string, result *int, message string) {
+	s.mutex.Lock()
+	job, ok := s.jobs[bid]
+	delete(s.jobs, bid)
+	s.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	_, err := s.db.Exec("UPDATE service_jobs SET status = ?, result = COALESCE(?, result), error_message = ?, updated_at = ? WHERE id = ?",
+		status, result, message, time.Now().UnixMilli(), job.id)
+	if err != nil {
+		log.Printf("更新服务任务失败 %s: %v", job.id, err)
+	}
+}
+
+// parseServiceReply 解析 services_reply 中的 result 与 output
+func parseServiceReply(msg *models.DJIMessage) *models.ServiceReply {
+	reply := &models.ServiceReply{
+		TID:    msg.TID,
+		BID:    msg.BID,
+		Method: msg.Method,
+		Data:   msg.Data,
+	}
+
+	var data serviceProgressEvent
+	if err := json.Unmarshal(msg.Data, &data); err == nil {
+		reply.Result = data.Result
+		reply.ErrorMessage = data.ErrorMessage
+		reply.Output = data.Output
+	}
+	return reply
+}
+
+// nullableJSON 空JSON写入数据库时存为 NULL
+func nullableJSON(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
+
+// dedicatedServiceEndpoint 需经专用接口下发的服务及其接口，通用接口直接下发会绕过安全检查与操作记录
+func dedicatedServiceEndpoint(sn, method string) (string, bool) {
+	switch method {
+	case "drc_mode_enter", "drc_mode_exit":
+		// DRC控制权由会话持有，直接下发会夺走当前操作员的控制
+		return "/ws/drc/" + sn, true
+	case "flighttask_prepare", "flighttask_execute":
+		// 航线任务需检查机场是否繁忙并记录任务，否则进度事件无法关联
+		return "/api/flight-tasks", true
+	case "ota_create":
+		// 升级需检查设备是否繁忙并记录升级任务
+		return "/api/upgrades", true
+	}
+	if _, ok := findRemoteDebugMethod(method); ok {
+		return fmt.Sprintf("/api/devices/%s/debug/%s", sn, method), true
+	}
+	return "", false
+}
+
+// 调用设备服务，长耗时服务或 async=true 时返回任务
+func (s *CommandService) CallService(sn, method string, data json.RawMessage, query *models.ServiceCallQuery) (*models.APIResponse, error) {
+	if endpoint, ok := dedicatedServiceEndpoint(sn, method); ok {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("服务 %s 需通过 %s 下发", method, endpoint),
+		}, nil
+	}
+
+	async := IsLongRunningMethod(method)
+	if query.Async != nil {
+		async = *query.Async
+	}
+
+	if !s.ingestService.IsConnected() {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "后端MQTT未连接",
+		}, nil
+	}
+
+	// 只订阅了已登记设备的回复主题
+	var exists int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM devices WHERE sn = ? OR airport_sn = ?", sn, sn).Scan(&exists)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("查询设备失败: %v", err),
+		}, err
+	}
+	if exists == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "设备未登记",
+		}, nil
+	}
+
+	if async {
+		job, err := s.Submit(sn, method, data)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("下发服务失败: %v", err),
+				Data:    job,
+			}, err
+		}
+		return &models.APIResponse{
+			Code:    0,
+			Message: "服务已下发",
+			Data:    job,
+		}, nil
+	}
+
+	timeout := time.Duration(query.Timeout) * time.Second
+	if timeout > commandMaxTimeout {
+		timeout = commandMaxTimeout
+	}
+
+	reply, err := s.Call(sn, method, data, timeout)
+	if err == ErrServiceReplyTimeout {
+		return &models.APIResponse{
+			Code:    1,
+			Message: err.Error(),
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("下发服务失败: %v", err),
+		}, err
+	}
+
+	if reply.Result != 0 {
+		message := reply.ErrorMessage
+		if message == "" {
+			message = fmt.Sprintf("设备返回错误码: %d", reply.Result)
+		}
+		return &models.APIResponse{
+			Code:    1,
+			Message: message,
+			Data:    reply,
+		}, nil
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    reply,
+	}, nil
+}
+
+const serviceJobColumns = `id, sn, method, tid, bid, status, result, error_message, progress, request, reply, output,
+	created_at, updated_at`
+
+// scanServiceJob 扫描服务任务记录
+func scanServiceJob(scanner interface{ Scan(...interface{}) error }) (models.ServiceJob, error) {
+	var job models.ServiceJob
+	var result sql.NullInt64
+	var request, reply, output sql.NullString
+
+	err := scanner.Scan(&job.ID, &job.SN, &job.Method, &job.TID, &job.BID, &job.Status, &result, &job.ErrorMessage,
+		&job.Progress, &request, &reply, &output, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return job, err
+	}
+
+	if result.Valid {
+		value := int(result.Int64)
+		job.Result = &value
+	}
+	if request.Valid {
+		job.Request = json.RawMessage(request.String)
+	}
+	if reply.Valid {
+		job.Reply = json.RawMessage(reply.String)
+	}
+	if output.Valid {
+		job.Output = json.RawMessage(output.String)
+	}
+	return job, nil
+}
+
+// 获取服务任务列表
+func (s *CommandService) GetJobs(query *models.ServiceJobQuery) (*models.APIResponse, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if query.SN != "" {
+		conditions = append(conditions, "sn = ?")
+		args = append(args, query.SN)
+	}
+	if query.Method != "" {
+		conditions = append(conditions, "method = ?")
+		args = append(args, query.Method)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, query.Status)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM service_jobs "+where, args...).Scan(&total); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取服务任务失败: %v", err),
+		}, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	rows, err := s.db.Query("SELECT "+serviceJobColumns+" FROM service_jobs "+where+
+		" ORDER BY created_at DESC LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取服务任务失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	jobs := []models.ServiceJob{}
+	for rows.Next() {
+		job, err := scanServiceJob(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描服务任务失败: %v", err),
+			}, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+			"items":    jobs,
+		},
+	}, nil
+}
+
+// 获取服务任务详情
+func (s *CommandService) GetJob(jobID string) (*models.APIResponse, error) {
+	job, err := scanServiceJob(s.db.QueryRow("SELECT "+serviceJobColumns+" FROM service_jobs WHERE id = ?", jobID))
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "服务任务不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取服务任务失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    job,
+	}, nil
+}
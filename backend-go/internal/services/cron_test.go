@@ -0,0 +1,160 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func cronBits(values ...int) uint64 {
+	var bits uint64
+	for _, value := range values {
+		bits |= 1 << uint(value)
+	}
+	return bits
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field string
+		min   int
+		max   int
+		want  uint64
+	}{
+		{"*", 0, 5, cronBits(0, 1, 2, 3, 4, 5)},
+		{"?", 1, 3, cronBits(1, 2, 3)},
+		{"3", 0, 59, cronBits(3)},
+		{"1,5,9", 0, 59, cronBits(1, 5, 9)},
+		{"2-5", 0, 59, cronBits(2, 3, 4, 5)},
+		{"*/15", 0, 59, cronBits(0, 15, 30, 45)},
+		{"10-20/5", 0, 59, cronBits(10, 15, 20)},
+		{"5/20", 0, 59, cronBits(5, 25, 45)},
+		{"*/2", 1, 31, cronBits(1, 3, 5, 7, 9, 11, 13, 15, 17, 19, 21, 23, 25, 27, 29, 31)},
+		{"1-3,8,20-22/2", 0, 59, cronBits(1, 2, 3, 8, 20, 22)},
+		{"0-7", 0, 7, cronBits(0, 1, 2, 3, 4, 5, 6, 7)},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.min, tt.max)
+		if err != nil {
+			t.Errorf("parseCronField(%q) 出错: %v", tt.field, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCronField(%q) = %b, 期望 %b", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-x * * * *",
+		"1,,2 * * * *",
+		"@reboot",
+	}
+
+	for _, expr := range tests {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) 期望出错", expr)
+		}
+	}
+}
+
+func TestParseCronRestricted(t *testing.T) {
+	tests := []struct {
+		expr          string
+		domRestricted bool
+		dowRestricted bool
+	}{
+		{"0 8 * * *", false, false},
+		{"0 8 ? * ?", false, false},
+		{"0 8 1 * *", true, false},
+		{"0 8 * * 1", false, true},
+		{"0 8 1,15 * 1-5", true, true},
+		{"0 8 */2 * 1", false, true},
+		{"0 8 1 * */2", true, false},
+		{"0 8 1-31/2 * 1", true, true},
+		{"@weekly", false, true},
+		{"@monthly", true, false},
+	}
+
+	for _, tt := range tests {
+		schedule, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q) 出错: %v", tt.expr, err)
+			continue
+		}
+		if schedule.domRestricted != tt.domRestricted || schedule.dowRestricted != tt.dowRestricted {
+			t.Errorf("parseCron(%q) 限定 = %v/%v, 期望 %v/%v", tt.expr,
+				schedule.domRestricted, schedule.dowRestricted, tt.domRestricted, tt.dowRestricted)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2024-01-01 为周一
+	base := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"每分钟", "* * * * *", base, at(1, 1, 10, 31)},
+		{"严格晚于起点", "30 10 * * *", base, at(1, 2, 10, 30)},
+		{"忽略秒", "31 10 * * *", base.Add(30 * time.Second), at(1, 1, 10, 31)},
+		{"当天稍后", "0 12 * * *", base, at(1, 1, 12, 0)},
+		{"次日", "0 8 * * *", base, at(1, 2, 8, 0)},
+		{"分钟步长", "*/15 * * * *", base, at(1, 1, 10, 45)},
+		{"范围与步长", "0 9-17/4 * * *", base, at(1, 1, 13, 0)},
+		{"分钟列表", "5,40 * * * *", base, at(1, 1, 10, 40)},
+		{"工作日", "0 8 * * 1-5", at(1, 5, 9, 0), at(1, 8, 8, 0)},
+		{"周日写作 7", "0 8 * * 7", base, at(1, 7, 8, 0)},
+		{"周日写作 0", "0 8 * * 0", base, at(1, 7, 8, 0)},
+		{"跨月", "0 0 1 * *", base, at(2, 1, 0, 0)},
+		{"指定月份", "0 0 1 3,6 *", base, at(3, 1, 0, 0)},
+		{"闰日", "0 0 29 2 *", base, at(2, 29, 0, 0)},
+		{"跨年", "0 0 1 1 *", base, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"宏", "@hourly", base, at(1, 1, 11, 0)},
+
+		// 日与周均有限定时任一满足：1 月 3 日为周三，15 日之前先遇到周五 1 月 5 日
+		{"日与周任一满足取周", "0 8 15 * 5", base, at(1, 5, 8, 0)},
+		{"日与周任一满足取日", "0 8 3 * 5", base, at(1, 3, 8, 0)},
+		// 日字段以 * 开头视为不限定，只看周一：1 月 8 日为周一且为偶数日，下一个奇数日周一为 15 日
+		{"*/2 的日字段不参与并集", "0 8 */2 * 1", base, at(1, 15, 8, 0)},
+		// 日字段为 1-31/2 时视为限定，与周取并集：1 月 3 日为奇数日
+		{"范围步长的日字段参与并集", "0 8 1-31/2 * 1", base, at(1, 3, 8, 0)},
+		// 周字段以 * 开头视为不限定，日与周须同时满足：1 月 10 日为周三，2 月 10 日为周六
+		{"*/2 的周字段不参与并集", "0 8 10 * */2", base, at(2, 10, 8, 0)},
+
+		{"无匹配", "0 0 30 2 *", base, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%q) 出错: %v", tt.expr, err)
+			}
+			if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("%q Next(%s) = %s, 期望 %s", tt.expr, tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -420,3 +420,139 @@ func (s *DeviceService) RemoveDefaultDevices() (*models.APIResponse, error) {
 		Message: fmt.Sprintf("已删除 %d 个默认设备", rowsAffected),
 	}, nil
 }
+
+// 更新设备在线状态，返回是否有记录被更新
+func (s *DeviceService) SetDeviceStatus(sn, status string, lastSeen int64) (bool, error) {
+	query := `UPDATE devices SET status = ?, last_seen = COALESCE(?, last_seen), updated_at = ? WHERE sn = ?`
+
+	var seen interface{}
+	if lastSeen > 0 {
+		seen = lastSeen
+	}
+
+	result, err := s.db.Exec(query, status, seen, time.Now().UnixMilli(), sn)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// 批量更新设备最后在线时间
+func (s *DeviceService) UpdateLastSeen(lastSeen map[string]int64) error {
+	if len(lastSeen) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for sn, seen := range lastSeen {
+		if _, err := tx.Exec("UPDATE devices SET last_seen = ? WHERE sn = ?", seen, sn); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// 获取当前在线设备及其最后在线时间
+func (s *DeviceService) GetOnlineDeviceLastSeen() (map[string]int64, error) {
+	rows, err := s.db.Query("SELECT sn, last_seen FROM devices WHERE status = 'online'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]int64)
+	for rows.Next() {
+		var sn string
+		var lastSeen sql.NullInt64
+		if err := rows.Scan(&sn, &lastSeen); err != nil {
+			return nil, err
+		}
+		result[sn] = lastSeen.Int64
+	}
+	return result, rows.Err()
+}
+
+// 获取挂载在机场下的子设备SN
+func (s *DeviceService) GetSubDeviceSNs(airportSN string) ([]string, error) {
+	rows, err := s.db.Query("SELECT sn FROM devices WHERE airport_sn = ? AND sn != ?", airportSN, airportSN)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sns []string
+	for rows.Next() {
+		var sn string
+		if err := rows.Scan(&sn); err != nil {
+			return nil, err
+		}
+		sns = append(sns, sn)
+	}
+	return sns, rows.Err()
+}
+
+// 确保子设备已登记，不存在时自动创建，返回是否新建
+func (s *DeviceService) EnsureSubDevice(sn, airportSN, deviceType string) (bool, error) {
+	var existingAirportSN string
+	err := s.db.QueryRow("SELECT airport_sn FROM devices WHERE sn = ?", sn).Scan(&existingAirportSN)
+	if err == nil {
+		if existingAirportSN != airportSN {
+			_, err = s.db.Exec("UPDATE devices SET airport_sn = ?, updated_at = ? WHERE sn = ?", airportSN, time.Now().UnixMilli(), sn)
+		}
+		return false, err
+	} else if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	deviceID := uuid.New().String()
+	currentTime := time.Now().UnixMilli()
+
+	query := `INSERT INTO devices (id, name, sn, type, status, airport_sn, last_seen, created_at, updated_at, is_current, is_gateway)
+			  VALUES (?, ?, ?, ?, 'online', ?, ?, ?, ?, 0, 0)`
+
+	_, err = s.db.Exec(query, deviceID, sn, sn, deviceType, airportSN, currentTime, currentTime, currentTime)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 获取设备名称，设备未登记时返回SN
+func (s *DeviceService) GetDeviceName(sn string) (string, error) {
+	var name string
+	err := s.db.QueryRow("SELECT name FROM devices WHERE sn = ?", sn).Scan(&name)
+	if err == sql.ErrNoRows || (err == nil && name == "") {
+		return sn, nil
+	}
+	if err != nil {
+		return sn, err
+	}
+	return name, nil
+}
+
+// 获取设备所属网关SN，机场下的飞行器返回机场SN，其余返回自身
+func (s *DeviceService) GetGatewaySN(sn string) (string, error) {
+	var airportSN string
+	err := s.db.QueryRow("SELECT airport_sn FROM devices WHERE sn = ?", sn).Scan(&airportSN)
+	if err == sql.ErrNoRows {
+		return sn, nil
+	}
+	if err != nil {
+		return sn, err
+	}
+	if airportSN == "" {
+		return sn, nil
+	}
+	return airportSN, nil
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/models"
+)
+
+const (
+	TopicStatus      = "sys/product/{sn}/status"
+	TopicStatusReply = "sys/product/{sn}/status_reply"
+)
+
+const (
+	DeviceStatusOnline  = "online"
+	DeviceStatusOffline = "offline"
+)
+
+// DeviceStatusService 根据拓扑上报与osd心跳自动维护设备在线状态
+type DeviceStatusService struct {
+	deviceService  *DeviceService
+	ingestService  *IngestService
+	offlineTimeout time.Duration
+
+	lastSeen map[string]int64
+	online   map[string]bool
+	pending  map[string]int64
+	mutex    sync.Mutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewDeviceStatusService 创建设备状态服务
+func NewDeviceStatusService(deviceService *DeviceService, ingestService *IngestService, offlineTimeout time.Duration) *DeviceStatusService {
+	s := &DeviceStatusService{
+		deviceService:  deviceService,
+		ingestService:  ingestService,
+		offlineTimeout: offlineTimeout,
+		lastSeen:       make(map[string]int64),
+		online:         make(map[string]bool),
+		pending:        make(map[string]int64),
+		stopCh:         make(chan struct{}),
+	}
+
+	ingestService.RegisterHandler(TopicOSD, 0, s.handleHeartbeat)
+	ingestService.RegisterHandler(TopicStatus, 1, s.handleStatus)
+
+	return s
+}
+
+// Start 启动离线检测
+func (s *DeviceStatusService) Start() {
+	// 已在线的设备以数据库中的最后在线时间为起点计算超时
+	onlineDevices, err := s.deviceService.GetOnlineDeviceLastSeen()
+	if err != nil {
+		log.Printf("加载在线设备失败: %v", err)
+	}
+
+	s.mutex.Lock()
+	now := time.Now().UnixMilli()
+	for sn, lastSeen := range onlineDevices {
+		if lastSeen == 0 {
+			lastSeen = now
+		}
+		s.online[sn] = true
+		s.lastSeen[sn] = lastSeen
+	}
+	s.mutex.Unlock()
+
+	go s.run()
+}
+
+// Stop 停止离线检测
+func (s *DeviceStatusService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+func (s *DeviceStatusService) run() {
+	interval := s.offlineTimeout / 2
+	if interval > 10*time.Second {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.sweep()
+		}
+	}
+}
+
+// handleHeartbeat osd 即心跳，网关字段同时证明网关在线
+func (s *DeviceStatusService) handleHeartbeat(sn string, msg *models.DJIMessage) {
+	s.markOnline(sn)
+	if msg.Gateway != "" && msg.Gateway != sn {
+		s.markOnline(msg.Gateway)
+	}
+}
+
+// handleStatus 处理 update_topo 拓扑更新并回复 status_reply
+func (s *DeviceStatusService) handleStatus(gatewaySN string, msg *models.DJIMessage) {
+	if msg.Method != "update_topo" {
+		return
+	}
+
+	var topo models.TopoUpdate
+	if err := json.Unmarshal(msg.Data, &topo); err != nil {
+		log.Printf("解析拓扑更新失败 %s: %v", gatewaySN, err)
+		return
+	}
+
+	s.markOnline(gatewaySN)
+
+	registered := false
+	current := make(map[string]bool)
+	for _, sub := range topo.SubDevices {
+		if sub.SN == "" {
+			continue
+		}
+		current[sub.SN] = true
+
+		created, err := s.deviceService.EnsureSubDevice(sub.SN, gatewaySN, subDeviceType(sub.Domain))
+		if err != nil {
+			log.Printf("登记子设备失败 %s: %v", sub.SN, err)
+			continue
+		}
+		if created {
+			log.Printf("自动登记子设备 %s (机场 %s)", sub.SN, gatewaySN)
+			registered = true
+		}
+		s.markOnline(sub.SN)
+	}
+
+	// 拓扑中不再出现的子设备视为离线
+	subSNs, err := s.deviceService.GetSubDeviceSNs(gatewaySN)
+	if err != nil {
+		log.Printf("获取子设备失败 %s: %v", gatewaySN, err)
+	}
+	for _, sn := range subSNs {
+		if !current[sn] {
+			s.markOffline(sn)
+		}
+	}
+
+	reply := strings.Replace(TopicStatusReply, "{sn}", gatewaySN, 1)
+	if err := s.ingestService.Reply(reply, msg, map[string]int{"result": 0}); err != nil {
+		log.Printf("发送status_reply失败 %s: %v", gatewaySN, err)
+	}
+
+	if registered {
+		go s.ingestService.RefreshSubscriptions()
+	}
+}
+
+// markOnline 记录心跳，状态由离线转为在线时立即写库
+func (s *DeviceStatusService) markOnline(sn string) {
+	now := time.Now().UnixMilli()
+
+	s.mutex.Lock()
+	wasOnline := s.online[sn]
+	s.online[sn] = true
+	s.lastSeen[sn] = now
+	if wasOnline {
+		s.pending[sn] = now
+	}
+	s.mutex.Unlock()
+
+	if !wasOnline {
+		if _, err := s.deviceService.SetDeviceStatus(sn, DeviceStatusOnline, now); err != nil {
+			log.Printf("更新设备在线状态失败 %s: %v", sn, err)
+		}
+	}
+}
+
+// markOffline 标记设备离线
+func (s *DeviceStatusService) markOffline(sn string) {
+	s.mutex.Lock()
+	wasOnline := s.online[sn]
+	delete(s.online, sn)
+	delete(s.pending, sn)
+	s.mutex.Unlock()
+
+	if !wasOnline {
+		return
+	}
+	if _, err := s.deviceService.SetDeviceStatus(sn, DeviceStatusOffline, 0); err != nil {
+		log.Printf("更新设备离线状态失败 %s: %v", sn, err)
+		return
+	}
+	log.Printf("设备离线: %s", sn)
+}
+
+// sweep 批量写入最后在线时间，并将超时未上报的设备标记为离线
+func (s *DeviceStatusService) sweep() {
+	now := time.Now().UnixMilli()
+	deadline := now - s.offlineTimeout.Milliseconds()
+
+	s.mutex.Lock()
+	pending := s.pending
+	s.pending = make(map[string]int64)
+	var expired []string
+	for sn := range s.online {
+		if s.lastSeen[sn] < deadline {
+			expired = append(expired, sn)
+		}
+	}
+	s.mutex.Unlock()
+
+	if err := s.deviceService.UpdateLastSeen(pending); err != nil {
+		log.Printf("更新设备最后在线时间失败: %v", err)
+	}
+
+	for _, sn := range expired {
+		s.markOffline(sn)
+	}
+}
+
+// GetLastSeen 获取设备最后一次上报时间（毫秒）
+func (s *DeviceStatusService) GetLastSeen(sn string) (int64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	lastSeen, ok := s.lastSeen[sn]
+	return lastSeen, ok && s.online[sn]
+}
+
+// subDeviceType 将DJI设备 domain 映射为设备表中的类型
+func subDeviceType(domain interface{}) string {
+	switch fmt.Sprint(domain) {
+	case "0":
+		return "aircraft"
+	case "3":
+		return "airport"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,493 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// DRC 指令飞行链路主题
+const (
+	TopicDrcUp   = "thing/product/{sn}/drc/up"
+	TopicDrcDown = "thing/product/{sn}/drc/down"
+)
+
+// DRC WebSocket 消息类型
+const (
+	DrcMessageReady  = "drc_ready"
+	DrcMessageUp     = "drc_up"
+	DrcMessageStatus = "drc_status"
+	DrcMessageError  = "error"
+)
+
+const (
+	drcHeartbeatInterval = time.Second
+	// 超过该时长未收到 drc/up 视为链路中断
+	drcLinkTimeout   = 3 * time.Second
+	drcPingInterval  = 5 * time.Second
+	drcPongWait      = 15 * time.Second
+	drcWriteWait     = 5 * time.Second
+	drcEnterTimeout  = 15 * time.Second
+	drcExitTimeout   = 10 * time.Second
+	drcBrokerTTL     = 2 * time.Hour
+	drcOutboxSize    = 256
+	drcMaxFrameSize  = 64 << 10
+	drcDefaultOSDHz  = 10
+	drcDefaultHSIHz  = 1
+	drcMethodControl = "drone_control"
+)
+
+// drcSession 单个机场的 DRC 控制会话，同一机场同时只允许一个操作员
+type drcSession struct {
+	sn         string
+	operator   string
+	remoteAddr string
+	conn       *websocket.Conn
+	outbox     chan *models.DrcSocketMessage
+	startedAt  time.Time
+
+	seq      int64
+	lastUpAt int64
+	linkLost int32
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// send 将消息放入发送队列，前端消费过慢时丢弃，避免阻塞MQTT分发
+func (session *drcSession) send(message *models.DrcSocketMessage) {
+	if message.Timestamp == 0 {
+		message.Timestamp = time.Now().UnixMilli()
+	}
+	select {
+	case session.outbox <- message:
+	case <-session.done:
+	default:
+	}
+}
+
+// DrcService 管理 DRC 会话：进入/退出 DRC 模式，并在 WebSocket 与 drc/up、drc/down 之间转发消息
+type DrcService struct {
+	db             *database.DB
+	ingestService  *IngestService
+	commandService *CommandService
+	mqttService    *MQTTService
+
+	brokerAddress  string
+	brokerUsername string
+	brokerPassword string
+	brokerTLS      bool
+
+	sessions map[string]*drcSession
+	mutex    sync.Mutex
+}
+
+// NewDrcService 创建 DRC 会话服务，brokerAddress 为空时使用默认MQTT配置
+func NewDrcService(db *database.DB, ingestService *IngestService, commandService *CommandService, mqttService *MQTTService,
+	brokerAddress, brokerUsername, brokerPassword string, brokerTLS bool) *DrcService {
+	s := &DrcService{
+		db:             db,
+		ingestService:  ingestService,
+		commandService: commandService,
+		mqttService:    mqttService,
+		brokerAddress:  brokerAddress,
+		brokerUsername: brokerUsername,
+		brokerPassword: brokerPassword,
+		brokerTLS:      brokerTLS,
+		sessions:       make(map[string]*drcSession),
+	}
+
+	ingestService.RegisterHandler(TopicDrcUp, 0, s.handleUp)
+
+	return s
+}
+
+// Stop 退出所有 DRC 会话
+func (s *DrcService) Stop() {
+	s.mutex.Lock()
+	sessions := make([]*drcSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mutex.Unlock()
+
+	for _, session := range sessions {
+		s.close(session, "server stopping")
+	}
+}
+
+// Serve 在已升级的 WebSocket 上建立 DRC 会话，连接断开后退出 DRC 模式
+func (s *DrcService) Serve(conn *websocket.Conn, sn string, query *models.DrcEnterQuery) {
+	session, err := s.open(conn, sn, query)
+	if err != nil {
+		log.Printf("DRC会话建立失败 %s (%s): %v", sn, query.Operator, err)
+		conn.WriteJSON(&models.DrcSocketMessage{
+			Type:      DrcMessageError,
+			Timestamp: time.Now().UnixMilli(),
+			Message:   err.Error(),
+		})
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, ""), time.Now().Add(drcWriteWait))
+		return
+	}
+	defer s.close(session, "")
+
+	// 进入DRC模式期间会话已被释放时，close 下发的退出可能早于进入，需要再退出一次
+	select {
+	case <-session.done:
+		s.exit(sn)
+		return
+	default:
+	}
+
+	log.Printf("DRC会话已建立 %s, 操作员: %s", sn, session.operator)
+
+	go s.writeLoop(session)
+	go s.heartbeat(session)
+
+	session.send(&models.DrcSocketMessage{Type: DrcMessageReady, Message: "已进入DRC模式"})
+	s.readLoop(session)
+}
+
+// open 占用机场控制权并下发 drc_mode_enter
+func (s *DrcService) open(conn *websocket.Conn, sn string, query *models.DrcEnterQuery) (*drcSession, error) {
+	var exists int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM devices WHERE sn = ? OR airport_sn = ?", sn, sn).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("设备未注册: %s", sn)
+	}
+
+	session := &drcSession{
+		sn:         sn,
+		operator:   query.Operator,
+		remoteAddr: conn.RemoteAddr().String(),
+		conn:       conn,
+		outbox:     make(chan *models.DrcSocketMessage, drcOutboxSize),
+		startedAt:  time.Now(),
+		done:       make(chan struct{}),
+	}
+
+	// 先占用控制权再进入DRC模式，避免并发连接重复下发
+	s.mutex.Lock()
+	if holder, ok := s.sessions[sn]; ok {
+		s.mutex.Unlock()
+		return nil, fmt.Errorf("设备 %s 正由 %s 控制", sn, holder.operator)
+	}
+	s.sessions[sn] = session
+	s.mutex.Unlock()
+
+	release := func() {
+		s.mutex.Lock()
+		if s.sessions[sn] == session {
+			delete(s.sessions, sn)
+		}
+		s.mutex.Unlock()
+	}
+
+	broker, err := s.brokerConfig(sn)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	osdFrequency := query.OSDFrequency
+	if osdFrequency == 0 {
+		osdFrequency = drcDefaultOSDHz
+	}
+	hsiFrequency := query.HSIFrequency
+	if hsiFrequency == 0 {
+		hsiFrequency = drcDefaultHSIHz
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"mqtt_broker":   broker,
+		"osd_frequency": osdFrequency,
+		"hsi_frequency": hsiFrequency,
+	})
+
+	reply, err := s.commandService.Call(sn, "drc_mode_enter", data, drcEnterTimeout)
+	if err != nil {
+		// 回复超时时机场可能已进入DRC模式，补发一次退出
+		if err == ErrServiceReplyTimeout {
+			go s.exit(sn)
+		}
+		release()
+		return nil, fmt.Errorf("进入DRC模式失败: %v", err)
+	}
+	if reply.Result != 0 {
+		release()
+		return nil, fmt.Errorf("进入DRC模式失败: %s", reply.ErrorMessage)
+	}
+
+	return session, nil
+}
+
+// brokerConfig 生成下发给机场的 DRC MQTT 连接信息
+func (s *DrcService) brokerConfig(sn string) (map[string]interface{}, error) {
+	address, username, password, tls := s.brokerAddress, s.brokerUsername, s.brokerPassword, s.brokerTLS
+
+	if address == "" {
+		profile, err := s.mqttService.GetDefaultProfile()
+		if err != nil {
+			return nil, fmt.Errorf("未配置DRC MQTT服务器且没有默认MQTT配置")
+		}
+		conn := brokerConnectionFromConfig(profile.Config)
+		if conn.Protocol == EmbeddedBrokerProtocol {
+			return nil, fmt.Errorf("默认MQTT配置使用内置broker，需通过 DRC_BROKER_ADDRESS 指定机场可访问的地址")
+		}
+		address = conn.Address()
+		username = conn.Username
+		password = conn.Password
+		tls = conn.UsesTLS()
+	}
+
+	now := time.Now()
+	return map[string]interface{}{
+		"address":     address,
+		"client_id":   fmt.Sprintf("drc-%s-%d", sn, now.Unix()),
+		"username":    username,
+		"password":    password,
+		"expire_time": now.Add(drcBrokerTTL).Unix(),
+		"enable_tls":  tls,
+	}, nil
+}
+
+// close 结束会话：关闭连接、下发 drc_mode_exit 后释放控制权
+func (s *DrcService) close(session *drcSession, reason string) {
+	session.closeOnce.Do(func() {
+		close(session.done)
+		session.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason), time.Now().Add(drcWriteWait))
+		session.conn.Close()
+
+		s.exit(session.sn)
+
+		s.mutex.Lock()
+		if s.sessions[session.sn] == session {
+			delete(s.sessions, session.sn)
+		}
+		s.mutex.Unlock()
+
+		log.Printf("DRC会话已结束 %s, 操作员: %s", session.sn, session.operator)
+	})
+}
+
+// exit 下发 drc_mode_exit
+func (s *DrcService) exit(sn string) {
+	reply, err := s.commandService.Call(sn, "drc_mode_exit", nil, drcExitTimeout)
+	if err != nil {
+		log.Printf("退出DRC模式失败 %s: %v", sn, err)
+		return
+	}
+	if reply.Result != 0 {
+		log.Printf("退出DRC模式失败 %s: %s", sn, reply.ErrorMessage)
+	}
+}
+
+// readLoop 读取前端指令并转发到 drc/down，超时未收到 pong 或消息即断开
+func (s *DrcService) readLoop(session *drcSession) {
+	conn := session.conn
+	conn.SetReadLimit(drcMaxFrameSize)
+	conn.SetReadDeadline(time.Now().Add(drcPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(drcPongWait))
+	})
+
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("DRC WebSocket 读取失败 %s: %v", session.sn, err)
+			}
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(drcPongWait))
+
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		if err := s.forward(session, message); err != nil {
+			session.send(&models.DrcSocketMessage{Type: DrcMessageError, Message: err.Error()})
+		}
+	}
+}
+
+// forward 为前端指令分配序号后发布到 drc/down
+func (s *DrcService) forward(session *drcSession, message []byte) error {
+	var request struct {
+		Method string          `json:"method"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(message, &request); err != nil {
+		return fmt.Errorf("消息格式错误: %v", err)
+	}
+	if request.Method == "" {
+		return fmt.Errorf("缺少method")
+	}
+	// 心跳由后端统一发送，前端心跳仅用于保活
+	if request.Method == "heart_beat" {
+		return nil
+	}
+
+	seq := atomic.AddInt64(&session.seq, 1)
+	data := request.Data
+	if request.Method == drcMethodControl {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+			return fmt.Errorf("drone_control 参数错误")
+		}
+		fields["seq"] = seq
+		data, _ = json.Marshal(fields)
+	}
+
+	return s.publishDown(session.sn, request.Method, seq, data)
+}
+
+// publishDown 发布 drc/down 消息，控制指令使用 QoS 0 以降低延迟
+func (s *DrcService) publishDown(sn, method string, seq int64, data json.RawMessage) error {
+	if len(data) == 0 {
+		data = json.RawMessage("{}")
+	}
+	topic := strings.Replace(TopicDrcDown, "{sn}", sn, 1)
+	return s.ingestService.Publish(topic, 0, map[string]interface{}{
+		"method":    method,
+		"seq":       seq,
+		"timestamp": time.Now().UnixMilli(),
+		"data":      data,
+	})
+}
+
+// writeLoop 串行写入 WebSocket 并定时发送 ping
+func (s *DrcService) writeLoop(session *drcSession) {
+	ticker := time.NewTicker(drcPingInterval)
+	defer ticker.Stop()
+
+	conn := session.conn
+	for {
+		select {
+		case <-session.done:
+			return
+		case message := <-session.outbox:
+			conn.SetWriteDeadline(time.Now().Add(drcWriteWait))
+			if err := conn.WriteJSON(message); err != nil {
+				conn.Close()
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(drcWriteWait)); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// heartbeat 定时向机场发送 heart_beat，并根据 drc/up 判断链路状态
+func (s *DrcService) heartbeat(session *drcSession) {
+	ticker := time.NewTicker(drcHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-session.done:
+			return
+		case now := <-ticker.C:
+			seq := atomic.AddInt64(&session.seq, 1)
+			data, _ := json.Marshal(map[string]int64{"timestamp": now.UnixMilli()})
+			if err := s.publishDown(session.sn, "heart_beat", seq, data); err != nil {
+				log.Printf("发送DRC心跳失败 %s: %v", session.sn, err)
+			}
+
+			lastUp := session.startedAt
+			if lastUpAt := atomic.LoadInt64(&session.lastUpAt); lastUpAt > 0 {
+				lastUp = time.UnixMilli(lastUpAt)
+			}
+			lost := now.Sub(lastUp) > drcLinkTimeout
+			if lost && atomic.CompareAndSwapInt32(&session.linkLost, 0, 1) {
+				session.send(&models.DrcSocketMessage{Type: DrcMessageStatus, Message: "link_lost"})
+			} else if !lost && atomic.CompareAndSwapInt32(&session.linkLost, 1, 0) {
+				session.send(&models.DrcSocketMessage{Type: DrcMessageStatus, Message: "link_ok"})
+			}
+		}
+	}
+}
+
+// handleUp 将 drc/up 消息转发给持有控制权的前端
+func (s *DrcService) handleUp(sn string, msg *models.DJIMessage) {
+	s.mutex.Lock()
+	session := s.sessions[sn]
+	s.mutex.Unlock()
+	if session == nil {
+		return
+	}
+
+	atomic.StoreInt64(&session.lastUpAt, time.Now().UnixMilli())
+	session.send(&models.DrcSocketMessage{
+		Type:      DrcMessageUp,
+		Method:    msg.Method,
+		Seq:       msg.Seq,
+		Timestamp: msg.Timestamp,
+		Data:      msg.Data,
+	})
+}
+
+// GetSessions 获取当前的 DRC 会话
+func (s *DrcService) GetSessions() (*models.APIResponse, error) {
+	s.mutex.Lock()
+	sessions := make([]models.DrcSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		item := models.DrcSession{
+			SN:         session.sn,
+			Operator:   session.operator,
+			RemoteAddr: session.remoteAddr,
+			Seq:        atomic.LoadInt64(&session.seq),
+			LinkLost:   atomic.LoadInt32(&session.linkLost) == 1,
+			StartedAt:  session.startedAt.UnixMilli(),
+		}
+		if lastUpAt := atomic.LoadInt64(&session.lastUpAt); lastUpAt > 0 {
+			item.LastUpAt = &lastUpAt
+		}
+		sessions = append(sessions, item)
+	}
+	s.mutex.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].SN < sessions[j].SN })
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    sessions,
+	}, nil
+}
+
+// ReleaseSession 强制结束机场的 DRC 会话并退出 DRC 模式
+func (s *DrcService) ReleaseSession(sn string) (*models.APIResponse, error) {
+	s.mutex.Lock()
+	session := s.sessions[sn]
+	s.mutex.Unlock()
+
+	if session == nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "该设备没有DRC会话",
+		}, nil
+	}
+
+	s.close(session, "session released")
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "DRC会话已释放",
+	}, nil
+}
@@ -0,0 +1,593 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MQTT配置 protocol 为 embedded 时在进程内连接内置broker
+const EmbeddedBrokerProtocol = "embedded"
+
+// 内置broker账号角色
+const (
+	// 设备账号只能收发自身及子设备SN下的主题
+	BrokerRoleDevice = "device"
+	// 管理账号不受主题限制
+	BrokerRoleAdmin = "admin"
+)
+
+const (
+	embeddedTCPListenerID       = "tcp"
+	embeddedInProcessListenerID = "in-process"
+	// 设备账号子设备列表的缓存时间，新登记的子设备在该时间内生效
+	brokerACLRefreshInterval = 30 * time.Second
+)
+
+// brokerPrincipal 已认证连接的账号信息
+type brokerPrincipal struct {
+	username    string
+	role        string
+	sn          string
+	allowed     map[string]bool
+	refreshedAt time.Time
+}
+
+// EmbeddedBroker 进程内MQTT broker，供无外网的实验室与外场部署使用
+type EmbeddedBroker struct {
+	db            *database.DB
+	enabled       bool
+	listenAddress string
+
+	server    *mqttserver.Server
+	inProcess *inProcessListener
+
+	principals map[*mqttserver.Client]*brokerPrincipal
+	mutex      sync.Mutex
+}
+
+// NewEmbeddedBroker 创建内置MQTT broker，未启用时 Start 不监听任何端口
+func NewEmbeddedBroker(db *database.DB, enabled bool, listenAddress string) *EmbeddedBroker {
+	return &EmbeddedBroker{
+		db:            db,
+		enabled:       enabled,
+		listenAddress: listenAddress,
+		principals:    make(map[*mqttserver.Client]*brokerPrincipal),
+	}
+}
+
+// Start 启动内置broker的TCP监听与进程内监听
+func (b *EmbeddedBroker) Start() error {
+	if !b.enabled {
+		return nil
+	}
+
+	server := mqttserver.New(&mqttserver.Options{
+		Logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})),
+	})
+	if err := server.AddHook(&embeddedBrokerHook{broker: b}, nil); err != nil {
+		return err
+	}
+
+	tcp := listeners.NewTCP(listeners.Config{ID: embeddedTCPListenerID, Address: b.listenAddress})
+	if err := server.AddListener(tcp); err != nil {
+		return err
+	}
+	inProcess := newInProcessListener(embeddedInProcessListenerID)
+	if err := server.AddListener(inProcess); err != nil {
+		return err
+	}
+
+	if err := server.Serve(); err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	b.server = server
+	b.inProcess = inProcess
+	b.mutex.Unlock()
+
+	log.Printf("Embedded MQTT broker listening on %s", b.listenAddress)
+	return nil
+}
+
+// Stop 关闭内置broker及所有连接
+func (b *EmbeddedBroker) Stop() {
+	b.mutex.Lock()
+	server := b.server
+	b.server = nil
+	b.inProcess = nil
+	b.mutex.Unlock()
+
+	if server != nil {
+		server.Close()
+	}
+}
+
+// ApplyClientOptions 让 paho 客户端通过进程内连接访问内置broker
+func (b *EmbeddedBroker) ApplyClientOptions(opts *mqtt.ClientOptions) error {
+	if !b.running() {
+		return fmt.Errorf("内置MQTT broker未启用")
+	}
+
+	opts.AddBroker(EmbeddedBrokerProtocol + "://local")
+	opts.SetCustomOpenConnectionFn(func(uri *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+		return b.Dial()
+	})
+	return nil
+}
+
+// running 内置broker是否已启动
+func (b *EmbeddedBroker) running() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.server != nil
+}
+
+// Dial 建立到内置broker的进程内连接
+func (b *EmbeddedBroker) Dial() (net.Conn, error) {
+	b.mutex.Lock()
+	inProcess := b.inProcess
+	b.mutex.Unlock()
+	if inProcess == nil {
+		return nil, fmt.Errorf("内置MQTT broker已停止")
+	}
+	return inProcess.dial()
+}
+
+// authenticate 校验连接账号，进程内连接视为后端自身，不做校验
+func (b *EmbeddedBroker) authenticate(cl *mqttserver.Client, pk packets.Packet) bool {
+	if cl.Net.Listener == embeddedInProcessListenerID {
+		b.setPrincipal(cl, &brokerPrincipal{role: BrokerRoleAdmin})
+		return true
+	}
+
+	username := string(pk.Connect.Username)
+	var passwordHash, role, sn string
+	var enabled bool
+	err := b.db.QueryRow("SELECT password_hash, role, sn, enabled FROM broker_users WHERE username = ?", username).
+		Scan(&passwordHash, &role, &sn, &enabled)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("查询broker账号失败 %s: %v", username, err)
+		}
+		return false
+	}
+	if !enabled || bcrypt.CompareHashAndPassword([]byte(passwordHash), pk.Connect.Password) != nil {
+		return false
+	}
+
+	b.setPrincipal(cl, &brokerPrincipal{username: username, role: role, sn: sn})
+	return true
+}
+
+func (b *EmbeddedBroker) setPrincipal(cl *mqttserver.Client, principal *brokerPrincipal) {
+	b.mutex.Lock()
+	b.principals[cl] = principal
+	b.mutex.Unlock()
+}
+
+// checkACL 设备账号只允许 thing/product/{sn}/ 与 sys/product/{sn}/ 下自身及子设备的主题
+func (b *EmbeddedBroker) checkACL(cl *mqttserver.Client, topic string) bool {
+	b.mutex.Lock()
+	principal, ok := b.principals[cl]
+	b.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	if principal.role == BrokerRoleAdmin {
+		return true
+	}
+
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || (parts[0] != "thing" && parts[0] != "sys") || parts[1] != "product" {
+		return false
+	}
+	if parts[2] == principal.sn {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if time.Since(principal.refreshedAt) > brokerACLRefreshInterval {
+		allowed, err := b.subDevices(principal.sn)
+		if err != nil {
+			log.Printf("查询子设备失败 %s: %v", principal.sn, err)
+		} else {
+			principal.allowed = allowed
+			principal.refreshedAt = time.Now()
+		}
+	}
+	return principal.allowed[parts[2]]
+}
+
+// subDevices 查询挂载在网关下的已登记设备
+func (b *EmbeddedBroker) subDevices(gatewaySN string) (map[string]bool, error) {
+	rows, err := b.db.Query("SELECT sn FROM devices WHERE airport_sn = ?", gatewaySN)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allowed := make(map[string]bool)
+	for rows.Next() {
+		var sn string
+		if err := rows.Scan(&sn); err != nil {
+			return nil, err
+		}
+		allowed[sn] = true
+	}
+	return allowed, rows.Err()
+}
+
+func (b *EmbeddedBroker) removePrincipal(cl *mqttserver.Client) {
+	b.mutex.Lock()
+	delete(b.principals, cl)
+	b.mutex.Unlock()
+}
+
+// disconnectUser 断开账号的现有连接，账号修改或删除后需重新认证
+func (b *EmbeddedBroker) disconnectUser(username string) {
+	b.mutex.Lock()
+	server := b.server
+	b.mutex.Unlock()
+	if server == nil {
+		return
+	}
+
+	for _, cl := range server.Clients.GetAll() {
+		if cl.Net.Listener != embeddedInProcessListenerID && bytes.Equal(cl.Properties.Username, []byte(username)) {
+			server.DisconnectClient(cl, packets.ErrNotAuthorized)
+		}
+	}
+}
+
+// 获取内置broker运行状态
+func (b *EmbeddedBroker) GetStatus() (*models.APIResponse, error) {
+	b.mutex.Lock()
+	server := b.server
+	b.mutex.Unlock()
+
+	status := map[string]interface{}{
+		"enabled":        b.enabled,
+		"running":        server != nil,
+		"listen_address": b.listenAddress,
+		"clients":        0,
+	}
+	if server != nil {
+		status["clients"] = server.Info.ClientsConnected
+		status["messages_received"] = server.Info.MessagesReceived
+		status["messages_sent"] = server.Info.MessagesSent
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    status,
+	}, nil
+}
+
+// 获取broker账号列表
+func (b *EmbeddedBroker) GetUsers() (*models.APIResponse, error) {
+	rows, err := b.db.Query("SELECT id, username, role, sn, enabled, created_at, updated_at FROM broker_users ORDER BY username")
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取broker账号失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	users := []models.BrokerUser{}
+	for rows.Next() {
+		var user models.BrokerUser
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.SN, &user.Enabled, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描broker账号失败: %v", err),
+			}, err
+		}
+		users = append(users, user)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    users,
+	}, nil
+}
+
+// validateBrokerUser 校验账号角色与SN
+func validateBrokerUser(payload *models.BrokerUserPayload) string {
+	if payload.Role == "" {
+		payload.Role = BrokerRoleDevice
+	}
+	switch payload.Role {
+	case BrokerRoleDevice:
+		if payload.SN == "" {
+			return "设备账号需要指定sn"
+		}
+	case BrokerRoleAdmin:
+		payload.SN = ""
+	default:
+		return "不支持的角色: " + payload.Role
+	}
+	return ""
+}
+
+// 创建broker账号
+func (b *EmbeddedBroker) CreateUser(payload *models.BrokerUserPayload) (*models.APIResponse, error) {
+	if payload.Password == "" {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "密码不能为空",
+		}, nil
+	}
+	if msg := validateBrokerUser(payload); msg != "" {
+		return &models.APIResponse{
+			Code:    1,
+			Message: msg,
+		}, nil
+	}
+
+	var exists int
+	if err := b.db.QueryRow("SELECT COUNT(*) FROM broker_users WHERE username = ?", payload.Username).Scan(&exists); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("查询broker账号失败: %v", err),
+		}, err
+	}
+	if exists > 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "用户名已存在",
+		}, nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(payload.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("生成密码摘要失败: %v", err),
+		}, err
+	}
+
+	enabled := true
+	if payload.Enabled != nil {
+		enabled = *payload.Enabled
+	}
+
+	id := uuid.New().String()
+	now := time.Now().UnixMilli()
+	_, err = b.db.Exec("INSERT INTO broker_users (id, username, password_hash, role, sn, enabled, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		id, payload.Username, string(hash), payload.Role, payload.SN, enabled, now, now)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建broker账号失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "broker账号创建成功",
+		Data:    map[string]string{"id": id},
+	}, nil
+}
+
+// 更新broker账号，密码为空时保留原密码
+func (b *EmbeddedBroker) UpdateUser(id string, payload *models.BrokerUserPayload) (*models.APIResponse, error) {
+	if msg := validateBrokerUser(payload); msg != "" {
+		return &models.APIResponse{
+			Code:    1,
+			Message: msg,
+		}, nil
+	}
+
+	var username, passwordHash string
+	var enabled bool
+	err := b.db.QueryRow("SELECT username, password_hash, enabled FROM broker_users WHERE id = ?", id).Scan(&username, &passwordHash, &enabled)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "broker账号不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("查询broker账号失败: %v", err),
+		}, err
+	}
+
+	if payload.Username != username {
+		var exists int
+		if err := b.db.QueryRow("SELECT COUNT(*) FROM broker_users WHERE username = ?", payload.Username).Scan(&exists); err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("查询broker账号失败: %v", err),
+			}, err
+		}
+		if exists > 0 {
+			return &models.APIResponse{
+				Code:    1,
+				Message: "用户名已存在",
+			}, nil
+		}
+	}
+
+	if payload.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(payload.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("生成密码摘要失败: %v", err),
+			}, err
+		}
+		passwordHash = string(hash)
+	}
+	if payload.Enabled != nil {
+		enabled = *payload.Enabled
+	}
+
+	_, err = b.db.Exec("UPDATE broker_users SET username = ?, password_hash = ?, role = ?, sn = ?, enabled = ?, updated_at = ? WHERE id = ?",
+		payload.Username, passwordHash, payload.Role, payload.SN, enabled, time.Now().UnixMilli(), id)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("更新broker账号失败: %v", err),
+		}, err
+	}
+
+	b.disconnectUser(username)
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "broker账号更新成功",
+	}, nil
+}
+
+// 删除broker账号并断开其连接
+func (b *EmbeddedBroker) DeleteUser(id string) (*models.APIResponse, error) {
+	var username string
+	err := b.db.QueryRow("SELECT username FROM broker_users WHERE id = ?", id).Scan(&username)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "broker账号不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("查询broker账号失败: %v", err),
+		}, err
+	}
+
+	if _, err := b.db.Exec("DELETE FROM broker_users WHERE id = ?", id); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("删除broker账号失败: %v", err),
+		}, err
+	}
+
+	b.disconnectUser(username)
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "broker账号删除成功",
+	}, nil
+}
+
+// embeddedBrokerHook 接入账号认证与主题ACL
+type embeddedBrokerHook struct {
+	mqttserver.HookBase
+	broker *EmbeddedBroker
+}
+
+func (h *embeddedBrokerHook) ID() string {
+	return "drone-patrol-auth"
+}
+
+func (h *embeddedBrokerHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqttserver.OnConnectAuthenticate,
+		mqttserver.OnACLCheck,
+		mqttserver.OnDisconnect,
+	}, []byte{b})
+}
+
+func (h *embeddedBrokerHook) OnConnectAuthenticate(cl *mqttserver.Client, pk packets.Packet) bool {
+	return h.broker.authenticate(cl, pk)
+}
+
+func (h *embeddedBrokerHook) OnACLCheck(cl *mqttserver.Client, topic string, write bool) bool {
+	return h.broker.checkACL(cl, topic)
+}
+
+func (h *embeddedBrokerHook) OnDisconnect(cl *mqttserver.Client, err error, expire bool) {
+	h.broker.removePrincipal(cl)
+}
+
+// inProcessListener 以 net.Pipe 接入后端自身的MQTT客户端
+type inProcessListener struct {
+	id        string
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newInProcessListener(id string) *inProcessListener {
+	return &inProcessListener{
+		id:    id,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *inProcessListener) Init(*slog.Logger) error {
+	return nil
+}
+
+func (l *inProcessListener) Serve(establish listeners.EstablishFn) {
+	for {
+		select {
+		case conn := <-l.conns:
+			go establish(l.id, conn)
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (l *inProcessListener) ID() string {
+	return l.id
+}
+
+func (l *inProcessListener) Address() string {
+	return EmbeddedBrokerProtocol + "://local"
+}
+
+func (l *inProcessListener) Protocol() string {
+	return "pipe"
+}
+
+func (l *inProcessListener) Close(closeClients listeners.CloseFn) {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		closeClients(l.id)
+	})
+}
+
+// dial 创建一对内存连接，服务端一侧交给broker
+func (l *inProcessListener) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		client.Close()
+		server.Close()
+		return nil, fmt.Errorf("内置MQTT broker已停止")
+	}
+}
@@ -2,40 +2,127 @@ package services
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
+	"log"
 	"os"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"drone-patrol-backend/internal/models"
 )
 
-type ErrorCodeService struct{}
+const errorCodeReloadInterval = 5 * time.Second
 
-func NewErrorCodeService() *ErrorCodeService {
-	return &ErrorCodeService{}
+// errorCodeCategories 按DJI错误码前三位划分的分类
+var errorCodeCategories = []models.ErrorCodeCategory{
+	{Value: "upgrade", Label: "设备升级", Prefixes: []string{"312"}},
+	{Value: "flight", Label: "飞行任务", Prefixes: []string{"314"}},
+	{Value: "communication", Label: "通信异常", Prefixes: []string{"315", "338"}},
+	{Value: "aircraft", Label: "飞行器配置", Prefixes: []string{"316"}},
+	{Value: "media", Label: "媒体文件", Prefixes: []string{"317"}},
+	{Value: "system", Label: "机场系统", Prefixes: []string{"319"}},
+	{Value: "wayline", Label: "航线执行", Prefixes: []string{"321", "322", "386"}},
+	{Value: "log", Label: "远程日志", Prefixes: []string{"324"}},
+	{Value: "command", Label: "指令下发", Prefixes: []string{"325"}},
+	{Value: "network", Label: "增强图传", Prefixes: []string{"326"}},
+	{Value: "control", Label: "远程控制", Prefixes: []string{"327"}},
+	{Value: "compliance", Label: "实名登记", Prefixes: []string{"328"}},
+	{Value: "flyto", Label: "指点飞行", Prefixes: []string{"336", "337"}},
+	{Value: "live", Label: "直播", Prefixes: []string{"513"}},
+	{Value: "dock", Label: "机场运行", Prefixes: []string{"514"}},
+	{Value: "other", Label: "其他"},
 }
 
-// 获取错误码列表
-func (s *ErrorCodeService) GetErrorCodes() (*models.APIResponse, error) {
-	// 读取错误码文档
-	errorCodesFile := filepath.Join("..", "..", "public", "docs", "dji-error-codes.md")
-	file, err := os.Open(errorCodesFile)
+// errorCodeEntry 带检索文本的错误码
+type errorCodeEntry struct {
+	models.ErrorCode
+	searchText string
+}
+
+// ErrorCodeService 错误码目录，启动时加载并在文件变更时自动重新加载
+type ErrorCodeService struct {
+	path          string
+	deviceService *DeviceService
+
+	entries []errorCodeEntry
+	index   map[string]int
+	modTime time.Time
+	mutex   sync.RWMutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func NewErrorCodeService(path string, deviceService *DeviceService) *ErrorCodeService {
+	s := &ErrorCodeService{
+		path:          path,
+		deviceService: deviceService,
+		index:         make(map[string]int),
+		stopCh:        make(chan struct{}),
+	}
+
+	if err := s.reload(); err != nil {
+		log.Printf("加载错误码文件失败: %v", err)
+	}
+
+	return s
+}
+
+// Start 启动文件变更检测
+func (s *ErrorCodeService) Start() {
+	go func() {
+		ticker := time.NewTicker(errorCodeReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stopCh:
+				return
+			case <-ticker.C:
+				info, err := os.Stat(s.path)
+				if err != nil {
+					continue
+				}
+				s.mutex.RLock()
+				changed := !info.ModTime().Equal(s.modTime)
+				s.mutex.RUnlock()
+				if changed {
+					if err := s.reload(); err != nil {
+						log.Printf("重新加载错误码文件失败: %v", err)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stop 停止文件变更检测
+func (s *ErrorCodeService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+// reload 解析错误码文件并替换索引
+func (s *ErrorCodeService) reload() error {
+	file, err := os.Open(s.path)
 	if err != nil {
-		return &models.APIResponse{
-			Code:    1,
-			Message: fmt.Sprintf("读取错误码文件失败: %v", err),
-			Data:    []models.ErrorCode{},
-		}, err
+		return err
 	}
 	defer file.Close()
 
-	var errorCodes []models.ErrorCode
-	scanner := bufio.NewScanner(file)
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
 
-	// 跳过标题行
-	scanner.Scan()
+	var entries []errorCodeEntry
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(file)
 
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
@@ -43,80 +130,276 @@ func (s *ErrorCodeService) GetErrorCodes() (*models.APIResponse, error) {
 			continue
 		}
 
-		parts := strings.Split(line, "\t")
-		if len(parts) >= 2 {
-			code := strings.TrimSpace(parts[0])
-			description := strings.TrimSpace(parts[1])
-
-			// 根据错误码范围确定分类
-			codeNum, _ := strconv.Atoi(code)
-			category := s.getErrorCategory(codeNum)
-			severity := s.getErrorSeverity(description)
-
-			errorCodes = append(errorCodes, models.ErrorCode{
-				Code:        code,
-				Description: description,
-				Category:    category,
-				Severity:    severity,
-			})
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) < 2 {
+			continue
 		}
+
+		// 跳过标题行等非数字错误码
+		code := strings.TrimSpace(parts[0])
+		if _, err := strconv.Atoi(code); err != nil {
+			continue
+		}
+		description := strings.TrimSpace(parts[1])
+		if _, exists := index[code]; exists {
+			continue
+		}
+
+		category := s.getErrorCategory(code)
+		index[code] = len(entries)
+		entries = append(entries, errorCodeEntry{
+			ErrorCode: models.ErrorCode{
+				Code:          code,
+				Description:   description,
+				Category:      category.Value,
+				CategoryLabel: category.Label,
+				Severity:      s.getErrorSeverity(description),
+			},
+			searchText: strings.ToLower(code + " " + description),
+		})
 	}
 
 	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.entries = entries
+	s.index = index
+	s.modTime = info.ModTime()
+	s.mutex.Unlock()
+
+	log.Printf("已加载 %d 条错误码: %s", len(entries), s.path)
+	return nil
+}
+
+// Lookup 按错误码查找
+func (s *ErrorCodeService) Lookup(code string) (models.ErrorCode, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	i, ok := s.index[strings.TrimSpace(code)]
+	if !ok {
+		return models.ErrorCode{}, false
+	}
+	return s.entries[i].ErrorCode, true
+}
+
+// FormatMessage 将 result 错误码翻译为文案，{dock_org_name} 等占位符以机场名称替换
+func (s *ErrorCodeService) FormatMessage(code int, gatewaySN string) string {
+	errorCode, ok := s.Lookup(strconv.Itoa(code))
+	if !ok {
+		return fmt.Sprintf("未知错误码: %d", code)
+	}
+
+	message := errorCode.Description
+	if strings.Contains(message, "{dock_org_name}") {
+		name := gatewaySN
+		if s.deviceService != nil && gatewaySN != "" {
+			if deviceName, err := s.deviceService.GetDeviceName(gatewaySN); err == nil {
+				name = deviceName
+			}
+		}
+		message = strings.ReplaceAll(message, "{dock_org_name}", name)
+	}
+	return message
+}
+
+// AnnotateData 当 data.result 非零时追加 error_message 字段，返回是否有修改
+func (s *ErrorCodeService) AnnotateData(data json.RawMessage, gatewaySN string) (json.RawMessage, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return data, false
+	}
+
+	raw, ok := fields["result"]
+	if !ok {
+		return data, false
+	}
+	var result int
+	if err := json.Unmarshal(raw, &result); err != nil || result == 0 {
+		return data, false
+	}
+
+	message, err := json.Marshal(s.FormatMessage(result, gatewaySN))
+	if err != nil {
+		return data, false
+	}
+	fields["error_message"] = message
+
+	annotated, err := json.Marshal(fields)
+	if err != nil {
+		return data, false
+	}
+	return annotated, true
+}
+
+// AnnotatePayload 翻译 services_reply 与 events 主题消息中的 result 错误码
+func (s *ErrorCodeService) AnnotatePayload(topic string, payload []byte) ([]byte, bool) {
+	if !isResultTopic(topic) {
+		return payload, false
+	}
+
+	var message map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return payload, false
+	}
+	data, ok := message["data"]
+	if !ok {
+		return payload, false
+	}
+
+	gatewaySN := ""
+	if raw, ok := message["gateway"]; ok {
+		json.Unmarshal(raw, &gatewaySN)
+	}
+	if gatewaySN == "" {
+		if _, sn, ok := parseDeviceTopic(topic); ok {
+			gatewaySN = sn
+		}
+	}
+
+	annotated, changed := s.AnnotateData(data, gatewaySN)
+	if !changed {
+		return payload, false
+	}
+	message["data"] = annotated
+
+	result, err := json.Marshal(message)
+	if err != nil {
+		return payload, false
+	}
+	return result, true
+}
+
+// isResultTopic 判断主题是否为携带 result 的回复或事件
+func isResultTopic(topic string) bool {
+	return strings.HasSuffix(topic, "/services_reply") || strings.HasSuffix(topic, "/events")
+}
+
+// Search 按关键词、分类与严重程度检索，关键词以空格分隔且需全部命中
+func (s *ErrorCodeService) Search(query string, category, severity string) []models.ErrorCode {
+	terms := strings.Fields(strings.ToLower(query))
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := []models.ErrorCode{}
+	for _, entry := range s.entries {
+		if category != "" && entry.Category != category {
+			continue
+		}
+		if severity != "" && entry.Severity != severity {
+			continue
+		}
+
+		matched := true
+		for _, term := range terms {
+			if !strings.Contains(entry.searchText, term) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			result = append(result, entry.ErrorCode)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Code < result[j].Code })
+	return result
+}
+
+// 获取错误码列表
+func (s *ErrorCodeService) GetErrorCodes(query *models.ErrorCodeQuery) (*models.APIResponse, error) {
+	s.mutex.RLock()
+	loaded := len(s.entries) > 0
+	s.mutex.RUnlock()
+
+	if !loaded {
 		return &models.APIResponse{
 			Code:    1,
-			Message: fmt.Sprintf("读取错误码文件失败: %v", err),
+			Message: fmt.Sprintf("错误码目录未加载: %s", s.path),
 			Data:    []models.ErrorCode{},
-		}, err
+		}, fmt.Errorf("error code catalog not loaded")
+	}
+
+	codes := s.Search(query.Q, query.Category, query.Severity)
+
+	page, pageSize := query.Page, query.PageSize
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 50
+	}
+	if pageSize > 1000 {
+		pageSize = 1000
+	}
+
+	start := (page - 1) * pageSize
+	if start > len(codes) {
+		start = len(codes)
+	}
+	end := start + pageSize
+	if end > len(codes) {
+		end = len(codes)
 	}
 
 	return &models.APIResponse{
 		Code:    0,
 		Message: "ok",
-		Data:    errorCodes,
+		Data: map[string]interface{}{
+			"total":      len(codes),
+			"page":       page,
+			"pageSize":   pageSize,
+			"items":      codes[start:end],
+			"categories": errorCodeCategories,
+		},
 	}, nil
 }
 
-// 根据错误码确定分类
-func (s *ErrorCodeService) getErrorCategory(codeNum int) string {
-	switch {
-	case codeNum >= 1000 && codeNum < 2000:
-		return "系统错误"
-	case codeNum >= 2000 && codeNum < 3000:
-		return "通信错误"
-	case codeNum >= 3000 && codeNum < 4000:
-		return "传感器错误"
-	case codeNum >= 4000 && codeNum < 5000:
-		return "电池错误"
-	case codeNum >= 5000 && codeNum < 6000:
-		return "电机错误"
-	case codeNum >= 6000 && codeNum < 7000:
-		return "GPS错误"
-	case codeNum >= 7000 && codeNum < 8000:
-		return "相机错误"
-	case codeNum >= 8000 && codeNum < 9000:
-		return "云台错误"
-	case codeNum >= 9000 && codeNum < 10000:
-		return "遥控器错误"
-	default:
-		return "未知错误"
+// 按错误码获取详情
+func (s *ErrorCodeService) GetErrorCode(code string) (*models.APIResponse, error) {
+	errorCode, ok := s.Lookup(code)
+	if !ok {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "错误码不存在",
+		}, nil
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    errorCode,
+	}, nil
+}
+
+// 根据错误码前缀确定分类
+func (s *ErrorCodeService) getErrorCategory(code string) models.ErrorCodeCategory {
+	if len(code) >= 3 {
+		prefix := code[:3]
+		for _, category := range errorCodeCategories {
+			for _, p := range category.Prefixes {
+				if p == prefix {
+					return category
+				}
+			}
+		}
 	}
+	return errorCodeCategories[len(errorCodeCategories)-1]
 }
 
 // 根据错误描述确定严重程度
 func (s *ErrorCodeService) getErrorSeverity(description string) string {
-	description = strings.ToLower(description)
-
 	switch {
-	case strings.Contains(description, "严重") || strings.Contains(description, "致命") || strings.Contains(description, "critical"):
-		return "严重"
-	case strings.Contains(description, "警告") || strings.Contains(description, "warning"):
-		return "警告"
-	case strings.Contains(description, "注意") || strings.Contains(description, "notice"):
-		return "注意"
-	case strings.Contains(description, "信息") || strings.Contains(description, "info"):
-		return "信息"
+	case strings.Contains(description, "失败") || strings.Contains(description, "异常") || strings.Contains(description, "错误"):
+		return "error"
+	case strings.Contains(description, "超时") || strings.Contains(description, "无法") || strings.Contains(description, "请重试"):
+		return "warning"
+	case strings.Contains(description, "成功") || strings.Contains(description, "完成"):
+		return "success"
 	default:
-		return "未知"
+		return "info"
 	}
 }
@@ -0,0 +1,465 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	FlightStatusInProgress = "in_progress"
+	FlightStatusCompleted  = "completed"
+	FlightStatusLost       = "lost"
+)
+
+const (
+	flightCheckInterval  = 15 * time.Second
+	flightSignalTimeout  = 5 * time.Minute
+	flightUpdateInterval = int64(30 * 1000)
+	flightMaxJumpMeters  = 500.0
+)
+
+// flyingModeCodes 飞行器处于空中的 mode_code
+var flyingModeCodes = map[int]bool{
+	3: true, 4: true, 5: true, 6: true, 7: true, 8: true, 9: true,
+	10: true, 11: true, 12: true, 15: true, 16: true, 17: true,
+}
+
+// groundModeCodes 可判定为已落地的 mode_code，14(未连接)不视为落地
+var groundModeCodes = map[int]bool{0: true, 1: true, 2: true}
+
+// activeFlight 进行中的飞行统计
+type activeFlight struct {
+	flight    models.Flight
+	lastLat   float64
+	lastLon   float64
+	lastTS    int64
+	lastSaved int64
+}
+
+// FlightService 根据osd检测起降并生成飞行记录
+type FlightService struct {
+	db               *database.DB
+	ingestService    *IngestService
+	telemetryService *TelemetryService
+
+	active map[string]*activeFlight
+	mutex  sync.Mutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewFlightService 创建飞行记录服务
+func NewFlightService(db *database.DB, ingestService *IngestService, telemetryService *TelemetryService) *FlightService {
+	s := &FlightService{
+		db:               db,
+		ingestService:    ingestService,
+		telemetryService: telemetryService,
+		active:           make(map[string]*activeFlight),
+		stopCh:           make(chan struct{}),
+	}
+
+	ingestService.RegisterHandler(TopicOSD, 0, s.handleOSD)
+
+	return s
+}
+
+// Start 恢复未结束的飞行并启动失联检测
+func (s *FlightService) Start() {
+	flights, err := s.queryFlights("WHERE status = ?", []interface{}{FlightStatusInProgress})
+	if err != nil {
+		log.Printf("加载进行中的飞行失败: %v", err)
+	}
+
+	s.mutex.Lock()
+	now := time.Now().UnixMilli()
+	for _, flight := range flights {
+		s.active[flight.AircraftSN] = &activeFlight{flight: flight, lastTS: now, lastSaved: now}
+	}
+	s.mutex.Unlock()
+
+	go s.run()
+}
+
+// Stop 停止并保存进行中的飞行统计
+func (s *FlightService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for _, active := range s.active {
+			s.saveFlight(&active.flight)
+		}
+	})
+}
+
+func (s *FlightService) run() {
+	ticker := time.NewTicker(flightCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.closeLostFlights()
+		}
+	}
+}
+
+// handleOSD 根据飞行器状态变化开始、更新或结束飞行
+func (s *FlightService) handleOSD(sn string, msg *models.DJIMessage) {
+	snapshot, ok := s.ingestService.GetSnapshot(sn)
+	if !ok || snapshot.Aircraft == nil {
+		return
+	}
+
+	osd := snapshot.Aircraft
+	ts := msg.Timestamp
+	if ts <= 0 {
+		ts = time.Now().UnixMilli()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	active, flying := s.active[sn]
+	switch {
+	case !flying && isAircraftInSky(osd):
+		s.startFlight(sn, snapshot.Gateway, osd, ts)
+	case flying && isAircraftLanded(osd):
+		s.updateFlight(active, osd, ts)
+		s.endFlight(sn, FlightStatusCompleted, "landed")
+	case flying:
+		s.updateFlight(active, osd, ts)
+		if ts-active.lastSaved >= flightUpdateInterval {
+			s.saveFlight(&active.flight)
+			active.lastSaved = ts
+		}
+	}
+}
+
+// isAircraftInSky 优先使用 in_the_sky 字段，缺失时按 mode_code 判断
+func isAircraftInSky(osd *models.AircraftOSD) bool {
+	if osd.InTheSky != nil {
+		return *osd.InTheSky == 1
+	}
+	return flyingModeCodes[osd.ModeCode]
+}
+
+// isAircraftLanded 判断飞行器是否已落地
+func isAircraftLanded(osd *models.AircraftOSD) bool {
+	if osd.InTheSky != nil {
+		return *osd.InTheSky == 0
+	}
+	return groundModeCodes[osd.ModeCode]
+}
+
+// startFlight 创建飞行记录，调用方需持有锁
+func (s *FlightService) startFlight(sn, dockSN string, osd *models.AircraftOSD, ts int64) {
+	now := time.Now().UnixMilli()
+	flight := models.Flight{
+		ID:           uuid.New().String(),
+		AircraftSN:   sn,
+		DockSN:       dockSN,
+		Status:       FlightStatusInProgress,
+		StartTime:    ts,
+		MaxAltitude:  osd.Elevation,
+		StartBattery: osd.Battery.CapacityPercent,
+		EndBattery:   osd.Battery.CapacityPercent,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	// 返航点优先使用机场位置，否则使用起飞位置
+	if dock, ok := s.ingestService.GetSnapshot(dockSN); ok && dock.Dock != nil && dock.Dock.Latitude != 0 {
+		flight.HomeLatitude = &dock.Dock.Latitude
+		flight.HomeLongitude = &dock.Dock.Longitude
+	} else if osd.Latitude != 0 || osd.Longitude != 0 {
+		lat, lon := osd.Latitude, osd.Longitude
+		flight.HomeLatitude = &lat
+		flight.HomeLongitude = &lon
+	}
+
+	query := `INSERT INTO flights (id, aircraft_sn, dock_sn, status, start_time, max_altitude, start_battery, end_battery,
+			  home_latitude, home_longitude, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.Exec(query, flight.ID, flight.AircraftSN, flight.DockSN, flight.Status, flight.StartTime,
+		flight.MaxAltitude, flight.StartBattery, flight.EndBattery, flight.HomeLatitude, flight.HomeLongitude,
+		flight.CreatedAt, flight.UpdatedAt)
+	if err != nil {
+		log.Printf("创建飞行记录失败 %s: %v", sn, err)
+		return
+	}
+
+	s.active[sn] = &activeFlight{
+		flight:    flight,
+		lastLat:   osd.Latitude,
+		lastLon:   osd.Longitude,
+		lastTS:    ts,
+		lastSaved: ts,
+	}
+	log.Printf("飞行开始: %s (机场 %s) flight=%s", sn, dockSN, flight.ID)
+}
+
+// updateFlight 累计飞行距离、最大高度与电量，调用方需持有锁
+func (s *FlightService) updateFlight(active *activeFlight, osd *models.AircraftOSD, ts int64) {
+	if osd.Latitude != 0 || osd.Longitude != 0 {
+		if active.lastLat != 0 || active.lastLon != 0 {
+			// 忽略定位跳变
+			if d := haversineMeters(active.lastLat, active.lastLon, osd.Latitude, osd.Longitude); d < flightMaxJumpMeters {
+				active.flight.Distance += d
+			}
+		}
+		active.lastLat = osd.Latitude
+		active.lastLon = osd.Longitude
+	}
+
+	if osd.Elevation > active.flight.MaxAltitude {
+		active.flight.MaxAltitude = osd.Elevation
+	}
+	if osd.Battery.CapacityPercent > 0 {
+		active.flight.EndBattery = osd.Battery.CapacityPercent
+		if active.flight.StartBattery == 0 {
+			active.flight.StartBattery = osd.Battery.CapacityPercent
+		}
+	}
+
+	if ts > active.lastTS {
+		active.lastTS = ts
+	}
+	active.flight.Duration = (active.lastTS - active.flight.StartTime) / 1000
+	active.flight.BatteryUsed = active.flight.StartBattery - active.flight.EndBattery
+}
+
+// endFlight 结束飞行并写入最终统计，调用方需持有锁
+func (s *FlightService) endFlight(sn, status, reason string) {
+	active, ok := s.active[sn]
+	if !ok {
+		return
+	}
+	delete(s.active, sn)
+
+	endTime := active.lastTS
+	active.flight.EndTime = &endTime
+	active.flight.Status = status
+	active.flight.EndReason = reason
+	s.saveFlight(&active.flight)
+
+	log.Printf("飞行结束: %s flight=%s 时长 %ds 距离 %.0fm (%s)", sn, active.flight.ID,
+		active.flight.Duration, active.flight.Distance, reason)
+}
+
+// saveFlight 保存飞行统计
+func (s *FlightService) saveFlight(flight *models.Flight) {
+	flight.UpdatedAt = time.Now().UnixMilli()
+
+	query := `UPDATE flights SET status = ?, end_reason = ?, end_time = ?, duration = ?, distance = ?, max_altitude = ?,
+			  start_battery = ?, end_battery = ?, battery_used = ?, updated_at = ? WHERE id = ?`
+	_, err := s.db.Exec(query, flight.Status, flight.EndReason, flight.EndTime, flight.Duration, flight.Distance,
+		flight.MaxAltitude, flight.StartBattery, flight.EndBattery, flight.BatteryUsed, flight.UpdatedAt, flight.ID)
+	if err != nil {
+		log.Printf("保存飞行记录失败 %s: %v", flight.ID, err)
+	}
+}
+
+// closeLostFlights 长时间无osd上报的飞行标记为失联结束
+func (s *FlightService) closeLostFlights() {
+	deadline := time.Now().Add(-flightSignalTimeout).UnixMilli()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for sn, active := range s.active {
+		if active.lastTS < deadline {
+			s.endFlight(sn, FlightStatusLost, "signal_timeout")
+		}
+	}
+}
+
+// haversineMeters 计算两点间球面距离（米）
+func haversineMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000.0
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// queryFlights 按条件查询飞行记录
+func (s *FlightService) queryFlights(where string, args []interface{}) ([]models.Flight, error) {
+	query := `SELECT id, aircraft_sn, dock_sn, status, end_reason, start_time, end_time, duration, distance, max_altitude,
+			  start_battery, end_battery, battery_used, home_latitude, home_longitude, created_at, updated_at
+			  FROM flights ` + where
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flights := []models.Flight{}
+	for rows.Next() {
+		var flight models.Flight
+		var endTime sql.NullInt64
+		var homeLat, homeLon sql.NullFloat64
+
+		err := rows.Scan(&flight.ID, &flight.AircraftSN, &flight.DockSN, &flight.Status, &flight.EndReason,
+			&flight.StartTime, &endTime, &flight.Duration, &flight.Distance, &flight.MaxAltitude,
+			&flight.StartBattery, &flight.EndBattery, &flight.BatteryUsed, &homeLat, &homeLon,
+			&flight.CreatedAt, &flight.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if endTime.Valid {
+			flight.EndTime = &endTime.Int64
+		}
+		if homeLat.Valid && homeLon.Valid {
+			flight.HomeLatitude = &homeLat.Float64
+			flight.HomeLongitude = &homeLon.Float64
+		}
+		flights = append(flights, flight)
+	}
+	return flights, rows.Err()
+}
+
+// 获取飞行记录列表
+func (s *FlightService) GetFlights(query *models.FlightQuery, from, to int64) (*models.APIResponse, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if query.SN != "" {
+		conditions = append(conditions, "aircraft_sn = ?")
+		args = append(args, query.SN)
+	}
+	if query.DockSN != "" {
+		conditions = append(conditions, "dock_sn = ?")
+		args = append(args, query.DockSN)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, query.Status)
+	}
+	if from > 0 {
+		conditions = append(conditions, "start_time >= ?")
+		args = append(args, from)
+	}
+	if to > 0 {
+		conditions = append(conditions, "start_time <= ?")
+		args = append(args, to)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM flights "+where, args...).Scan(&total); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取飞行记录失败: %v", err),
+		}, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	pageArgs := append(args, pageSize, (page-1)*pageSize)
+
+	flights, err := s.queryFlights(where+" ORDER BY start_time DESC LIMIT ? OFFSET ?", pageArgs)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取飞行记录失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+			"items":    flights,
+		},
+	}, nil
+}
+
+// 获取飞行记录详情及轨迹
+func (s *FlightService) GetFlight(flightID string) (*models.APIResponse, error) {
+	flights, err := s.queryFlights("WHERE id = ?", []interface{}{flightID})
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取飞行记录失败: %v", err),
+		}, err
+	}
+	if len(flights) == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "飞行记录不存在",
+		}, nil
+	}
+
+	flight := flights[0]
+
+	// 进行中的飞行使用内存中的最新统计
+	s.mutex.Lock()
+	if active, ok := s.active[flight.AircraftSN]; ok && active.flight.ID == flight.ID {
+		flight = active.flight
+	}
+	s.mutex.Unlock()
+
+	end := time.Now().UnixMilli()
+	if flight.EndTime != nil {
+		end = *flight.EndTime
+	}
+
+	s.telemetryService.flush()
+	fields := []string{"latitude", "longitude", "height", "elevation", "horizontal_speed", "battery", "attitude_head"}
+	trajectory, _, err := s.telemetryService.QueryTelemetry(flight.AircraftSN, flight.StartTime, end, fields, telemetryMaxPoints)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取飞行轨迹失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"flight":     flight,
+			"trajectory": trajectory,
+		},
+	}, nil
+}
+
+// normalizePage 规范化分页参数
+func normalizePage(page, pageSize int) (int, int) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+	if pageSize > 200 {
+		pageSize = 200
+	}
+	return page, pageSize
+}
@@ -0,0 +1,354 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+)
+
+// HmsService HMS健康告警服务，持久化告警并解析中英文文案
+type HmsService struct {
+	db            *database.DB
+	ingestService *IngestService
+
+	messages map[string]map[string]string
+
+	// 每个网关当前未清除的告警: sn -> alarm_key -> id
+	active map[string]map[string]int64
+	mutex  sync.Mutex
+}
+
+// NewHmsService 创建HMS告警服务
+func NewHmsService(db *database.DB, ingestService *IngestService, docsDir string) *HmsService {
+	s := &HmsService{
+		db:            db,
+		ingestService: ingestService,
+		messages:      make(map[string]map[string]string),
+		active:        make(map[string]map[string]int64),
+	}
+
+	if err := s.loadMessages(filepath.Join(docsDir, "hms.json")); err != nil {
+		log.Printf("加载HMS文案失败: %v", err)
+	}
+
+	ingestService.RegisterHandler(TopicEvents, 1, s.handleEvent)
+
+	return s
+}
+
+// loadMessages 加载 hms.json 文案
+func (s *HmsService) loadMessages(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var messages map[string]map[string]string
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return fmt.Errorf("解析 %s 失败: %v", path, err)
+	}
+
+	// hms.json 中的告警码大小写不统一，以小写键建立索引；大小写两种写法并存时保留大写十六进制的条目
+	index := make(map[string]map[string]string, len(messages))
+	for key, entry := range messages {
+		lower := strings.ToLower(key)
+		if _, exists := index[lower]; exists && key == lower {
+			continue
+		}
+		index[lower] = entry
+	}
+
+	s.messages = index
+	log.Printf("已加载 %d 条HMS文案", len(index))
+	return nil
+}
+
+// Start 加载未清除的告警
+func (s *HmsService) Start() {
+	rows, err := s.db.Query("SELECT id, sn, alarm_key FROM hms_alarms WHERE active = 1")
+	if err != nil {
+		log.Printf("加载HMS告警失败: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for rows.Next() {
+		var id int64
+		var sn, key string
+		if err := rows.Scan(&id, &sn, &key); err != nil {
+			log.Printf("加载HMS告警失败: %v", err)
+			return
+		}
+		if s.active[sn] == nil {
+			s.active[sn] = make(map[string]int64)
+		}
+		s.active[sn][key] = id
+	}
+}
+
+// handleEvent 处理 hms 事件，list 为当前全部告警，不在列表中的告警视为已清除
+func (s *HmsService) handleEvent(sn string, msg *models.DJIMessage) {
+	if msg.Method != "hms" {
+		return
+	}
+
+	var data models.HmsEventData
+	if err := json.Unmarshal(msg.Data, &data); err != nil {
+		log.Printf("解析HMS事件失败 %s: %v", sn, err)
+		return
+	}
+
+	now := time.Now().UnixMilli()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous := s.active[sn]
+	current := make(map[string]int64, len(data.List))
+
+	for _, item := range data.List {
+		key := hmsAlarmKey(item)
+		if _, seen := current[key]; seen {
+			continue
+		}
+
+		if id, ok := previous[key]; ok {
+			if _, err := s.db.Exec("UPDATE hms_alarms SET last_seen = ?, level = ? WHERE id = ?", now, item.Level, id); err != nil {
+				log.Printf("更新HMS告警失败 %d: %v", id, err)
+			}
+			current[key] = id
+			continue
+		}
+
+		zh, en := s.Describe(item)
+		result, err := s.db.Exec(`INSERT INTO hms_alarms (sn, alarm_key, code, device_type, level, module, in_the_sky,
+			component_index, sensor_index, message_zh, message_en, active, first_seen, last_seen)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?)`,
+			sn, key, normalizeHmsCode(item.Code), item.DeviceType, item.Level, item.Module, item.InTheSky,
+			item.Args.ComponentIndex, item.Args.SensorIndex, zh, en, now, now)
+		if err != nil {
+			log.Printf("保存HMS告警失败 %s: %v", sn, err)
+			continue
+		}
+		id, _ := result.LastInsertId()
+		current[key] = id
+	}
+
+	for key, id := range previous {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		if _, err := s.db.Exec("UPDATE hms_alarms SET active = 0, cleared_at = ? WHERE id = ?", now, id); err != nil {
+			log.Printf("清除HMS告警失败 %d: %v", id, err)
+		}
+	}
+
+	s.active[sn] = current
+}
+
+// hmsAlarmKey 告警唯一标识：同一设备同一告警码在同一部件上只保留一条未清除记录
+func hmsAlarmKey(item models.HmsEventItem) string {
+	return fmt.Sprintf("%s|%s|%d|%d", normalizeHmsCode(item.Code), item.DeviceType,
+		item.Args.ComponentIndex, item.Args.SensorIndex)
+}
+
+// normalizeHmsCode 统一告警码格式为 0x 加大写十六进制
+func normalizeHmsCode(code string) string {
+	code = strings.TrimSpace(code)
+	if strings.HasPrefix(code, "0x") || strings.HasPrefix(code, "0X") {
+		return "0x" + strings.ToUpper(code[2:])
+	}
+	return strings.ToUpper(code)
+}
+
+// Describe 按 hms.json 解析告警的中英文文案
+func (s *HmsService) Describe(item models.HmsEventItem) (string, string) {
+	code := normalizeHmsCode(item.Code)
+
+	// 机场告警使用 dock_tip 前缀，飞行器及负载使用 fpv_tip 前缀；空中告警优先匹配 _in_the_sky 文案
+	prefixes := []string{"fpv_tip_", "dock_tip_"}
+	if strings.HasPrefix(item.DeviceType, "3-") {
+		prefixes = []string{"dock_tip_", "fpv_tip_"}
+	}
+
+	lower := strings.ToLower(code)
+	var entry map[string]string
+	for _, prefix := range prefixes {
+		if item.InTheSky == 1 {
+			if e, ok := s.messages[prefix+lower+"_in_the_sky"]; ok {
+				entry = e
+				break
+			}
+		}
+		if e, ok := s.messages[prefix+lower]; ok {
+			entry = e
+			break
+		}
+	}
+
+	if entry == nil {
+		return "未知告警 " + code, "Unknown alarm " + code
+	}
+
+	zh := formatHmsMessage(entry["zh"], item, code, "zh")
+	en := formatHmsMessage(entry["en"], item, code, "en")
+	if zh == "" {
+		zh = en
+	}
+	if en == "" {
+		en = zh
+	}
+	return zh, en
+}
+
+// formatHmsMessage 替换文案中的占位符
+func formatHmsMessage(text string, item models.HmsEventItem, code, lang string) string {
+	if text == "" {
+		return ""
+	}
+
+	side := map[string][2]string{"zh": {"左", "右"}, "en": {"Left", "Right"}}[lang]
+	sideText := side[0]
+	if item.Args.ComponentIndex == 1 {
+		sideText = side[1]
+	}
+
+	sensor := strconv.Itoa(item.Args.SensorIndex + 1)
+	replacer := strings.NewReplacer(
+		"%alarmid", code,
+		"%component_index", strconv.Itoa(item.Args.ComponentIndex+1),
+		"%battery_index", sideText,
+		"%dock_cover_index", sideText,
+		"%lidar_index", sensor,
+		"%lte_index", sensor,
+		"%index", sensor,
+	)
+	return replacer.Replace(text)
+}
+
+// 获取HMS告警列表
+func (s *HmsService) GetAlarms(query *models.HmsQuery) (*models.APIResponse, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if query.SN != "" {
+		conditions = append(conditions, "sn = ?")
+		args = append(args, query.SN)
+	}
+	if query.Level != nil {
+		conditions = append(conditions, "level = ?")
+		args = append(args, *query.Level)
+	}
+	if query.Active != nil {
+		conditions = append(conditions, "active = ?")
+		args = append(args, *query.Active)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM hms_alarms "+where, args...).Scan(&total); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取HMS告警失败: %v", err),
+		}, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	rows, err := s.db.Query(`SELECT id, sn, code, device_type, level, module, in_the_sky, component_index, sensor_index,
+		message_zh, message_en, active, first_seen, last_seen, cleared_at, acknowledged, acknowledged_by, acknowledged_at
+		FROM hms_alarms `+where+` ORDER BY active DESC, last_seen DESC LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取HMS告警失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	alarms := []models.HmsAlarm{}
+	for rows.Next() {
+		var alarm models.HmsAlarm
+		var clearedAt, acknowledgedAt sql.NullInt64
+
+		err := rows.Scan(&alarm.ID, &alarm.SN, &alarm.Code, &alarm.DeviceType, &alarm.Level, &alarm.Module,
+			&alarm.InTheSky, &alarm.ComponentIndex, &alarm.SensorIndex, &alarm.MessageZh, &alarm.MessageEn,
+			&alarm.Active, &alarm.FirstSeen, &alarm.LastSeen, &clearedAt, &alarm.Acknowledged,
+			&alarm.AcknowledgedBy, &acknowledgedAt)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描HMS告警失败: %v", err),
+			}, err
+		}
+
+		if clearedAt.Valid {
+			alarm.ClearedAt = &clearedAt.Int64
+		}
+		if acknowledgedAt.Valid {
+			alarm.AcknowledgedAt = &acknowledgedAt.Int64
+		}
+		alarms = append(alarms, alarm)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+			"items":    alarms,
+		},
+	}, nil
+}
+
+// 确认HMS告警
+func (s *HmsService) AcknowledgeAlarm(alarmID int64, user string) (*models.APIResponse, error) {
+	result, err := s.db.Exec("UPDATE hms_alarms SET acknowledged = 1, acknowledged_by = ?, acknowledged_at = ? WHERE id = ?",
+		user, time.Now().UnixMilli(), alarmID)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("确认HMS告警失败: %v", err),
+		}, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取确认结果失败: %v", err),
+		}, err
+	}
+
+	if rowsAffected == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "HMS告警不存在",
+		}, nil
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "HMS告警已确认",
+	}, nil
+}
@@ -0,0 +1,772 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// DJI Cloud API 主题模板，{sn} 为设备序列号占位符
+const (
+	TopicOSD         = "thing/product/{sn}/osd"
+	TopicState       = "thing/product/{sn}/state"
+	TopicEvents      = "thing/product/{sn}/events"
+	TopicEventsReply = "thing/product/{sn}/events_reply"
+)
+
+const (
+	ingestSyncInterval  = 10 * time.Second
+	ingestFlushInterval = 5 * time.Second
+	ingestClientID      = "drone_patrol_backend"
+	ingestQueueSize     = 4096
+)
+
+// MessageHandler 设备消息处理函数
+type MessageHandler func(sn string, msg *models.DJIMessage)
+
+// MessageTap 后端MQTT连接收到或发布的原始消息旁路，outgoing 为后端自身发布的消息
+type MessageTap func(msg *BrokerMessage, outgoing bool)
+
+// IngestService 后端常驻MQTT消费服务，负责订阅设备主题并维护遥测快照
+type IngestService struct {
+	db               *database.DB
+	mqttService      *MQTTService
+	errorCodeService *ErrorCodeService
+	embeddedBroker   *EmbeddedBroker
+
+	client           BrokerClient
+	profileID        string
+	profileUpdatedAt int64
+	lastConnectError string
+	clientMutex      sync.Mutex
+
+	handlers     map[string][]MessageHandler
+	handlerQoS   map[string]byte
+	subscribed   map[string]bool
+	handlerMutex sync.RWMutex
+
+	requests *RequestRouter
+
+	taps     map[int]MessageTap
+	nextTap  int
+	tapMutex sync.RWMutex
+
+	// 共享订阅分组及使用共享订阅的主题模板，多个后端副本分摊这些主题的消息
+	sharedGroup  string
+	sharedTopics map[string]bool
+
+	queue   chan *BrokerMessage
+	dropped int64
+
+	snapshots     map[string]*models.DeviceSnapshot
+	dirty         map[string]bool
+	snapshotMutex sync.RWMutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewIngestService 创建MQTT消费服务，sharedGroup 非空时 sharedTopics 中的主题模板以 $share/{group}/ 共享订阅
+func NewIngestService(db *database.DB, mqttService *MQTTService, errorCodeService *ErrorCodeService, embeddedBroker *EmbeddedBroker,
+	sharedGroup string, sharedTopics []string) *IngestService {
+	s := &IngestService{
+		db:               db,
+		mqttService:      mqttService,
+		errorCodeService: errorCodeService,
+		embeddedBroker:   embeddedBroker,
+		handlers:         make(map[string][]MessageHandler),
+		handlerQoS:       make(map[string]byte),
+		subscribed:       make(map[string]bool),
+		taps:             make(map[int]MessageTap),
+		snapshots:        make(map[string]*models.DeviceSnapshot),
+		dirty:            make(map[string]bool),
+		sharedGroup:      sharedGroup,
+		sharedTopics:     make(map[string]bool),
+		queue:            make(chan *BrokerMessage, ingestQueueSize),
+		stopCh:           make(chan struct{}),
+	}
+	if sharedGroup != "" {
+		for _, template := range sharedTopics {
+			s.sharedTopics[template] = true
+		}
+	}
+
+	s.RegisterHandler(TopicOSD, 0, s.handleOSD)
+	s.RegisterHandler(TopicState, 0, s.handleState)
+	s.RegisterHandler(TopicEvents, 1, s.handleEventReply)
+
+	s.requests = NewRequestRouter(s)
+	s.RegisterHandler(TopicRequests, 1, s.requests.Dispatch)
+
+	return s
+}
+
+// RegisterHandler 注册主题处理函数，需在 Start 之前调用
+// 同一主题的处理函数按注册顺序调用，内置的快照处理最先执行，后续处理函数可通过 GetSnapshot 读取已解析的数据
+func (s *IngestService) RegisterHandler(template string, qos byte, handler MessageHandler) {
+	s.handlerMutex.Lock()
+	defer s.handlerMutex.Unlock()
+
+	s.handlers[template] = append(s.handlers[template], handler)
+	if qos > s.handlerQoS[template] || len(s.handlers[template]) == 1 {
+		s.handlerQoS[template] = qos
+	}
+}
+
+// RegisterRequestHandler 注册设备 requests 的处理函数，回复由 requests_reply 统一发送
+func (s *IngestService) RegisterRequestHandler(method string, handler RequestHandler) {
+	s.requests.Handle(method, handler)
+}
+
+// AddTap 注册原始消息旁路，返回 RemoveTap 使用的编号
+// 旁路在MQTT回调中同步调用，不能阻塞；只能看到后端已订阅主题的消息，不会额外订阅
+func (s *IngestService) AddTap(tap MessageTap) int {
+	s.tapMutex.Lock()
+	defer s.tapMutex.Unlock()
+
+	s.nextTap++
+	s.taps[s.nextTap] = tap
+	return s.nextTap
+}
+
+// RemoveTap 移除原始消息旁路
+func (s *IngestService) RemoveTap(id int) {
+	s.tapMutex.Lock()
+	delete(s.taps, id)
+	s.tapMutex.Unlock()
+}
+
+// tap 将消息交给已注册的旁路
+func (s *IngestService) tap(msg *BrokerMessage, outgoing bool) {
+	s.tapMutex.RLock()
+	defer s.tapMutex.RUnlock()
+
+	for _, tap := range s.taps {
+		tap(msg, outgoing)
+	}
+}
+
+// Start 启动后台消费循环
+func (s *IngestService) Start() {
+	if err := s.loadSnapshots(); err != nil {
+		log.Printf("加载设备快照失败: %v", err)
+	}
+
+	go s.dispatch()
+	go s.run()
+}
+
+// Stop 停止消费并落盘快照
+func (s *IngestService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+
+		s.clientMutex.Lock()
+		if s.client != nil {
+			s.client.Disconnect()
+		}
+		s.clientMutex.Unlock()
+
+		s.flushSnapshots()
+	})
+}
+
+func (s *IngestService) run() {
+	s.ensureConnected()
+
+	syncTicker := time.NewTicker(ingestSyncInterval)
+	defer syncTicker.Stop()
+	flushTicker := time.NewTicker(ingestFlushInterval)
+	defer flushTicker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-syncTicker.C:
+			s.ensureConnected()
+			s.RefreshSubscriptions()
+		case <-flushTicker.C:
+			s.flushSnapshots()
+		}
+	}
+}
+
+// IsConnected 是否已连接到MQTT服务器
+func (s *IngestService) IsConnected() bool {
+	s.clientMutex.Lock()
+	defer s.clientMutex.Unlock()
+
+	return s.client != nil && s.client.IsConnectionOpen()
+}
+
+// Publish 通过后端MQTT连接发布消息，payload 非 []byte/string 时按JSON序列化
+func (s *IngestService) Publish(topic string, qos byte, payload interface{}) error {
+	var body []byte
+	switch p := payload.(type) {
+	case []byte:
+		body = p
+	case string:
+		body = []byte(p)
+	default:
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("序列化消息失败: %v", err)
+		}
+		body = data
+	}
+
+	s.clientMutex.Lock()
+	client := s.client
+	s.clientMutex.Unlock()
+
+	if client == nil || !client.IsConnectionOpen() {
+		return fmt.Errorf("MQTT client not connected")
+	}
+
+	msg := &BrokerMessage{Topic: topic, QoS: qos, Payload: body}
+	if err := client.Publish(msg); err != nil {
+		return err
+	}
+	s.tap(msg, true)
+	return nil
+}
+
+// Reply 按请求消息的 tid/bid 发送回复
+func (s *IngestService) Reply(topic string, request *models.DJIMessage, data interface{}) error {
+	reply := map[string]interface{}{
+		"tid":       request.TID,
+		"bid":       request.BID,
+		"timestamp": time.Now().UnixMilli(),
+		"method":    request.Method,
+		"data":      data,
+	}
+	return s.Publish(topic, 1, reply)
+}
+
+// ensureConnected 使用默认MQTT配置建立连接，默认配置变更时重新连接
+func (s *IngestService) ensureConnected() {
+	profile, err := s.mqttService.GetDefaultProfile()
+	if err != nil {
+		msg := err.Error()
+		if err == sql.ErrNoRows {
+			msg = "未配置默认MQTT配置"
+		}
+		s.logConnectError(msg)
+		return
+	}
+
+	s.clientMutex.Lock()
+	defer s.clientMutex.Unlock()
+
+	if s.client != nil {
+		if s.profileID == profile.ID && s.profileUpdatedAt == profile.UpdatedAt {
+			return
+		}
+		log.Printf("默认MQTT配置已变更，重新连接: %s", profile.Name)
+		s.client.Disconnect()
+		s.client = nil
+	}
+
+	client, err := newIngestClient(profile.Config, s.embeddedBroker, s.sharedGroup != "", BrokerClientOptions{
+		OnConnect: func() {
+			log.Printf("Ingest MQTT connected, profile: %s", profile.Name)
+			s.handlerMutex.Lock()
+			s.subscribed = make(map[string]bool)
+			s.handlerMutex.Unlock()
+			go s.RefreshSubscriptions()
+		},
+		OnConnectionLost: func(err error) {
+			log.Printf("Ingest MQTT connection lost: %v", err)
+		},
+		OnMessage: s.onMessage,
+	})
+	if err != nil {
+		s.logConnectError(err.Error())
+		return
+	}
+
+	// 开启自动重连后 Connect 不会阻塞等待，连接结果由回调记录
+	client.Connect()
+
+	s.client = client
+	s.profileID = profile.ID
+	s.profileUpdatedAt = profile.UpdatedAt
+	s.lastConnectError = ""
+}
+
+func (s *IngestService) logConnectError(msg string) {
+	if s.lastConnectError == msg {
+		return
+	}
+	s.lastConnectError = msg
+	log.Printf("Ingest MQTT connect skipped: %s", msg)
+}
+
+// RefreshSubscriptions 按设备表同步订阅主题
+func (s *IngestService) RefreshSubscriptions() {
+	s.clientMutex.Lock()
+	client := s.client
+	s.clientMutex.Unlock()
+
+	if client == nil || !client.IsConnectionOpen() {
+		return
+	}
+
+	sns, err := s.deviceSNs()
+	if err != nil {
+		log.Printf("获取设备SN列表失败: %v", err)
+		return
+	}
+
+	s.handlerMutex.Lock()
+	wanted := make(map[string]byte)
+	for template := range s.handlers {
+		for _, sn := range sns {
+			filter := strings.Replace(template, "{sn}", sn, 1)
+			if s.sharedTopics[template] {
+				filter = "$share/" + s.sharedGroup + "/" + filter
+			}
+			wanted[filter] = s.handlerQoS[template]
+		}
+	}
+
+	var toSubscribe = make(map[string]byte)
+	var toUnsubscribe []string
+	for topic, qos := range wanted {
+		if !s.subscribed[topic] {
+			toSubscribe[topic] = qos
+		}
+	}
+	for topic := range s.subscribed {
+		if _, ok := wanted[topic]; !ok {
+			toUnsubscribe = append(toUnsubscribe, topic)
+		}
+	}
+	s.handlerMutex.Unlock()
+
+	if len(toSubscribe) > 0 {
+		failed, err := client.Subscribe(toSubscribe)
+		if err == nil {
+			// 被拒绝的主题不记录，下次同步时重试
+			s.handlerMutex.Lock()
+			for topic := range toSubscribe {
+				if failed[topic] == nil {
+					s.subscribed[topic] = true
+				}
+			}
+			s.handlerMutex.Unlock()
+			for topic, err := range failed {
+				log.Printf("Ingest subscribe %s rejected: %v", topic, err)
+			}
+			log.Printf("Ingest subscribed %d topics", len(toSubscribe)-len(failed))
+		} else {
+			log.Printf("Ingest subscribe failed: %v", err)
+		}
+	}
+
+	if len(toUnsubscribe) > 0 {
+		if err := client.Unsubscribe(toUnsubscribe...); err == nil {
+			s.handlerMutex.Lock()
+			for _, topic := range toUnsubscribe {
+				delete(s.subscribed, topic)
+			}
+			s.handlerMutex.Unlock()
+		}
+	}
+}
+
+func (s *IngestService) deviceSNs() ([]string, error) {
+	// 机场可能仅作为 airport_sn 出现在飞行器记录中，同样需要订阅
+	rows, err := s.db.Query("SELECT sn FROM devices UNION SELECT airport_sn FROM devices WHERE airport_sn != ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sns []string
+	for rows.Next() {
+		var sn string
+		if err := rows.Scan(&sn); err != nil {
+			return nil, err
+		}
+		if sn != "" {
+			sns = append(sns, sn)
+		}
+	}
+	return sns, rows.Err()
+}
+
+// onMessage 将消息放入分发队列，避免处理函数阻塞paho回调
+func (s *IngestService) onMessage(msg *BrokerMessage) {
+	s.tap(msg, false)
+
+	select {
+	case s.queue <- msg:
+	default:
+		if atomic.AddInt64(&s.dropped, 1)%100 == 1 {
+			log.Printf("Ingest queue full, dropped %d messages", atomic.LoadInt64(&s.dropped))
+		}
+	}
+}
+
+// dispatch 按到达顺序处理队列中的消息
+func (s *IngestService) dispatch() {
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case msg := <-s.queue:
+			s.handleMessage(msg)
+		}
+	}
+}
+
+// handleMessage 解析主题与信封并分发到已注册的处理函数
+func (s *IngestService) handleMessage(msg *BrokerMessage) {
+	template, sn, ok := parseDeviceTopic(msg.Topic)
+	if !ok {
+		return
+	}
+
+	s.handlerMutex.RLock()
+	handlers := s.handlers[template]
+	s.handlerMutex.RUnlock()
+	if len(handlers) == 0 {
+		return
+	}
+
+	var message models.DJIMessage
+	if err := json.Unmarshal(msg.Payload, &message); err != nil {
+		log.Printf("解析设备消息失败 %s: %v", msg.Topic, err)
+		return
+	}
+
+	// 非零 result 先翻译为错误文案，处理函数落库时即带有 error_message
+	if isResultTopic(msg.Topic) && s.errorCodeService != nil {
+		gatewaySN := message.Gateway
+		if gatewaySN == "" {
+			gatewaySN = sn
+		}
+		if data, ok := s.errorCodeService.AnnotateData(message.Data, gatewaySN); ok {
+			message.Data = data
+		}
+	}
+
+	for _, handler := range handlers {
+		handler(sn, &message)
+	}
+}
+
+// parseDeviceTopic 将 thing/product/{sn}/osd 形式的主题拆分为模板与SN
+func parseDeviceTopic(topic string) (string, string, bool) {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || parts[1] != "product" {
+		return "", "", false
+	}
+	sn := parts[2]
+	parts[2] = "{sn}"
+	return strings.Join(parts, "/"), sn, true
+}
+
+// handleEventReply 对需要回复的事件统一回复 events_reply
+func (s *IngestService) handleEventReply(sn string, msg *models.DJIMessage) {
+	if msg.NeedReply != 1 {
+		return
+	}
+
+	topic := strings.Replace(TopicEventsReply, "{sn}", sn, 1)
+	if err := s.Reply(topic, msg, map[string]int{"result": 0}); err != nil {
+		log.Printf("发送events_reply失败 %s: %v", sn, err)
+	}
+}
+
+// handleOSD 解析osd数据并更新快照
+func (s *IngestService) handleOSD(sn string, msg *models.DJIMessage) {
+	if len(msg.Data) == 0 {
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Data, &fields); err != nil {
+		log.Printf("解析osd数据失败 %s: %v", sn, err)
+		return
+	}
+
+	kind := detectOSDKind(fields)
+	var dock *models.DockOSD
+	var aircraft *models.AircraftOSD
+	switch kind {
+	case models.OSDKindDock:
+		dock = &models.DockOSD{}
+		if err := json.Unmarshal(msg.Data, dock); err != nil {
+			log.Printf("解析机场osd失败 %s: %v", sn, err)
+			return
+		}
+	case models.OSDKindAircraft:
+		aircraft = &models.AircraftOSD{}
+		if err := json.Unmarshal(msg.Data, aircraft); err != nil {
+			log.Printf("解析飞行器osd失败 %s: %v", sn, err)
+			return
+		}
+	}
+
+	now := time.Now().UnixMilli()
+
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+
+	snapshot := s.copySnapshotLocked(sn)
+	snapshot.Kind = kind
+	if msg.Gateway != "" {
+		snapshot.Gateway = msg.Gateway
+	}
+	snapshot.Dock = dock
+	snapshot.Aircraft = aircraft
+	snapshot.OSD = append(json.RawMessage(nil), msg.Data...)
+	snapshot.OSDUpdatedAt = now
+	snapshot.UpdatedAt = now
+
+	s.snapshots[sn] = snapshot
+	s.dirty[sn] = true
+}
+
+// handleState 合并state上报的变化字段到快照
+func (s *IngestService) handleState(sn string, msg *models.DJIMessage) {
+	if len(msg.Data) == 0 {
+		return
+	}
+
+	var changes map[string]interface{}
+	if err := json.Unmarshal(msg.Data, &changes); err != nil {
+		log.Printf("解析state数据失败 %s: %v", sn, err)
+		return
+	}
+
+	now := time.Now().UnixMilli()
+
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+
+	snapshot := s.copySnapshotLocked(sn)
+	state := make(map[string]interface{}, len(snapshot.State)+len(changes))
+	for k, v := range snapshot.State {
+		state[k] = v
+	}
+	for k, v := range changes {
+		state[k] = v
+	}
+	if msg.Gateway != "" {
+		snapshot.Gateway = msg.Gateway
+	}
+	snapshot.State = state
+	snapshot.StateUpdatedAt = now
+	snapshot.UpdatedAt = now
+
+	s.snapshots[sn] = snapshot
+	s.dirty[sn] = true
+}
+
+// copySnapshotLocked 复制快照用于写入，调用方需持有写锁
+func (s *IngestService) copySnapshotLocked(sn string) *models.DeviceSnapshot {
+	if existing, ok := s.snapshots[sn]; ok {
+		copied := *existing
+		return &copied
+	}
+	return &models.DeviceSnapshot{SN: sn, Kind: models.OSDKindUnknown}
+}
+
+// detectOSDKind 根据osd字段判断上报设备类型
+func detectOSDKind(fields map[string]json.RawMessage) string {
+	for _, key := range []string{"cover_state", "drone_in_dock", "putter_state"} {
+		if _, ok := fields[key]; ok {
+			return models.OSDKindDock
+		}
+	}
+	for _, key := range []string{"attitude_head", "horizontal_speed", "elevation"} {
+		if _, ok := fields[key]; ok {
+			return models.OSDKindAircraft
+		}
+	}
+	return models.OSDKindUnknown
+}
+
+// GetSnapshot 获取设备最新快照
+func (s *IngestService) GetSnapshot(sn string) (models.DeviceSnapshot, bool) {
+	s.snapshotMutex.RLock()
+	defer s.snapshotMutex.RUnlock()
+
+	snapshot, ok := s.snapshots[sn]
+	if !ok {
+		return models.DeviceSnapshot{}, false
+	}
+	return *snapshot, true
+}
+
+// GetSnapshots 获取所有设备最新快照
+func (s *IngestService) GetSnapshots() []models.DeviceSnapshot {
+	s.snapshotMutex.RLock()
+	defer s.snapshotMutex.RUnlock()
+
+	snapshots := make([]models.DeviceSnapshot, 0, len(s.snapshots))
+	for _, snapshot := range s.snapshots {
+		snapshots = append(snapshots, *snapshot)
+	}
+	return snapshots
+}
+
+// flushSnapshots 将变更的快照写入数据库
+func (s *IngestService) flushSnapshots() {
+	s.snapshotMutex.Lock()
+	pending := make([]models.DeviceSnapshot, 0, len(s.dirty))
+	for sn := range s.dirty {
+		if snapshot, ok := s.snapshots[sn]; ok {
+			pending = append(pending, *snapshot)
+		}
+	}
+	s.dirty = make(map[string]bool)
+	s.snapshotMutex.Unlock()
+
+	query := `INSERT INTO device_snapshots (sn, gateway, kind, osd, state, osd_updated_at, state_updated_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			  ON CONFLICT(sn) DO UPDATE SET gateway = excluded.gateway, kind = excluded.kind, osd = excluded.osd,
+			  state = excluded.state, osd_updated_at = excluded.osd_updated_at,
+			  state_updated_at = excluded.state_updated_at, updated_at = excluded.updated_at`
+
+	for _, snapshot := range pending {
+		stateJSON, err := json.Marshal(snapshot.State)
+		if err != nil {
+			log.Printf("序列化设备状态失败 %s: %v", snapshot.SN, err)
+			continue
+		}
+		_, err = s.db.Exec(query, snapshot.SN, snapshot.Gateway, snapshot.Kind, string(snapshot.OSD), string(stateJSON),
+			snapshot.OSDUpdatedAt, snapshot.StateUpdatedAt, snapshot.UpdatedAt)
+		if err != nil {
+			log.Printf("保存设备快照失败 %s: %v", snapshot.SN, err)
+		}
+	}
+}
+
+// loadSnapshots 启动时从数据库恢复快照
+func (s *IngestService) loadSnapshots() error {
+	rows, err := s.db.Query(`SELECT sn, gateway, kind, osd, state, osd_updated_at, state_updated_at, updated_at FROM device_snapshots`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+
+	for rows.Next() {
+		var snapshot models.DeviceSnapshot
+		var osd, state sql.NullString
+		var osdUpdatedAt, stateUpdatedAt sql.NullInt64
+
+		if err := rows.Scan(&snapshot.SN, &snapshot.Gateway, &snapshot.Kind, &osd, &state,
+			&osdUpdatedAt, &stateUpdatedAt, &snapshot.UpdatedAt); err != nil {
+			return err
+		}
+		snapshot.OSDUpdatedAt = osdUpdatedAt.Int64
+		snapshot.StateUpdatedAt = stateUpdatedAt.Int64
+
+		if osd.Valid && osd.String != "" {
+			snapshot.OSD = json.RawMessage(osd.String)
+			switch snapshot.Kind {
+			case models.OSDKindDock:
+				snapshot.Dock = &models.DockOSD{}
+				json.Unmarshal(snapshot.OSD, snapshot.Dock)
+			case models.OSDKindAircraft:
+				snapshot.Aircraft = &models.AircraftOSD{}
+				json.Unmarshal(snapshot.OSD, snapshot.Aircraft)
+			}
+		}
+		if state.Valid && state.String != "" && state.String != "null" {
+			json.Unmarshal([]byte(state.String), &snapshot.State)
+		}
+
+		s.snapshots[snapshot.SN] = &snapshot
+	}
+	return rows.Err()
+}
+
+// 获取所有设备最新遥测
+func (s *IngestService) GetLatestTelemetry() (*models.APIResponse, error) {
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"connected": s.IsConnected(),
+			"devices":   s.GetSnapshots(),
+		},
+	}, nil
+}
+
+// 获取单个设备最新遥测
+func (s *IngestService) GetDeviceLatestTelemetry(sn string) (*models.APIResponse, error) {
+	snapshot, ok := s.GetSnapshot(sn)
+	if !ok {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "暂无该设备遥测数据",
+		}, nil
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    snapshot,
+	}, nil
+}
+
+// newIngestClient 根据MQTT配置创建后端客户端，options 只需设置回调
+// 使用共享订阅时多个副本同时在线，ClientID 附加随机后缀以免互相踢下线
+func newIngestClient(config map[string]interface{}, embeddedBroker *EmbeddedBroker, shared bool, options BrokerClientOptions) (BrokerClient, error) {
+	conn := brokerConnectionFromConfig(config)
+	conn.ClientID = ingestClientID
+	if shared {
+		conn.ClientID += "_" + uuid.New().String()[:8]
+	}
+
+	options.CleanSession = true
+	options.AutoReconnect = true
+	options.RetryInterval = 10 * time.Second
+	options.MaxRetryInterval = time.Minute
+	options.ConnectTimeout = 30 * time.Second
+	options.KeepAlive = 60 * time.Second
+
+	return newBrokerClient(conn, embeddedBroker, options)
+}
+
+// configString 读取配置中的字符串字段
+func configString(config map[string]interface{}, key string) string {
+	if value, ok := config[key].(string); ok {
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
+
+// configInt 读取配置中的整数字段，兼容JSON数字与字符串
+func configInt(config map[string]interface{}, key string) int {
+	switch value := config[key].(type) {
+	case float64:
+		return int(value)
+	case int:
+		return value
+	case string:
+		var n int
+		fmt.Sscanf(value, "%d", &n)
+		return n
+	}
+	return 0
+}
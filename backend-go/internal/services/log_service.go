@@ -0,0 +1,713 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	LogUploadStatusCreated    = "created"
+	LogUploadStatusSent       = "sent"
+	LogUploadStatusInProgress = "in_progress"
+	LogUploadStatusSucceeded  = "succeeded"
+	LogUploadStatusFailed     = "failed"
+)
+
+const (
+	LogFileStatusPending   = "pending"
+	LogFileStatusUploading = "uploading"
+	LogFileStatusSucceeded = "succeeded"
+	LogFileStatusFailed    = "failed"
+)
+
+const (
+	logCheckInterval     = time.Minute
+	logProgressTimeout   = time.Hour
+	logCredentialTTL     = time.Hour
+	logObjectKeyPrefix   = "logs"
+	logDefaultModuleList = "0,3"
+)
+
+// logFileProgressOutput fileupload_progress 事件的 output
+type logFileProgressOutput struct {
+	Status string `json:"status"`
+	Ext    struct {
+		Files []struct {
+			Module      string `json:"module"`
+			Size        int64  `json:"size"`
+			DeviceSN    string `json:"device_sn"`
+			Key         string `json:"key"`
+			Fingerprint string `json:"fingerprint"`
+			Progress    struct {
+				Progress int    `json:"progress"`
+				Result   int    `json:"result"`
+				Status   string `json:"status"`
+			} `json:"progress"`
+		} `json:"files"`
+	} `json:"ext"`
+}
+
+// LogService 设备远程日志列表查询、上传编排与归档索引
+type LogService struct {
+	db             *database.DB
+	ingestService  *IngestService
+	commandService *CommandService
+	deviceService  *DeviceService
+	storage        *ObjectStorage
+
+	// 进行中的上传: bid -> 上传记录ID
+	active    map[string]string
+	lastEvent map[string]time.Time
+	mutex     sync.Mutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewLogService 创建远程日志服务
+func NewLogService(db *database.DB, ingestService *IngestService, commandService *CommandService,
+	deviceService *DeviceService, storage *ObjectStorage) *LogService {
+	s := &LogService{
+		db:             db,
+		ingestService:  ingestService,
+		commandService: commandService,
+		deviceService:  deviceService,
+		storage:        storage,
+		active:         make(map[string]string),
+		lastEvent:      make(map[string]time.Time),
+		stopCh:         make(chan struct{}),
+	}
+
+	ingestService.RegisterHandler(TopicEvents, 1, s.handleEvent)
+	storage.OnObjectStored(s.handleObjectStored)
+
+	return s
+}
+
+// Start 恢复进行中的上传并启动超时检测
+func (s *LogService) Start() {
+	now := time.Now()
+
+	// 重启前尚未下发的上传无法恢复，直接标记失败
+	_, err := s.db.Exec("UPDATE log_uploads SET status = ?, error_message = ?, updated_at = ?, finished_at = ? WHERE status = ?",
+		LogUploadStatusFailed, "服务重启，任务未下发", now.UnixMilli(), now.UnixMilli(), LogUploadStatusCreated)
+	if err != nil {
+		log.Printf("更新日志上传失败: %v", err)
+	}
+
+	// 临时凭证仅保存在内存中，重启后设备无法继续上传
+	rows, err := s.db.Query("SELECT id FROM log_uploads WHERE status IN (?, ?)",
+		LogUploadStatusSent, LogUploadStatusInProgress)
+	if err != nil {
+		log.Printf("加载日志上传失败: %v", err)
+		go s.run()
+		return
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("加载日志上传失败: %v", err)
+			break
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		s.failUpload(id, "", nil, "服务重启，上传凭证已失效")
+	}
+
+	go s.run()
+}
+
+// Stop 停止超时检测
+func (s *LogService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+func (s *LogService) run() {
+	ticker := time.NewTicker(logCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.checkTimeouts()
+		}
+	}
+}
+
+// 获取设备日志文件列表
+func (s *LogService) ListLogFiles(sn string, query *models.LogFileListQuery) (*models.APIResponse, error) {
+	if !s.ingestService.IsConnected() {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "后端MQTT未连接",
+		}, nil
+	}
+
+	gatewaySN, err := s.deviceService.GetGatewaySN(sn)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取设备网关失败: %v", err),
+		}, err
+	}
+
+	moduleList := query.ModuleList
+	if moduleList == "" {
+		moduleList = logDefaultModuleList
+	}
+	modules := []string{}
+	for _, module := range strings.Split(moduleList, ",") {
+		if module = strings.TrimSpace(module); module != "" {
+			modules = append(modules, module)
+		}
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"module_list": modules})
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("构造日志查询指令失败: %v", err),
+		}, err
+	}
+
+	reply, err := s.commandService.Call(gatewaySN, "fileupload_list", data, 0)
+	if err == ErrServiceReplyTimeout {
+		return &models.APIResponse{
+			Code:    1,
+			Message: err.Error(),
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("查询日志列表失败: %v", err),
+		}, err
+	}
+	if reply.Result != 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: reply.ErrorMessage,
+			Data:    reply,
+		}, nil
+	}
+
+	// 文件列表位于 data.files，部分固件放在 data.output.files
+	var listing struct {
+		Files json.RawMessage `json:"files"`
+	}
+	json.Unmarshal(reply.Data, &listing)
+	if len(listing.Files) == 0 && len(reply.Output) > 0 {
+		json.Unmarshal(reply.Output, &listing)
+	}
+	if len(listing.Files) == 0 {
+		listing.Files = json.RawMessage("[]")
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"gateway_sn": gatewaySN,
+			"files":      listing.Files,
+		},
+	}, nil
+}
+
+// logObjectKey 日志归档在对象存储中的Key
+func logObjectKey(gatewaySN, uploadID, module string) string {
+	return path.Join(logObjectKeyPrefix, gatewaySN, uploadID, module+".tar")
+}
+
+// 发起日志上传，设备按签发的临时凭证上传至本地对象存储
+func (s *LogService) StartUpload(sn string, payload *models.LogUploadPayload) (*models.APIResponse, error) {
+	if !s.ingestService.IsConnected() {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "后端MQTT未连接",
+		}, nil
+	}
+
+	gatewaySN, err := s.deviceService.GetGatewaySN(sn)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取设备网关失败: %v", err),
+		}, err
+	}
+
+	now := time.Now().UnixMilli()
+	upload := models.LogUpload{
+		ID:        uuid.New().String(),
+		GatewaySN: gatewaySN,
+		Status:    LogUploadStatusCreated,
+		Operator:  payload.Operator,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Files:     []models.LogFile{},
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range payload.Files {
+		module := strings.TrimSpace(item.Module)
+		if module == "" || seen[module] {
+			continue
+		}
+		seen[module] = true
+
+		upload.Files = append(upload.Files, models.LogFile{
+			ID:          uuid.New().String(),
+			UploadID:    upload.ID,
+			Module:      module,
+			BootIndexes: item.BootIndexes,
+			ObjectKey:   logObjectKey(gatewaySN, upload.ID, module),
+			Status:      LogFileStatusPending,
+			CreatedAt:   now,
+		})
+	}
+	if len(upload.Files) == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "未指定日志模块",
+		}, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建日志上传失败: %v", err),
+		}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO log_uploads (id, gateway_sn, status, operator, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, upload.ID, upload.GatewaySN, upload.Status, upload.Operator, upload.CreatedAt, upload.UpdatedAt)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建日志上传失败: %v", err),
+		}, err
+	}
+
+	for _, file := range upload.Files {
+		bootIndexes, _ := json.Marshal(file.BootIndexes)
+		_, err = tx.Exec(`INSERT INTO log_files (id, upload_id, module, boot_indexes, object_key, status, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, file.ID, file.UploadID, file.Module, string(bootIndexes), file.ObjectKey,
+			file.Status, file.CreatedAt)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("创建日志上传失败: %v", err),
+			}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建日志上传失败: %v", err),
+		}, err
+	}
+
+	go s.sendUpload(upload)
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "日志上传已创建",
+		Data:    upload,
+	}, nil
+}
+
+// sendUpload 签发凭证并向网关下发 fileupload_start
+func (s *LogService) sendUpload(upload models.LogUpload) {
+	prefix := path.Join(logObjectKeyPrefix, upload.GatewaySN, upload.ID) + "/"
+	credentials := s.storage.IssueCredentials(prefix, logCredentialTTL)
+
+	files := []interface{}{}
+	for _, file := range upload.Files {
+		list := []interface{}{}
+		for _, bootIndex := range file.BootIndexes {
+			list = append(list, map[string]interface{}{"boot_index": bootIndex})
+		}
+		files = append(files, map[string]interface{}{
+			"object_key": file.ObjectKey,
+			"module":     file.Module,
+			"list":       list,
+		})
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"bucket":      s.storage.Bucket(),
+		"region":      s.storage.Region(),
+		"credentials": credentials,
+		"endpoint":    s.storage.Endpoint(),
+		"provider":    s.storage.Provider(),
+		"params":      map[string]interface{}{"files": files},
+	})
+	if err != nil {
+		s.failUpload(upload.ID, "", nil, fmt.Sprintf("构造上传指令失败: %v", err))
+		return
+	}
+
+	msg := NewServiceMessage("fileupload_start", data)
+
+	// 先登记 bid，避免进度事件先于回复处理时丢失
+	s.mutex.Lock()
+	s.active[msg.BID] = upload.ID
+	s.lastEvent[msg.BID] = time.Now()
+	s.mutex.Unlock()
+
+	_, err = s.db.Exec("UPDATE log_uploads SET status = ?, tid = ?, bid = ?, updated_at = ? WHERE id = ?",
+		LogUploadStatusSent, msg.TID, msg.BID, time.Now().UnixMilli(), upload.ID)
+	if err != nil {
+		log.Printf("更新日志上传失败 %s: %v", upload.ID, err)
+	}
+
+	reply, err := s.commandService.Send(upload.GatewaySN, msg, 0)
+	if err != nil {
+		s.failUpload(upload.ID, msg.BID, nil, fmt.Sprintf("下发上传指令失败: %v", err))
+		return
+	}
+	if reply.Result != 0 {
+		result := reply.Result
+		s.failUpload(upload.ID, msg.BID, &result, reply.ErrorMessage)
+		return
+	}
+
+	// 进度事件可能已将上传推进，仅在仍为 sent 时更新
+	_, err = s.db.Exec("UPDATE log_uploads SET status = ?, result = 0, updated_at = ? WHERE id = ? AND status = ?",
+		LogUploadStatusInProgress, time.Now().UnixMilli(), upload.ID, LogUploadStatusSent)
+	if err != nil {
+		log.Printf("更新日志上传失败 %s: %v", upload.ID, err)
+	}
+}
+
+// failUpload 将上传标记为失败并停止跟踪，未完成的文件一并标记失败
+func (s *LogService) failUpload(uploadID, bid string, result *int, message string) {
+	if bid != "" {
+		s.mutex.Lock()
+		delete(s.active, bid)
+		delete(s.lastEvent, bid)
+		s.mutex.Unlock()
+	}
+
+	now := time.Now().UnixMilli()
+	_, err := s.db.Exec(`UPDATE log_uploads SET status = ?, result = COALESCE(?, result), error_message = ?, updated_at = ?,
+		finished_at = ? WHERE id = ?`, LogUploadStatusFailed, result, message, now, now, uploadID)
+	if err != nil {
+		log.Printf("更新日志上传失败 %s: %v", uploadID, err)
+	}
+
+	_, err = s.db.Exec("UPDATE log_files SET status = ? WHERE upload_id = ? AND status IN (?, ?)",
+		LogFileStatusFailed, uploadID, LogFileStatusPending, LogFileStatusUploading)
+	if err != nil {
+		log.Printf("更新日志文件失败 %s: %v", uploadID, err)
+	}
+}
+
+// handleEvent 处理 fileupload_progress 进度事件
+func (s *LogService) handleEvent(sn string, msg *models.DJIMessage) {
+	if msg.Method != "fileupload_progress" {
+		return
+	}
+
+	s.mutex.Lock()
+	uploadID, ok := s.active[msg.BID]
+	if ok {
+		s.lastEvent[msg.BID] = time.Now()
+	}
+	s.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	var event serviceProgressEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		log.Printf("解析日志上传进度失败 %s: %v", sn, err)
+		return
+	}
+	var output logFileProgressOutput
+	json.Unmarshal(event.Output, &output)
+
+	for _, file := range output.Ext.Files {
+		status := LogFileStatusUploading
+		switch progressJobStatus(file.Progress.Result, file.Progress.Status) {
+		case ServiceJobSucceeded:
+			status = LogFileStatusSucceeded
+		case ServiceJobFailed:
+			status = LogFileStatusFailed
+		}
+
+		_, err := s.db.Exec(`UPDATE log_files SET device_sn = CASE WHEN ? != '' THEN ? ELSE device_sn END,
+			size = CASE WHEN stored_at IS NULL THEN ? ELSE size END, fingerprint = ?, progress = ?, status = ?
+			WHERE upload_id = ? AND (object_key = ? OR module = ?)`,
+			file.DeviceSN, file.DeviceSN, file.Size, file.Fingerprint, file.Progress.Progress, status,
+			uploadID, file.Key, file.Module)
+		if err != nil {
+			log.Printf("更新日志文件失败 %s: %v", uploadID, err)
+		}
+	}
+
+	status := LogUploadStatusInProgress
+	switch progressJobStatus(event.Result, output.Status) {
+	case ServiceJobSucceeded:
+		status = LogUploadStatusSucceeded
+	case ServiceJobFailed:
+		status = LogUploadStatusFailed
+	}
+
+	now := time.Now().UnixMilli()
+	var finishedAt interface{}
+	if status != LogUploadStatusInProgress {
+		finishedAt = now
+		s.mutex.Lock()
+		delete(s.active, msg.BID)
+		delete(s.lastEvent, msg.BID)
+		s.mutex.Unlock()
+	}
+
+	_, err := s.db.Exec(`UPDATE log_uploads SET status = ?, result = ?, error_message = ?, updated_at = ?,
+		finished_at = COALESCE(?, finished_at) WHERE id = ?`,
+		status, event.Result, event.ErrorMessage, now, finishedAt, uploadID)
+	if err != nil {
+		log.Printf("更新日志上传失败 %s: %v", uploadID, err)
+	}
+}
+
+// handleObjectStored 对象写入完成后记录归档大小与时间
+func (s *LogService) handleObjectStored(key string, size int64) {
+	_, err := s.db.Exec("UPDATE log_files SET size = ?, stored_at = ? WHERE object_key = ?",
+		size, time.Now().UnixMilli(), key)
+	if err != nil {
+		log.Printf("更新日志归档失败 %s: %v", key, err)
+	}
+}
+
+// checkTimeouts 长时间无进度上报的上传标记为失败
+func (s *LogService) checkTimeouts() {
+	now := time.Now()
+
+	s.mutex.Lock()
+	expired := make(map[string]string)
+	for bid, last := range s.lastEvent {
+		if now.Sub(last) > logProgressTimeout {
+			expired[bid] = s.active[bid]
+		}
+	}
+	s.mutex.Unlock()
+
+	for bid, uploadID := range expired {
+		s.failUpload(uploadID, bid, nil, "日志上传进度上报超时")
+	}
+}
+
+const logUploadColumns = `id, gateway_sn, tid, bid, status, result, error_message, operator, created_at, updated_at, finished_at`
+
+const logFileColumns = `id, upload_id, device_sn, module, boot_indexes, object_key, size, fingerprint, progress, status,
+	stored_at, created_at`
+
+// scanLogUpload 扫描日志上传记录
+func scanLogUpload(scanner interface{ Scan(...interface{}) error }) (models.LogUpload, error) {
+	var upload models.LogUpload
+	var result, finishedAt sql.NullInt64
+
+	err := scanner.Scan(&upload.ID, &upload.GatewaySN, &upload.TID, &upload.BID, &upload.Status, &result,
+		&upload.ErrorMessage, &upload.Operator, &upload.CreatedAt, &upload.UpdatedAt, &finishedAt)
+	if err != nil {
+		return upload, err
+	}
+
+	if result.Valid {
+		value := int(result.Int64)
+		upload.Result = &value
+	}
+	if finishedAt.Valid {
+		upload.FinishedAt = &finishedAt.Int64
+	}
+	return upload, nil
+}
+
+// scanLogFile 扫描日志文件记录
+func scanLogFile(scanner interface{ Scan(...interface{}) error }) (models.LogFile, error) {
+	var file models.LogFile
+	var bootIndexes string
+	var storedAt sql.NullInt64
+
+	err := scanner.Scan(&file.ID, &file.UploadID, &file.DeviceSN, &file.Module, &bootIndexes, &file.ObjectKey,
+		&file.Size, &file.Fingerprint, &file.Progress, &file.Status, &storedAt, &file.CreatedAt)
+	if err != nil {
+		return file, err
+	}
+
+	file.BootIndexes = []int{}
+	json.Unmarshal([]byte(bootIndexes), &file.BootIndexes)
+	if storedAt.Valid {
+		file.StoredAt = &storedAt.Int64
+	}
+	return file, nil
+}
+
+// 获取日志上传列表
+func (s *LogService) GetUploads(query *models.LogUploadQuery) (*models.APIResponse, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if query.SN != "" {
+		conditions = append(conditions, "(gateway_sn = ? OR id IN (SELECT upload_id FROM log_files WHERE device_sn = ?))")
+		args = append(args, query.SN, query.SN)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, query.Status)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM log_uploads "+where, args...).Scan(&total); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取日志上传失败: %v", err),
+		}, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	rows, err := s.db.Query("SELECT "+logUploadColumns+" FROM log_uploads "+where+
+		" ORDER BY created_at DESC LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取日志上传失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	uploads := []models.LogUpload{}
+	for rows.Next() {
+		upload, err := scanLogUpload(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描日志上传失败: %v", err),
+			}, err
+		}
+		uploads = append(uploads, upload)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+			"items":    uploads,
+		},
+	}, nil
+}
+
+// 获取日志上传详情，包含归档文件
+func (s *LogService) GetUpload(uploadID string) (*models.APIResponse, error) {
+	upload, err := scanLogUpload(s.db.QueryRow("SELECT "+logUploadColumns+" FROM log_uploads WHERE id = ?", uploadID))
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "日志上传不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取日志上传失败: %v", err),
+		}, err
+	}
+
+	rows, err := s.db.Query("SELECT "+logFileColumns+" FROM log_files WHERE upload_id = ? ORDER BY module", uploadID)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取日志文件失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	upload.Files = []models.LogFile{}
+	for rows.Next() {
+		file, err := scanLogFile(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描日志文件失败: %v", err),
+			}, err
+		}
+		upload.Files = append(upload.Files, file)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    upload,
+	}, nil
+}
+
+// GetFileDownload 获取日志归档的本地路径与下载文件名，不可下载时返回错误响应
+func (s *LogService) GetFileDownload(fileID string) (string, string, *models.APIResponse, error) {
+	file, err := scanLogFile(s.db.QueryRow("SELECT "+logFileColumns+" FROM log_files WHERE id = ?", fileID))
+	if err == sql.ErrNoRows {
+		return "", "", &models.APIResponse{
+			Code:    1,
+			Message: "日志文件不存在",
+		}, nil
+	}
+	if err != nil {
+		return "", "", &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取日志文件失败: %v", err),
+		}, err
+	}
+	if file.StoredAt == nil {
+		return "", "", &models.APIResponse{
+			Code:    1,
+			Message: "日志文件尚未上传完成",
+		}, nil
+	}
+
+	filePath, err := s.storage.ObjectPath(file.ObjectKey)
+	if err != nil {
+		return "", "", &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取日志文件失败: %v", err),
+		}, err
+	}
+
+	deviceSN := file.DeviceSN
+	if deviceSN == "" {
+		deviceSN = path.Base(path.Dir(path.Dir(file.ObjectKey)))
+	}
+	name := fmt.Sprintf("%s_%s_%s.tar", deviceSN, file.Module, time.UnixMilli(*file.StoredAt).Format("20060102150405"))
+	return filePath, name, nil, nil
+}
@@ -0,0 +1,726 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/eclipse/paho.golang/paho/session/state"
+	"github.com/eclipse/paho.golang/paho/store/file"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gorilla/websocket"
+)
+
+// 订阅、取消订阅与QoS1/2发布等待broker确认的时间
+const brokerRequestTimeout = 10 * time.Second
+
+// BrokerClient 后端消费服务与WebSocket代理使用的MQTT客户端，屏蔽 MQTT 3.1.1 与 MQTT 5 的差异
+type BrokerClient interface {
+	// Connect 建立连接，开启自动重连时不等待连接结果
+	Connect() error
+	Disconnect()
+	IsConnectionOpen() bool
+	// Subscribe 订阅多个过滤器，broker拒绝的过滤器在返回的 map 中给出原因码错误
+	Subscribe(filters map[string]byte) (map[string]error, error)
+	Unsubscribe(filters ...string) error
+	Publish(message *BrokerMessage) error
+	// Server 返回broker地址，用于日志与状态展示
+	Server() string
+}
+
+// BrokerMessage 收发的MQTT消息，ContentType 等属性仅 MQTT 5 支持，MQTT 3.1.1 下为空并在发布时忽略
+type BrokerMessage struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retained bool
+
+	ContentType     string
+	ResponseTopic   string
+	CorrelationData []byte
+	UserProperties  []MQTTUserProperty
+}
+
+// MQTTUserProperty MQTT 5 用户属性，同一键可出现多次
+type MQTTUserProperty struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// BrokerClientOptions MQTT客户端的会话、保活、重连与回调设置
+type BrokerClientOptions struct {
+	CleanSession bool
+	// 持久会话断开后在broker上保留的时长，仅 MQTT 5 使用
+	SessionExpiry time.Duration
+	// 未确认的QoS1/2消息的本地存储目录，为空时保存在内存
+	StoreDir       string
+	KeepAlive      time.Duration
+	ConnectTimeout time.Duration
+
+	// 连接失败或断开后自动重连，间隔从 RetryInterval 起翻倍，不超过 MaxRetryInterval
+	AutoReconnect    bool
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+
+	OnConnect        func()
+	OnConnectionLost func(err error)
+	OnMessage        func(message *BrokerMessage)
+}
+
+// ReasonCodeError broker返回的失败原因码，来自 CONNACK、SUBACK、PUBACK 及 MQTT 5 的 DISCONNECT
+type ReasonCodeError struct {
+	Code   byte
+	Reason string
+}
+
+// Error 返回原因说明及原因码
+func (e *ReasonCodeError) Error() string {
+	return fmt.Sprintf("%s (reason code 0x%02X)", e.Reason, e.Code)
+}
+
+// 未授权，代理访问控制拒绝时也返回该原因码
+const mqttReasonNotAuthorized = 0x87
+
+// mqttReasonCodes MQTT 5 原因码说明，broker未返回 ReasonString 时使用
+var mqttReasonCodes = map[byte]string{
+	0x00: "正常断开",
+	0x04: "断开并发布遗嘱消息",
+	0x80: "未指定错误",
+	0x81: "报文格式错误",
+	0x82: "协议错误",
+	0x83: "实现特定错误",
+	0x84: "不支持的协议版本",
+	0x85: "客户端标识符无效",
+	0x86: "用户名或密码错误",
+	0x87: "未授权",
+	0x88: "服务端不可用",
+	0x89: "服务端繁忙",
+	0x8A: "客户端已被禁止",
+	0x8B: "服务端正在关闭",
+	0x8C: "认证方法无效",
+	0x8D: "保活超时",
+	0x8E: "会话被接管",
+	0x8F: "主题过滤器无效",
+	0x90: "主题名无效",
+	0x91: "报文标识符已被占用",
+	0x93: "超出接收最大值",
+	0x95: "报文过长",
+	0x96: "消息速率过高",
+	0x97: "超出配额",
+	0x98: "管理操作",
+	0x99: "载荷格式无效",
+	0x9A: "不支持保留消息",
+	0x9B: "不支持的QoS",
+	0x9C: "请使用其他服务端",
+	0x9D: "服务端已迁移",
+	0x9E: "不支持共享订阅",
+	0x9F: "超出连接速率",
+	0xA0: "超出最大连接时间",
+	0xA1: "不支持订阅标识符",
+	0xA2: "不支持通配符订阅",
+}
+
+// newReasonCodeError 创建原因码错误，reason 为空时按原因码取说明
+func newReasonCodeError(code byte, reason string) *ReasonCodeError {
+	if reason == "" {
+		reason = mqttReasonCodes[code]
+	}
+	if reason == "" {
+		reason = "未知错误"
+	}
+	return &ReasonCodeError{Code: code, Reason: reason}
+}
+
+// reasonCodeOf 返回错误中的原因码，不是原因码错误时返回 0
+func reasonCodeOf(err error) int {
+	var reasonErr *ReasonCodeError
+	if errors.As(err, &reasonErr) {
+		return int(reasonErr.Code)
+	}
+	return 0
+}
+
+// newBrokerClient 按连接参数中的协议版本创建MQTT客户端
+func newBrokerClient(conn BrokerConnection, embeddedBroker *EmbeddedBroker, options BrokerClientOptions) (BrokerClient, error) {
+	if conn.ProtocolVersion == MQTTProtocolV5 {
+		return newBrokerClientV5(conn, embeddedBroker, options)
+	}
+	return newBrokerClientV3(conn, embeddedBroker, options)
+}
+
+// brokerClientV3 基于 paho.mqtt.golang 的 MQTT 3.1/3.1.1 客户端
+type brokerClientV3 struct {
+	client        mqtt.Client
+	server        string
+	autoReconnect bool
+}
+
+// newBrokerClientV3 创建 MQTT 3.1.1 客户端
+func newBrokerClientV3(conn BrokerConnection, embeddedBroker *EmbeddedBroker, options BrokerClientOptions) (*brokerClientV3, error) {
+	opts, err := newBrokerClientOptions(conn, embeddedBroker)
+	if err != nil {
+		return nil, err
+	}
+	opts.SetCleanSession(options.CleanSession)
+	if options.StoreDir != "" {
+		opts.SetStore(mqtt.NewFileStore(options.StoreDir))
+	}
+	opts.SetConnectTimeout(options.ConnectTimeout)
+	opts.SetKeepAlive(options.KeepAlive)
+	opts.SetOrderMatters(true)
+	opts.SetAutoReconnect(options.AutoReconnect)
+	if options.AutoReconnect {
+		opts.SetConnectRetry(true)
+		opts.SetConnectRetryInterval(options.RetryInterval)
+		opts.SetMaxReconnectInterval(options.MaxRetryInterval)
+	}
+
+	if options.OnConnect != nil {
+		opts.SetOnConnectHandler(func(c mqtt.Client) {
+			options.OnConnect()
+		})
+	}
+	if options.OnConnectionLost != nil {
+		opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+			options.OnConnectionLost(err)
+		})
+	}
+	if options.OnMessage != nil {
+		opts.SetDefaultPublishHandler(func(c mqtt.Client, msg mqtt.Message) {
+			options.OnMessage(&BrokerMessage{
+				Topic:    msg.Topic(),
+				Payload:  msg.Payload(),
+				QoS:      msg.Qos(),
+				Retained: msg.Retained(),
+			})
+		})
+	}
+
+	return &brokerClientV3{
+		client:        mqtt.NewClient(opts),
+		server:        opts.Servers[0].String(),
+		autoReconnect: options.AutoReconnect,
+	}, nil
+}
+
+// Connect 建立连接，开启连接重试后 Connect 不会阻塞等待，连接结果由回调通知
+func (c *brokerClientV3) Connect() error {
+	token := c.client.Connect()
+	if c.autoReconnect {
+		return nil
+	}
+	if token.Wait() && token.Error() != nil {
+		// CONNACK 返回码 1-5 为broker拒绝连接
+		if connectToken, ok := token.(*mqtt.ConnectToken); ok {
+			if code := connectToken.ReturnCode(); code > 0 && code < 0x80 {
+				return &ReasonCodeError{Code: code, Reason: token.Error().Error()}
+			}
+		}
+		return token.Error()
+	}
+	return nil
+}
+
+// Disconnect 断开连接
+func (c *brokerClientV3) Disconnect() {
+	c.client.Disconnect(250)
+}
+
+// IsConnectionOpen 是否已连接
+func (c *brokerClientV3) IsConnectionOpen() bool {
+	return c.client.IsConnectionOpen()
+}
+
+// Subscribe 订阅多个过滤器，SUBACK 返回 0x80 的过滤器视为被拒绝
+func (c *brokerClientV3) Subscribe(filters map[string]byte) (map[string]error, error) {
+	token := c.client.SubscribeMultiple(filters, nil)
+	if !token.WaitTimeout(brokerRequestTimeout) {
+		return nil, fmt.Errorf("subscribe timeout")
+	}
+	if token.Error() != nil {
+		return nil, token.Error()
+	}
+
+	failed := make(map[string]error)
+	if subscribeToken, ok := token.(*mqtt.SubscribeToken); ok {
+		for filter, code := range subscribeToken.Result() {
+			if code >= 0x80 {
+				failed[filter] = newReasonCodeError(code, "broker拒绝订阅")
+			}
+		}
+	}
+	return failed, nil
+}
+
+// Unsubscribe 取消订阅
+func (c *brokerClientV3) Unsubscribe(filters ...string) error {
+	token := c.client.Unsubscribe(filters...)
+	if !token.WaitTimeout(brokerRequestTimeout) {
+		return fmt.Errorf("unsubscribe timeout")
+	}
+	return token.Error()
+}
+
+// Publish 发布消息，MQTT 5 属性被忽略
+func (c *brokerClientV3) Publish(message *BrokerMessage) error {
+	token := c.client.Publish(message.Topic, message.QoS, message.Retained, message.Payload)
+	if !token.WaitTimeout(brokerRequestTimeout) {
+		return fmt.Errorf("publish timeout: %s", message.Topic)
+	}
+	return token.Error()
+}
+
+// Server 返回broker地址
+func (c *brokerClientV3) Server() string {
+	return c.server
+}
+
+// brokerClientV5 基于 paho.golang 的 MQTT 5 客户端，每次连接创建新的 paho.Client，会话状态跨连接保留
+type brokerClientV5 struct {
+	conn           BrokerConnection
+	embeddedBroker *EmbeddedBroker
+	options        BrokerClientOptions
+	session        *state.State
+
+	client  *paho.Client
+	stopped bool
+	stopCh  chan struct{}
+	mutex   sync.Mutex
+}
+
+// newBrokerClientV5 创建 MQTT 5 客户端，指定 StoreDir 时未确认的消息保存在该目录
+func newBrokerClientV5(conn BrokerConnection, embeddedBroker *EmbeddedBroker, options BrokerClientOptions) (*brokerClientV5, error) {
+	if err := conn.Validate(); err != nil {
+		return nil, err
+	}
+	if conn.Protocol == EmbeddedBrokerProtocol {
+		if !embeddedBroker.running() {
+			return nil, fmt.Errorf("内置MQTT broker未启用")
+		}
+	}
+
+	session := state.NewInMemory()
+	if options.StoreDir != "" {
+		if err := os.MkdirAll(options.StoreDir, 0755); err != nil {
+			return nil, fmt.Errorf("创建MQTT消息存储目录失败: %v", err)
+		}
+		clientStore, err := file.New(options.StoreDir, "client_", ".msg")
+		if err != nil {
+			return nil, fmt.Errorf("创建MQTT消息存储失败: %v", err)
+		}
+		serverStore, err := file.New(options.StoreDir, "server_", ".msg")
+		if err != nil {
+			return nil, fmt.Errorf("创建MQTT消息存储失败: %v", err)
+		}
+		session = state.New(clientStore, serverStore)
+	}
+
+	return &brokerClientV5{
+		conn:           conn,
+		embeddedBroker: embeddedBroker,
+		options:        options,
+		session:        session,
+		stopped:        true,
+	}, nil
+}
+
+// Connect 建立连接，开启自动重连时在后台重试
+func (c *brokerClientV5) Connect() error {
+	c.mutex.Lock()
+	if c.stopped {
+		c.stopped = false
+		c.stopCh = make(chan struct{})
+	}
+	stopCh := c.stopCh
+	c.mutex.Unlock()
+
+	if c.options.AutoReconnect {
+		go c.retry(stopCh)
+		return nil
+	}
+	return c.connect()
+}
+
+// retry 按退避间隔重试连接，直到连接成功或调用 Disconnect
+func (c *brokerClientV5) retry(stopCh chan struct{}) {
+	delay := c.options.RetryInterval
+	for {
+		err := c.connect()
+		if err == nil {
+			return
+		}
+		log.Printf("MQTT %s connect failed: %v", c.Server(), err)
+
+		select {
+		case <-time.After(delay):
+		case <-stopCh:
+			return
+		}
+		delay *= 2
+		if delay > c.options.MaxRetryInterval {
+			delay = c.options.MaxRetryInterval
+		}
+	}
+}
+
+// connect 拨号并完成一次 MQTT 5 握手，CONNACK 失败时返回原因码错误
+func (c *brokerClientV5) connect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.options.ConnectTimeout)
+	defer cancel()
+
+	netConn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 连接断开的回调可能早于 Connect 返回，等待 client 记录完成后再处理
+	ready := make(chan struct{})
+	var client *paho.Client
+	var lostOnce sync.Once
+	lost := func(err error) {
+		<-ready
+		lostOnce.Do(func() {
+			c.connectionLost(client, err)
+		})
+	}
+
+	client = paho.NewClient(paho.ClientConfig{
+		Conn:    netConn,
+		Session: c.session,
+		OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+			func(received paho.PublishReceived) (bool, error) {
+				if c.options.OnMessage != nil {
+					c.options.OnMessage(brokerMessageFromPublish(received.Packet))
+				}
+				return true, nil
+			},
+		},
+		OnClientError: lost,
+		OnServerDisconnect: func(disconnect *paho.Disconnect) {
+			reason := ""
+			if disconnect.Properties != nil {
+				reason = disconnect.Properties.ReasonString
+			}
+			lost(newReasonCodeError(disconnect.ReasonCode, reason))
+		},
+	})
+
+	connect := &paho.Connect{
+		ClientID:     c.conn.ClientID,
+		KeepAlive:    uint16(c.options.KeepAlive / time.Second),
+		CleanStart:   c.options.CleanSession,
+		Username:     c.conn.Username,
+		UsernameFlag: c.conn.Username != "",
+		Password:     []byte(c.conn.Password),
+		PasswordFlag: c.conn.Password != "",
+	}
+	if !c.options.CleanSession && c.options.SessionExpiry > 0 {
+		expiry := uint32(c.options.SessionExpiry / time.Second)
+		// 携带属性时 RequestProblemInfo 需显式开启，否则broker不再下发原因说明与用户属性
+		connect.Properties = &paho.ConnectProperties{SessionExpiryInterval: &expiry, RequestProblemInfo: true}
+	}
+
+	connack, err := client.Connect(ctx, connect)
+	if err != nil {
+		close(ready)
+		if connack != nil && connack.ReasonCode >= 0x80 {
+			reason := ""
+			if connack.Properties != nil {
+				reason = connack.Properties.ReasonString
+			}
+			return newReasonCodeError(connack.ReasonCode, reason)
+		}
+		return err
+	}
+
+	c.mutex.Lock()
+	stopped := c.stopped
+	if !stopped {
+		c.client = client
+	}
+	c.mutex.Unlock()
+	close(ready)
+
+	if stopped {
+		client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+		return fmt.Errorf("MQTT client disconnected")
+	}
+	if c.options.OnConnect != nil {
+		c.options.OnConnect()
+	}
+	return nil
+}
+
+// connectionLost 连接异常断开后通知调用方，开启自动重连时在后台重连；主动断开时不通知
+func (c *brokerClientV5) connectionLost(client *paho.Client, err error) {
+	c.mutex.Lock()
+	if c.stopped || c.client != client {
+		c.mutex.Unlock()
+		return
+	}
+	c.client = nil
+	stopCh := c.stopCh
+	c.mutex.Unlock()
+
+	if c.options.OnConnectionLost != nil {
+		c.options.OnConnectionLost(err)
+	}
+	if c.options.AutoReconnect {
+		go c.retry(stopCh)
+	}
+}
+
+// dial 按传输方式建立网络连接
+func (c *brokerClientV5) dial(ctx context.Context) (net.Conn, error) {
+	if c.conn.Protocol == EmbeddedBrokerProtocol {
+		conn, err := c.embeddedBroker.Dial()
+		if err != nil {
+			return nil, err
+		}
+		return packets.NewThreadSafeConn(conn), nil
+	}
+
+	tlsConfig, err := c.conn.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.conn.Protocol {
+	case "ws", "wss":
+		dialer := *websocket.DefaultDialer
+		dialer.TLSClientConfig = tlsConfig
+		dialer.Subprotocols = []string{"mqtt"}
+		ws, _, err := dialer.DialContext(ctx, c.conn.URL(), nil)
+		if err != nil {
+			return nil, err
+		}
+		return &brokerWebSocketConn{Conn: ws}, nil
+	case "ssl":
+		dialer := tls.Dialer{Config: tlsConfig}
+		conn, err := dialer.DialContext(ctx, "tcp", c.conn.Address())
+		if err != nil {
+			return nil, err
+		}
+		return packets.NewThreadSafeConn(conn), nil
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.conn.Address())
+	if err != nil {
+		return nil, err
+	}
+	return packets.NewThreadSafeConn(conn), nil
+}
+
+// current 返回当前连接
+func (c *brokerClientV5) current() (*paho.Client, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.client == nil {
+		return nil, fmt.Errorf("MQTT client not connected")
+	}
+	return c.client, nil
+}
+
+// Disconnect 发送 DISCONNECT 后断开，并停止自动重连
+func (c *brokerClientV5) Disconnect() {
+	c.mutex.Lock()
+	client := c.client
+	c.client = nil
+	if !c.stopped {
+		c.stopped = true
+		close(c.stopCh)
+	}
+	c.mutex.Unlock()
+
+	if client != nil {
+		client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+	}
+}
+
+// IsConnectionOpen 是否已连接
+func (c *brokerClientV5) IsConnectionOpen() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.client != nil
+}
+
+// Subscribe 订阅多个过滤器，SUBACK 原因码不小于 0x80 的过滤器视为被拒绝
+func (c *brokerClientV5) Subscribe(filters map[string]byte) (map[string]error, error) {
+	client, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+
+	subscribe := &paho.Subscribe{}
+	for filter, qos := range filters {
+		subscribe.Subscriptions = append(subscribe.Subscriptions, paho.SubscribeOptions{Topic: filter, QoS: qos})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), brokerRequestTimeout)
+	defer cancel()
+	suback, err := client.Subscribe(ctx, subscribe)
+	if suback == nil {
+		return nil, err
+	}
+
+	reason := ""
+	if suback.Properties != nil {
+		reason = suback.Properties.ReasonString
+	}
+	failed := make(map[string]error)
+	for i, code := range suback.Reasons {
+		if code >= 0x80 && i < len(subscribe.Subscriptions) {
+			failed[subscribe.Subscriptions[i].Topic] = newReasonCodeError(code, reason)
+		}
+	}
+	return failed, nil
+}
+
+// Unsubscribe 取消订阅
+func (c *brokerClientV5) Unsubscribe(filters ...string) error {
+	client, err := c.current()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), brokerRequestTimeout)
+	defer cancel()
+	unsuback, err := client.Unsubscribe(ctx, &paho.Unsubscribe{Topics: filters})
+	if err != nil {
+		return err
+	}
+	for _, code := range unsuback.Reasons {
+		if code >= 0x80 {
+			reason := ""
+			if unsuback.Properties != nil {
+				reason = unsuback.Properties.ReasonString
+			}
+			return newReasonCodeError(code, reason)
+		}
+	}
+	return nil
+}
+
+// Publish 发布消息，QoS1/2 的 PUBACK/PUBREC 原因码不小于 0x80 时返回原因码错误
+func (c *brokerClientV5) Publish(message *BrokerMessage) error {
+	client, err := c.current()
+	if err != nil {
+		return err
+	}
+
+	properties := &paho.PublishProperties{
+		ContentType:     message.ContentType,
+		ResponseTopic:   message.ResponseTopic,
+		CorrelationData: message.CorrelationData,
+	}
+	for _, property := range message.UserProperties {
+		properties.User.Add(property.Key, property.Value)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), brokerRequestTimeout)
+	defer cancel()
+	response, err := client.Publish(ctx, &paho.Publish{
+		Topic:      message.Topic,
+		QoS:        message.QoS,
+		Retain:     message.Retained,
+		Payload:    message.Payload,
+		Properties: properties,
+	})
+	if response != nil && response.ReasonCode >= 0x80 {
+		reason := ""
+		if response.Properties != nil {
+			reason = response.Properties.ReasonString
+		}
+		return newReasonCodeError(response.ReasonCode, reason)
+	}
+	return err
+}
+
+// Server 返回broker地址
+func (c *brokerClientV5) Server() string {
+	if c.conn.Protocol == EmbeddedBrokerProtocol {
+		return EmbeddedBrokerProtocol + "://local"
+	}
+	return c.conn.URL()
+}
+
+// brokerMessageFromPublish 转换收到的 PUBLISH 及其 MQTT 5 属性
+func brokerMessageFromPublish(publish *paho.Publish) *BrokerMessage {
+	message := &BrokerMessage{
+		Topic:    publish.Topic,
+		Payload:  publish.Payload,
+		QoS:      publish.QoS,
+		Retained: publish.Retain,
+	}
+	if publish.Properties != nil {
+		message.ContentType = publish.Properties.ContentType
+		message.ResponseTopic = publish.Properties.ResponseTopic
+		message.CorrelationData = publish.Properties.CorrelationData
+		for _, property := range publish.Properties.User {
+			message.UserProperties = append(message.UserProperties, MQTTUserProperty{Key: property.Key, Value: property.Value})
+		}
+	}
+	return message
+}
+
+// brokerWebSocketConn 将 WebSocket 连接包装为 net.Conn，供 ws/wss 传输使用
+type brokerWebSocketConn struct {
+	*websocket.Conn
+	reader io.Reader
+	// 写入由 paho 通过 sync.Locker 串行化
+	sync.Mutex
+	readMutex sync.Mutex
+}
+
+// SetDeadline 同时设置读写超时
+func (c *brokerWebSocketConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// Write 以二进制帧写入
+func (c *brokerWebSocketConn) Write(p []byte) (int, error) {
+	if err := c.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Read 按字节流读取，跨越帧边界
+func (c *brokerWebSocketConn) Read(p []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+
+	for {
+		if c.reader == nil {
+			_, reader, err := c.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			c.reader = reader
+		}
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// BrokerConnection MQTT配置中的连接参数，证书与私钥为PEM文本
+type BrokerConnection struct {
+	Protocol           string
+	Host               string
+	Port               int
+	Path               string
+	Username           string
+	Password           string
+	ClientID           string
+	CACert             string
+	ClientCert         string
+	ClientKey          string
+	InsecureSkipVerify bool
+	// MQTT协议版本，3 为 3.1、4 为 3.1.1、5 为 MQTT 5，为 0 时使用 3.1.1
+	ProtocolVersion int
+}
+
+// MQTT协议版本
+const (
+	MQTTProtocolV31  = 3
+	MQTTProtocolV311 = 4
+	MQTTProtocolV5   = 5
+)
+
+// 各传输方式的默认端口
+var defaultBrokerPorts = map[string]int{
+	"tcp": 1883,
+	"ssl": 8883,
+	"ws":  8083,
+	"wss": 8084,
+}
+
+// brokerConnectionFromConfig 解析MQTT配置，host 兼容旧配置的 broker 字段
+func brokerConnectionFromConfig(config map[string]interface{}) BrokerConnection {
+	host := configString(config, "host")
+	if host == "" {
+		host = configString(config, "broker")
+	}
+
+	insecure, _ := config["insecureSkipVerify"].(bool)
+
+	return BrokerConnection{
+		Protocol:           normalizeBrokerProtocol(configString(config, "protocol")),
+		Host:               host,
+		Port:               configInt(config, "port"),
+		Path:               configString(config, "path"),
+		Username:           configString(config, "username"),
+		Password:           configString(config, "password"),
+		ClientID:           configString(config, "clientId"),
+		CACert:             configString(config, "caCert"),
+		ClientCert:         configString(config, "clientCert"),
+		ClientKey:          configString(config, "clientKey"),
+		InsecureSkipVerify: insecure,
+		ProtocolVersion:    configInt(config, "protocolVersion"),
+	}
+}
+
+// normalizeBrokerProtocol 统一传输方式写法，未识别的值原样返回由 Validate 报错
+func normalizeBrokerProtocol(protocol string) string {
+	switch strings.ToLower(protocol) {
+	case "", "tcp", "mqtt":
+		return "tcp"
+	case "ssl", "tls", "mqtts":
+		return "ssl"
+	case "ws":
+		return "ws"
+	case "wss":
+		return "wss"
+	case EmbeddedBrokerProtocol:
+		return EmbeddedBrokerProtocol
+	}
+	return protocol
+}
+
+// UsesTLS 是否为 ssl 或 wss 传输
+func (c BrokerConnection) UsesTLS() bool {
+	return c.Protocol == "ssl" || c.Protocol == "wss"
+}
+
+// Address 返回 host:port，未配置端口时按传输方式取默认端口
+func (c BrokerConnection) Address() string {
+	port := c.Port
+	if port == 0 {
+		port = defaultBrokerPorts[c.Protocol]
+	}
+	return fmt.Sprintf("%s:%d", c.Host, port)
+}
+
+// URL 返回 paho 使用的 broker 地址
+func (c BrokerConnection) URL() string {
+	url := fmt.Sprintf("%s://%s", c.Protocol, c.Address())
+	if c.Protocol == "ws" || c.Protocol == "wss" {
+		path := c.Path
+		if path == "" {
+			path = "/mqtt"
+		}
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		url += path
+	}
+	return url
+}
+
+// Validate 校验传输方式、地址与证书
+func (c BrokerConnection) Validate() error {
+	switch c.ProtocolVersion {
+	case 0, MQTTProtocolV31, MQTTProtocolV311, MQTTProtocolV5:
+	default:
+		return fmt.Errorf("不支持的MQTT协议版本: %d", c.ProtocolVersion)
+	}
+	if c.Protocol == EmbeddedBrokerProtocol {
+		return nil
+	}
+	if _, ok := defaultBrokerPorts[c.Protocol]; !ok {
+		return fmt.Errorf("不支持的传输方式: %s", c.Protocol)
+	}
+	if c.Host == "" {
+		return fmt.Errorf("缺少host配置")
+	}
+	_, err := c.TLSConfig()
+	return err
+}
+
+// TLSConfig 构建 ssl/wss 使用的TLS配置，未上传CA时使用系统根证书
+func (c BrokerConnection) TLSConfig() (*tls.Config, error) {
+	if !c.UsesTLS() {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         c.Host,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(c.CACert)) {
+			return nil, fmt.Errorf("CA证书不是有效的PEM格式")
+		}
+		config.RootCAs = pool
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		if c.ClientCert == "" || c.ClientKey == "" {
+			return nil, fmt.Errorf("客户端证书与私钥需同时配置")
+		}
+		cert, err := tls.X509KeyPair([]byte(c.ClientCert), []byte(c.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("客户端证书或私钥无效: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// newBrokerClientOptions 按连接参数构建 MQTT 3.1.1 客户端选项，重连与保活等由调用方设置
+func newBrokerClientOptions(conn BrokerConnection, embeddedBroker *EmbeddedBroker) (*mqtt.ClientOptions, error) {
+	if err := conn.Validate(); err != nil {
+		return nil, err
+	}
+
+	opts := mqtt.NewClientOptions()
+	opts.SetClientID(conn.ClientID)
+	opts.SetUsername(conn.Username)
+	opts.SetPassword(conn.Password)
+	if conn.ProtocolVersion != 0 {
+		opts.SetProtocolVersion(uint(conn.ProtocolVersion))
+	}
+
+	if conn.Protocol == EmbeddedBrokerProtocol {
+		if err := embeddedBroker.ApplyClientOptions(opts); err != nil {
+			return nil, err
+		}
+		return opts, nil
+	}
+
+	opts.AddBroker(conn.URL())
+	tlsConfig, err := conn.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	return opts, nil
+}
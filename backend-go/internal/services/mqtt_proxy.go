@@ -1,84 +1,415 @@
 package services
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-// MQTTProxyService MQTT代理服务
+// 最后一个浏览器离开后上游连接保留的时间，刷新页面时无需重新连接broker
+const proxyUpstreamIdleTimeout = 30 * time.Second
+
+// 过滤器最后一个引用取消后继续订阅并缓存消息的时间，浏览器重连后可补发期间的消息
+const proxyFilterLinger = proxyUpstreamIdleTimeout
+
+// MQTT 5 持久会话在上游断开后由broker保留的时长，覆盖重连退避期间的离线消息
+const proxySessionExpiry = time.Hour
+
+const (
+	proxyPingInterval = 10 * time.Second
+	// 超过该时长未收到 pong 或消息视为浏览器已断开
+	proxyPongWait  = 30 * time.Second
+	proxyWriteWait = 10 * time.Second
+	// 不可丢弃的消息积压超过队列长度的倍数时断开慢速浏览器
+	proxyOutboxHardLimit = 4
+	// 每个浏览器等待处理的请求数上限
+	proxyInboxSize = 64
+)
+
+// 发送队列满时的处理策略
+const (
+	// 丢弃队列中最早的可丢弃消息（默认）
+	ProxyDropOldest = "drop_oldest"
+	// 丢弃新到的可丢弃消息
+	ProxyDropNewest = "drop_newest"
+	// 断开慢速浏览器
+	ProxyDropDisconnect = "disconnect"
+)
+
+// MQTTProxyService MQTT代理服务，连接参数相同的浏览器共享一个上游MQTT连接
 type MQTTProxyService struct {
-	clients map[string]*MQTTClient
-	mutex   sync.RWMutex
+	errorCodeService *ErrorCodeService
+	embeddedBroker   *EmbeddedBroker
+	mqttService      *MQTTService
+	acl              *ProxyACLService
+	clients          map[string]*MQTTClient
+	upstreams        map[string]*proxyUpstream
+	mutex            sync.RWMutex
+
+	options MQTTProxyOptions
+
+	framesSent      int64
+	framesDropped   int64
+	slowDisconnects int64
+	deadPeers       int64
 }
 
-// MQTTClient MQTT客户端包装
+// MQTTClient 浏览器WebSocket连接，所有写入经发送队列由单独的协程完成
 type MQTTClient struct {
 	ID          string
-	Client      mqtt.Client
 	WSConn      *websocket.Conn
-	Config      MQTTConfig
 	IsConnected bool
-	mutex       sync.RWMutex
+	upstream    *proxyUpstream
+	// 启用访问控制时 auth 成功后的账号
+	principal *ProxyPrincipal
+	mutex     sync.RWMutex
+
+	// 浏览器请求由单独的协程按序处理，连接broker等耗时操作不阻塞读循环与 pong 处理
+	inbox chan WebSocketMessage
+
+	outbox     []proxyFrame
+	notify     chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+	closed     bool
+	outboxLock sync.Mutex
+
+	connectedAt int64
+	lastSeenAt  int64
+	sent        int64
+	dropped     int64
+	dead        int32
+}
+
+// proxyFrame 待发送的WebSocket帧，droppable 为队列满时可丢弃的高频消息
+type proxyFrame struct {
+	data      []byte
+	droppable bool
 }
 
 // MQTTConfig MQTT配置
 type MQTTConfig struct {
+	// tcp/ssl/ws/wss，为 embedded 时连接内置broker，忽略 Host 与 Port
+	Protocol string `json:"protocol,omitempty"`
 	Host     string `json:"host"`
 	Port     int    `json:"port"`
+	Path     string `json:"path,omitempty"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// 上游连接由多个浏览器共享，不使用浏览器传入的 ClientID
 	ClientID string `json:"clientId"`
+	// ssl/wss 使用的PEM证书
+	CACert             string `json:"caCert,omitempty"`
+	ClientCert         string `json:"clientCert,omitempty"`
+	ClientKey          string `json:"clientKey,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	// 4 为 MQTT 3.1.1（默认），5 为 MQTT 5
+	ProtocolVersion int `json:"protocolVersion,omitempty"`
+}
+
+// brokerConnection 转换为通用连接参数
+func (c *MQTTConfig) brokerConnection() BrokerConnection {
+	return BrokerConnection{
+		Protocol:           normalizeBrokerProtocol(c.Protocol),
+		Host:               c.Host,
+		Port:               c.Port,
+		Path:               c.Path,
+		Username:           c.Username,
+		Password:           c.Password,
+		ClientID:           c.ClientID,
+		CACert:             c.CACert,
+		ClientCert:         c.ClientCert,
+		ClientKey:          c.ClientKey,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		ProtocolVersion:    c.ProtocolVersion,
+	}
 }
 
 // WebSocketMessage WebSocket消息
 type WebSocketMessage struct {
-	Type    string      `json:"type"`
-	Topic   string      `json:"topic,omitempty"`
-	Payload string      `json:"payload,omitempty"`
-	QoS     int         `json:"qos,omitempty"`
-	Retain  bool        `json:"retain,omitempty"`
-	Config  *MQTTConfig `json:"config,omitempty"`
+	Type    string `json:"type"`
+	Topic   string `json:"topic,omitempty"`
+	Payload string `json:"payload,omitempty"`
+	QoS     int    `json:"qos,omitempty"`
+	Retain  bool   `json:"retain,omitempty"`
+	// connect 时使用已保存的MQTT配置，优先于 Config
+	ProfileID string      `json:"profileId,omitempty"`
+	Config    *MQTTConfig `json:"config,omitempty"`
+	// mqtt_message 在上游连接内的序号；subscribe 时指定 since 补发缓存中序号更大的消息
+	Seq   int64  `json:"seq,omitempty"`
+	Since *int64 `json:"since,omitempty"`
+	// mqtt_reconnecting 的重试次数与距下次重试的毫秒数
+	Attempt int   `json:"attempt,omitempty"`
+	RetryIn int64 `json:"retryIn,omitempty"`
+	// mqtt_resubscribed 重连后恢复的订阅
+	Subscriptions []ProxySubscription `json:"subscriptions,omitempty"`
+	// MQTT 5 消息属性，mqtt_message 为收到的属性，publish 时随消息发布，上游为 MQTT 3.1.1 时忽略
+	ContentType     string             `json:"contentType,omitempty"`
+	ResponseTopic   string             `json:"responseTopic,omitempty"`
+	CorrelationData string             `json:"correlationData,omitempty"`
+	UserProperties  []MQTTUserProperty `json:"userProperties,omitempty"`
+	// 连接、订阅、发布失败及上游断开时broker返回的原因码，Payload 为对应的错误说明
+	ReasonCode int `json:"reasonCode,omitempty"`
+	// auth 消息的账号密码，auth_result 返回账号角色与可访问的设备
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	Role     string   `json:"role,omitempty"`
+	Devices  []string `json:"devices,omitempty"`
+	// 访问控制拒绝时的错误类型（unauthenticated / forbidden）、被拒绝的操作及命中的规则
+	Code   string `json:"code,omitempty"`
+	Action string `json:"action,omitempty"`
+	RuleID string `json:"ruleId,omitempty"`
+	// mqtt_message 为抓包回放注入的消息
+	Replay bool `json:"replay,omitempty"`
+}
+
+// ProxySubscription 浏览器的订阅及恢复结果
+type ProxySubscription struct {
+	Topic      string `json:"topic"`
+	QoS        int    `json:"qos"`
+	Error      string `json:"error,omitempty"`
+	ReasonCode int    `json:"reasonCode,omitempty"`
+}
+
+// proxyUpstream 共享的上游MQTT连接
+// 每个浏览器的订阅单独记录，上游对同一过滤器只订阅一次，收到消息后在本地匹配分发
+type proxyUpstream struct {
+	key     string
+	broker  string
+	version int
+	client  BrokerClient
+
+	// 连接结束后关闭，err 为连接结果
+	ready chan struct{}
+	err   error
+
+	// members 浏览器 -> 过滤器 -> QoS，filters 为上游已订阅的过滤器及QoS
+	members   map[*MQTTClient]map[string]byte
+	filters   map[string]byte
+	idleTimer *time.Timer
+	mutex     sync.Mutex
+
+	// 消息序号、各过滤器最近消息的缓冲与延迟取消订阅的过滤器
+	seq     int64
+	buffers map[string]*proxyRingBuffer
+	lingers map[string]*proxyLinger
+
+	// 自动重连中为 true，released 在上游连接被释放后关闭以停止重连
+	reconnecting bool
+	attempts     int
+	released     chan struct{}
+	releaseOnce  sync.Once
+
+	// 串行化上游订阅变更，避免订阅与取消订阅交错
+	subscribeMutex sync.Mutex
+}
+
+// MQTTProxyOptions MQTT代理的发送队列、重连与会话配置
+type MQTTProxyOptions struct {
+	// 每个浏览器的发送队列长度、队列满时的策略与可丢弃消息的主题过滤器
+	QueueSize       int
+	DropPolicy      string
+	DroppableTopics []string
+
+	// 上游连接断开后以 ReconnectMinInterval 起按倍数退避重连，间隔不超过 ReconnectMaxInterval
+	Reconnect            bool
+	ReconnectMinInterval time.Duration
+	ReconnectMaxInterval time.Duration
+
+	// 上游使用由连接参数得到的固定 ClientID 与持久会话，未确认的QoS1/2消息保存在 StoreDir
+	PersistentSession bool
+	StoreDir          string
+	// 每个主题过滤器缓存的最近消息数，不缓存可丢弃的高频消息
+	BufferSize int
+}
+
+// proxyLinger 过滤器延迟取消订阅的定时器
+type proxyLinger struct {
+	timer *time.Timer
+}
+
+// proxyRingBuffer 固定容量的消息缓冲，写满后覆盖最早的消息
+type proxyRingBuffer struct {
+	frames []proxyBufferedFrame
+	next   int
+	full   bool
+}
+
+// proxyBufferedFrame 缓存的 mqtt_message 帧
+type proxyBufferedFrame struct {
+	seq  int64
+	data []byte
+}
+
+// newProxyRingBuffer 创建消息缓冲
+func newProxyRingBuffer(size int) *proxyRingBuffer {
+	return &proxyRingBuffer{frames: make([]proxyBufferedFrame, size)}
+}
+
+// add 追加消息
+func (b *proxyRingBuffer) add(seq int64, data []byte) {
+	b.frames[b.next] = proxyBufferedFrame{seq: seq, data: data}
+	b.next = (b.next + 1) % len(b.frames)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// len 缓存的消息数
+func (b *proxyRingBuffer) len() int {
+	if b.full {
+		return len(b.frames)
+	}
+	return b.next
+}
+
+// since 按顺序返回序号大于 seq 的消息
+func (b *proxyRingBuffer) since(seq int64) []proxyFrame {
+	start := 0
+	if b.full {
+		start = b.next
+	}
+	frames := []proxyFrame{}
+	for i := 0; i < b.len(); i++ {
+		frame := b.frames[(start+i)%len(b.frames)]
+		if frame.seq > seq {
+			frames = append(frames, proxyFrame{data: frame.data})
+		}
+	}
+	return frames
 }
 
 // NewMQTTProxyService 创建MQTT代理服务
-func NewMQTTProxyService() *MQTTProxyService {
-	return &MQTTProxyService{
-		clients: make(map[string]*MQTTClient),
+func NewMQTTProxyService(errorCodeService *ErrorCodeService, embeddedBroker *EmbeddedBroker, mqttService *MQTTService, acl *ProxyACLService, options MQTTProxyOptions) *MQTTProxyService {
+	switch options.DropPolicy {
+	case ProxyDropOldest, ProxyDropNewest, ProxyDropDisconnect:
+	default:
+		log.Printf("未知的WebSocket代理丢弃策略 %q，使用 %s", options.DropPolicy, ProxyDropOldest)
+		options.DropPolicy = ProxyDropOldest
+	}
+
+	s := &MQTTProxyService{
+		errorCodeService: errorCodeService,
+		embeddedBroker:   embeddedBroker,
+		mqttService:      mqttService,
+		acl:              acl,
+		clients:          make(map[string]*MQTTClient),
+		upstreams:        make(map[string]*proxyUpstream),
+		options:          options,
+	}
+	if acl != nil {
+		acl.RegisterChangeHandler(s.reauthorize)
 	}
+	return s
 }
 
-// AddClient 添加客户端
+// AddClient 添加客户端，启动发送协程并开启 ping/pong 保活
 func (s *MQTTProxyService) AddClient(clientID string, wsConn *websocket.Conn) *MQTTClient {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
+	now := time.Now().UnixMilli()
 	client := &MQTTClient{
 		ID:          clientID,
 		WSConn:      wsConn,
 		IsConnected: false,
+		inbox:       make(chan WebSocketMessage, proxyInboxSize),
+		notify:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		connectedAt: now,
+		lastSeenAt:  now,
 	}
 
+	if wsConn != nil {
+		wsConn.SetReadDeadline(time.Now().Add(proxyPongWait))
+		wsConn.SetPongHandler(func(string) error {
+			client.touch()
+			return nil
+		})
+		go s.writeLoop(client)
+	}
+	go s.handleLoop(client)
+
+	s.mutex.Lock()
 	s.clients[clientID] = client
+	s.mutex.Unlock()
+
 	return client
 }
 
-// RemoveClient 移除客户端
+// RemoveClient 移除客户端并释放其订阅
 func (s *MQTTProxyService) RemoveClient(clientID string) {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	client, exists := s.clients[clientID]
+	delete(s.clients, clientID)
+	s.mutex.Unlock()
 
-	if client, exists := s.clients[clientID]; exists {
-		if client.Client != nil && client.Client.IsConnected() {
-			client.Client.Disconnect(250)
-		}
-		delete(s.clients, clientID)
+	if !exists {
+		return
+	}
+
+	if time.Since(time.UnixMilli(atomic.LoadInt64(&client.lastSeenAt))) >= proxyPongWait {
+		s.markDead(client, "no pong received")
+	}
+	s.handleDisconnect(client)
+	client.close()
+}
+
+// Send 向浏览器发送消息，不会被丢弃
+func (s *MQTTProxyService) Send(clientID string, message interface{}) {
+	client, exists := s.GetClient(clientID)
+	if !exists {
+		return
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to marshal WebSocket message: %v", err)
+		return
+	}
+	s.enqueue(client, proxyFrame{data: data})
+}
+
+// touch 收到浏览器消息或 pong 后延长读超时
+func (c *MQTTClient) touch() {
+	atomic.StoreInt64(&c.lastSeenAt, time.Now().UnixMilli())
+	if c.WSConn != nil {
+		c.WSConn.SetReadDeadline(time.Now().Add(proxyPongWait))
+	}
+}
+
+// markDead 记录无响应的浏览器并关闭连接，每个连接只计数一次
+func (s *MQTTProxyService) markDead(client *MQTTClient, reason string) {
+	if atomic.CompareAndSwapInt32(&client.dead, 0, 1) {
+		atomic.AddInt64(&s.deadPeers, 1)
+		log.Printf("WebSocket client %s dead: %s", client.ID, reason)
 	}
+	client.close()
+}
+
+// close 停止发送协程并关闭连接，读循环随之退出
+func (c *MQTTClient) close() {
+	c.closeOnce.Do(func() {
+		c.outboxLock.Lock()
+		c.closed = true
+		c.outbox = nil
+		c.outboxLock.Unlock()
+
+		close(c.done)
+		if c.WSConn != nil {
+			c.WSConn.Close()
+		}
+	})
 }
 
 // GetClient 获取客户端
@@ -90,199 +421,1284 @@ func (s *MQTTProxyService) GetClient(clientID string) (*MQTTClient, bool) {
 	return client, exists
 }
 
-// HandleWebSocketMessage 处理WebSocket消息
+// HandleWebSocketMessage 将WebSocket消息交给浏览器的处理协程按序处理，不等待处理结果
 func (s *MQTTProxyService) HandleWebSocketMessage(clientID string, message WebSocketMessage) error {
 	client, exists := s.GetClient(clientID)
 	if !exists {
 		return fmt.Errorf("client not found: %s", clientID)
 	}
+	client.touch()
+
+	select {
+	case client.inbox <- message:
+		return nil
+	case <-client.done:
+		return fmt.Errorf("client closed: %s", clientID)
+	default:
+		return fmt.Errorf("too many pending requests")
+	}
+}
+
+// handleLoop 按序处理浏览器请求，处理失败时返回 error 消息；
+// 浏览器在请求处理期间断开时（如等待上游连接）再次离开上游连接，避免遗留成员
+func (s *MQTTProxyService) handleLoop(client *MQTTClient) {
+	for {
+		select {
+		case <-client.done:
+			return
+		case message := <-client.inbox:
+			if err := s.handleMessage(client, &message); err != nil {
+				log.Printf("Failed to handle WebSocket message: %v", err)
+				s.sendWebSocketMessage(client, WebSocketMessage{
+					Type:    "error",
+					Payload: err.Error(),
+				})
+			}
+
+			select {
+			case <-client.done:
+				s.handleDisconnect(client)
+				return
+			default:
+			}
+		}
+	}
+}
 
+// handleMessage 处理单条WebSocket消息
+func (s *MQTTProxyService) handleMessage(client *MQTTClient, message *WebSocketMessage) error {
 	switch message.Type {
+	case "auth":
+		return s.handleAuth(client, message)
 	case "connect":
-		return s.handleConnect(client, message.Config)
+		return s.handleConnect(client, message)
 	case "disconnect":
 		return s.handleDisconnect(client)
 	case "subscribe":
-		return s.handleSubscribe(client, message.Topic, message.QoS)
+		return s.handleSubscribe(client, message.Topic, message.QoS, message.Since)
 	case "unsubscribe":
 		return s.handleUnsubscribe(client, message.Topic)
 	case "publish":
-		return s.handlePublish(client, message.Topic, message.Payload, message.QoS, message.Retain)
+		return s.handlePublish(client, message)
 	default:
 		return fmt.Errorf("unknown message type: %s", message.Type)
 	}
 }
 
-// handleConnect 处理连接
-func (s *MQTTProxyService) handleConnect(client *MQTTClient, config *MQTTConfig) error {
-	if config == nil {
-		return fmt.Errorf("config is required for connect")
+// handleAuth 以代理账号认证，之后的订阅与发布按账号角色的规则检查
+func (s *MQTTProxyService) handleAuth(client *MQTTClient, message *WebSocketMessage) error {
+	if s.acl == nil || !s.acl.Enabled() {
+		s.sendWebSocketMessage(client, WebSocketMessage{
+			Type:    "auth_result",
+			Payload: "success",
+		})
+		return nil
+	}
+
+	principal, err := s.acl.Authenticate(message.Username, message.Password)
+	if err != nil {
+		s.sendACLError(client, "auth_result", err)
+		return err
 	}
 
 	client.mutex.Lock()
-	defer client.mutex.Unlock()
-
-	// 如果已经连接，先断开
-	if client.Client != nil && client.Client.IsConnected() {
-		client.Client.Disconnect(250)
-	}
-
-	client.Config = *config
-
-	// 创建MQTT客户端选项
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", config.Host, config.Port))
-	opts.SetClientID(config.ClientID)
-	opts.SetUsername(config.Username)
-	opts.SetPassword(config.Password)
-	opts.SetCleanSession(true)
-	opts.SetAutoReconnect(false)
-	opts.SetConnectTimeout(30 * time.Second)
-	opts.SetKeepAlive(60 * time.Second)
-
-	// 设置连接处理器
-	opts.SetOnConnectHandler(func(c mqtt.Client) {
-		log.Printf("MQTT client %s connected to %s:%d", client.ID, config.Host, config.Port)
-		client.IsConnected = true
+	client.principal = principal
+	client.mutex.Unlock()
+
+	log.Printf("MQTT client %s authenticated as %s (%s)", client.ID, principal.Username(), principal.Role())
+	s.sendWebSocketMessage(client, WebSocketMessage{
+		Type:     "auth_result",
+		Payload:  "success",
+		Username: principal.Username(),
+		Role:     principal.Role(),
+		Devices:  principal.Devices(),
+	})
+	return nil
+}
+
+// authorize 启用访问控制时检查浏览器账号能否执行操作，未认证时 action 为空只检查是否已认证
+func (s *MQTTProxyService) authorize(client *MQTTClient, action, topic string) error {
+	if s.acl == nil || !s.acl.Enabled() {
+		return nil
+	}
+
+	client.mutex.RLock()
+	principal := client.principal
+	client.mutex.RUnlock()
+	if principal == nil {
+		return &ProxyACLError{Code: ProxyErrorUnauthenticated, Action: action, Topic: topic, Reason: "未认证，请先发送 auth 消息"}
+	}
+	if action == "" {
+		return nil
+	}
+	return s.acl.Authorize(principal, action, topic)
+}
+
+// sendACLError 以指定类型的结果消息返回访问控制错误，其他错误只带说明
+func (s *MQTTProxyService) sendACLError(client *MQTTClient, messageType string, err error) {
+	message := WebSocketMessage{
+		Type:    messageType,
+		Payload: err.Error(),
+	}
+	if aclErr, ok := err.(*ProxyACLError); ok {
+		message.Topic = aclErr.Topic
+		message.Code = aclErr.Code
+		message.Action = aclErr.Action
+		message.RuleID = aclErr.RuleID
+		message.ReasonCode = mqttReasonNotAuthorized
+	}
+	s.sendWebSocketMessage(client, message)
+}
+
+// reauthorize 账号或规则变更后重新检查已认证浏览器，账号失效时离开上游连接，不再允许的订阅被取消
+func (s *MQTTProxyService) reauthorize() {
+	s.mutex.RLock()
+	clients := make([]*MQTTClient, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mutex.RUnlock()
+
+	for _, client := range clients {
+		client.mutex.Lock()
+		principal, upstream := client.principal, client.upstream
+		client.mutex.Unlock()
+		if principal == nil {
+			continue
+		}
+
+		if err := s.acl.Refresh(principal); err != nil {
+			if _, ok := err.(*ProxyACLError); !ok {
+				log.Printf("Reload proxy account %s failed: %v", principal.Username(), err)
+				continue
+			}
+			client.mutex.Lock()
+			client.principal = nil
+			client.mutex.Unlock()
+			s.handleDisconnect(client)
+			log.Printf("MQTT client %s account %s revoked: %v", client.ID, principal.Username(), err)
+			s.sendACLError(client, "auth_revoked", err)
+			continue
+		}
+		if upstream == nil {
+			continue
+		}
+
+		upstream.mutex.Lock()
+		filters := []string{}
+		for filter := range upstream.members[client] {
+			filters = append(filters, filter)
+		}
+		upstream.mutex.Unlock()
+		sort.Strings(filters)
+
+		for _, filter := range filters {
+			err := s.acl.Authorize(principal, ProxyActionSubscribe, filter)
+			if err == nil {
+				continue
+			}
+			if _, ok := err.(*ProxyACLError); !ok {
+				log.Printf("Authorize %s for %s failed: %v", filter, principal.Username(), err)
+				continue
+			}
+			upstream.unsubscribe(client, filter)
+			log.Printf("MQTT client %s subscription %s revoked: %v", client.ID, filter, err)
+			s.sendACLError(client, "subscription_revoked", err)
+		}
+	}
+}
+
+// resolveConnection 解析 connect 消息中的连接参数
+func (s *MQTTProxyService) resolveConnection(message *WebSocketMessage) (BrokerConnection, error) {
+	if message.ProfileID != "" {
+		profile, err := s.mqttService.FindProfile(message.ProfileID)
+		if err != nil {
+			return BrokerConnection{}, fmt.Errorf("MQTT配置不存在: %s", message.ProfileID)
+		}
+		return brokerConnectionFromConfig(profile.Config), nil
+	}
+	if message.Config == nil {
+		return BrokerConnection{}, fmt.Errorf("config is required for connect")
+	}
+	conn := message.Config.brokerConnection()
+	if err := s.mqttService.restoreSecrets(&conn); err != nil {
+		return BrokerConnection{}, err
+	}
+	return conn, nil
+}
+
+// upstreamKey 按连接参数（不含 ClientID）计算共享键，配置修改后自动使用新的上游连接
+func upstreamKey(conn BrokerConnection) string {
+	conn.ClientID = ""
+	data, _ := json.Marshal(conn)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// handleConnect 加入共享的上游连接，不存在时创建，同时加入的浏览器等待同一次连接结果
+func (s *MQTTProxyService) handleConnect(client *MQTTClient, message *WebSocketMessage) error {
+	if err := s.authorize(client, "", ""); err != nil {
+		s.sendACLError(client, "mqtt_error", err)
+		return err
+	}
+
+	conn, err := s.resolveConnection(message)
+	if err != nil {
+		return err
+	}
+
+	// 如果已经连接，先离开原来的上游连接
+	s.handleDisconnect(client)
+
+	key := upstreamKey(conn)
+
+	s.mutex.Lock()
+	upstream, exists := s.upstreams[key]
+	if !exists {
+		upstream = &proxyUpstream{
+			key:      key,
+			ready:    make(chan struct{}),
+			released: make(chan struct{}),
+			members:  make(map[*MQTTClient]map[string]byte),
+			filters:  make(map[string]byte),
+			buffers:  make(map[string]*proxyRingBuffer),
+			lingers:  make(map[string]*proxyLinger),
+		}
+		s.upstreams[key] = upstream
+	}
+	upstream.attach(client)
+	s.mutex.Unlock()
+
+	if !exists {
+		s.connectUpstream(upstream, conn)
+	}
+	<-upstream.ready
+
+	if upstream.err != nil {
+		upstream.detach(client)
 		s.sendWebSocketMessage(client, WebSocketMessage{
-			Type: "mqtt_connected",
+			Type:       "mqtt_error",
+			Payload:    upstream.err.Error(),
+			ReasonCode: reasonCodeOf(upstream.err),
 		})
-	})
+		return upstream.err
+	}
+
+	client.mutex.Lock()
+	client.upstream = upstream
+	client.IsConnected = true
+	client.mutex.Unlock()
 
-	// 设置连接丢失处理器
-	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
-		log.Printf("MQTT client %s connection lost: %v", client.ID, err)
-		client.IsConnected = false
+	log.Printf("MQTT client %s joined upstream %s", client.ID, upstream.broker)
+
+	// 上游正在重连时告知浏览器，重连成功后与其他浏览器一起收到 mqtt_connected
+	upstream.mutex.Lock()
+	reconnecting, attempt := upstream.reconnecting, upstream.attempts
+	upstream.mutex.Unlock()
+	if reconnecting {
 		s.sendWebSocketMessage(client, WebSocketMessage{
-			Type: "mqtt_disconnected",
+			Type:    "mqtt_reconnecting",
+			Attempt: attempt,
 		})
+		return nil
+	}
+
+	s.sendWebSocketMessage(client, WebSocketMessage{
+		Type: "mqtt_connected",
 	})
+	return nil
+}
 
-	// 创建客户端
-	client.Client = mqtt.NewClient(opts)
+// connectUpstream 建立上游连接，失败时从共享表移除，下次加入时重新创建
+func (s *MQTTProxyService) connectUpstream(upstream *proxyUpstream, conn BrokerConnection) {
+	defer close(upstream.ready)
 
-	// 连接
-	if token := client.Client.Connect(); token.Wait() && token.Error() != nil {
-		log.Printf("MQTT client %s connection failed: %v", client.ID, token.Error())
-		s.sendWebSocketMessage(client, WebSocketMessage{
-			Type:    "mqtt_error",
-			Payload: token.Error().Error(),
+	conn.ClientID = fmt.Sprintf("proxy_%s_%s", upstream.key[:8], uuid.New().String()[:8])
+	options := BrokerClientOptions{
+		CleanSession: true,
+		// 由 reconnectUpstream 重连，以便通知浏览器重连进度并恢复订阅
+		AutoReconnect:  false,
+		ConnectTimeout: 30 * time.Second,
+		KeepAlive:      60 * time.Second,
+		// 订阅时不注册回调，所有消息在本地匹配分发
+		OnMessage: func(message *BrokerMessage) {
+			s.routeMessage(upstream, message, false)
+		},
+		OnConnectionLost: func(err error) {
+			s.upstreamLost(upstream, err)
+		},
+	}
+	if s.options.PersistentSession {
+		// 连接参数不变时 ClientID 不变，进程重启后沿用同一个会话与消息存储
+		conn.ClientID = "proxy_" + upstream.key[:16]
+		options.CleanSession = false
+		options.SessionExpiry = proxySessionExpiry
+		options.StoreDir = filepath.Join(s.options.StoreDir, conn.ClientID)
+	}
+
+	client, err := newBrokerClient(conn, s.embeddedBroker, options)
+	if err != nil {
+		upstream.err = err
+		s.removeUpstream(upstream)
+		return
+	}
+	upstream.client = client
+	upstream.broker = client.Server()
+	upstream.version = conn.ProtocolVersion
+	if upstream.version == 0 {
+		upstream.version = MQTTProtocolV311
+	}
+	if s.options.PersistentSession {
+		if err := s.clearSession(conn); err != nil {
+			log.Printf("MQTT upstream %s clear session failed: %v", upstream.broker, err)
+		}
+	}
+
+	if err := client.Connect(); err != nil {
+		log.Printf("MQTT upstream %s connection failed: %v", upstream.broker, err)
+		upstream.err = err
+		s.removeUpstream(upstream)
+		return
+	}
+
+	log.Printf("MQTT upstream connected to %s (MQTT %s)", upstream.broker, mqttVersionName(upstream.version))
+}
+
+// upstreamLost 上游连接断开，开启重连时保留浏览器并在后台重连，否则通知浏览器断开及原因码
+func (s *MQTTProxyService) upstreamLost(upstream *proxyUpstream, err error) {
+	log.Printf("MQTT upstream %s connection lost: %v", upstream.broker, err)
+	if s.options.Reconnect {
+		upstream.mutex.Lock()
+		upstream.reconnecting = true
+		// clean session 下broker已丢弃订阅，重连后按各浏览器的订阅重新订阅
+		if !s.options.PersistentSession {
+			upstream.filters = make(map[string]byte)
+		}
+		upstream.mutex.Unlock()
+		go s.reconnectUpstream(upstream, err)
+		return
+	}
+
+	s.removeUpstream(upstream)
+	for _, member := range upstream.detachAll() {
+		member.mutex.Lock()
+		if member.upstream == upstream {
+			member.upstream = nil
+			member.IsConnected = false
+		}
+		member.mutex.Unlock()
+		s.sendWebSocketMessage(member, WebSocketMessage{
+			Type:       "mqtt_disconnected",
+			Payload:    err.Error(),
+			ReasonCode: reasonCodeOf(err),
 		})
-		return token.Error()
 	}
+}
+
+// mqttVersionName 协议版本的显示名称
+func mqttVersionName(version int) string {
+	switch version {
+	case MQTTProtocolV31:
+		return "3.1"
+	case MQTTProtocolV5:
+		return "5"
+	}
+	return "3.1.1"
+}
 
+// clearSession 以同一 ClientID 建立一次 clean session 连接，丢弃上次进程遗留的订阅与离线消息，
+// 此时还没有浏览器订阅，遗留的消息无法分发；本地存储中未确认的发布在持久会话建立后重发
+func (s *MQTTProxyService) clearSession(conn BrokerConnection) error {
+	client, err := newBrokerClient(conn, s.embeddedBroker, BrokerClientOptions{
+		CleanSession:   true,
+		ConnectTimeout: 30 * time.Second,
+		KeepAlive:      60 * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	client.Disconnect()
 	return nil
 }
 
-// handleDisconnect 处理断开连接
-func (s *MQTTProxyService) handleDisconnect(client *MQTTClient) error {
-	client.mutex.Lock()
-	defer client.mutex.Unlock()
+// reconnectUpstream 按指数退避重连上游连接，成功后恢复各浏览器的订阅，上游被释放后停止
+func (s *MQTTProxyService) reconnectUpstream(upstream *proxyUpstream, cause error) {
+	delay := s.options.ReconnectMinInterval
+	for attempt := 1; ; attempt++ {
+		upstream.mutex.Lock()
+		upstream.attempts = attempt
+		upstream.mutex.Unlock()
+
+		s.broadcast(upstream, WebSocketMessage{
+			Type:       "mqtt_reconnecting",
+			Payload:    cause.Error(),
+			Attempt:    attempt,
+			RetryIn:    delay.Milliseconds(),
+			ReasonCode: reasonCodeOf(cause),
+		})
+
+		select {
+		case <-time.After(delay):
+		case <-upstream.released:
+			return
+		}
+
+		err := upstream.client.Connect()
+		if err == nil {
+			break
+		}
+		cause = err
+		log.Printf("MQTT upstream %s reconnect attempt %d failed: %v", upstream.broker, attempt, cause)
+
+		delay *= 2
+		if delay > s.options.ReconnectMaxInterval {
+			delay = s.options.ReconnectMaxInterval
+		}
+	}
+
+	select {
+	case <-upstream.released:
+		upstream.client.Disconnect()
+		return
+	default:
+	}
+
+	log.Printf("MQTT upstream %s reconnected", upstream.broker)
+	s.resubscribe(upstream)
+}
+
+// resubscribe 重连后按各浏览器的订阅重新订阅，同一过滤器取最高QoS，并通知浏览器恢复结果
+func (s *MQTTProxyService) resubscribe(upstream *proxyUpstream) {
+	upstream.subscribeMutex.Lock()
+	defer upstream.subscribeMutex.Unlock()
+
+	upstream.mutex.Lock()
+	filters := make(map[string]byte)
+	for _, subs := range upstream.members {
+		for filter, qos := range subs {
+			if current, ok := filters[filter]; !ok || qos > current {
+				filters[filter] = qos
+			}
+		}
+	}
+	upstream.mutex.Unlock()
 
-	if client.Client != nil && client.Client.IsConnected() {
-		client.Client.Disconnect(250)
+	failed := make(map[string]error)
+	if len(filters) > 0 {
+		rejected, err := upstream.client.Subscribe(filters)
+		for filter := range filters {
+			if err != nil {
+				failed[filter] = err
+			} else if rejected[filter] != nil {
+				failed[filter] = rejected[filter]
+			}
+		}
+	}
+
+	upstream.mutex.Lock()
+	upstream.reconnecting = false
+	upstream.attempts = 0
+	for filter, qos := range filters {
+		if _, ok := failed[filter]; !ok {
+			upstream.filters[filter] = qos
+		}
+	}
+	results := make(map[*MQTTClient][]ProxySubscription, len(upstream.members))
+	for member, subs := range upstream.members {
+		subscriptions := make([]ProxySubscription, 0, len(subs))
+		for filter, qos := range subs {
+			subscription := ProxySubscription{
+				Topic: filter,
+				QoS:   int(qos),
+			}
+			if err := failed[filter]; err != nil {
+				subscription.Error = err.Error()
+				subscription.ReasonCode = reasonCodeOf(err)
+			}
+			subscriptions = append(subscriptions, subscription)
+		}
+		sort.Slice(subscriptions, func(i, j int) bool {
+			return subscriptions[i].Topic < subscriptions[j].Topic
+		})
+		results[member] = subscriptions
 	}
+	upstream.mutex.Unlock()
 
+	for member, subscriptions := range results {
+		s.sendWebSocketMessage(member, WebSocketMessage{
+			Type: "mqtt_connected",
+		})
+		s.sendWebSocketMessage(member, WebSocketMessage{
+			Type:          "mqtt_resubscribed",
+			Subscriptions: subscriptions,
+		})
+	}
+}
+
+// broadcast 向上游连接的所有浏览器发送消息
+func (s *MQTTProxyService) broadcast(upstream *proxyUpstream, message WebSocketMessage) {
+	upstream.mutex.Lock()
+	members := make([]*MQTTClient, 0, len(upstream.members))
+	for member := range upstream.members {
+		members = append(members, member)
+	}
+	upstream.mutex.Unlock()
+
+	for _, member := range members {
+		s.sendWebSocketMessage(member, message)
+	}
+}
+
+// removeUpstream 从共享表中移除上游连接
+func (s *MQTTProxyService) removeUpstream(upstream *proxyUpstream) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.upstreams[upstream.key] == upstream {
+		delete(s.upstreams, upstream.key)
+	}
+}
+
+// releaseIdleUpstream 断开空闲超时后仍没有浏览器使用的上游连接
+func (s *MQTTProxyService) releaseIdleUpstream(upstream *proxyUpstream) {
+	s.mutex.Lock()
+	upstream.mutex.Lock()
+	idle := len(upstream.members) == 0
+	if idle && s.upstreams[upstream.key] == upstream {
+		delete(s.upstreams, upstream.key)
+	}
+	upstream.mutex.Unlock()
+	s.mutex.Unlock()
+
+	if !idle {
+		return
+	}
+
+	log.Printf("MQTT upstream %s idle, disconnecting", upstream.broker)
+	upstream.releaseOnce.Do(func() {
+		close(upstream.released)
+	})
+	if !upstream.client.IsConnectionOpen() {
+		return
+	}
+
+	// 持久会话断开后broker仍会为其缓存消息，断开前取消全部订阅
+	if s.options.PersistentSession {
+		upstream.mutex.Lock()
+		filters := make([]string, 0, len(upstream.filters))
+		for filter := range upstream.filters {
+			filters = append(filters, filter)
+		}
+		upstream.mutex.Unlock()
+		if len(filters) > 0 {
+			if err := upstream.client.Unsubscribe(filters...); err != nil {
+				log.Printf("MQTT upstream %s unsubscribe failed: %v", upstream.broker, err)
+			}
+		}
+	}
+	upstream.client.Disconnect()
+}
+
+// handleDisconnect 离开上游连接并释放订阅，上游连接空闲一段时间后才断开
+func (s *MQTTProxyService) handleDisconnect(client *MQTTClient) error {
+	client.mutex.Lock()
+	upstream := client.upstream
+	client.upstream = nil
 	client.IsConnected = false
+	client.mutex.Unlock()
+
+	if upstream != nil && upstream.detach(client) {
+		upstream.mutex.Lock()
+		if upstream.idleTimer != nil {
+			upstream.idleTimer.Stop()
+		}
+		upstream.idleTimer = time.AfterFunc(proxyUpstreamIdleTimeout, func() {
+			s.releaseIdleUpstream(upstream)
+		})
+		upstream.mutex.Unlock()
+	}
 	return nil
 }
 
-// handleSubscribe 处理订阅
-func (s *MQTTProxyService) handleSubscribe(client *MQTTClient, topic string, qos int) error {
-	if !client.IsConnected || client.Client == nil {
-		return fmt.Errorf("MQTT client not connected")
+// connectedUpstream 返回客户端当前加入的上游连接
+func (c *MQTTClient) connectedUpstream() (*proxyUpstream, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if !c.IsConnected || c.upstream == nil {
+		return nil, fmt.Errorf("MQTT client not connected")
+	}
+	return c.upstream, nil
+}
+
+// handleSubscribe 处理订阅，指定 since 时在 subscription_success 之后补发缓存中序号更大的消息
+func (s *MQTTProxyService) handleSubscribe(client *MQTTClient, topic string, qos int, since *int64) error {
+	upstream, err := client.connectedUpstream()
+	if err != nil {
+		return err
+	}
+	if err := s.authorize(client, ProxyActionSubscribe, topic); err != nil {
+		log.Printf("MQTT subscribe denied for client %s, topic %s: %v", client.ID, topic, err)
+		s.sendACLError(client, "subscribe_result", err)
+		return err
 	}
 
-	if token := client.Client.Subscribe(topic, byte(qos), func(c mqtt.Client, msg mqtt.Message) {
-		// 处理接收到的消息
-		s.handleMQTTMessage(client, msg.Topic(), string(msg.Payload()), int(msg.Qos()), msg.Retained())
-	}); token.Wait() && token.Error() != nil {
-		log.Printf("MQTT subscribe failed for client %s, topic %s: %v", client.ID, topic, token.Error())
+	// 在上游锁内入队，保证补发的消息先于之后分发的实时消息
+	err = upstream.subscribe(client, topic, byte(qos), since, s.options.BufferSize, func(replay []proxyFrame) {
+		s.sendWebSocketMessage(client, WebSocketMessage{
+			Type:  "subscription_success",
+			Topic: topic,
+			QoS:   qos,
+		})
+		for _, frame := range replay {
+			s.enqueue(client, frame)
+		}
+	})
+	if err != nil {
+		log.Printf("MQTT subscribe failed for client %s, topic %s: %v", client.ID, topic, err)
 		s.sendWebSocketMessage(client, WebSocketMessage{
-			Type:    "subscribe_result",
-			Topic:   topic,
-			Payload: token.Error().Error(),
+			Type:       "subscribe_result",
+			Topic:      topic,
+			Payload:    err.Error(),
+			ReasonCode: reasonCodeOf(err),
 		})
-		return token.Error()
+		return err
 	}
 
 	log.Printf("MQTT client %s subscribed to topic: %s", client.ID, topic)
-	s.sendWebSocketMessage(client, WebSocketMessage{
-		Type:  "subscription_success",
-		Topic: topic,
-		QoS:   qos,
-	})
-
 	return nil
 }
 
 // handleUnsubscribe 处理取消订阅
 func (s *MQTTProxyService) handleUnsubscribe(client *MQTTClient, topic string) error {
-	if !client.IsConnected || client.Client == nil {
-		return fmt.Errorf("MQTT client not connected")
-	}
-
-	if token := client.Client.Unsubscribe(topic); token.Wait() && token.Error() != nil {
-		log.Printf("MQTT unsubscribe failed for client %s, topic %s: %v", client.ID, topic, token.Error())
-		return token.Error()
+	upstream, err := client.connectedUpstream()
+	if err != nil {
+		return err
 	}
 
+	upstream.unsubscribe(client, topic)
 	log.Printf("MQTT client %s unsubscribed from topic: %s", client.ID, topic)
 	return nil
 }
 
-// handlePublish 处理发布
-func (s *MQTTProxyService) handlePublish(client *MQTTClient, topic, payload string, qos int, retain bool) error {
-	if !client.IsConnected || client.Client == nil {
-		return fmt.Errorf("MQTT client not connected")
+// handlePublish 处理发布，上游为 MQTT 5 时附带消息属性
+func (s *MQTTProxyService) handlePublish(client *MQTTClient, message *WebSocketMessage) error {
+	upstream, err := client.connectedUpstream()
+	if err != nil {
+		return err
+	}
+	if err := s.authorize(client, ProxyActionPublish, message.Topic); err != nil {
+		log.Printf("MQTT publish denied for client %s, topic %s: %v", client.ID, message.Topic, err)
+		s.sendACLError(client, "publish_result", err)
+		return err
 	}
 
-	if token := client.Client.Publish(topic, byte(qos), retain, payload); token.Wait() && token.Error() != nil {
-		log.Printf("MQTT publish failed for client %s, topic %s: %v", client.ID, topic, token.Error())
+	err = upstream.client.Publish(&BrokerMessage{
+		Topic:           message.Topic,
+		Payload:         []byte(message.Payload),
+		QoS:             byte(message.QoS),
+		Retained:        message.Retain,
+		ContentType:     message.ContentType,
+		ResponseTopic:   message.ResponseTopic,
+		CorrelationData: []byte(message.CorrelationData),
+		UserProperties:  message.UserProperties,
+	})
+	if err != nil {
+		log.Printf("MQTT publish failed for client %s, topic %s: %v", client.ID, message.Topic, err)
 		s.sendWebSocketMessage(client, WebSocketMessage{
-			Type:    "publish_result",
-			Topic:   topic,
-			Payload: token.Error().Error(),
+			Type:       "publish_result",
+			Topic:      message.Topic,
+			Payload:    err.Error(),
+			ReasonCode: reasonCodeOf(err),
 		})
-		return token.Error()
+		return err
 	}
 
-	log.Printf("MQTT client %s published to topic: %s", client.ID, topic)
+	log.Printf("MQTT client %s published to topic: %s", client.ID, message.Topic)
 	s.sendWebSocketMessage(client, WebSocketMessage{
 		Type:    "publish_result",
-		Topic:   topic,
+		Topic:   message.Topic,
 		Payload: "success",
 	})
 
 	return nil
 }
 
-// handleMQTTMessage 处理MQTT消息
-func (s *MQTTProxyService) handleMQTTMessage(client *MQTTClient, topic, payload string, qos int, retain bool) {
-	log.Printf("MQTT message received for client %s: %s -> %s", client.ID, topic, payload)
+// routeMessage 为上游消息分配序号并写入匹配过滤器的缓冲，再分发给过滤器匹配的浏览器，
+// 多个过滤器匹配时每个浏览器只收到一份，可丢弃的高频消息不进入缓冲；replay 为抓包回放注入的消息
+func (s *MQTTProxyService) routeMessage(upstream *proxyUpstream, msg *BrokerMessage, replay bool) {
+	// 非零 result 附加 error_message 后再转发
+	payload := msg.Payload
+	if s.errorCodeService != nil {
+		if annotated, ok := s.errorCodeService.AnnotatePayload(msg.Topic, payload); ok {
+			payload = annotated
+		}
+	}
+	droppable := s.isDroppable(msg.Topic)
 
-	s.sendWebSocketMessage(client, WebSocketMessage{
-		Type:    "mqtt_message",
-		Topic:   topic,
-		Payload: payload,
-		QoS:     qos,
-		Retain:  retain,
+	// 分配序号、写入缓冲与确定接收者在同一临界区内完成，与订阅时的补发互斥
+	upstream.mutex.Lock()
+	upstream.seq++
+	data, err := json.Marshal(WebSocketMessage{
+		Type:            "mqtt_message",
+		Topic:           msg.Topic,
+		Payload:         string(payload),
+		QoS:             int(msg.QoS),
+		Retain:          msg.Retained,
+		Seq:             upstream.seq,
+		ContentType:     msg.ContentType,
+		ResponseTopic:   msg.ResponseTopic,
+		CorrelationData: string(msg.CorrelationData),
+		UserProperties:  msg.UserProperties,
+		Replay:          replay,
+	})
+	if err != nil {
+		upstream.mutex.Unlock()
+		log.Printf("Failed to marshal WebSocket message: %v", err)
+		return
+	}
+	if !droppable {
+		for filter, buffer := range upstream.buffers {
+			if mqttTopicMatches(filter, msg.Topic) {
+				buffer.add(upstream.seq, data)
+			}
+		}
+	}
+	recipients := upstream.recipients(msg.Topic)
+	upstream.mutex.Unlock()
+
+	frame := proxyFrame{data: data, droppable: droppable}
+	for _, client := range recipients {
+		s.enqueue(client, frame)
+	}
+}
+
+// matchUpstreams 返回已连接且共享键以 prefix 开头的上游连接，prefix 为空时返回全部
+func (s *MQTTProxyService) matchUpstreams(prefix string) []*proxyUpstream {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	upstreams := []*proxyUpstream{}
+	for key, upstream := range s.upstreams {
+		select {
+		case <-upstream.ready:
+		default:
+			continue
+		}
+		if upstream.err == nil && strings.HasPrefix(key, prefix) {
+			upstreams = append(upstreams, upstream)
+		}
+	}
+	return upstreams
+}
+
+// InjectMessage 将消息注入上游连接的分发流程，浏览器按订阅收到带 replay 标记的 mqtt_message，
+// upstream 为 GetUpstreams 返回的 key，为空时注入所有上游连接，返回注入的上游连接数
+func (s *MQTTProxyService) InjectMessage(upstream string, msg *BrokerMessage) int {
+	upstreams := s.matchUpstreams(upstream)
+	for _, target := range upstreams {
+		s.routeMessage(target, msg, true)
+	}
+	return len(upstreams)
+}
+
+// isDroppable 主题是否为队列满时可丢弃的高频消息，events 等其他消息从不丢弃
+func (s *MQTTProxyService) isDroppable(topic string) bool {
+	for _, filter := range s.options.DroppableTopics {
+		if mqttTopicMatches(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUpstreams 获取共享上游连接及各过滤器的引用数
+func (s *MQTTProxyService) GetUpstreams() (*models.APIResponse, error) {
+	s.mutex.RLock()
+	upstreams := make([]*proxyUpstream, 0, len(s.upstreams))
+	for _, upstream := range s.upstreams {
+		upstreams = append(upstreams, upstream)
+	}
+	s.mutex.RUnlock()
+
+	items := []map[string]interface{}{}
+	for _, upstream := range upstreams {
+		select {
+		case <-upstream.ready:
+		default:
+			// 仍在连接中
+			continue
+		}
+
+		upstream.mutex.Lock()
+		filters := []map[string]interface{}{}
+		for filter, qos := range upstream.filters {
+			refs := 0
+			for _, subs := range upstream.members {
+				if _, ok := subs[filter]; ok {
+					refs++
+				}
+			}
+			buffered := 0
+			if buffer, ok := upstream.buffers[filter]; ok {
+				buffered = buffer.len()
+			}
+			_, lingering := upstream.lingers[filter]
+			filters = append(filters, map[string]interface{}{
+				"filter":    filter,
+				"qos":       qos,
+				"refs":      refs,
+				"buffered":  buffered,
+				"lingering": lingering,
+			})
+		}
+		clients := len(upstream.members)
+		reconnecting, attempts, seq := upstream.reconnecting, upstream.attempts, upstream.seq
+		upstream.mutex.Unlock()
+
+		sort.Slice(filters, func(i, j int) bool {
+			return filters[i]["filter"].(string) < filters[j]["filter"].(string)
+		})
+		items = append(items, map[string]interface{}{
+			"key":                upstream.key[:8],
+			"broker":             upstream.broker,
+			"protocol_version":   upstream.version,
+			"connected":          upstream.client.IsConnectionOpen(),
+			"reconnecting":       reconnecting,
+			"reconnect_attempts": attempts,
+			"clients":            clients,
+			"seq":                seq,
+			"filters":            filters,
+		})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i]["key"].(string) < items[j]["key"].(string)
 	})
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    items,
+	}, nil
+}
+
+// attach 加入浏览器并取消空闲断开
+func (u *proxyUpstream) attach(client *MQTTClient) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if _, ok := u.members[client]; !ok {
+		u.members[client] = make(map[string]byte)
+	}
+	if u.idleTimer != nil {
+		u.idleTimer.Stop()
+		u.idleTimer = nil
+	}
+}
+
+// detach 移除浏览器及其订阅，返回上游连接是否已无浏览器使用
+func (u *proxyUpstream) detach(client *MQTTClient) bool {
+	u.subscribeMutex.Lock()
+	defer u.subscribeMutex.Unlock()
+
+	u.mutex.Lock()
+	subs, ok := u.members[client]
+	if !ok {
+		u.mutex.Unlock()
+		return false
+	}
+	filters := make([]string, 0, len(subs))
+	for filter := range subs {
+		filters = append(filters, filter)
+	}
+	u.mutex.Unlock()
+
+	for _, filter := range filters {
+		u.release(client, filter)
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	delete(u.members, client)
+	return len(u.members) == 0
+}
+
+// detachAll 移除全部浏览器，上游连接断开时使用
+func (u *proxyUpstream) detachAll() []*MQTTClient {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	members := make([]*MQTTClient, 0, len(u.members))
+	for member := range u.members {
+		members = append(members, member)
+	}
+	u.members = make(map[*MQTTClient]map[string]byte)
+	u.filters = make(map[string]byte)
+	u.buffers = make(map[string]*proxyRingBuffer)
+	for _, linger := range u.lingers {
+		linger.timer.Stop()
+	}
+	u.lingers = make(map[string]*proxyLinger)
+	return members
+}
+
+// subscribe 记录浏览器订阅，过滤器未订阅或请求的QoS更高时才向broker订阅，
+// 成功后在锁内以 since 之后的缓存消息调用 added
+func (u *proxyUpstream) subscribe(client *MQTTClient, filter string, qos byte, since *int64, bufferSize int, added func(replay []proxyFrame)) error {
+	u.subscribeMutex.Lock()
+	defer u.subscribeMutex.Unlock()
+
+	u.mutex.Lock()
+	current, subscribed := u.filters[filter]
+	reconnecting := u.reconnecting
+	u.mutex.Unlock()
+
+	// 重连期间只记录订阅，重连成功后统一恢复
+	if !reconnecting && (!subscribed || qos > current) {
+		failed, err := u.client.Subscribe(map[string]byte{filter: qos})
+		if err != nil {
+			return err
+		}
+		if err := failed[filter]; err != nil {
+			return err
+		}
+		current = qos
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	subs, ok := u.members[client]
+	if !ok {
+		return fmt.Errorf("MQTT client not connected")
+	}
+	subs[filter] = qos
+	if !reconnecting {
+		u.filters[filter] = current
+	}
+	if linger, ok := u.lingers[filter]; ok {
+		linger.timer.Stop()
+		delete(u.lingers, filter)
+	}
+	buffer, ok := u.buffers[filter]
+	if !ok && bufferSize > 0 {
+		buffer = newProxyRingBuffer(bufferSize)
+		u.buffers[filter] = buffer
+	}
+
+	replay := []proxyFrame{}
+	if since != nil && buffer != nil {
+		from := *since
+		// 序号大于当前值说明上游连接已重建，补发全部缓存
+		if from > u.seq {
+			from = 0
+		}
+		replay = buffer.since(from)
+	}
+	added(replay)
+	return nil
+}
+
+// unsubscribe 移除浏览器订阅
+func (u *proxyUpstream) unsubscribe(client *MQTTClient, filter string) {
+	u.subscribeMutex.Lock()
+	defer u.subscribeMutex.Unlock()
+
+	u.release(client, filter)
+}
+
+// release 移除浏览器订阅，没有其他浏览器引用时延迟 proxyFilterLinger 后再取消订阅，调用方需持有 subscribeMutex
+func (u *proxyUpstream) release(client *MQTTClient, filter string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if subs, ok := u.members[client]; ok {
+		delete(subs, filter)
+	}
+	for _, subs := range u.members {
+		if _, ok := subs[filter]; ok {
+			return
+		}
+	}
+	if _, ok := u.lingers[filter]; ok {
+		return
+	}
+	_, subscribed := u.filters[filter]
+	_, buffered := u.buffers[filter]
+	if !subscribed && !buffered {
+		return
+	}
+
+	linger := &proxyLinger{}
+	linger.timer = time.AfterFunc(proxyFilterLinger, func() {
+		u.expire(filter, linger)
+	})
+	u.lingers[filter] = linger
+}
+
+// expire 延迟到期后仍无浏览器引用时向broker取消订阅并丢弃缓冲
+func (u *proxyUpstream) expire(filter string, linger *proxyLinger) {
+	u.subscribeMutex.Lock()
+	defer u.subscribeMutex.Unlock()
+
+	u.mutex.Lock()
+	if u.lingers[filter] != linger {
+		u.mutex.Unlock()
+		return
+	}
+	delete(u.lingers, filter)
+	_, subscribed := u.filters[filter]
+	delete(u.filters, filter)
+	delete(u.buffers, filter)
+	reconnecting := u.reconnecting
+	u.mutex.Unlock()
+
+	select {
+	case <-u.released:
+		return
+	default:
+	}
+	if !subscribed || reconnecting {
+		return
+	}
+	if err := u.client.Unsubscribe(filter); err != nil {
+		log.Printf("MQTT upstream %s unsubscribe %s failed: %v", u.broker, filter, err)
+	}
+}
+
+// recipients 返回订阅过滤器匹配该主题的浏览器，调用方需持有 mutex
+func (u *proxyUpstream) recipients(topic string) []*MQTTClient {
+	recipients := []*MQTTClient{}
+	for member, subs := range u.members {
+		for filter := range subs {
+			if mqttTopicMatches(filter, topic) {
+				recipients = append(recipients, member)
+				break
+			}
+		}
+	}
+	return recipients
+}
+
+// mqttTopicMatches 判断主题是否匹配订阅过滤器，支持 + 与 # 通配符及 $share 共享订阅
+func mqttTopicMatches(filter, topic string) bool {
+	filter = mqttSharedFilter(filter)
+	if filter == "" {
+		return false
+	}
+
+	// 以通配符开头的过滤器不匹配 $ 开头的系统主题
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// mqttSharedFilter 去掉 $share/<group>/ 前缀，返回实际的主题过滤器，格式错误时返回空
+func mqttSharedFilter(filter string) string {
+	if !strings.HasPrefix(filter, "$share/") {
+		return filter
+	}
+	parts := strings.SplitN(filter, "/", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[2]
 }
 
 // sendWebSocketMessage 发送WebSocket消息
 func (s *MQTTProxyService) sendWebSocketMessage(client *MQTTClient, message WebSocketMessage) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Failed to marshal WebSocket message: %v", err)
+		return
+	}
+	s.enqueue(client, proxyFrame{data: data})
+}
+
+// enqueue 放入发送队列，不阻塞调用方；队列满时按丢弃策略处理，不可丢弃的消息积压过多时断开浏览器
+func (s *MQTTProxyService) enqueue(client *MQTTClient, frame proxyFrame) {
 	if client.WSConn == nil {
 		return
 	}
 
-	messageBytes, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Failed to marshal WebSocket message: %v", err)
+	client.outboxLock.Lock()
+	if client.closed {
+		client.outboxLock.Unlock()
+		return
+	}
+
+	dropped := false
+	slow := false
+	queued := len(client.outbox)
+	if len(client.outbox) >= s.options.QueueSize {
+		switch {
+		case s.options.DropPolicy == ProxyDropDisconnect:
+			slow = true
+		case !frame.droppable:
+			// 不可丢弃的消息允许超出队列长度，超过硬上限时断开
+			if s.options.DropPolicy == ProxyDropOldest {
+				dropped = client.dropOldest()
+			}
+			slow = !dropped && len(client.outbox) >= s.options.QueueSize*proxyOutboxHardLimit
+		case s.options.DropPolicy == ProxyDropOldest && client.dropOldest():
+			dropped = true
+		default:
+			// 没有可丢弃的旧消息或策略为 drop_newest 时丢弃新消息
+			atomic.AddInt64(&client.dropped, 1)
+			atomic.AddInt64(&s.framesDropped, 1)
+			client.outboxLock.Unlock()
+			return
+		}
+	}
+	if !slow {
+		client.outbox = append(client.outbox, frame)
+	}
+	client.outboxLock.Unlock()
+
+	if dropped {
+		atomic.AddInt64(&client.dropped, 1)
+		atomic.AddInt64(&s.framesDropped, 1)
+	}
+	if slow {
+		atomic.AddInt64(&s.slowDisconnects, 1)
+		log.Printf("WebSocket client %s too slow, %d frames queued, disconnecting", client.ID, queued)
+		// 已按慢速断开计数，发送协程随后的写入失败不再计为无响应
+		atomic.StoreInt32(&client.dead, 1)
+		client.close()
 		return
 	}
 
-	if err := client.WSConn.WriteMessage(websocket.TextMessage, messageBytes); err != nil {
-		log.Printf("Failed to send WebSocket message: %v", err)
+	select {
+	case client.notify <- struct{}{}:
+	default:
+	}
+}
+
+// dropOldest 移除队列中最早的可丢弃消息，调用方需持有 outboxLock
+func (c *MQTTClient) dropOldest() bool {
+	for i, queued := range c.outbox {
+		if queued.droppable {
+			c.outbox = append(c.outbox[:i], c.outbox[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// writeLoop 串行写入 WebSocket 并定时发送 ping，写入失败时关闭连接
+func (s *MQTTProxyService) writeLoop(client *MQTTClient) {
+	ticker := time.NewTicker(proxyPingInterval)
+	defer ticker.Stop()
+
+	conn := client.WSConn
+	for {
+		select {
+		case <-client.done:
+			return
+		case <-client.notify:
+			for {
+				client.outboxLock.Lock()
+				if len(client.outbox) == 0 {
+					client.outboxLock.Unlock()
+					break
+				}
+				frame := client.outbox[0]
+				client.outbox[0] = proxyFrame{}
+				client.outbox = client.outbox[1:]
+				client.outboxLock.Unlock()
+
+				conn.SetWriteDeadline(time.Now().Add(proxyWriteWait))
+				if err := conn.WriteMessage(websocket.TextMessage, frame.data); err != nil {
+					s.markDead(client, err.Error())
+					return
+				}
+				atomic.AddInt64(&client.sent, 1)
+				atomic.AddInt64(&s.framesSent, 1)
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(proxyWriteWait)); err != nil {
+				s.markDead(client, err.Error())
+				return
+			}
+		}
+	}
+}
+
+// GetStats 获取浏览器连接、发送队列与丢弃计数
+func (s *MQTTProxyService) GetStats() (*models.APIResponse, error) {
+	s.mutex.RLock()
+	clients := make([]*MQTTClient, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mutex.RUnlock()
+
+	items := []map[string]interface{}{}
+	queued := 0
+	for _, client := range clients {
+		client.outboxLock.Lock()
+		length := len(client.outbox)
+		client.outboxLock.Unlock()
+		queued += length
+
+		broker, username := "", ""
+		client.mutex.RLock()
+		if client.upstream != nil {
+			broker = client.upstream.broker
+		}
+		if client.principal != nil {
+			username = client.principal.Username()
+		}
+		client.mutex.RUnlock()
+
+		items = append(items, map[string]interface{}{
+			"id":           client.ID,
+			"broker":       broker,
+			"username":     username,
+			"queued":       length,
+			"sent":         atomic.LoadInt64(&client.sent),
+			"dropped":      atomic.LoadInt64(&client.dropped),
+			"connected_at": client.connectedAt,
+			"last_seen_at": atomic.LoadInt64(&client.lastSeenAt),
+		})
 	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i]["id"].(string) < items[j]["id"].(string)
+	})
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"queue_size":       s.options.QueueSize,
+			"drop_policy":      s.options.DropPolicy,
+			"droppable_topics": s.options.DroppableTopics,
+			"queued":           queued,
+			"frames_sent":      atomic.LoadInt64(&s.framesSent),
+			"frames_dropped":   atomic.LoadInt64(&s.framesDropped),
+			"slow_disconnects": atomic.LoadInt64(&s.slowDisconnects),
+			"dead_peers":       atomic.LoadInt64(&s.deadPeers),
+			"clients":          items,
+		},
+	}, nil
 }
@@ -9,16 +9,32 @@ import (
 	"drone-patrol-backend/internal/database"
 	"drone-patrol-backend/internal/models"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/google/uuid"
 )
 
 type MQTTService struct {
-	db *database.DB
+	db             *database.DB
+	embeddedBroker *EmbeddedBroker
 }
 
-func NewMQTTService(db *database.DB) *MQTTService {
-	return &MQTTService{db: db}
+// 上传证书文件的大小上限
+const MQTTCertificateMaxSize = 1 << 20
+
+// MQTTCertificateFields 证书上传表单字段与配置字段的对应关系
+var MQTTCertificateFields = map[string]string{
+	"ca_cert":     "caCert",
+	"client_cert": "clientCert",
+	"client_key":  "clientKey",
+}
+
+// MQTTSecretMask 接口返回的MQTT配置中代替密码与私钥的占位符
+const MQTTSecretMask = "******"
+
+// mqttSecretFields 不在接口中返回的配置字段，真实值只用于建立连接
+var mqttSecretFields = []string{"password", "clientKey"}
+
+func NewMQTTService(db *database.DB, embeddedBroker *EmbeddedBroker) *MQTTService {
+	return &MQTTService{db: db, embeddedBroker: embeddedBroker}
 }
 
 // 获取MQTT配置列表
@@ -55,6 +71,7 @@ func (s *MQTTService) GetProfiles() (*models.APIResponse, error) {
 				Message: fmt.Sprintf("解析MQTT配置失败: %v", err),
 			}, err
 		}
+		redactMQTTConfig(profile.Config)
 
 		profiles = append(profiles, profile)
 	}
@@ -68,6 +85,13 @@ func (s *MQTTService) GetProfiles() (*models.APIResponse, error) {
 
 // 创建MQTT配置
 func (s *MQTTService) CreateProfile(payload *models.MQTTProfilePayload) (*models.APIResponse, error) {
+	if err := brokerConnectionFromConfig(payload.Config).Validate(); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("MQTT配置错误: %v", err),
+		}, nil
+	}
+
 	profileID := uuid.New().String()
 	currentTime := time.Now().UnixMilli()
 
@@ -138,6 +162,7 @@ func (s *MQTTService) GetProfile(profileID string) (*models.APIResponse, error)
 			Message: fmt.Sprintf("解析MQTT配置失败: %v", err),
 		}, err
 	}
+	redactMQTTConfig(profile.Config)
 
 	return &models.APIResponse{
 		Code:    0,
@@ -146,11 +171,11 @@ func (s *MQTTService) GetProfile(profileID string) (*models.APIResponse, error)
 	}, nil
 }
 
-// 更新MQTT配置
+// 更新MQTT配置，密码与私钥为占位符时保留原值
 func (s *MQTTService) UpdateProfile(profileID string, payload *models.MQTTProfilePayload) (*models.APIResponse, error) {
 	// 检查配置是否存在
-	var existingID string
-	err := s.db.QueryRow("SELECT id FROM mqtt_profiles WHERE id = ?", profileID).Scan(&existingID)
+	var existingJSON string
+	err := s.db.QueryRow("SELECT config FROM mqtt_profiles WHERE id = ?", profileID).Scan(&existingJSON)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return &models.APIResponse{
@@ -164,6 +189,29 @@ func (s *MQTTService) UpdateProfile(profileID string, payload *models.MQTTProfil
 		}, err
 	}
 
+	existing := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(existingJSON), &existing); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("解析MQTT配置失败: %v", err),
+		}, err
+	}
+	if payload.Config == nil {
+		payload.Config = map[string]interface{}{}
+	}
+	for _, field := range mqttSecretFields {
+		if value, _ := payload.Config[field].(string); value == MQTTSecretMask {
+			payload.Config[field] = existing[field]
+		}
+	}
+
+	if err := brokerConnectionFromConfig(payload.Config).Validate(); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("MQTT配置错误: %v", err),
+		}, nil
+	}
+
 	// 如果设置为默认，先清除其他默认配置
 	if payload.IsDefault {
 		_, err = s.db.Exec("UPDATE mqtt_profiles SET is_default = 0")
@@ -291,49 +339,39 @@ func (s *MQTTService) SetDefaultProfile(profileID string) (*models.APIResponse,
 
 // 测试MQTT连接
 func (s *MQTTService) TestConnection(config map[string]interface{}) (*models.APIResponse, error) {
-	// 解析配置
-	broker, ok := config["broker"].(string)
-	if !ok {
+	conn := brokerConnectionFromConfig(config)
+	if err := s.restoreSecrets(&conn); err != nil {
 		return &models.APIResponse{
 			Code:    1,
-			Message: "缺少broker配置",
+			Message: err.Error(),
+			Data:    map[string]bool{"connected": false},
 		}, nil
 	}
 
-	port, ok := config["port"].(float64)
-	if !ok {
+	client, err := newBrokerClient(conn, s.embeddedBroker, BrokerClientOptions{
+		CleanSession:   true,
+		ConnectTimeout: 10 * time.Second,
+		KeepAlive:      30 * time.Second,
+	})
+	if err != nil {
 		return &models.APIResponse{
 			Code:    1,
-			Message: "缺少port配置",
+			Message: fmt.Sprintf("MQTT配置错误: %v", err),
+			Data:    map[string]bool{"connected": false},
 		}, nil
 	}
 
-	username, _ := config["username"].(string)
-	password, _ := config["password"].(string)
-	clientID, _ := config["clientId"].(string)
-
-	// 创建MQTT客户端选项
-	opts := mqtt.NewClientOptions()
-	opts.AddBroker(fmt.Sprintf("tcp://%s:%d", broker, int(port)))
-	opts.SetClientID(clientID)
-	opts.SetUsername(username)
-	opts.SetPassword(password)
-	opts.SetCleanSession(true)
-
-	// 创建客户端
-	client := mqtt.NewClient(opts)
-
-	// 尝试连接
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
+	// 尝试连接，broker拒绝时错误中带有原因码
+	if err := client.Connect(); err != nil {
 		return &models.APIResponse{
 			Code:    1,
-			Message: fmt.Sprintf("连接错误: %v", token.Error()),
+			Message: fmt.Sprintf("连接错误: %v", err),
 			Data:    map[string]bool{"connected": false},
 		}, nil
 	}
 
 	// 连接成功，断开连接
-	client.Disconnect(250)
+	client.Disconnect()
 
 	return &models.APIResponse{
 		Code:    0,
@@ -341,3 +379,156 @@ func (s *MQTTService) TestConnection(config map[string]interface{}) (*models.API
 		Data:    map[string]bool{"connected": true},
 	}, nil
 }
+
+// 上传MQTT配置使用的CA证书、客户端证书与私钥，certificates 以配置字段为键
+func (s *MQTTService) UploadCertificates(profileID string, certificates map[string]string) (*models.APIResponse, error) {
+	if len(certificates) == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "未上传证书文件",
+		}, nil
+	}
+
+	var configJSON string
+	err := s.db.QueryRow("SELECT config FROM mqtt_profiles WHERE id = ?", profileID).Scan(&configJSON)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "MQTT配置不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取MQTT配置失败: %v", err),
+		}, err
+	}
+
+	config := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("解析MQTT配置失败: %v", err),
+		}, err
+	}
+	for key, pem := range certificates {
+		config[key] = pem
+	}
+
+	// 无论当前传输方式是否使用TLS，都按TLS校验证书内容
+	conn := brokerConnectionFromConfig(config)
+	conn.Protocol = "ssl"
+	if _, err := conn.TLSConfig(); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("证书错误: %v", err),
+		}, nil
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("序列化配置失败: %v", err),
+		}, err
+	}
+	if _, err := s.db.Exec("UPDATE mqtt_profiles SET config = ?, updated_at = ? WHERE id = ?", string(data), time.Now().UnixMilli(), profileID); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("更新MQTT配置失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "证书上传成功",
+	}, nil
+}
+
+// redactMQTTConfig 以占位符代替接口返回的配置中的密码与私钥
+func redactMQTTConfig(config map[string]interface{}) {
+	for _, field := range mqttSecretFields {
+		if value, _ := config[field].(string); value != "" {
+			config[field] = MQTTSecretMask
+		}
+	}
+}
+
+// restoreSecrets 浏览器回传接口返回的配置时密码或私钥为占位符，
+// 从传输方式、地址、端口与账号相同的已保存配置中恢复真实值
+func (s *MQTTService) restoreSecrets(conn *BrokerConnection) error {
+	if conn.Password != MQTTSecretMask && conn.ClientKey != MQTTSecretMask {
+		return nil
+	}
+
+	rows, err := s.db.Query("SELECT config FROM mqtt_profiles ORDER BY is_default DESC, updated_at DESC")
+	if err != nil {
+		return fmt.Errorf("获取MQTT配置失败: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var configJSON string
+		if err := rows.Scan(&configJSON); err != nil {
+			return fmt.Errorf("获取MQTT配置失败: %v", err)
+		}
+		config := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
+			continue
+		}
+
+		stored := brokerConnectionFromConfig(config)
+		if stored.Protocol != conn.Protocol || stored.Host != conn.Host || stored.Port != conn.Port || stored.Username != conn.Username {
+			continue
+		}
+		if conn.Password == MQTTSecretMask {
+			conn.Password = stored.Password
+		}
+		if conn.ClientKey == MQTTSecretMask {
+			conn.ClientKey = stored.ClientKey
+		}
+		return nil
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("获取MQTT配置失败: %v", err)
+	}
+	return fmt.Errorf("未找到与连接参数匹配的已保存MQTT配置，请重新输入密码或私钥")
+}
+
+// 获取默认MQTT配置
+func (s *MQTTService) GetDefaultProfile() (*models.MQTTProfile, error) {
+	query := `SELECT id, name, config, is_default, updated_at FROM mqtt_profiles WHERE is_default = 1 ORDER BY updated_at DESC LIMIT 1`
+
+	var profile models.MQTTProfile
+	var configJSON string
+
+	err := s.db.QueryRow(query).Scan(&profile.ID, &profile.Name, &configJSON, &profile.IsDefault, &profile.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(configJSON), &profile.Config); err != nil {
+		return nil, fmt.Errorf("解析MQTT配置失败: %v", err)
+	}
+
+	return &profile, nil
+}
+
+// 按ID获取MQTT配置
+func (s *MQTTService) FindProfile(profileID string) (*models.MQTTProfile, error) {
+	query := `SELECT id, name, config, is_default, updated_at FROM mqtt_profiles WHERE id = ?`
+
+	var profile models.MQTTProfile
+	var configJSON string
+
+	err := s.db.QueryRow(query, profileID).Scan(&profile.ID, &profile.Name, &configJSON, &profile.IsDefault, &profile.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(configJSON), &profile.Config); err != nil {
+		return nil, fmt.Errorf("解析MQTT配置失败: %v", err)
+	}
+
+	return &profile, nil
+}
@@ -0,0 +1,869 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	PatrolScheduleCron     = "cron"
+	PatrolScheduleInterval = "interval"
+)
+
+const (
+	PatrolRunSkipped   = "skipped"
+	PatrolRunLaunched  = "launched"
+	PatrolRunSucceeded = "succeeded"
+	PatrolRunFailed    = "failed"
+	PatrolRunCanceled  = "canceled"
+)
+
+const (
+	PatrolTriggerSchedule = "schedule"
+	PatrolTriggerManual   = "manual"
+)
+
+const (
+	patrolCheckInterval = 15 * time.Second
+	// 超过该时长未执行的计划视为错过（例如服务停机期间）
+	patrolMissedGrace = 10 * time.Minute
+	// 超过该时长未更新的机场状态不作为前置条件依据
+	patrolSnapshotMaxAge = 2 * time.Minute
+	patrolWindowLayout   = "15:04"
+)
+
+// PatrolService 巡检计划管理与定时调度
+type PatrolService struct {
+	db             *database.DB
+	ingestService  *IngestService
+	waylineService *WaylineService
+
+	// 串行执行调度与手动触发，避免同一计划重复起飞
+	launchMutex sync.Mutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPatrolService 创建巡检计划服务
+func NewPatrolService(db *database.DB, ingestService *IngestService, waylineService *WaylineService) *PatrolService {
+	return &PatrolService{
+		db:             db,
+		ingestService:  ingestService,
+		waylineService: waylineService,
+		stopCh:         make(chan struct{}),
+	}
+}
+
+// Start 启动巡检调度
+func (s *PatrolService) Start() {
+	go s.run()
+}
+
+// Stop 停止巡检调度
+func (s *PatrolService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+func (s *PatrolService) run() {
+	ticker := time.NewTicker(patrolCheckInterval)
+	defer ticker.Stop()
+
+	s.tick()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.tick()
+		}
+	}
+}
+
+func (s *PatrolService) tick() {
+	s.syncRuns()
+	s.launchDuePlans()
+}
+
+// parseWindow 解析 HH:MM 格式的时间窗，返回当天的分钟数
+func parseWindow(value string) (int, error) {
+	t, err := time.Parse(patrolWindowLayout, value)
+	if err != nil {
+		return 0, fmt.Errorf("时间格式应为 HH:MM: %s", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// inWindow 判断时间是否位于计划时间窗内，时间窗可跨越午夜
+func inWindow(plan *models.PatrolPlan, t time.Time) bool {
+	if plan.WindowStart == "" || plan.WindowEnd == "" {
+		return true
+	}
+	start, err1 := parseWindow(plan.WindowStart)
+	end, err2 := parseWindow(plan.WindowEnd)
+	if err1 != nil || err2 != nil || start == end {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// nextWindowStart 返回 t 之后最近的时间窗开始时间
+func nextWindowStart(plan *models.PatrolPlan, t time.Time) time.Time {
+	start, _ := parseWindow(plan.WindowStart)
+	candidate := time.Date(t.Year(), t.Month(), t.Day(), start/60, start%60, 0, 0, t.Location())
+	if !candidate.After(t) {
+		candidate = candidate.AddDate(0, 0, 1)
+	}
+	return candidate
+}
+
+// nextRunAfter 计算 after 之后的下一次执行时间，跳过时间窗之外的时刻
+func nextRunAfter(plan *models.PatrolPlan, after time.Time) (time.Time, error) {
+	var schedule *cronSchedule
+	if plan.ScheduleType == PatrolScheduleCron {
+		var err error
+		if schedule, err = parseCron(plan.CronExpr); err != nil {
+			return time.Time{}, err
+		}
+	} else if plan.IntervalMinutes <= 0 {
+		return time.Time{}, fmt.Errorf("执行间隔必须大于0")
+	}
+
+	for i := 0; i < 1000; i++ {
+		var candidate time.Time
+		if schedule != nil {
+			candidate = schedule.Next(after)
+		} else {
+			candidate = after.Add(time.Duration(plan.IntervalMinutes) * time.Minute)
+		}
+		if candidate.IsZero() {
+			return candidate, fmt.Errorf("cron表达式没有可执行的时间")
+		}
+		if inWindow(plan, candidate) {
+			return candidate, nil
+		}
+
+		windowStart := nextWindowStart(plan, candidate)
+		if schedule == nil {
+			// 间隔计划在时间窗开始时恢复执行
+			return windowStart, nil
+		}
+		after = windowStart.Add(-time.Minute)
+	}
+	return time.Time{}, fmt.Errorf("时间窗内没有可执行的时间")
+}
+
+// validatePlan 校验计划参数并确认航线与机场存在
+func (s *PatrolService) validatePlan(payload *models.PatrolPlanPayload) (string, error) {
+	switch payload.ScheduleType {
+	case PatrolScheduleCron:
+		if _, err := parseCron(payload.CronExpr); err != nil {
+			return err.Error(), nil
+		}
+	case PatrolScheduleInterval:
+		if payload.IntervalMinutes <= 0 {
+			return "执行间隔必须大于0", nil
+		}
+	}
+
+	if (payload.WindowStart == "") != (payload.WindowEnd == "") {
+		return "时间窗开始与结束需同时设置", nil
+	}
+	if payload.WindowStart != "" {
+		if _, err := parseWindow(payload.WindowStart); err != nil {
+			return err.Error(), nil
+		}
+		if _, err := parseWindow(payload.WindowEnd); err != nil {
+			return err.Error(), nil
+		}
+	}
+	if payload.MinTemperature != nil && payload.MaxTemperature != nil && *payload.MinTemperature > *payload.MaxTemperature {
+		return "最低温度不能高于最高温度", nil
+	}
+
+	var exists int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM waylines WHERE id = ?", payload.WaylineID).Scan(&exists); err != nil {
+		return "", err
+	}
+	if exists == 0 {
+		return "航线不存在", nil
+	}
+
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM devices WHERE sn = ?", payload.DockSN).Scan(&exists); err != nil {
+		return "", err
+	}
+	if exists == 0 {
+		return "机场未登记", nil
+	}
+	return "", nil
+}
+
+// planFromPayload 根据请求构造计划并计算下一次执行时间
+func planFromPayload(plan *models.PatrolPlan, payload *models.PatrolPlanPayload) error {
+	plan.Name = payload.Name
+	plan.WaylineID = payload.WaylineID
+	plan.DockSN = payload.DockSN
+	plan.ScheduleType = payload.ScheduleType
+	plan.CronExpr = ""
+	plan.IntervalMinutes = 0
+	if payload.ScheduleType == PatrolScheduleCron {
+		plan.CronExpr = strings.TrimSpace(payload.CronExpr)
+	} else {
+		plan.IntervalMinutes = payload.IntervalMinutes
+	}
+	plan.WindowStart = payload.WindowStart
+	plan.WindowEnd = payload.WindowEnd
+	plan.MaxWindSpeed = payload.MaxWindSpeed
+	plan.MinBattery = payload.MinBattery
+	plan.AllowRain = payload.AllowRain
+	plan.MinTemperature = payload.MinTemperature
+	plan.MaxTemperature = payload.MaxTemperature
+	plan.RthAltitude = payload.RthAltitude
+	if plan.RthAltitude == 0 {
+		plan.RthAltitude = waylineDefaultRthAltitude
+	}
+	if payload.Enabled != nil {
+		plan.Enabled = *payload.Enabled
+	}
+	if payload.Operator != "" {
+		plan.Operator = payload.Operator
+	}
+
+	plan.NextRunAt = nil
+	if plan.Enabled {
+		next, err := nextRunAfter(plan, time.Now())
+		if err != nil {
+			return err
+		}
+		nextRunAt := next.UnixMilli()
+		plan.NextRunAt = &nextRunAt
+	}
+	return nil
+}
+
+// 创建巡检计划
+func (s *PatrolService) CreatePlan(payload *models.PatrolPlanPayload) (*models.APIResponse, error) {
+	message, err := s.validatePlan(payload)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("校验巡检计划失败: %v", err),
+		}, err
+	}
+	if message != "" {
+		return &models.APIResponse{
+			Code:    1,
+			Message: message,
+		}, nil
+	}
+
+	now := time.Now().UnixMilli()
+	plan := models.PatrolPlan{
+		ID:        uuid.New().String(),
+		Enabled:   true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := planFromPayload(&plan, payload); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: err.Error(),
+		}, nil
+	}
+
+	_, err = s.db.Exec(`INSERT INTO patrol_plans (id, name, wayline_id, dock_sn, schedule_type, cron_expr, interval_minutes,
+		window_start, window_end, max_wind_speed, min_battery, allow_rain, min_temperature, max_temperature, rth_altitude,
+		enabled, next_run_at, operator, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		plan.ID, plan.Name, plan.WaylineID, plan.DockSN, plan.ScheduleType, plan.CronExpr, plan.IntervalMinutes,
+		plan.WindowStart, plan.WindowEnd, plan.MaxWindSpeed, plan.MinBattery, plan.AllowRain, plan.MinTemperature,
+		plan.MaxTemperature, plan.RthAltitude, plan.Enabled, plan.NextRunAt, plan.Operator, plan.CreatedAt, plan.UpdatedAt)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建巡检计划失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "巡检计划创建成功",
+		Data:    plan,
+	}, nil
+}
+
+// 更新巡检计划
+func (s *PatrolService) UpdatePlan(planID string, payload *models.PatrolPlanPayload) (*models.APIResponse, error) {
+	plan, err := s.getPlan(planID)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "巡检计划不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取巡检计划失败: %v", err),
+		}, err
+	}
+
+	message, err := s.validatePlan(payload)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("校验巡检计划失败: %v", err),
+		}, err
+	}
+	if message != "" {
+		return &models.APIResponse{
+			Code:    1,
+			Message: message,
+		}, nil
+	}
+
+	if err := planFromPayload(plan, payload); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: err.Error(),
+		}, nil
+	}
+	plan.UpdatedAt = time.Now().UnixMilli()
+
+	_, err = s.db.Exec(`UPDATE patrol_plans SET name = ?, wayline_id = ?, dock_sn = ?, schedule_type = ?, cron_expr = ?,
+		interval_minutes = ?, window_start = ?, window_end = ?, max_wind_speed = ?, min_battery = ?, allow_rain = ?,
+		min_temperature = ?, max_temperature = ?, rth_altitude = ?, enabled = ?, next_run_at = ?, operator = ?, updated_at = ?
+		WHERE id = ?`,
+		plan.Name, plan.WaylineID, plan.DockSN, plan.ScheduleType, plan.CronExpr, plan.IntervalMinutes, plan.WindowStart,
+		plan.WindowEnd, plan.MaxWindSpeed, plan.MinBattery, plan.AllowRain, plan.MinTemperature, plan.MaxTemperature,
+		plan.RthAltitude, plan.Enabled, plan.NextRunAt, plan.Operator, plan.UpdatedAt, plan.ID)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("更新巡检计划失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "巡检计划更新成功",
+		Data:    plan,
+	}, nil
+}
+
+// 删除巡检计划，保留历史执行记录
+func (s *PatrolService) DeletePlan(planID string) (*models.APIResponse, error) {
+	result, err := s.db.Exec("DELETE FROM patrol_plans WHERE id = ?", planID)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("删除巡检计划失败: %v", err),
+		}, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "巡检计划不存在",
+		}, nil
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "巡检计划删除成功",
+	}, nil
+}
+
+const patrolPlanColumns = `id, name, wayline_id, dock_sn, schedule_type, cron_expr, interval_minutes, window_start,
+	window_end, max_wind_speed, min_battery, allow_rain, min_temperature, max_temperature, rth_altitude, enabled,
+	next_run_at, last_run_at, operator, created_at, updated_at`
+
+// scanPatrolPlan 扫描巡检计划记录
+func scanPatrolPlan(scanner interface{ Scan(...interface{}) error }) (models.PatrolPlan, error) {
+	var plan models.PatrolPlan
+	var maxWindSpeed, minTemperature, maxTemperature sql.NullFloat64
+	var minBattery, nextRunAt, lastRunAt sql.NullInt64
+
+	err := scanner.Scan(&plan.ID, &plan.Name, &plan.WaylineID, &plan.DockSN, &plan.ScheduleType, &plan.CronExpr,
+		&plan.IntervalMinutes, &plan.WindowStart, &plan.WindowEnd, &maxWindSpeed, &minBattery, &plan.AllowRain,
+		&minTemperature, &maxTemperature, &plan.RthAltitude, &plan.Enabled, &nextRunAt, &lastRunAt, &plan.Operator,
+		&plan.CreatedAt, &plan.UpdatedAt)
+	if err != nil {
+		return plan, err
+	}
+
+	if maxWindSpeed.Valid {
+		plan.MaxWindSpeed = &maxWindSpeed.Float64
+	}
+	if minBattery.Valid {
+		value := int(minBattery.Int64)
+		plan.MinBattery = &value
+	}
+	if minTemperature.Valid {
+		plan.MinTemperature = &minTemperature.Float64
+	}
+	if maxTemperature.Valid {
+		plan.MaxTemperature = &maxTemperature.Float64
+	}
+	if nextRunAt.Valid {
+		plan.NextRunAt = &nextRunAt.Int64
+	}
+	if lastRunAt.Valid {
+		plan.LastRunAt = &lastRunAt.Int64
+	}
+	return plan, nil
+}
+
+func (s *PatrolService) getPlan(planID string) (*models.PatrolPlan, error) {
+	plan, err := scanPatrolPlan(s.db.QueryRow("SELECT "+patrolPlanColumns+" FROM patrol_plans WHERE id = ?", planID))
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// 获取巡检计划列表
+func (s *PatrolService) GetPlans(query *models.PatrolPlanQuery) (*models.APIResponse, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if query.SN != "" {
+		conditions = append(conditions, "dock_sn = ?")
+		args = append(args, query.SN)
+	}
+	if query.Enabled != nil {
+		conditions = append(conditions, "enabled = ?")
+		args = append(args, *query.Enabled)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM patrol_plans "+where, args...).Scan(&total); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取巡检计划失败: %v", err),
+		}, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	rows, err := s.db.Query("SELECT "+patrolPlanColumns+" FROM patrol_plans "+where+
+		" ORDER BY created_at DESC LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取巡检计划失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	plans := []models.PatrolPlan{}
+	for rows.Next() {
+		plan, err := scanPatrolPlan(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描巡检计划失败: %v", err),
+			}, err
+		}
+		plans = append(plans, plan)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+			"items":    plans,
+		},
+	}, nil
+}
+
+// 获取巡检计划详情
+func (s *PatrolService) GetPlan(planID string) (*models.APIResponse, error) {
+	plan, err := s.getPlan(planID)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "巡检计划不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取巡检计划失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    plan,
+	}, nil
+}
+
+// 立即执行一次巡检计划，忽略时间窗但仍检查前置条件
+func (s *PatrolService) TriggerPlan(planID string) (*models.APIResponse, error) {
+	plan, err := s.getPlan(planID)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "巡检计划不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取巡检计划失败: %v", err),
+		}, err
+	}
+
+	run, err := s.launch(plan, PatrolTriggerManual, time.Now())
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("执行巡检计划失败: %v", err),
+		}, err
+	}
+
+	response := &models.APIResponse{
+		Code:    0,
+		Message: "巡检任务已下发",
+		Data:    run,
+	}
+	if run.Status != PatrolRunLaunched {
+		response.Code = 1
+		response.Message = run.Reason
+	}
+	return response, nil
+}
+
+// checkPreconditions 根据机场最新状态检查前置条件，不满足时返回原因
+func (s *PatrolService) checkPreconditions(plan *models.PatrolPlan) string {
+	snapshot, ok := s.ingestService.GetSnapshot(plan.DockSN)
+	freshAfter := time.Now().Add(-patrolSnapshotMaxAge).UnixMilli()
+	if !ok || snapshot.Dock == nil || snapshot.OSDUpdatedAt < freshAfter {
+		return "机场状态未知或长时间未更新"
+	}
+	dock := snapshot.Dock
+
+	if dock.DroneInDock != 1 {
+		return "飞行器不在机场内"
+	}
+	if plan.MaxWindSpeed != nil && dock.WindSpeed > *plan.MaxWindSpeed {
+		return fmt.Sprintf("风速 %.1f m/s 超过上限 %.1f m/s", dock.WindSpeed, *plan.MaxWindSpeed)
+	}
+	if !plan.AllowRain && dock.Rainfall > 0 {
+		return "机场检测到降雨"
+	}
+	if plan.MinBattery != nil && dock.DroneChargeState.CapacityPercent < *plan.MinBattery {
+		return fmt.Sprintf("飞行器电量 %d%% 低于 %d%%", dock.DroneChargeState.CapacityPercent, *plan.MinBattery)
+	}
+	if plan.MinTemperature != nil && dock.EnvironmentTemperature < *plan.MinTemperature {
+		return fmt.Sprintf("环境温度 %.1f℃ 低于 %.1f℃", dock.EnvironmentTemperature, *plan.MinTemperature)
+	}
+	if plan.MaxTemperature != nil && dock.EnvironmentTemperature > *plan.MaxTemperature {
+		return fmt.Sprintf("环境温度 %.1f℃ 高于 %.1f℃", dock.EnvironmentTemperature, *plan.MaxTemperature)
+	}
+	return ""
+}
+
+// launch 检查前置条件后创建航线任务，并记录本次执行
+func (s *PatrolService) launch(plan *models.PatrolPlan, trigger string, scheduledAt time.Time) (*models.PatrolRun, error) {
+	s.launchMutex.Lock()
+	defer s.launchMutex.Unlock()
+
+	now := time.Now().UnixMilli()
+	run := &models.PatrolRun{
+		ID:          uuid.New().String(),
+		PlanID:      plan.ID,
+		DockSN:      plan.DockSN,
+		WaylineID:   plan.WaylineID,
+		Trigger:     trigger,
+		ScheduledAt: scheduledAt.UnixMilli(),
+		Status:      PatrolRunSkipped,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	var running int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM patrol_runs WHERE plan_id = ? AND status = ?",
+		plan.ID, PatrolRunLaunched).Scan(&running)
+	if err != nil {
+		return nil, err
+	}
+
+	if running > 0 {
+		run.Reason = "上一次巡检尚未结束"
+	} else if reason := s.checkPreconditions(plan); reason != "" {
+		run.Reason = reason
+	} else {
+		response, err := s.waylineService.CreateTask(&models.FlightTaskPayload{
+			WaylineID:   plan.WaylineID,
+			DockSN:      plan.DockSN,
+			TaskType:    0,
+			RthAltitude: plan.RthAltitude,
+			Operator:    "patrol:" + plan.Name,
+		})
+		switch {
+		case err != nil:
+			run.Status = PatrolRunFailed
+			run.Reason = response.Message
+		case response.Code != 0:
+			run.Status = PatrolRunFailed
+			run.Reason = response.Message
+		default:
+			run.Status = PatrolRunLaunched
+			if task, ok := response.Data.(models.FlightTask); ok {
+				run.FlightTaskID = task.ID
+			}
+		}
+	}
+
+	if run.Status != PatrolRunLaunched {
+		run.FinishedAt = &now
+	}
+
+	_, err = s.db.Exec(`INSERT INTO patrol_runs (id, plan_id, dock_sn, wayline_id, trigger_type, scheduled_at, status,
+		flight_task_id, reason, created_at, updated_at, finished_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.ID, run.PlanID, run.DockSN, run.WaylineID, run.Trigger, run.ScheduledAt, run.Status, run.FlightTaskID,
+		run.Reason, run.CreatedAt, run.UpdatedAt, run.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if run.Status == PatrolRunSkipped {
+		log.Printf("巡检计划 %s 跳过: %s", plan.Name, run.Reason)
+	}
+	return run, nil
+}
+
+// launchDuePlans 执行到期的巡检计划并计算下一次执行时间
+func (s *PatrolService) launchDuePlans() {
+	now := time.Now()
+
+	rows, err := s.db.Query("SELECT "+patrolPlanColumns+" FROM patrol_plans WHERE enabled = 1 AND next_run_at <= ?",
+		now.UnixMilli())
+	if err != nil {
+		log.Printf("查询巡检计划失败: %v", err)
+		return
+	}
+	plans := []models.PatrolPlan{}
+	for rows.Next() {
+		plan, err := scanPatrolPlan(rows)
+		if err != nil {
+			log.Printf("扫描巡检计划失败: %v", err)
+			break
+		}
+		plans = append(plans, plan)
+	}
+	rows.Close()
+
+	for i := range plans {
+		plan := &plans[i]
+		scheduledAt := time.UnixMilli(*plan.NextRunAt)
+
+		if now.Sub(scheduledAt) > patrolMissedGrace {
+			s.recordMissed(plan, scheduledAt)
+		} else if _, err := s.launch(plan, PatrolTriggerSchedule, scheduledAt); err != nil {
+			log.Printf("执行巡检计划失败 %s: %v", plan.ID, err)
+		}
+
+		// 间隔计划保持原有节拍，cron 计划从当前时间继续
+		after := now
+		if plan.ScheduleType == PatrolScheduleInterval {
+			interval := time.Duration(plan.IntervalMinutes) * time.Minute
+			after = scheduledAt.Add(now.Sub(scheduledAt) / interval * interval)
+		}
+
+		var nextRunAt interface{}
+		if next, err := nextRunAfter(plan, after); err != nil {
+			log.Printf("计算巡检计划执行时间失败 %s: %v", plan.ID, err)
+		} else {
+			nextRunAt = next.UnixMilli()
+		}
+
+		_, err := s.db.Exec("UPDATE patrol_plans SET next_run_at = ?, last_run_at = ? WHERE id = ?",
+			nextRunAt, now.UnixMilli(), plan.ID)
+		if err != nil {
+			log.Printf("更新巡检计划失败 %s: %v", plan.ID, err)
+		}
+	}
+}
+
+// recordMissed 记录因服务未运行而错过的执行
+func (s *PatrolService) recordMissed(plan *models.PatrolPlan, scheduledAt time.Time) {
+	now := time.Now().UnixMilli()
+	_, err := s.db.Exec(`INSERT INTO patrol_runs (id, plan_id, dock_sn, wayline_id, trigger_type, scheduled_at, status,
+		reason, created_at, updated_at, finished_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		uuid.New().String(), plan.ID, plan.DockSN, plan.WaylineID, PatrolTriggerSchedule, scheduledAt.UnixMilli(),
+		PatrolRunSkipped, "错过计划执行时间", now, now, now)
+	if err != nil {
+		log.Printf("记录巡检执行失败 %s: %v", plan.ID, err)
+	}
+}
+
+// syncRuns 根据航线任务状态更新执行中的巡检记录
+func (s *PatrolService) syncRuns() {
+	rows, err := s.db.Query(`SELECT r.id, t.status, t.error_message, t.finished_at FROM patrol_runs r
+		LEFT JOIN flight_tasks t ON t.id = r.flight_task_id WHERE r.status = ?`, PatrolRunLaunched)
+	if err != nil {
+		log.Printf("查询巡检执行记录失败: %v", err)
+		return
+	}
+
+	type runUpdate struct {
+		id, status, reason string
+		finishedAt         sql.NullInt64
+	}
+	updates := []runUpdate{}
+	for rows.Next() {
+		var id string
+		var taskStatus, errorMessage sql.NullString
+		var finishedAt sql.NullInt64
+		if err := rows.Scan(&id, &taskStatus, &errorMessage, &finishedAt); err != nil {
+			log.Printf("扫描巡检执行记录失败: %v", err)
+			break
+		}
+
+		update := runUpdate{id: id, reason: errorMessage.String, finishedAt: finishedAt}
+		switch taskStatus.String {
+		case FlightTaskStatusSucceeded:
+			update.status = PatrolRunSucceeded
+		case FlightTaskStatusFailed, FlightTaskStatusPartiallyDone:
+			// 中断的任务未完成全部航点，巡检按失败记录
+			update.status = PatrolRunFailed
+		case FlightTaskStatusCanceled:
+			update.status = PatrolRunCanceled
+		case "":
+			update.status = PatrolRunFailed
+			update.reason = "航线任务不存在"
+		default:
+			continue
+		}
+		updates = append(updates, update)
+	}
+	rows.Close()
+
+	now := time.Now().UnixMilli()
+	for _, update := range updates {
+		finishedAt := now
+		if update.finishedAt.Valid {
+			finishedAt = update.finishedAt.Int64
+		}
+		_, err := s.db.Exec("UPDATE patrol_runs SET status = ?, reason = ?, updated_at = ?, finished_at = ? WHERE id = ?",
+			update.status, update.reason, now, finishedAt, update.id)
+		if err != nil {
+			log.Printf("更新巡检执行记录失败 %s: %v", update.id, err)
+		}
+	}
+}
+
+const patrolRunColumns = `id, plan_id, dock_sn, wayline_id, trigger_type, scheduled_at, status, flight_task_id, reason,
+	created_at, updated_at, finished_at`
+
+// scanPatrolRun 扫描巡检执行记录
+func scanPatrolRun(scanner interface{ Scan(...interface{}) error }) (models.PatrolRun, error) {
+	var run models.PatrolRun
+	var finishedAt sql.NullInt64
+
+	err := scanner.Scan(&run.ID, &run.PlanID, &run.DockSN, &run.WaylineID, &run.Trigger, &run.ScheduledAt, &run.Status,
+		&run.FlightTaskID, &run.Reason, &run.CreatedAt, &run.UpdatedAt, &finishedAt)
+	if err != nil {
+		return run, err
+	}
+
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Int64
+	}
+	return run, nil
+}
+
+// 获取巡检执行记录
+func (s *PatrolService) GetRuns(query *models.PatrolRunQuery) (*models.APIResponse, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if query.PlanID != "" {
+		conditions = append(conditions, "plan_id = ?")
+		args = append(args, query.PlanID)
+	}
+	if query.SN != "" {
+		conditions = append(conditions, "dock_sn = ?")
+		args = append(args, query.SN)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, query.Status)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM patrol_runs "+where, args...).Scan(&total); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取巡检执行记录失败: %v", err),
+		}, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	rows, err := s.db.Query("SELECT "+patrolRunColumns+" FROM patrol_runs "+where+
+		" ORDER BY scheduled_at DESC LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取巡检执行记录失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	runs := []models.PatrolRun{}
+	for rows.Next() {
+		run, err := scanPatrolRun(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描巡检执行记录失败: %v", err),
+			}, err
+		}
+		runs = append(runs, run)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+			"items":    runs,
+		},
+	}, nil
+}
@@ -0,0 +1,610 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// 设备属性设置主题
+const (
+	TopicPropertySet      = "thing/product/{sn}/property/set"
+	TopicPropertySetReply = "thing/product/{sn}/property/set_reply"
+)
+
+// 属性设置状态
+const (
+	// 设备回复非零 result
+	PropertyStatusRejected = "rejected"
+	// 未收到回复，等待上报确认
+	PropertyStatusUnconfirmed = "unconfirmed"
+	// 设备已接受，等待上报确认
+	PropertyStatusAccepted = "accepted"
+	// 上报值与期望值一致
+	PropertyStatusApplied = "applied"
+	// 上报值与期望值不一致
+	PropertyStatusDrift = "drift"
+	// 已被同一属性的后续设置替代
+	PropertyStatusSuperseded = "superseded"
+)
+
+const (
+	propertySetTimeout = 10 * time.Second
+	// 设置后该时长内上报的旧值视为设备尚未生效，不判定为偏离
+	propertySettleTime = 5 * time.Second
+)
+
+type propertyValidator func(value interface{}) error
+
+// settableProperties 允许设置的属性及取值校验
+var settableProperties = map[string]propertyValidator{
+	"night_lights_state": enumValidator(0, 1),
+	"height_limit":       rangeValidator(20, 1500),
+	"distance_limit_status": objectValidator(map[string]propertyValidator{
+		"state":          enumValidator(0, 1),
+		"distance_limit": rangeValidator(15, 8000),
+	}),
+	"obstacle_avoidance": objectValidator(map[string]propertyValidator{
+		"horizon":  enumValidator(0, 1),
+		"upside":   enumValidator(0, 1),
+		"downside": enumValidator(0, 1),
+	}),
+	"silent_mode":                 enumValidator(0, 1),
+	"user_experience_improvement": enumValidator(0, 1, 2),
+}
+
+// integerValue 取整数值，JSON数字解析为 float64
+func integerValue(value interface{}) (int, error) {
+	number, ok := value.(float64)
+	if !ok || number != math.Trunc(number) {
+		return 0, fmt.Errorf("需要整数")
+	}
+	return int(number), nil
+}
+
+func enumValidator(values ...int) propertyValidator {
+	return func(value interface{}) error {
+		n, err := integerValue(value)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			if n == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("可选值为 %v", values)
+	}
+}
+
+func rangeValidator(min, max int) propertyValidator {
+	return func(value interface{}) error {
+		n, err := integerValue(value)
+		if err != nil {
+			return err
+		}
+		if n < min || n > max {
+			return fmt.Errorf("取值范围为 %d-%d", min, max)
+		}
+		return nil
+	}
+}
+
+func objectValidator(fields map[string]propertyValidator) propertyValidator {
+	return func(value interface{}) error {
+		object, ok := value.(map[string]interface{})
+		if !ok || len(object) == 0 {
+			return fmt.Errorf("需要非空对象")
+		}
+		for key, v := range object {
+			validate, ok := fields[key]
+			if !ok {
+				return fmt.Errorf("不支持的字段 %s", key)
+			}
+			if err := validate(v); err != nil {
+				return fmt.Errorf("%s %v", key, err)
+			}
+		}
+		return nil
+	}
+}
+
+// propertyMatches 判断上报值是否满足期望值，对象只比较期望中出现的字段
+func propertyMatches(desired, reported interface{}) bool {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		r, ok := reported.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range d {
+			if !propertyMatches(value, r[key]) {
+				return false
+			}
+		}
+		return true
+	case float64:
+		r, ok := reported.(float64)
+		return ok && math.Abs(d-r) < 1e-6
+	default:
+		return reflect.DeepEqual(desired, reported)
+	}
+}
+
+// trackedProperty 等待或持续核对上报值的期望设置
+type trackedProperty struct {
+	id         string
+	desired    interface{}
+	status     string
+	acceptedAt time.Time
+}
+
+// PropertyService 通过 property/set 设置设备属性，并根据后续 osd/state 上报核对是否生效
+type PropertyService struct {
+	db               *database.DB
+	ingestService    *IngestService
+	errorCodeService *ErrorCodeService
+
+	waiters map[string]chan *models.DJIMessage
+	tracked map[string]map[string]*trackedProperty
+	mutex   sync.Mutex
+}
+
+// NewPropertyService 创建属性设置服务
+func NewPropertyService(db *database.DB, ingestService *IngestService, errorCodeService *ErrorCodeService) *PropertyService {
+	s := &PropertyService{
+		db:               db,
+		ingestService:    ingestService,
+		errorCodeService: errorCodeService,
+		waiters:          make(map[string]chan *models.DJIMessage),
+		tracked:          make(map[string]map[string]*trackedProperty),
+	}
+
+	ingestService.RegisterHandler(TopicPropertySetReply, 1, s.handleReply)
+	ingestService.RegisterHandler(TopicOSD, 0, s.handleReport)
+	ingestService.RegisterHandler(TopicState, 0, s.handleReport)
+
+	return s
+}
+
+// Start 加载仍需核对的期望设置
+func (s *PropertyService) Start() {
+	rows, err := s.db.Query("SELECT id, sn, property, desired, status, updated_at FROM property_settings WHERE status IN (?, ?, ?, ?)",
+		PropertyStatusUnconfirmed, PropertyStatusAccepted, PropertyStatusApplied, PropertyStatusDrift)
+	if err != nil {
+		log.Printf("加载属性设置失败: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for rows.Next() {
+		var id, sn, property, desired, status string
+		var updatedAt int64
+		if err := rows.Scan(&id, &sn, &property, &desired, &status, &updatedAt); err != nil {
+			log.Printf("加载属性设置失败: %v", err)
+			return
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(desired), &value); err != nil {
+			continue
+		}
+		s.trackLocked(sn, property, &trackedProperty{id: id, desired: value, status: status, acceptedAt: time.UnixMilli(updatedAt)})
+	}
+}
+
+func (s *PropertyService) trackLocked(sn, property string, tracked *trackedProperty) {
+	if s.tracked[sn] == nil {
+		s.tracked[sn] = make(map[string]*trackedProperty)
+	}
+	s.tracked[sn][property] = tracked
+}
+
+// 设置设备属性，等待 set_reply 后记录期望值
+func (s *PropertyService) SetProperties(sn string, payload *models.PropertySetPayload) (*models.APIResponse, error) {
+	if len(payload.Properties) == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "properties 不能为空",
+		}, nil
+	}
+	for name, value := range payload.Properties {
+		validate, ok := settableProperties[name]
+		if !ok {
+			return &models.APIResponse{
+				Code:    1,
+				Message: "不支持设置的属性: " + name,
+			}, nil
+		}
+		if err := validate(value); err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("属性 %s 取值错误: %v", name, err),
+			}, nil
+		}
+	}
+
+	var exists int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM devices WHERE sn = ? OR airport_sn = ?", sn, sn).Scan(&exists); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("查询设备失败: %v", err),
+		}, err
+	}
+	if exists == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "设备未登记",
+		}, nil
+	}
+
+	if !s.ingestService.IsConnected() {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "后端MQTT未连接",
+		}, nil
+	}
+
+	data, err := json.Marshal(payload.Properties)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("序列化属性失败: %v", err),
+		}, err
+	}
+	msg := &models.DJIMessage{
+		TID:       uuid.New().String(),
+		BID:       uuid.New().String(),
+		Timestamp: time.Now().UnixMilli(),
+		Data:      data,
+	}
+
+	reply, err := s.send(sn, msg)
+	if err != nil && err != ErrServiceReplyTimeout {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("下发属性设置失败: %v", err),
+		}, err
+	}
+
+	// set_reply 中每个属性单独返回 result
+	var results map[string]struct {
+		Result int `json:"result"`
+	}
+	if reply != nil {
+		json.Unmarshal(reply.Data, &results)
+	}
+
+	names := make([]string, 0, len(payload.Properties))
+	for name := range payload.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	settings := []models.PropertySetting{}
+	failures := []string{}
+	for _, name := range names {
+		desired, _ := json.Marshal(payload.Properties[name])
+		setting := models.PropertySetting{
+			ID:        uuid.New().String(),
+			SN:        sn,
+			Property:  name,
+			Desired:   desired,
+			Status:    PropertyStatusUnconfirmed,
+			Operator:  payload.Operator,
+			CreatedAt: now.UnixMilli(),
+			UpdatedAt: now.UnixMilli(),
+		}
+		if result, ok := results[name]; ok {
+			value := result.Result
+			setting.Result = &value
+			if result.Result == 0 {
+				setting.Status = PropertyStatusAccepted
+			} else {
+				setting.Status = PropertyStatusRejected
+				setting.ErrorMessage = s.errorCodeService.FormatMessage(result.Result, sn)
+				failures = append(failures, name+": "+setting.ErrorMessage)
+			}
+		}
+
+		if err := s.saveSetting(&setting, now); err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("记录属性设置失败: %v", err),
+			}, err
+		}
+		settings = append(settings, setting)
+	}
+
+	if len(failures) > 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "设备拒绝属性设置: " + strings.Join(failures, "；"),
+			Data:    settings,
+		}, nil
+	}
+
+	message := "属性设置已生效，等待设备上报确认"
+	if reply == nil {
+		message = "等待设备回复超时，将根据设备上报确认是否生效"
+	}
+	return &models.APIResponse{
+		Code:    0,
+		Message: message,
+		Data:    settings,
+	}, nil
+}
+
+// saveSetting 记录设置，未被拒绝时替代同一属性之前的期望值并开始核对
+func (s *PropertyService) saveSetting(setting *models.PropertySetting, now time.Time) error {
+	if setting.Status != PropertyStatusRejected {
+		_, err := s.db.Exec("UPDATE property_settings SET status = ?, updated_at = ? WHERE sn = ? AND property = ? AND status IN (?, ?, ?, ?)",
+			PropertyStatusSuperseded, now.UnixMilli(), setting.SN, setting.Property,
+			PropertyStatusUnconfirmed, PropertyStatusAccepted, PropertyStatusApplied, PropertyStatusDrift)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := s.db.Exec(`INSERT INTO property_settings (id, sn, property, desired, status, result, error_message, operator,
+		created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		setting.ID, setting.SN, setting.Property, string(setting.Desired), setting.Status, setting.Result,
+		setting.ErrorMessage, setting.Operator, setting.CreatedAt, setting.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if setting.Status != PropertyStatusRejected {
+		var desired interface{}
+		json.Unmarshal(setting.Desired, &desired)
+
+		s.mutex.Lock()
+		s.trackLocked(setting.SN, setting.Property, &trackedProperty{
+			id:         setting.ID,
+			desired:    desired,
+			status:     setting.Status,
+			acceptedAt: now,
+		})
+		s.mutex.Unlock()
+	}
+	return nil
+}
+
+// send 发布 property/set 并等待 set_reply
+func (s *PropertyService) send(sn string, msg *models.DJIMessage) (*models.DJIMessage, error) {
+	replyCh := make(chan *models.DJIMessage, 1)
+
+	s.mutex.Lock()
+	s.waiters[msg.TID] = replyCh
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.waiters, msg.TID)
+		s.mutex.Unlock()
+	}()
+
+	topic := strings.Replace(TopicPropertySet, "{sn}", sn, 1)
+	if err := s.ingestService.Publish(topic, 1, msg); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replyCh:
+		return reply, nil
+	case <-time.After(propertySetTimeout):
+		return nil, ErrServiceReplyTimeout
+	}
+}
+
+// handleReply 将 set_reply 交给等待方
+func (s *PropertyService) handleReply(sn string, msg *models.DJIMessage) {
+	s.mutex.Lock()
+	replyCh, ok := s.waiters[msg.TID]
+	s.mutex.Unlock()
+
+	if ok {
+		select {
+		case replyCh <- msg:
+		default:
+		}
+	}
+}
+
+// handleReport 用 osd/state 上报核对期望值，状态变化时落库
+func (s *PropertyService) handleReport(sn string, msg *models.DJIMessage) {
+	s.mutex.Lock()
+	count := len(s.tracked[sn])
+	s.mutex.Unlock()
+	if count == 0 || len(msg.Data) == 0 {
+		return
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(msg.Data, &fields); err != nil {
+		return
+	}
+
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for name, tracked := range s.tracked[sn] {
+		reported, ok := fields[name]
+		if !ok {
+			continue
+		}
+
+		status := PropertyStatusApplied
+		if !propertyMatches(tracked.desired, reported) {
+			if now.Sub(tracked.acceptedAt) < propertySettleTime {
+				continue
+			}
+			status = PropertyStatusDrift
+		}
+		if status == tracked.status {
+			continue
+		}
+		tracked.status = status
+
+		reportedJSON, _ := json.Marshal(reported)
+		var verifiedAt interface{}
+		if status == PropertyStatusApplied {
+			verifiedAt = now.UnixMilli()
+		} else {
+			log.Printf("设备属性偏离期望值 %s %s: 上报 %s", sn, name, reportedJSON)
+		}
+
+		_, err := s.db.Exec("UPDATE property_settings SET status = ?, reported = ?, verified_at = COALESCE(?, verified_at), updated_at = ? WHERE id = ?",
+			status, string(reportedJSON), verifiedAt, now.UnixMilli(), tracked.id)
+		if err != nil {
+			log.Printf("更新属性设置失败 %s: %v", tracked.id, err)
+		}
+	}
+}
+
+const propertySettingColumns = `id, sn, property, desired, reported, status, result, error_message, operator,
+	created_at, updated_at, verified_at`
+
+// scanPropertySetting 扫描属性设置记录
+func scanPropertySetting(scanner interface{ Scan(...interface{}) error }) (models.PropertySetting, error) {
+	var setting models.PropertySetting
+	var desired, reported sql.NullString
+	var result, verifiedAt sql.NullInt64
+
+	err := scanner.Scan(&setting.ID, &setting.SN, &setting.Property, &desired, &reported, &setting.Status, &result,
+		&setting.ErrorMessage, &setting.Operator, &setting.CreatedAt, &setting.UpdatedAt, &verifiedAt)
+	if err != nil {
+		return setting, err
+	}
+
+	if desired.Valid {
+		setting.Desired = json.RawMessage(desired.String)
+	}
+	if reported.Valid {
+		setting.Reported = json.RawMessage(reported.String)
+	}
+	if result.Valid {
+		value := int(result.Int64)
+		setting.Result = &value
+	}
+	if verifiedAt.Valid {
+		setting.VerifiedAt = &verifiedAt.Int64
+	}
+	return setting, nil
+}
+
+// 获取设备当前的期望属性及核对状态
+func (s *PropertyService) GetDeviceProperties(sn string) (*models.APIResponse, error) {
+	rows, err := s.db.Query("SELECT "+propertySettingColumns+" FROM property_settings WHERE sn = ? AND status IN (?, ?, ?, ?) ORDER BY property",
+		sn, PropertyStatusUnconfirmed, PropertyStatusAccepted, PropertyStatusApplied, PropertyStatusDrift)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取设备属性失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	settings := []models.PropertySetting{}
+	for rows.Next() {
+		setting, err := scanPropertySetting(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描设备属性失败: %v", err),
+			}, err
+		}
+		settings = append(settings, setting)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    settings,
+	}, nil
+}
+
+// 获取属性设置历史
+func (s *PropertyService) GetSettings(query *models.PropertySettingQuery) (*models.APIResponse, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if query.SN != "" {
+		conditions = append(conditions, "sn = ?")
+		args = append(args, query.SN)
+	}
+	if query.Property != "" {
+		conditions = append(conditions, "property = ?")
+		args = append(args, query.Property)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, query.Status)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM property_settings "+where, args...).Scan(&total); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取属性设置记录失败: %v", err),
+		}, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	rows, err := s.db.Query("SELECT "+propertySettingColumns+" FROM property_settings "+where+
+		" ORDER BY created_at DESC LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取属性设置记录失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	settings := []models.PropertySetting{}
+	for rows.Next() {
+		setting, err := scanPropertySetting(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描属性设置记录失败: %v", err),
+			}, err
+		}
+		settings = append(settings, setting)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+			"items":    settings,
+		},
+	}, nil
+}
@@ -0,0 +1,785 @@
+package services
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// WebSocket代理受访问控制的操作
+const (
+	ProxyActionSubscribe = "subscribe"
+	ProxyActionPublish   = "publish"
+)
+
+// 访问规则对操作的决定
+const (
+	ProxyACLAllow = "allow"
+	ProxyACLDeny  = "deny"
+)
+
+// 访问被拒绝时返回给浏览器的错误类型
+const (
+	// 未认证或账号已停用
+	ProxyErrorUnauthenticated = "unauthenticated"
+	// 规则拒绝或未匹配任何允许规则
+	ProxyErrorForbidden = "forbidden"
+)
+
+// 规则主题中按账号分配的设备展开的占位符
+const proxyACLSNPlaceholder = "{sn}"
+
+// 子设备登记等规则之外的变更在该时间内生效
+const proxyACLRefreshInterval = 30 * time.Second
+
+// ProxyACLError 访问控制拒绝，Code 为 ProxyErrorUnauthenticated 或 ProxyErrorForbidden
+type ProxyACLError struct {
+	Code   string
+	Action string
+	Topic  string
+	RuleID string
+	Reason string
+}
+
+func (e *ProxyACLError) Error() string {
+	return e.Reason
+}
+
+// ProxyPrincipal 已认证的代理账号，规则与设备在账号或规则变更后重新加载
+type ProxyPrincipal struct {
+	userID   string
+	username string
+	role     string
+	devices  []string
+	rules    []models.ProxyACLRule
+	revision int64
+	loadedAt time.Time
+	mutex    sync.Mutex
+}
+
+// Username 返回账号用户名
+func (p *ProxyPrincipal) Username() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.username
+}
+
+// Role 返回账号角色
+func (p *ProxyPrincipal) Role() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.role
+}
+
+// Devices 返回账号可访问的设备，包含分配设备的子设备
+func (p *ProxyPrincipal) Devices() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]string{}, p.devices...)
+}
+
+// ProxyACLService WebSocket代理的账号与按角色的主题访问规则
+type ProxyACLService struct {
+	db      *database.DB
+	enabled bool
+	// 管理账号、规则及抓包回放接口使用的令牌
+	adminToken string
+
+	// 账号或规则每次变更后递增，已认证的连接据此重新加载
+	revision int64
+
+	changeHandlers []func()
+	mutex          sync.Mutex
+}
+
+// NewProxyACLService 创建代理访问控制服务，未启用时浏览器无需认证且不做主题检查
+func NewProxyACLService(db *database.DB, enabled bool, adminToken string) *ProxyACLService {
+	return &ProxyACLService{
+		db:         db,
+		enabled:    enabled,
+		adminToken: adminToken,
+	}
+}
+
+// Enabled 是否启用访问控制
+func (s *ProxyACLService) Enabled() bool {
+	return s.enabled
+}
+
+// AdminRequired 管理接口是否需要令牌：启用访问控制或配置了令牌时需要，
+// 否则浏览器可以修改限制自己的规则
+func (s *ProxyACLService) AdminRequired() bool {
+	return s.enabled || s.adminToken != ""
+}
+
+// CheckAdminToken 校验管理令牌，未配置令牌时一律拒绝
+func (s *ProxyACLService) CheckAdminToken(token string) bool {
+	if s.adminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+// RegisterChangeHandler 注册账号或规则变更后的回调
+func (s *ProxyACLService) RegisterChangeHandler(handler func()) {
+	s.mutex.Lock()
+	s.changeHandlers = append(s.changeHandlers, handler)
+	s.mutex.Unlock()
+}
+
+// changed 递增版本并通知已注册的回调
+func (s *ProxyACLService) changed() {
+	atomic.AddInt64(&s.revision, 1)
+
+	s.mutex.Lock()
+	handlers := append([]func(){}, s.changeHandlers...)
+	s.mutex.Unlock()
+
+	for _, handler := range handlers {
+		handler()
+	}
+}
+
+// Authenticate 校验账号密码并加载其角色规则与设备
+func (s *ProxyACLService) Authenticate(username, password string) (*ProxyPrincipal, error) {
+	var passwordHash string
+	var enabled bool
+	err := s.db.QueryRow("SELECT password_hash, enabled FROM proxy_users WHERE username = ?", username).Scan(&passwordHash, &enabled)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("查询代理账号失败: %v", err)
+	}
+	if err == sql.ErrNoRows || !enabled || bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		return nil, &ProxyACLError{Code: ProxyErrorUnauthenticated, Reason: "用户名或密码错误"}
+	}
+
+	principal := &ProxyPrincipal{username: username}
+	if err := s.reload(principal); err != nil {
+		return nil, err
+	}
+	return principal, nil
+}
+
+// Refresh 账号或规则变更后重新加载，账号被删除或停用时返回 ProxyErrorUnauthenticated
+func (s *ProxyACLService) Refresh(principal *ProxyPrincipal) error {
+	principal.mutex.Lock()
+	stale := principal.revision != atomic.LoadInt64(&s.revision) || time.Since(principal.loadedAt) > proxyACLRefreshInterval
+	principal.mutex.Unlock()
+	if !stale {
+		return nil
+	}
+	return s.reload(principal)
+}
+
+// reload 加载账号的角色、设备与规则，已加载过的账号按ID查询，改名后仍然有效
+func (s *ProxyACLService) reload(principal *ProxyPrincipal) error {
+	revision := atomic.LoadInt64(&s.revision)
+
+	principal.mutex.Lock()
+	column, value := "username", principal.username
+	if principal.userID != "" {
+		column, value = "id", principal.userID
+	}
+	principal.mutex.Unlock()
+
+	user, err := s.findUser(column, value)
+	if err == sql.ErrNoRows || (err == nil && !user.Enabled) {
+		return &ProxyACLError{Code: ProxyErrorUnauthenticated, Reason: "代理账号不存在或已停用"}
+	}
+	if err != nil {
+		return fmt.Errorf("查询代理账号失败: %v", err)
+	}
+	devices, err := s.expandDevices(user.Devices)
+	if err != nil {
+		return fmt.Errorf("查询子设备失败: %v", err)
+	}
+	rules, err := s.roleRules(user.Role)
+	if err != nil {
+		return fmt.Errorf("查询访问规则失败: %v", err)
+	}
+
+	principal.mutex.Lock()
+	principal.userID = user.ID
+	principal.username = user.Username
+	principal.role = user.Role
+	principal.devices = devices
+	principal.rules = rules
+	principal.revision = revision
+	principal.loadedAt = time.Now()
+	principal.mutex.Unlock()
+	return nil
+}
+
+// Authorize 检查账号能否订阅过滤器或发布到主题，拒绝时返回 *ProxyACLError
+func (s *ProxyACLService) Authorize(principal *ProxyPrincipal, action, topic string) error {
+	if err := s.Refresh(principal); err != nil {
+		return err
+	}
+
+	principal.mutex.Lock()
+	rules, devices := principal.rules, principal.devices
+	principal.mutex.Unlock()
+
+	return evaluateProxyACL(rules, devices, action, topic)
+}
+
+// evaluateProxyACL 按优先级依次匹配规则，第一条对该操作作出决定的规则生效，未匹配时拒绝。
+// 发布按主题匹配；订阅时允许规则需完整覆盖过滤器，拒绝规则与过滤器有交集即生效
+func evaluateProxyACL(rules []models.ProxyACLRule, devices []string, action, topic string) error {
+	denied := func(rule *models.ProxyACLRule, reason string) error {
+		err := &ProxyACLError{Code: ProxyErrorForbidden, Action: action, Topic: topic, Reason: reason}
+		if rule != nil {
+			err.RuleID = rule.ID
+		}
+		return err
+	}
+
+	verb := "订阅"
+	if action == ProxyActionPublish {
+		verb = "发布到"
+		if strings.ContainsAny(topic, "+#") {
+			return denied(nil, fmt.Sprintf("发布主题不能包含通配符: %s", topic))
+		}
+	}
+	filter := mqttSharedFilter(topic)
+
+	for i := range rules {
+		rule := &rules[i]
+		decision := rule.Subscribe
+		if action == ProxyActionPublish {
+			decision = rule.Publish
+		}
+		if decision == "" {
+			continue
+		}
+
+		for _, pattern := range expandProxyACLTopic(rule.Topic, devices) {
+			var matched bool
+			switch {
+			case action == ProxyActionPublish:
+				matched = mqttTopicMatches(pattern, filter)
+			case decision == ProxyACLDeny:
+				matched = mqttFiltersOverlap(pattern, filter)
+			default:
+				matched = mqttFilterCovers(pattern, filter)
+			}
+			if !matched {
+				continue
+			}
+			if decision == ProxyACLDeny {
+				return denied(rule, fmt.Sprintf("无权%s主题 %s，被规则 %s 拒绝", verb, topic, rule.Topic))
+			}
+			return nil
+		}
+	}
+	return denied(nil, fmt.Sprintf("无权%s主题 %s，未匹配允许的规则", verb, topic))
+}
+
+// expandProxyACLTopic 将规则主题中的 {sn} 展开为每个可访问设备，没有设备时不匹配任何主题
+func expandProxyACLTopic(topic string, devices []string) []string {
+	if !strings.Contains(topic, proxyACLSNPlaceholder) {
+		return []string{topic}
+	}
+	patterns := make([]string, 0, len(devices))
+	for _, sn := range devices {
+		patterns = append(patterns, strings.ReplaceAll(topic, proxyACLSNPlaceholder, sn))
+	}
+	return patterns
+}
+
+// mqttFilterCovers 判断 pattern 是否匹配 filter 可能匹配的所有主题
+func mqttFilterCovers(pattern, filter string) bool {
+	patternLevels := strings.Split(pattern, "/")
+	filterLevels := strings.Split(filter, "/")
+	for i, level := range patternLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(filterLevels) || filterLevels[i] == "#" {
+			return false
+		}
+		if level != "+" && level != filterLevels[i] {
+			return false
+		}
+	}
+	return len(patternLevels) == len(filterLevels)
+}
+
+// mqttFiltersOverlap 判断两个过滤器是否存在同时匹配的主题
+func mqttFiltersOverlap(a, b string) bool {
+	aLevels := strings.Split(a, "/")
+	bLevels := strings.Split(b, "/")
+	for i := 0; ; i++ {
+		if (i < len(aLevels) && aLevels[i] == "#") || (i < len(bLevels) && bLevels[i] == "#") {
+			return true
+		}
+		if i >= len(aLevels) || i >= len(bLevels) {
+			return len(aLevels) == len(bLevels)
+		}
+		if aLevels[i] != "+" && bLevels[i] != "+" && aLevels[i] != bLevels[i] {
+			return false
+		}
+	}
+}
+
+// validMQTTFilter 校验过滤器的通配符只占据整个层级且 # 位于最后
+func validMQTTFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+// expandDevices 返回分配的设备及挂载在其下的已登记子设备
+func (s *ProxyACLService) expandDevices(assigned []string) ([]string, error) {
+	devices := append([]string{}, assigned...)
+	seen := make(map[string]bool)
+	for _, sn := range assigned {
+		seen[sn] = true
+	}
+
+	for _, sn := range assigned {
+		rows, err := s.db.Query("SELECT sn FROM devices WHERE airport_sn = ?", sn)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var child string
+			if err := rows.Scan(&child); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !seen[child] {
+				seen[child] = true
+				devices = append(devices, child)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return devices, nil
+}
+
+// roleRules 按优先级与创建时间返回角色的规则
+func (s *ProxyACLService) roleRules(role string) ([]models.ProxyACLRule, error) {
+	return s.queryRules("WHERE role = ?", role)
+}
+
+func (s *ProxyACLService) queryRules(where string, args ...interface{}) ([]models.ProxyACLRule, error) {
+	rows, err := s.db.Query(`SELECT id, role, topic, subscribe, publish, priority, description, created_at, updated_at
+		FROM proxy_acl_rules `+where+` ORDER BY role, priority, created_at`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.ProxyACLRule{}
+	for rows.Next() {
+		var rule models.ProxyACLRule
+		if err := rows.Scan(&rule.ID, &rule.Role, &rule.Topic, &rule.Subscribe, &rule.Publish, &rule.Priority,
+			&rule.Description, &rule.CreatedAt, &rule.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func scanProxyUser(scanner interface{ Scan(...interface{}) error }) (models.ProxyUser, error) {
+	var user models.ProxyUser
+	var devices string
+	if err := scanner.Scan(&user.ID, &user.Username, &user.Role, &devices, &user.Enabled, &user.CreatedAt, &user.UpdatedAt); err != nil {
+		return user, err
+	}
+	user.Devices = []string{}
+	json.Unmarshal([]byte(devices), &user.Devices)
+	return user, nil
+}
+
+// findUser 按 id 或 username 查询账号
+func (s *ProxyACLService) findUser(column, value string) (models.ProxyUser, error) {
+	return scanProxyUser(s.db.QueryRow("SELECT id, username, role, devices, enabled, created_at, updated_at FROM proxy_users WHERE "+column+" = ?", value))
+}
+
+// 获取代理账号列表
+func (s *ProxyACLService) GetUsers() (*models.APIResponse, error) {
+	rows, err := s.db.Query("SELECT id, username, role, devices, enabled, created_at, updated_at FROM proxy_users ORDER BY username")
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取代理账号失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	users := []models.ProxyUser{}
+	for rows.Next() {
+		user, err := scanProxyUser(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描代理账号失败: %v", err),
+			}, err
+		}
+		users = append(users, user)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    users,
+	}, nil
+}
+
+// usernameTaken 用户名是否已被其他账号使用
+func (s *ProxyACLService) usernameTaken(username, exceptID string) (bool, error) {
+	var exists int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM proxy_users WHERE username = ? AND id != ?", username, exceptID).Scan(&exists)
+	return exists > 0, err
+}
+
+// 创建代理账号
+func (s *ProxyACLService) CreateUser(payload *models.ProxyUserPayload) (*models.APIResponse, error) {
+	if payload.Password == "" {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "密码不能为空",
+		}, nil
+	}
+
+	taken, err := s.usernameTaken(payload.Username, "")
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("查询代理账号失败: %v", err),
+		}, err
+	}
+	if taken {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "用户名已存在",
+		}, nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(payload.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("生成密码摘要失败: %v", err),
+		}, err
+	}
+
+	if payload.Devices == nil {
+		payload.Devices = []string{}
+	}
+	devices, _ := json.Marshal(payload.Devices)
+	enabled := true
+	if payload.Enabled != nil {
+		enabled = *payload.Enabled
+	}
+
+	id := uuid.New().String()
+	now := time.Now().UnixMilli()
+	_, err = s.db.Exec("INSERT INTO proxy_users (id, username, password_hash, role, devices, enabled, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		id, payload.Username, string(hash), payload.Role, string(devices), enabled, now, now)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建代理账号失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "代理账号创建成功",
+		Data:    map[string]string{"id": id},
+	}, nil
+}
+
+// 更新代理账号，密码为空时保留原密码，已认证的连接按新的角色与设备重新检查订阅
+func (s *ProxyACLService) UpdateUser(id string, payload *models.ProxyUserPayload) (*models.APIResponse, error) {
+	var passwordHash string
+	var enabled bool
+	err := s.db.QueryRow("SELECT password_hash, enabled FROM proxy_users WHERE id = ?", id).Scan(&passwordHash, &enabled)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "代理账号不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("查询代理账号失败: %v", err),
+		}, err
+	}
+
+	taken, err := s.usernameTaken(payload.Username, id)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("查询代理账号失败: %v", err),
+		}, err
+	}
+	if taken {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "用户名已存在",
+		}, nil
+	}
+
+	if payload.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(payload.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("生成密码摘要失败: %v", err),
+			}, err
+		}
+		passwordHash = string(hash)
+	}
+	if payload.Enabled != nil {
+		enabled = *payload.Enabled
+	}
+	if payload.Devices == nil {
+		payload.Devices = []string{}
+	}
+	devices, _ := json.Marshal(payload.Devices)
+
+	_, err = s.db.Exec("UPDATE proxy_users SET username = ?, password_hash = ?, role = ?, devices = ?, enabled = ?, updated_at = ? WHERE id = ?",
+		payload.Username, passwordHash, payload.Role, string(devices), enabled, time.Now().UnixMilli(), id)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("更新代理账号失败: %v", err),
+		}, err
+	}
+
+	s.changed()
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "代理账号更新成功",
+	}, nil
+}
+
+// 删除代理账号，已认证的连接随之失效
+func (s *ProxyACLService) DeleteUser(id string) (*models.APIResponse, error) {
+	result, err := s.db.Exec("DELETE FROM proxy_users WHERE id = ?", id)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("删除代理账号失败: %v", err),
+		}, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "代理账号不存在",
+		}, nil
+	}
+
+	s.changed()
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "代理账号删除成功",
+	}, nil
+}
+
+// 获取访问规则，指定 role 时只返回该角色的规则
+func (s *ProxyACLService) GetRules(role string) (*models.APIResponse, error) {
+	var rules []models.ProxyACLRule
+	var err error
+	if role != "" {
+		rules, err = s.roleRules(role)
+	} else {
+		rules, err = s.queryRules("")
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取访问规则失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    rules,
+	}, nil
+}
+
+// validateProxyACLRule 校验规则主题与决定
+func validateProxyACLRule(payload *models.ProxyACLRulePayload) string {
+	for _, decision := range []string{payload.Subscribe, payload.Publish} {
+		if decision != "" && decision != ProxyACLAllow && decision != ProxyACLDeny {
+			return "subscribe/publish 只能为 allow、deny 或空: " + decision
+		}
+	}
+	if payload.Subscribe == "" && payload.Publish == "" {
+		return "subscribe 与 publish 至少指定一个"
+	}
+	if !validMQTTFilter(strings.ReplaceAll(payload.Topic, proxyACLSNPlaceholder, "sn")) {
+		return "无效的主题过滤器: " + payload.Topic
+	}
+	return ""
+}
+
+// 创建访问规则
+func (s *ProxyACLService) CreateRule(payload *models.ProxyACLRulePayload) (*models.APIResponse, error) {
+	if msg := validateProxyACLRule(payload); msg != "" {
+		return &models.APIResponse{
+			Code:    1,
+			Message: msg,
+		}, nil
+	}
+
+	id := uuid.New().String()
+	now := time.Now().UnixMilli()
+	_, err := s.db.Exec(`INSERT INTO proxy_acl_rules (id, role, topic, subscribe, publish, priority, description, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, payload.Role, payload.Topic, payload.Subscribe, payload.Publish, payload.Priority, payload.Description, now, now)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建访问规则失败: %v", err),
+		}, err
+	}
+
+	s.changed()
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "访问规则创建成功",
+		Data:    map[string]string{"id": id},
+	}, nil
+}
+
+// 更新访问规则
+func (s *ProxyACLService) UpdateRule(id string, payload *models.ProxyACLRulePayload) (*models.APIResponse, error) {
+	if msg := validateProxyACLRule(payload); msg != "" {
+		return &models.APIResponse{
+			Code:    1,
+			Message: msg,
+		}, nil
+	}
+
+	result, err := s.db.Exec(`UPDATE proxy_acl_rules SET role = ?, topic = ?, subscribe = ?, publish = ?, priority = ?, description = ?, updated_at = ?
+		WHERE id = ?`,
+		payload.Role, payload.Topic, payload.Subscribe, payload.Publish, payload.Priority, payload.Description, time.Now().UnixMilli(), id)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("更新访问规则失败: %v", err),
+		}, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "访问规则不存在",
+		}, nil
+	}
+
+	s.changed()
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "访问规则更新成功",
+	}, nil
+}
+
+// 删除访问规则
+func (s *ProxyACLService) DeleteRule(id string) (*models.APIResponse, error) {
+	result, err := s.db.Exec("DELETE FROM proxy_acl_rules WHERE id = ?", id)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("删除访问规则失败: %v", err),
+		}, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "访问规则不存在",
+		}, nil
+	}
+
+	s.changed()
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "访问规则删除成功",
+	}, nil
+}
+
+// 检查账号对主题的访问结果，便于调试规则
+func (s *ProxyACLService) CheckAccess(req *models.ProxyACLCheckRequest) (*models.APIResponse, error) {
+	if req.Action != ProxyActionSubscribe && req.Action != ProxyActionPublish {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "action 只能为 subscribe 或 publish",
+		}, nil
+	}
+
+	principal := &ProxyPrincipal{username: req.Username}
+	if err := s.reload(principal); err != nil {
+		response := &models.APIResponse{
+			Code:    1,
+			Message: err.Error(),
+		}
+		// 账号不存在或已停用不是服务端错误
+		if _, ok := err.(*ProxyACLError); ok {
+			return response, nil
+		}
+		return response, err
+	}
+
+	result := map[string]interface{}{
+		"username": principal.username,
+		"role":     principal.role,
+		"devices":  principal.devices,
+		"action":   req.Action,
+		"topic":    req.Topic,
+		"allowed":  true,
+	}
+	if err := evaluateProxyACL(principal.rules, principal.devices, req.Action, req.Topic); err != nil {
+		aclErr := err.(*ProxyACLError)
+		result["allowed"] = false
+		result["reason"] = aclErr.Reason
+		if aclErr.RuleID != "" {
+			result["rule_id"] = aclErr.RuleID
+		}
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    result,
+	}, nil
+}
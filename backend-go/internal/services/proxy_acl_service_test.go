@@ -0,0 +1,192 @@
+package services
+
+import (
+	"testing"
+
+	"drone-patrol-backend/internal/models"
+)
+
+func TestEvaluateProxyACL(t *testing.T) {
+	// 规则已按 priority 排序，与 roleRules 的返回顺序一致
+	operator := []models.ProxyACLRule{
+		{ID: "allow-dock1-services", Topic: "thing/product/DOCK1/services", Publish: ProxyACLAllow, Priority: 0},
+		{ID: "deny-services", Topic: "thing/product/+/services", Subscribe: ProxyACLDeny, Publish: ProxyACLDeny, Priority: 1},
+		{ID: "allow-devices", Topic: "thing/product/{sn}/#", Subscribe: ProxyACLAllow, Publish: ProxyACLAllow, Priority: 10},
+		{ID: "allow-broadcast", Topic: "sys/broadcast/#", Subscribe: ProxyACLAllow, Priority: 20},
+	}
+	snOnly := []models.ProxyACLRule{
+		{ID: "allow-devices", Topic: "thing/product/{sn}/#", Subscribe: ProxyACLAllow, Publish: ProxyACLAllow},
+	}
+	devices := []string{"DOCK1", "AC1"}
+
+	tests := []struct {
+		name    string
+		rules   []models.ProxyACLRule
+		devices []string
+		action  string
+		topic   string
+		allowed bool
+		ruleID  string
+	}{
+		{"# 不被 {sn} 允许规则覆盖", snOnly, devices, ProxyActionSubscribe, "#", false, ""},
+		{"thing/product/# 不被 {sn} 允许规则覆盖", snOnly, devices, ProxyActionSubscribe, "thing/product/#", false, ""},
+		{"+ 层级不被具体设备覆盖", snOnly, devices, ProxyActionSubscribe, "thing/product/+/osd", false, ""},
+		{"设备主题", snOnly, devices, ProxyActionSubscribe, "thing/product/DOCK1/osd", true, ""},
+		{"子设备主题", snOnly, devices, ProxyActionSubscribe, "thing/product/AC1/osd", true, ""},
+		{"设备下的 #", snOnly, devices, ProxyActionSubscribe, "thing/product/DOCK1/#", true, ""},
+		{"# 规则覆盖父层级", snOnly, devices, ProxyActionSubscribe, "thing/product/DOCK1", true, ""},
+		{"未分配的设备", snOnly, devices, ProxyActionSubscribe, "thing/product/DOCK2/osd", false, ""},
+
+		{"拒绝规则与 # 有交集", operator, devices, ProxyActionSubscribe, "#", false, "deny-services"},
+		{"拒绝规则与设备 # 有交集", operator, devices, ProxyActionSubscribe, "thing/product/DOCK1/#", false, "deny-services"},
+		{"拒绝规则与 +/services 有交集", operator, devices, ProxyActionSubscribe, "thing/product/+/services", false, "deny-services"},
+		{"拒绝规则与 +/+ 有交集", operator, devices, ProxyActionSubscribe, "thing/product/DOCK1/+", false, "deny-services"},
+		{"拒绝规则匹配具体设备", operator, devices, ProxyActionSubscribe, "thing/product/AC1/services", false, "deny-services"},
+		{"拒绝规则不影响其他主题", operator, devices, ProxyActionSubscribe, "thing/product/DOCK1/events", true, ""},
+		{"订阅未设置的规则被跳过", operator, devices, ProxyActionSubscribe, "thing/product/DOCK1/services_reply", true, ""},
+
+		{"共享订阅去掉前缀后匹配", operator, devices, ProxyActionSubscribe, "$share/g/thing/product/AC1/osd", true, ""},
+		{"共享订阅的拒绝规则", operator, devices, ProxyActionSubscribe, "$share/g/thing/product/AC1/services", false, "deny-services"},
+		{"共享订阅 #", snOnly, devices, ProxyActionSubscribe, "$share/g/#", false, ""},
+		{"共享订阅未分配的设备", snOnly, devices, ProxyActionSubscribe, "$share/g/thing/product/DOCK2/osd", false, ""},
+
+		{"发布通配符 +", operator, devices, ProxyActionPublish, "thing/product/DOCK1/+", false, ""},
+		{"发布通配符 #", operator, devices, ProxyActionPublish, "thing/product/#", false, ""},
+		{"发布被拒绝", operator, devices, ProxyActionPublish, "thing/product/AC1/services", false, "deny-services"},
+		{"高优先级允许先于拒绝", operator, devices, ProxyActionPublish, "thing/product/DOCK1/services", true, ""},
+		{"发布设备主题", operator, devices, ProxyActionPublish, "thing/product/DOCK1/events", true, ""},
+		{"发布只有订阅规则的主题", operator, devices, ProxyActionPublish, "sys/broadcast/notice", false, ""},
+
+		{"无设备时 {sn} 规则不匹配", snOnly, nil, ProxyActionSubscribe, "thing/product/DOCK1/osd", false, ""},
+		{"无设备时发布", snOnly, nil, ProxyActionPublish, "thing/product/DOCK1/events", false, ""},
+		{"无设备时固定主题规则仍生效", operator, nil, ProxyActionSubscribe, "sys/broadcast/notice", true, ""},
+		{"无设备时拒绝规则仍生效", operator, nil, ProxyActionSubscribe, "thing/product/DOCK1/services", false, "deny-services"},
+		{"无规则时拒绝", nil, devices, ProxyActionSubscribe, "thing/product/DOCK1/osd", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := evaluateProxyACL(tt.rules, tt.devices, tt.action, tt.topic)
+			if tt.allowed {
+				if err != nil {
+					t.Fatalf("%s %s: 期望允许，得到 %v", tt.action, tt.topic, err)
+				}
+				return
+			}
+
+			aclErr, ok := err.(*ProxyACLError)
+			if !ok {
+				t.Fatalf("%s %s: 期望 *ProxyACLError，得到 %v", tt.action, tt.topic, err)
+			}
+			if aclErr.Code != ProxyErrorForbidden {
+				t.Errorf("Code = %q, 期望 %q", aclErr.Code, ProxyErrorForbidden)
+			}
+			if aclErr.RuleID != tt.ruleID {
+				t.Errorf("RuleID = %q, 期望 %q", aclErr.RuleID, tt.ruleID)
+			}
+			if aclErr.Action != tt.action || aclErr.Topic != tt.topic {
+				t.Errorf("Action/Topic = %q/%q, 期望 %q/%q", aclErr.Action, aclErr.Topic, tt.action, tt.topic)
+			}
+		})
+	}
+}
+
+func TestMQTTFilterCovers(t *testing.T) {
+	tests := []struct {
+		pattern string
+		filter  string
+		want    bool
+	}{
+		{"#", "#", true},
+		{"#", "a/b", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a/#", true},
+		{"a/#", "#", false},
+		{"a/#", "b/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/+", true},
+		{"a/+", "a/#", false},
+		{"a/+", "a/b/c", false},
+		{"a/b", "a/+", false},
+		{"a/b", "a/b", true},
+		{"a/b", "a", false},
+		{"a", "a/b", false},
+		{"+/b", "a/b", true},
+		{"+/b", "+/b", true},
+	}
+
+	for _, tt := range tests {
+		if got := mqttFilterCovers(tt.pattern, tt.filter); got != tt.want {
+			t.Errorf("mqttFilterCovers(%q, %q) = %v, 期望 %v", tt.pattern, tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestMQTTFiltersOverlap(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want bool
+	}{
+		{"#", "a/b", true},
+		{"a/#", "a", true},
+		{"a/#", "+/b", true},
+		{"a/#", "b/#", false},
+		{"a/+", "+/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/+", true},
+		{"a/b", "a/c", false},
+		{"a/b", "a/b", true},
+		{"a", "a/b", false},
+		{"+", "a/b", false},
+		{"thing/product/+/services", "thing/product/DOCK1/#", true},
+		{"thing/product/+/services", "thing/product/DOCK1/osd", false},
+	}
+
+	for _, tt := range tests {
+		if got := mqttFiltersOverlap(tt.a, tt.b); got != tt.want {
+			t.Errorf("mqttFiltersOverlap(%q, %q) = %v, 期望 %v", tt.a, tt.b, got, tt.want)
+		}
+		if got := mqttFiltersOverlap(tt.b, tt.a); got != tt.want {
+			t.Errorf("mqttFiltersOverlap(%q, %q) = %v, 期望 %v", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestExpandProxyACLTopic(t *testing.T) {
+	got := expandProxyACLTopic("thing/product/{sn}/osd", []string{"DOCK1", "AC1"})
+	want := []string{"thing/product/DOCK1/osd", "thing/product/AC1/osd"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expandProxyACLTopic = %v, 期望 %v", got, want)
+	}
+
+	if got := expandProxyACLTopic("thing/product/{sn}/osd", nil); len(got) != 0 {
+		t.Errorf("无设备时 expandProxyACLTopic = %v, 期望为空", got)
+	}
+	if got := expandProxyACLTopic("sys/#", nil); len(got) != 1 || got[0] != "sys/#" {
+		t.Errorf("不含 {sn} 时 expandProxyACLTopic = %v, 期望 [sys/#]", got)
+	}
+}
+
+func TestValidMQTTFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"#", true},
+		{"a/#", true},
+		{"a/+/c", true},
+		{"+", true},
+		{"", false},
+		{"a/#/c", false},
+		{"a#", false},
+		{"a/b+", false},
+	}
+
+	for _, tt := range tests {
+		if got := validMQTTFilter(tt.filter); got != tt.want {
+			t.Errorf("validMQTTFilter(%q) = %v, 期望 %v", tt.filter, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// 远程调试指令记录状态，下发后的状态取自关联的服务任务
+const (
+	RemoteDebugStatusRefused = "refused"
+	RemoteDebugStatusFailed  = "failed"
+)
+
+// 超过该时长未更新的osd快照无法用于安全检查
+const remoteDebugSnapshotMaxAge = 2 * time.Minute
+
+// remoteDebugMethod 远程调试指令及其执行前的安全检查
+type remoteDebugMethod struct {
+	method string
+	label  string
+	// 需要机场处于远程调试模式
+	requireDebugMode bool
+	// 需要确认飞行器不在空中
+	requireGrounded bool
+	// 需要飞行器在舱内
+	requireDroneInDock bool
+	// 需要 action 参数时的可选值
+	actions []int
+}
+
+var remoteDebugMethods = []remoteDebugMethod{
+	{method: "debug_mode_open", label: "开启远程调试", requireGrounded: true},
+	{method: "debug_mode_close", label: "关闭远程调试"},
+	{method: "cover_open", label: "打开舱盖", requireDebugMode: true},
+	{method: "cover_close", label: "关闭舱盖", requireDebugMode: true, requireGrounded: true},
+	{method: "cover_force_close", label: "强制关闭舱盖", requireDebugMode: true, requireGrounded: true},
+	{method: "putter_open", label: "展开推杆", requireDebugMode: true},
+	{method: "putter_close", label: "闭合推杆", requireDebugMode: true, requireGrounded: true},
+	{method: "charge_open", label: "打开充电", requireDebugMode: true, requireDroneInDock: true},
+	{method: "charge_close", label: "关闭充电", requireDebugMode: true},
+	{method: "drone_open", label: "飞行器开机", requireDebugMode: true, requireDroneInDock: true},
+	{method: "drone_close", label: "飞行器关机", requireDebugMode: true, requireGrounded: true},
+	{method: "device_reboot", label: "重启机场", requireDebugMode: true, requireGrounded: true},
+	{method: "drone_format", label: "格式化飞行器存储", requireDebugMode: true, requireGrounded: true},
+	{method: "device_format", label: "格式化机场存储", requireDebugMode: true, requireGrounded: true},
+	{method: "supplement_light_open", label: "打开补光灯", requireDebugMode: true},
+	{method: "supplement_light_close", label: "关闭补光灯", requireDebugMode: true},
+	{method: "battery_maintenance_switch", label: "电池保养开关", requireDebugMode: true, actions: []int{0, 1}},
+	{method: "air_conditioner_mode_switch", label: "空调模式切换", requireDebugMode: true, actions: []int{0, 1, 2, 3}},
+	{method: "alarm_state_switch", label: "声光报警开关", actions: []int{0, 1}},
+}
+
+// findRemoteDebugMethod 查找远程调试指令定义
+func findRemoteDebugMethod(method string) (*remoteDebugMethod, bool) {
+	for i := range remoteDebugMethods {
+		if remoteDebugMethods[i].method == method {
+			return &remoteDebugMethods[i], true
+		}
+	}
+	return nil, false
+}
+
+// RemoteDebugService 机场远程调试指令：下发前按机场与飞行器状态做安全检查，并记录操作人与结果
+type RemoteDebugService struct {
+	db             *database.DB
+	ingestService  *IngestService
+	commandService *CommandService
+}
+
+// NewRemoteDebugService 创建远程调试服务
+func NewRemoteDebugService(db *database.DB, ingestService *IngestService, commandService *CommandService) *RemoteDebugService {
+	return &RemoteDebugService{
+		db:             db,
+		ingestService:  ingestService,
+		commandService: commandService,
+	}
+}
+
+// 获取支持的远程调试指令
+func (s *RemoteDebugService) GetMethods() (*models.APIResponse, error) {
+	methods := make([]models.RemoteDebugMethod, 0, len(remoteDebugMethods))
+	for _, method := range remoteDebugMethods {
+		methods = append(methods, models.RemoteDebugMethod{
+			Method:           method.method,
+			Label:            method.label,
+			RequireDebugMode: method.requireDebugMode,
+			Actions:          method.actions,
+		})
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    methods,
+	}, nil
+}
+
+// 执行远程调试指令，安全检查不通过时拒绝并记录原因
+func (s *RemoteDebugService) Execute(sn, method string, payload *models.RemoteDebugPayload) (*models.APIResponse, error) {
+	spec, ok := findRemoteDebugMethod(method)
+	if !ok {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "不支持的远程调试指令: " + method,
+		}, nil
+	}
+
+	var params json.RawMessage
+	if len(spec.actions) > 0 {
+		if payload.Action == nil || !slices.Contains(spec.actions, *payload.Action) {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("%s 需要 action 参数，可选值: %v", method, spec.actions),
+			}, nil
+		}
+		params, _ = json.Marshal(map[string]int{"action": *payload.Action})
+	}
+
+	var exists int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM devices WHERE sn = ? OR airport_sn = ?", sn, sn).Scan(&exists); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("查询设备失败: %v", err),
+		}, err
+	}
+	if exists == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "设备未登记",
+		}, nil
+	}
+
+	if !s.ingestService.IsConnected() {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "后端MQTT未连接",
+		}, nil
+	}
+
+	command := &models.RemoteDebugCommand{
+		ID:        uuid.New().String(),
+		DockSN:    sn,
+		Method:    method,
+		Params:    params,
+		Operator:  payload.Operator,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	command.UpdatedAt = command.CreatedAt
+
+	if reason := s.checkInterlock(sn, spec); reason != "" {
+		command.Status = RemoteDebugStatusRefused
+		command.ErrorMessage = reason
+		if err := s.insertCommand(command); err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("记录远程调试指令失败: %v", err),
+			}, err
+		}
+		return &models.APIResponse{
+			Code:    1,
+			Message: reason,
+			Data:    command,
+		}, nil
+	}
+
+	job, err := s.commandService.Submit(sn, method, params)
+	if job != nil {
+		command.JobID = job.ID
+		command.Status = job.Status
+		command.ErrorMessage = job.ErrorMessage
+	}
+	if err != nil && job == nil {
+		command.Status = RemoteDebugStatusFailed
+		command.ErrorMessage = fmt.Sprintf("下发失败: %v", err)
+	}
+	if err := s.insertCommand(command); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("记录远程调试指令失败: %v", err),
+		}, err
+	}
+
+	if command.Status == ServiceJobFailed {
+		return &models.APIResponse{
+			Code:    1,
+			Message: command.ErrorMessage,
+			Data:    command,
+		}, nil
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: spec.label + "指令已下发",
+		Data:    command,
+	}, nil
+}
+
+// checkInterlock 根据最新机场与飞行器状态判断指令是否可以安全执行，不可执行时返回原因
+func (s *RemoteDebugService) checkInterlock(sn string, spec *remoteDebugMethod) string {
+	freshAfter := time.Now().Add(-remoteDebugSnapshotMaxAge).UnixMilli()
+	snapshot, ok := s.ingestService.GetSnapshot(sn)
+	if !ok || snapshot.Dock == nil || snapshot.OSDUpdatedAt < freshAfter {
+		return "机场状态未知或长时间未更新，无法确认指令是否安全"
+	}
+	dock := snapshot.Dock
+
+	if spec.method == "debug_mode_open" {
+		switch dock.ModeCode {
+		case dockModeOnsiteDebug:
+			return "机场处于现场调试模式"
+		case dockModeUpgrading:
+			return "机场正在升级固件"
+		case dockModeWorking:
+			return "机场正在执行作业"
+		}
+	}
+	if spec.requireDebugMode && dock.ModeCode != dockModeRemoteDebug {
+		return "机场未处于远程调试模式，请先开启远程调试"
+	}
+	if spec.requireDroneInDock && dock.DroneInDock != 1 {
+		return "飞行器不在机场内"
+	}
+	if spec.requireGrounded {
+		airborne, known := s.aircraftAirborne(sn, dock)
+		if !known {
+			return "无法确认飞行器是否在空中，禁止" + spec.label
+		}
+		if airborne {
+			return "飞行器在空中，禁止" + spec.label
+		}
+	}
+	return ""
+}
+
+// aircraftAirborne 判断机场下的飞行器是否在空中，known 为 false 表示无法确认
+func (s *RemoteDebugService) aircraftAirborne(sn string, dock *models.DockOSD) (airborne bool, known bool) {
+	aircraftSN := ""
+	if dock.SubDevice != nil {
+		aircraftSN = dock.SubDevice.DeviceSN
+	}
+	if aircraftSN == "" {
+		err := s.db.QueryRow("SELECT sn FROM devices WHERE airport_sn = ? AND sn != ? LIMIT 1", sn, sn).Scan(&aircraftSN)
+		if err != nil && err != sql.ErrNoRows {
+			return false, false
+		}
+	}
+
+	if aircraftSN != "" {
+		freshAfter := time.Now().Add(-remoteDebugSnapshotMaxAge).UnixMilli()
+		if snapshot, ok := s.ingestService.GetSnapshot(aircraftSN); ok && snapshot.Aircraft != nil && snapshot.OSDUpdatedAt >= freshAfter {
+			return isAircraftInSky(snapshot.Aircraft), true
+		}
+	}
+
+	// 飞行器关机或无上报时以机场舱内检测为准
+	if dock.DroneInDock == 1 {
+		return false, true
+	}
+	return false, false
+}
+
+func (s *RemoteDebugService) insertCommand(command *models.RemoteDebugCommand) error {
+	_, err := s.db.Exec(`INSERT INTO remote_debug_commands (id, dock_sn, method, params, operator, status, error_message,
+		job_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		command.ID, command.DockSN, command.Method, nullableJSON(command.Params), command.Operator, command.Status,
+		command.ErrorMessage, command.JobID, command.CreatedAt)
+	return err
+}
+
+const remoteDebugCommandColumns = `c.id, c.dock_sn, c.method, c.params, c.operator, COALESCE(j.status, c.status), j.result,
+	CASE WHEN j.id IS NULL THEN c.error_message ELSE COALESCE(j.error_message, '') END, COALESCE(j.progress, 0), c.job_id,
+	c.created_at, COALESCE(j.updated_at, c.created_at)`
+
+const remoteDebugCommandFrom = ` FROM remote_debug_commands c LEFT JOIN service_jobs j ON j.id = c.job_id `
+
+// scanRemoteDebugCommand 扫描远程调试指令记录
+func scanRemoteDebugCommand(scanner interface{ Scan(...interface{}) error }) (models.RemoteDebugCommand, error) {
+	var command models.RemoteDebugCommand
+	var params sql.NullString
+	var result sql.NullInt64
+
+	err := scanner.Scan(&command.ID, &command.DockSN, &command.Method, &params, &command.Operator, &command.Status,
+		&result, &command.ErrorMessage, &command.Progress, &command.JobID, &command.CreatedAt, &command.UpdatedAt)
+	if err != nil {
+		return command, err
+	}
+
+	if params.Valid {
+		command.Params = json.RawMessage(params.String)
+	}
+	if result.Valid {
+		value := int(result.Int64)
+		command.Result = &value
+	}
+	return command, nil
+}
+
+// 获取远程调试指令记录
+func (s *RemoteDebugService) GetCommands(query *models.RemoteDebugCommandQuery) (*models.APIResponse, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if query.SN != "" {
+		conditions = append(conditions, "c.dock_sn = ?")
+		args = append(args, query.SN)
+	}
+	if query.Method != "" {
+		conditions = append(conditions, "c.method = ?")
+		args = append(args, query.Method)
+	}
+	if query.Operator != "" {
+		conditions = append(conditions, "c.operator = ?")
+		args = append(args, query.Operator)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "COALESCE(j.status, c.status) = ?")
+		args = append(args, query.Status)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*)"+remoteDebugCommandFrom+where, args...).Scan(&total); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取远程调试记录失败: %v", err),
+		}, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	rows, err := s.db.Query("SELECT "+remoteDebugCommandColumns+remoteDebugCommandFrom+where+
+		" ORDER BY c.created_at DESC LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取远程调试记录失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	commands := []models.RemoteDebugCommand{}
+	for rows.Next() {
+		command, err := scanRemoteDebugCommand(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描远程调试记录失败: %v", err),
+			}, err
+		}
+		commands = append(commands, command)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+			"items":    commands,
+		},
+	}, nil
+}
+
+// 获取远程调试指令详情
+func (s *RemoteDebugService) GetCommand(commandID string) (*models.APIResponse, error) {
+	command, err := scanRemoteDebugCommand(s.db.QueryRow("SELECT "+remoteDebugCommandColumns+remoteDebugCommandFrom+
+		"WHERE c.id = ?", commandID))
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "远程调试记录不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取远程调试记录失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    command,
+	}, nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"drone-patrol-backend/internal/models"
+)
+
+const (
+	// storage_config_get 下发的临时凭证有效期
+	requestStorageCredentialTTL = time.Hour
+	mediaObjectKeyPrefix        = "media"
+	storageModuleMedia          = 0
+)
+
+// StorageCredentialIssuer 签发对象存储临时凭证，由 ObjectStorage 实现
+type StorageCredentialIssuer interface {
+	IssueCredentials(prefix string, ttl time.Duration) models.StorageCredentials
+	Endpoint() string
+	Bucket() string
+	Region() string
+	Provider() string
+}
+
+// NewConfigRequestHandler 处理 config 请求，返回 NTP 服务器与 DJI 应用授权信息
+func NewConfigRequestHandler(appID, appKey, appLicense, ntpHost string, ntpPort int) RequestHandler {
+	return func(sn string, msg *models.DJIMessage) (interface{}, error) {
+		return map[string]interface{}{
+			"ntp_server_host": ntpHost,
+			"ntp_server_port": ntpPort,
+			"app_id":          appID,
+			"app_key":         appKey,
+			"app_license":     appLicense,
+		}, nil
+	}
+}
+
+// NewStorageConfigRequestHandler 处理 storage_config_get 请求，签发仅能写入该设备媒体目录的临时凭证
+func NewStorageConfigRequestHandler(storage StorageCredentialIssuer) RequestHandler {
+	return func(sn string, msg *models.DJIMessage) (interface{}, error) {
+		var request struct {
+			Module int `json:"module"`
+		}
+		if len(msg.Data) > 0 {
+			if err := json.Unmarshal(msg.Data, &request); err != nil {
+				return nil, fmt.Errorf("解析请求失败: %v", err)
+			}
+		}
+		if request.Module != storageModuleMedia {
+			return nil, fmt.Errorf("不支持的存储模块: %d", request.Module)
+		}
+
+		objectKeyPrefix := path.Join(mediaObjectKeyPrefix, sn)
+		return map[string]interface{}{
+			"result": 0,
+			"output": map[string]interface{}{
+				"bucket":            storage.Bucket(),
+				"region":            storage.Region(),
+				"endpoint":          storage.Endpoint(),
+				"provider":          storage.Provider(),
+				"object_key_prefix": objectKeyPrefix,
+				"credentials":       storage.IssueCredentials(objectKeyPrefix+"/", requestStorageCredentialTTL),
+			},
+		}, nil
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/models"
+)
+
+// 设备主动请求主题
+const (
+	TopicRequests      = "thing/product/{sn}/requests"
+	TopicRequestsReply = "thing/product/{sn}/requests_reply"
+)
+
+// 处理函数返回错误时回复的 result
+const requestErrorResult = 1
+
+// RequestHandler 处理设备 requests，返回值作为 requests_reply 的 data
+type RequestHandler func(sn string, msg *models.DJIMessage) (interface{}, error)
+
+// MessagePublisher 发布MQTT消息，后端常驻连接与测试用的假broker均可实现
+type MessagePublisher interface {
+	Publish(topic string, qos byte, payload interface{}) error
+}
+
+// RequestRouter 按 method 分发设备 requests 并在 requests_reply 上回复
+type RequestRouter struct {
+	publisher MessagePublisher
+
+	handlers  map[string]RequestHandler
+	unhandled map[string]bool
+	mutex     sync.RWMutex
+}
+
+// NewRequestRouter 创建设备请求分发器
+func NewRequestRouter(publisher MessagePublisher) *RequestRouter {
+	return &RequestRouter{
+		publisher: publisher,
+		handlers:  make(map[string]RequestHandler),
+		unhandled: make(map[string]bool),
+	}
+}
+
+// Handle 注册 method 的处理函数，重复注册时覆盖
+func (r *RequestRouter) Handle(method string, handler RequestHandler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.handlers[method] = handler
+}
+
+// Methods 返回已注册的 method
+func (r *RequestRouter) Methods() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	methods := make([]string, 0, len(r.handlers))
+	for method := range r.handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Dispatch 调用对应的处理函数并回复；未注册的 method 回复非零 result，避免设备反复重试，仅首次记录日志
+func (r *RequestRouter) Dispatch(sn string, msg *models.DJIMessage) {
+	r.mutex.RLock()
+	handler, ok := r.handlers[msg.Method]
+	r.mutex.RUnlock()
+
+	var data interface{}
+	if ok {
+		var err error
+		data, err = handler(sn, msg)
+		if err != nil {
+			log.Printf("处理设备请求失败 %s %s: %v", sn, msg.Method, err)
+			data = map[string]int{"result": requestErrorResult}
+		}
+	} else {
+		r.mutex.Lock()
+		if !r.unhandled[msg.Method] {
+			r.unhandled[msg.Method] = true
+			log.Printf("未处理的设备请求 %s: %s", sn, msg.Method)
+		}
+		r.mutex.Unlock()
+		data = map[string]int{"result": requestErrorResult}
+	}
+
+	reply := map[string]interface{}{
+		"tid":       msg.TID,
+		"bid":       msg.BID,
+		"timestamp": time.Now().UnixMilli(),
+		"method":    msg.Method,
+		"data":      data,
+	}
+	if msg.Gateway != "" {
+		reply["gateway"] = msg.Gateway
+	}
+
+	topic := strings.Replace(TopicRequestsReply, "{sn}", sn, 1)
+	if err := r.publisher.Publish(topic, 1, reply); err != nil {
+		log.Printf("发送requests_reply失败 %s %s: %v", sn, msg.Method, err)
+	}
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"drone-patrol-backend/internal/models"
+)
+
+// fakePublisher 记录发布的消息，代替后端MQTT连接
+type fakePublisher struct {
+	messages []fakePublishedMessage
+}
+
+type fakePublishedMessage struct {
+	topic   string
+	qos     byte
+	payload interface{}
+}
+
+func (p *fakePublisher) Publish(topic string, qos byte, payload interface{}) error {
+	p.messages = append(p.messages, fakePublishedMessage{topic: topic, qos: qos, payload: payload})
+	return nil
+}
+
+// dispatchRequest 分发一条设备请求并返回解析后的 requests_reply
+func dispatchRequest(t *testing.T, router *RequestRouter, publisher *fakePublisher, sn, method, data string) map[string]interface{} {
+	t.Helper()
+
+	msg := &models.DJIMessage{
+		TID:     "tid-" + method,
+		BID:     "bid-" + method,
+		Gateway: sn,
+		Method:  method,
+	}
+	if data != "" {
+		msg.Data = json.RawMessage(data)
+	}
+
+	publisher.messages = nil
+	router.Dispatch(sn, msg)
+	if len(publisher.messages) != 1 {
+		t.Fatalf("%s: 发布了 %d 条消息, 期望 1 条", method, len(publisher.messages))
+	}
+
+	published := publisher.messages[0]
+	if want := "thing/product/" + sn + "/requests_reply"; published.topic != want {
+		t.Errorf("%s: 主题 = %q, 期望 %q", method, published.topic, want)
+	}
+	if published.qos != 1 {
+		t.Errorf("%s: QoS = %d, 期望 1", method, published.qos)
+	}
+
+	raw, err := json.Marshal(published.payload)
+	if err != nil {
+		t.Fatalf("%s: 序列化回复失败: %v", method, err)
+	}
+	var reply map[string]interface{}
+	if err := json.Unmarshal(raw, &reply); err != nil {
+		t.Fatalf("%s: 解析回复失败: %v", method, err)
+	}
+
+	if reply["tid"] != msg.TID || reply["bid"] != msg.BID || reply["method"] != method || reply["gateway"] != sn {
+		t.Errorf("%s: 回复未沿用请求的 tid/bid/method/gateway: %s", method, raw)
+	}
+	return reply
+}
+
+func TestRequestRouterDispatch(t *testing.T) {
+	publisher := &fakePublisher{}
+	router := NewRequestRouter(publisher)
+	router.Handle("config", NewConfigRequestHandler("app-id", "app-key", "app-license", "ntp.example.com", 123))
+	router.Handle("storage_config_get", NewStorageConfigRequestHandler(NewObjectStorage(t.TempDir(), "", "http://127.0.0.1:9000", "media", "local")))
+
+	tests := []struct {
+		name   string
+		method string
+		data   string
+		want   string
+	}{
+		{"config 返回配置的应用授权与NTP", "config", `{"config_scope": "product", "config_type": "json"}`,
+			`{"app_id": "app-id", "app_key": "app-key", "app_license": "app-license", "ntp_server_host": "ntp.example.com", "ntp_server_port": 123}`},
+		{"未注册的 method", "airport_bind_status", `{"devices": []}`, `{"result": 1}`},
+		{"未注册的 method 再次请求", "airport_bind_status", "", `{"result": 1}`},
+		{"处理函数出错", "storage_config_get", `{"module": 1}`, `{"result": 1}`},
+		{"请求数据无法解析", "storage_config_get", `[]`, `{"result": 1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := dispatchRequest(t, router, publisher, "DOCK1", tt.method, tt.data)
+
+			var want interface{}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatalf("期望值无效: %v", err)
+			}
+			if !reflect.DeepEqual(reply["data"], want) {
+				t.Errorf("data = %v, 期望 %v", reply["data"], want)
+			}
+		})
+	}
+}
+
+func TestStorageConfigRequest(t *testing.T) {
+	storage := NewObjectStorage(t.TempDir(), "", "http://127.0.0.1:9000", "media", "local")
+	publisher := &fakePublisher{}
+	router := NewRequestRouter(publisher)
+	router.Handle("storage_config_get", NewStorageConfigRequestHandler(storage))
+
+	tests := []struct {
+		sn     string
+		data   string
+		prefix string
+	}{
+		{"DOCK1", `{"module": 0}`, "media/DOCK1"},
+		{"DOCK2", "", "media/DOCK2"},
+	}
+
+	for _, tt := range tests {
+		reply := dispatchRequest(t, router, publisher, tt.sn, "storage_config_get", tt.data)
+
+		data, _ := reply["data"].(map[string]interface{})
+		if data["result"] != float64(0) {
+			t.Fatalf("%s: result = %v, 期望 0", tt.sn, data["result"])
+		}
+		output, _ := data["output"].(map[string]interface{})
+		if output["object_key_prefix"] != tt.prefix {
+			t.Errorf("%s: object_key_prefix = %v, 期望 %s", tt.sn, output["object_key_prefix"], tt.prefix)
+		}
+		if output["bucket"] != "media" || output["endpoint"] != "http://127.0.0.1:9000" || output["region"] != "local" {
+			t.Errorf("%s: 存储信息 = %v", tt.sn, output)
+		}
+
+		credentials, _ := output["credentials"].(map[string]interface{})
+		accessKey, _ := credentials["access_key_id"].(string)
+		storage.mutex.Lock()
+		credential, ok := storage.credentials[accessKey]
+		storage.mutex.Unlock()
+		if !ok {
+			t.Fatalf("%s: 凭证 %q 未登记", tt.sn, accessKey)
+		}
+		if credential.prefix != tt.prefix+"/" {
+			t.Errorf("%s: 凭证前缀 = %q, 期望 %q", tt.sn, credential.prefix, tt.prefix+"/")
+		}
+		if credentials["access_key_secret"] != credential.secret || credentials["security_token"] != credential.token {
+			t.Errorf("%s: 回复的凭证与登记的不一致", tt.sn)
+		}
+		if credentials["expire"] != requestStorageCredentialTTL.Seconds() {
+			t.Errorf("%s: expire = %v, 期望 %v", tt.sn, credentials["expire"], requestStorageCredentialTTL.Seconds())
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+)
+
+const (
+	telemetryFlushInterval  = 5 * time.Second
+	telemetryRollupInterval = time.Hour
+	telemetryRollupBucket   = int64(60 * 1000)
+	telemetryMinInterval    = int64(1000)
+	telemetryDefaultPoints  = 2000
+	telemetryMaxPoints      = 20000
+)
+
+// telemetryFieldColumns 查询字段与数据库列的映射
+var telemetryFieldColumns = map[string]string{
+	"mode_code":        "mode_code",
+	"latitude":         "latitude",
+	"longitude":        "longitude",
+	"height":           "height",
+	"elevation":        "elevation",
+	"horizontal_speed": "horizontal_speed",
+	"vertical_speed":   "vertical_speed",
+	"battery":          "battery_percent",
+	"attitude_pitch":   "attitude_pitch",
+	"attitude_roll":    "attitude_roll",
+	"attitude_head":    "attitude_head",
+}
+
+// TelemetryService 飞行器遥测历史存储
+type TelemetryService struct {
+	db                  *database.DB
+	ingestService       *IngestService
+	rawRetentionDays    int
+	rollupRetentionDays int
+
+	buffer []models.TelemetrySample
+	lastTS map[string]int64
+	mutex  sync.Mutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTelemetryService 创建遥测历史服务
+func NewTelemetryService(db *database.DB, ingestService *IngestService, rawRetentionDays, rollupRetentionDays int) *TelemetryService {
+	s := &TelemetryService{
+		db:                  db,
+		ingestService:       ingestService,
+		rawRetentionDays:    rawRetentionDays,
+		rollupRetentionDays: rollupRetentionDays,
+		lastTS:              make(map[string]int64),
+		stopCh:              make(chan struct{}),
+	}
+
+	ingestService.RegisterHandler(TopicOSD, 0, s.handleOSD)
+
+	return s
+}
+
+// Start 启动采样落盘与数据汇总任务
+func (s *TelemetryService) Start() {
+	go s.run()
+}
+
+// Stop 停止并写入剩余采样
+func (s *TelemetryService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		s.flush()
+	})
+}
+
+func (s *TelemetryService) run() {
+	flushTicker := time.NewTicker(telemetryFlushInterval)
+	defer flushTicker.Stop()
+	rollupTicker := time.NewTicker(telemetryRollupInterval)
+	defer rollupTicker.Stop()
+
+	s.rollup()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-flushTicker.C:
+			s.flush()
+		case <-rollupTicker.C:
+			s.rollup()
+		}
+	}
+}
+
+// handleOSD 记录飞行器osd采样，同一设备最多每秒一条
+func (s *TelemetryService) handleOSD(sn string, msg *models.DJIMessage) {
+	snapshot, ok := s.ingestService.GetSnapshot(sn)
+	if !ok || snapshot.Aircraft == nil {
+		return
+	}
+
+	osd := snapshot.Aircraft
+	if osd.Latitude == 0 && osd.Longitude == 0 {
+		return
+	}
+
+	ts := msg.Timestamp
+	if ts <= 0 {
+		ts = time.Now().UnixMilli()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ts-s.lastTS[sn] < telemetryMinInterval {
+		return
+	}
+	s.lastTS[sn] = ts
+
+	s.buffer = append(s.buffer, models.TelemetrySample{
+		SN:              sn,
+		Timestamp:       ts,
+		ModeCode:        osd.ModeCode,
+		Latitude:        osd.Latitude,
+		Longitude:       osd.Longitude,
+		Height:          osd.Height,
+		Elevation:       osd.Elevation,
+		HorizontalSpeed: osd.HorizontalSpeed,
+		VerticalSpeed:   osd.VerticalSpeed,
+		Battery:         float64(osd.Battery.CapacityPercent),
+		AttitudePitch:   osd.AttitudePitch,
+		AttitudeRoll:    osd.AttitudeRoll,
+		AttitudeHead:    osd.AttitudeHead,
+	})
+}
+
+// flush 批量写入缓冲的采样
+func (s *TelemetryService) flush() {
+	s.mutex.Lock()
+	samples := s.buffer
+	s.buffer = nil
+	s.mutex.Unlock()
+
+	if len(samples) == 0 {
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Printf("写入遥测采样失败: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO telemetry_samples (sn, ts, mode_code, latitude, longitude, height, elevation,
+		horizontal_speed, vertical_speed, battery_percent, attitude_pitch, attitude_roll, attitude_head)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		log.Printf("写入遥测采样失败: %v", err)
+		return
+	}
+	defer stmt.Close()
+
+	for _, sample := range samples {
+		_, err := stmt.Exec(sample.SN, sample.Timestamp, sample.ModeCode, sample.Latitude, sample.Longitude,
+			sample.Height, sample.Elevation, sample.HorizontalSpeed, sample.VerticalSpeed, sample.Battery,
+			sample.AttitudePitch, sample.AttitudeRoll, sample.AttitudeHead)
+		if err != nil {
+			log.Printf("写入遥测采样失败 %s: %v", sample.SN, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("提交遥测采样失败: %v", err)
+	}
+}
+
+// rollup 将超过保留期的原始采样汇总为分钟级数据，并清理过期汇总
+func (s *TelemetryService) rollup() {
+	now := time.Now().UnixMilli()
+	rawCutoff := now - int64(s.rawRetentionDays)*24*3600*1000
+	rawCutoff -= rawCutoff % telemetryRollupBucket
+	rollupCutoff := now - int64(s.rollupRetentionDays)*24*3600*1000
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Printf("遥测数据汇总失败: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	// 航向角取平均在0/360附近会失真，分钟级数据仅用于回放概览，可以接受
+	_, err = tx.Exec(`INSERT OR REPLACE INTO telemetry_rollups (sn, ts, samples, mode_code, latitude, longitude, height,
+		elevation, horizontal_speed, vertical_speed, battery_percent, attitude_pitch, attitude_roll, attitude_head)
+		SELECT sn, (ts / ?) * ? AS bucket, COUNT(*), MAX(mode_code), AVG(latitude), AVG(longitude), AVG(height),
+		AVG(elevation), AVG(horizontal_speed), AVG(vertical_speed), AVG(battery_percent), AVG(attitude_pitch),
+		AVG(attitude_roll), AVG(attitude_head)
+		FROM telemetry_samples WHERE ts < ? GROUP BY sn, bucket`,
+		telemetryRollupBucket, telemetryRollupBucket, rawCutoff)
+	if err != nil {
+		log.Printf("遥测数据汇总失败: %v", err)
+		return
+	}
+
+	result, err := tx.Exec("DELETE FROM telemetry_samples WHERE ts < ?", rawCutoff)
+	if err != nil {
+		log.Printf("清理遥测原始数据失败: %v", err)
+		return
+	}
+	rolled, _ := result.RowsAffected()
+
+	result, err = tx.Exec("DELETE FROM telemetry_rollups WHERE ts < ?", rollupCutoff)
+	if err != nil {
+		log.Printf("清理遥测汇总数据失败: %v", err)
+		return
+	}
+	expired, _ := result.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("提交遥测数据汇总失败: %v", err)
+		return
+	}
+
+	if rolled > 0 || expired > 0 {
+		log.Printf("遥测数据汇总完成: 汇总原始采样 %d 条，清理过期汇总 %d 条", rolled, expired)
+	}
+}
+
+// QueryTelemetry 查询时间范围内的遥测数据，按 maxPoints 等间隔降采样
+func (s *TelemetryService) QueryTelemetry(sn string, from, to int64, fields []string, maxPoints int) ([]map[string]interface{}, int64, error) {
+	columns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		columns = append(columns, telemetryFieldColumns[field])
+	}
+	columnList := strings.Join(columns, ", ")
+
+	query := fmt.Sprintf(`SELECT ts, %[1]s FROM telemetry_samples WHERE sn = ? AND ts BETWEEN ? AND ?
+		UNION ALL
+		SELECT ts, %[1]s FROM telemetry_rollups WHERE sn = ? AND ts BETWEEN ? AND ?
+		ORDER BY ts`, columnList)
+
+	rows, err := s.db.Query(query, sn, from, to, sn, from, to)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	bucket := int64(0)
+	if maxPoints > 0 && to > from {
+		bucket = (to - from) / int64(maxPoints)
+	}
+
+	points := []map[string]interface{}{}
+	lastBucket := int64(-1)
+	for rows.Next() {
+		values := make([]interface{}, len(fields)+1)
+		var ts int64
+		values[0] = &ts
+		raw := make([]*float64, len(fields))
+		for i := range fields {
+			values[i+1] = &raw[i]
+		}
+		if err := rows.Scan(values...); err != nil {
+			return nil, 0, err
+		}
+
+		// 每个时间桶只保留第一条采样，保证轨迹点是真实上报的位置
+		if bucket > 0 {
+			current := (ts - from) / bucket
+			if current == lastBucket {
+				continue
+			}
+			lastBucket = current
+		}
+
+		point := map[string]interface{}{"timestamp": ts}
+		for i, field := range fields {
+			if raw[i] != nil {
+				point[field] = *raw[i]
+			}
+		}
+		points = append(points, point)
+	}
+
+	return points, bucket, rows.Err()
+}
+
+// 查询设备遥测历史
+func (s *TelemetryService) GetTelemetryHistory(sn string, from, to int64, fieldsParam string, maxPoints int) (*models.APIResponse, error) {
+	if to <= 0 {
+		to = time.Now().UnixMilli()
+	}
+	if from <= 0 {
+		from = to - int64(time.Hour/time.Millisecond)
+	}
+	if from > to {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "开始时间不能晚于结束时间",
+		}, nil
+	}
+
+	fields, err := parseTelemetryFields(fieldsParam)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: err.Error(),
+		}, nil
+	}
+
+	if maxPoints <= 0 {
+		maxPoints = telemetryDefaultPoints
+	}
+	if maxPoints > telemetryMaxPoints {
+		maxPoints = telemetryMaxPoints
+	}
+
+	// 先写入缓冲中的采样，保证查询到最新数据
+	s.flush()
+
+	points, bucket, err := s.QueryTelemetry(sn, from, to, fields, maxPoints)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("查询遥测历史失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"sn":       sn,
+			"from":     from,
+			"to":       to,
+			"fields":   fields,
+			"interval": bucket,
+			"points":   points,
+		},
+	}, nil
+}
+
+// parseTelemetryFields 解析逗号分隔的字段列表，为空时返回全部字段
+func parseTelemetryFields(fieldsParam string) ([]string, error) {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(fieldsParam, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || seen[field] {
+			continue
+		}
+		if _, ok := telemetryFieldColumns[field]; !ok {
+			return nil, fmt.Errorf("不支持的字段: %s", field)
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+
+	if len(fields) == 0 {
+		for field := range telemetryFieldColumns {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+	}
+	return fields, nil
+}
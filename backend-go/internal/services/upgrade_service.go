@@ -0,0 +1,661 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	UpgradeStatusCreated    = "created"
+	UpgradeStatusSent       = "sent"
+	UpgradeStatusInProgress = "in_progress"
+	UpgradeStatusSucceeded  = "succeeded"
+	UpgradeStatusFailed     = "failed"
+)
+
+const (
+	// 设备升级中，请勿重复操作
+	errorCodeUpgrading = 312014
+	// 机场业务繁忙无法进行设备升级
+	errorCodeUpgradeBusy = 312015
+)
+
+const (
+	upgradeCheckInterval   = time.Minute
+	upgradeProgressTimeout = time.Hour
+	upgradeDefaultType     = 3
+	// 超过该时长未更新的osd快照不作为繁忙判断依据
+	upgradeSnapshotMaxAge = 2 * time.Minute
+)
+
+// 机场 mode_code：1 现场调试，2 远程调试，3 固件升级中，4 作业中
+const (
+	dockModeOnsiteDebug = 1
+	dockModeRemoteDebug = 2
+	dockModeUpgrading   = 3
+	dockModeWorking     = 4
+)
+
+var dockBusyModeCodes = map[int]bool{1: true, 2: true, 4: true}
+
+// upgradeProgressOutput ota_progress 事件的 output
+type upgradeProgressOutput struct {
+	Status   string `json:"status"`
+	Progress struct {
+		Percent     int         `json:"percent"`
+		CurrentStep interface{} `json:"current_step"`
+	} `json:"progress"`
+}
+
+// UpgradeService 固件包管理与远程升级任务编排
+type UpgradeService struct {
+	db               *database.DB
+	ingestService    *IngestService
+	commandService   *CommandService
+	deviceService    *DeviceService
+	errorCodeService *ErrorCodeService
+
+	// 进行中的升级任务: bid -> 任务ID
+	active    map[string]string
+	lastEvent map[string]time.Time
+	mutex     sync.Mutex
+	// 串行化繁忙检查与任务写入，避免并发请求为同一设备重复创建任务
+	createMutex sync.Mutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewUpgradeService 创建固件升级服务
+func NewUpgradeService(db *database.DB, ingestService *IngestService, commandService *CommandService,
+	deviceService *DeviceService, errorCodeService *ErrorCodeService) *UpgradeService {
+	s := &UpgradeService{
+		db:               db,
+		ingestService:    ingestService,
+		commandService:   commandService,
+		deviceService:    deviceService,
+		errorCodeService: errorCodeService,
+		active:           make(map[string]string),
+		lastEvent:        make(map[string]time.Time),
+		stopCh:           make(chan struct{}),
+	}
+
+	ingestService.RegisterHandler(TopicEvents, 1, s.handleEvent)
+
+	return s
+}
+
+// Start 恢复进行中的升级任务并启动超时检测
+func (s *UpgradeService) Start() {
+	now := time.Now()
+
+	// 重启前尚未下发的任务无法恢复，直接标记失败
+	_, err := s.db.Exec("UPDATE upgrade_jobs SET status = ?, error_message = ?, updated_at = ?, finished_at = ? WHERE status = ?",
+		UpgradeStatusFailed, "服务重启，任务未下发", now.UnixMilli(), now.UnixMilli(), UpgradeStatusCreated)
+	if err != nil {
+		log.Printf("更新升级任务失败: %v", err)
+	}
+
+	rows, err := s.db.Query("SELECT id, bid FROM upgrade_jobs WHERE status IN (?, ?) AND bid != ''",
+		UpgradeStatusSent, UpgradeStatusInProgress)
+	if err != nil {
+		log.Printf("加载升级任务失败: %v", err)
+	} else {
+		s.mutex.Lock()
+		for rows.Next() {
+			var id, bid string
+			if err := rows.Scan(&id, &bid); err != nil {
+				log.Printf("加载升级任务失败: %v", err)
+				break
+			}
+			s.active[bid] = id
+			s.lastEvent[bid] = now
+		}
+		s.mutex.Unlock()
+		rows.Close()
+	}
+
+	go s.run()
+}
+
+// Stop 停止超时检测
+func (s *UpgradeService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+func (s *UpgradeService) run() {
+	ticker := time.NewTicker(upgradeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.checkTimeouts()
+		}
+	}
+}
+
+// 获取固件包列表
+func (s *UpgradeService) GetPackages() (*models.APIResponse, error) {
+	rows, err := s.db.Query(`SELECT id, file_name, product_version, device_type, device_model, file_url, file_size, md5,
+		firmware_upgrade_type, release_note, created_at FROM firmware_packages ORDER BY created_at DESC`)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取固件包列表失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	packages := []models.FirmwarePackage{}
+	for rows.Next() {
+		var pkg models.FirmwarePackage
+		err := rows.Scan(&pkg.ID, &pkg.FileName, &pkg.ProductVersion, &pkg.DeviceType, &pkg.DeviceModel, &pkg.FileURL,
+			&pkg.FileSize, &pkg.MD5, &pkg.FirmwareUpgradeType, &pkg.ReleaseNote, &pkg.CreatedAt)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描固件包数据失败: %v", err),
+			}, err
+		}
+		packages = append(packages, pkg)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    packages,
+	}, nil
+}
+
+// 创建固件包
+func (s *UpgradeService) CreatePackage(payload *models.FirmwarePackagePayload) (*models.APIResponse, error) {
+	pkg := models.FirmwarePackage{
+		ID:                  uuid.New().String(),
+		FileName:            payload.FileName,
+		ProductVersion:      payload.ProductVersion,
+		DeviceType:          payload.DeviceType,
+		DeviceModel:         payload.DeviceModel,
+		FileURL:             payload.FileURL,
+		FileSize:            payload.FileSize,
+		MD5:                 payload.MD5,
+		FirmwareUpgradeType: payload.FirmwareUpgradeType,
+		ReleaseNote:         payload.ReleaseNote,
+		CreatedAt:           time.Now().UnixMilli(),
+	}
+	if pkg.FirmwareUpgradeType == 0 {
+		pkg.FirmwareUpgradeType = upgradeDefaultType
+	}
+
+	_, err := s.db.Exec(`INSERT INTO firmware_packages (id, file_name, product_version, device_type, device_model, file_url,
+		file_size, md5, firmware_upgrade_type, release_note, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		pkg.ID, pkg.FileName, pkg.ProductVersion, pkg.DeviceType, pkg.DeviceModel, pkg.FileURL, pkg.FileSize, pkg.MD5,
+		pkg.FirmwareUpgradeType, pkg.ReleaseNote, pkg.CreatedAt)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建固件包失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "固件包创建成功",
+		Data:    pkg,
+	}, nil
+}
+
+// 删除固件包
+func (s *UpgradeService) DeletePackage(packageID string) (*models.APIResponse, error) {
+	result, err := s.db.Exec("DELETE FROM firmware_packages WHERE id = ?", packageID)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("删除固件包失败: %v", err),
+		}, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取删除结果失败: %v", err),
+		}, err
+	}
+
+	if rowsAffected == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "固件包不存在",
+		}, nil
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "固件包删除成功",
+	}, nil
+}
+
+// getPackage 按ID获取固件包
+func (s *UpgradeService) getPackage(packageID string) (*models.FirmwarePackage, error) {
+	var pkg models.FirmwarePackage
+	err := s.db.QueryRow(`SELECT id, file_name, product_version, device_type, device_model, file_url, file_size, md5,
+		firmware_upgrade_type, release_note, created_at FROM firmware_packages WHERE id = ?`, packageID).Scan(
+		&pkg.ID, &pkg.FileName, &pkg.ProductVersion, &pkg.DeviceType, &pkg.DeviceModel, &pkg.FileURL,
+		&pkg.FileSize, &pkg.MD5, &pkg.FirmwareUpgradeType, &pkg.ReleaseNote, &pkg.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+// checkBusy 检查设备能否升级，不能升级时返回对应错误码
+func (s *UpgradeService) checkBusy(deviceSN, gatewaySN string) (int, error) {
+	var active int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM upgrade_jobs WHERE device_sn = ? AND status IN (?, ?, ?)",
+		deviceSN, UpgradeStatusCreated, UpgradeStatusSent, UpgradeStatusInProgress).Scan(&active)
+	if err != nil {
+		return 0, err
+	}
+	if active > 0 {
+		return errorCodeUpgrading, nil
+	}
+
+	freshAfter := time.Now().Add(-upgradeSnapshotMaxAge).UnixMilli()
+
+	if snapshot, ok := s.ingestService.GetSnapshot(gatewaySN); ok && snapshot.Dock != nil && snapshot.OSDUpdatedAt >= freshAfter {
+		if snapshot.Dock.ModeCode == dockModeUpgrading {
+			return errorCodeUpgrading, nil
+		}
+		if dockBusyModeCodes[snapshot.Dock.ModeCode] {
+			return errorCodeUpgradeBusy, nil
+		}
+	}
+
+	if snapshot, ok := s.ingestService.GetSnapshot(deviceSN); ok && snapshot.Aircraft != nil && snapshot.OSDUpdatedAt >= freshAfter {
+		if isAircraftInSky(snapshot.Aircraft) {
+			return errorCodeUpgradeBusy, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// 创建升级任务，每台设备一个任务，设备繁忙时拒绝
+func (s *UpgradeService) CreateJobs(payload *models.UpgradeJobPayload) (*models.APIResponse, error) {
+	pkg, err := s.getPackage(payload.PackageID)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "固件包不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取固件包失败: %v", err),
+		}, err
+	}
+
+	if !s.ingestService.IsConnected() {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "后端MQTT未连接",
+		}, nil
+	}
+
+	upgradeType := payload.FirmwareUpgradeType
+	if upgradeType == 0 {
+		upgradeType = pkg.FirmwareUpgradeType
+	}
+
+	jobs := []models.UpgradeJob{}
+	rejected := []map[string]interface{}{}
+	seen := make(map[string]bool)
+
+	s.createMutex.Lock()
+	defer s.createMutex.Unlock()
+
+	for _, deviceSN := range payload.DeviceSNs {
+		deviceSN = strings.TrimSpace(deviceSN)
+		if deviceSN == "" || seen[deviceSN] {
+			continue
+		}
+		seen[deviceSN] = true
+
+		gatewaySN, err := s.deviceService.GetGatewaySN(deviceSN)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("获取设备网关失败: %v", err),
+			}, err
+		}
+
+		code, err := s.checkBusy(deviceSN, gatewaySN)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("检查设备状态失败: %v", err),
+			}, err
+		}
+		if code != 0 {
+			rejected = append(rejected, map[string]interface{}{
+				"device_sn":     deviceSN,
+				"result":        code,
+				"error_message": s.errorCodeService.FormatMessage(code, gatewaySN),
+			})
+			continue
+		}
+
+		now := time.Now().UnixMilli()
+		job := models.UpgradeJob{
+			ID:                  uuid.New().String(),
+			DeviceSN:            deviceSN,
+			GatewaySN:           gatewaySN,
+			PackageID:           pkg.ID,
+			ProductVersion:      pkg.ProductVersion,
+			FirmwareUpgradeType: upgradeType,
+			Status:              UpgradeStatusCreated,
+			Operator:            payload.Operator,
+			CreatedAt:           now,
+			UpdatedAt:           now,
+		}
+
+		_, err = s.db.Exec(`INSERT INTO upgrade_jobs (id, device_sn, gateway_sn, package_id, product_version,
+			firmware_upgrade_type, status, operator, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			job.ID, job.DeviceSN, job.GatewaySN, job.PackageID, job.ProductVersion, job.FirmwareUpgradeType,
+			job.Status, job.Operator, job.CreatedAt, job.UpdatedAt)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("创建升级任务失败: %v", err),
+			}, err
+		}
+
+		jobs = append(jobs, job)
+		go s.sendJob(job, pkg)
+	}
+
+	response := &models.APIResponse{
+		Code:    0,
+		Message: fmt.Sprintf("已创建 %d 个升级任务", len(jobs)),
+		Data: map[string]interface{}{
+			"jobs":     jobs,
+			"rejected": rejected,
+		},
+	}
+	if len(jobs) == 0 {
+		response.Code = 1
+		response.Message = "没有可升级的设备"
+		if len(rejected) > 0 {
+			response.Message = fmt.Sprint(rejected[0]["error_message"])
+		}
+	}
+	return response, nil
+}
+
+// sendJob 向网关下发 ota_create 并根据 services_reply 更新任务状态
+func (s *UpgradeService) sendJob(job models.UpgradeJob, pkg *models.FirmwarePackage) {
+	device := map[string]interface{}{
+		"sn":                    job.DeviceSN,
+		"product_version":       job.ProductVersion,
+		"file_url":              pkg.FileURL,
+		"file_name":             pkg.FileName,
+		"file_size":             pkg.FileSize,
+		"md5":                   pkg.MD5,
+		"firmware_upgrade_type": job.FirmwareUpgradeType,
+	}
+	data, err := json.Marshal(map[string]interface{}{"devices": []interface{}{device}})
+	if err != nil {
+		s.failJob(job.ID, "", nil, fmt.Sprintf("构造升级指令失败: %v", err))
+		return
+	}
+
+	msg := NewServiceMessage("ota_create", data)
+
+	// 先登记 bid，避免进度事件先于回复处理时丢失
+	s.mutex.Lock()
+	s.active[msg.BID] = job.ID
+	s.lastEvent[msg.BID] = time.Now()
+	s.mutex.Unlock()
+
+	_, err = s.db.Exec("UPDATE upgrade_jobs SET status = ?, tid = ?, bid = ?, updated_at = ? WHERE id = ?",
+		UpgradeStatusSent, msg.TID, msg.BID, time.Now().UnixMilli(), job.ID)
+	if err != nil {
+		log.Printf("更新升级任务失败 %s: %v", job.ID, err)
+	}
+
+	reply, err := s.commandService.Send(job.GatewaySN, msg, 0)
+	if err != nil {
+		s.failJob(job.ID, msg.BID, nil, fmt.Sprintf("下发升级指令失败: %v", err))
+		return
+	}
+	if reply.Result != 0 {
+		result := reply.Result
+		s.failJob(job.ID, msg.BID, &result, reply.ErrorMessage)
+		return
+	}
+
+	// 进度事件可能已将任务推进，仅在仍为 sent 时更新
+	_, err = s.db.Exec("UPDATE upgrade_jobs SET status = ?, result = 0, updated_at = ? WHERE id = ? AND status = ?",
+		UpgradeStatusInProgress, time.Now().UnixMilli(), job.ID, UpgradeStatusSent)
+	if err != nil {
+		log.Printf("更新升级任务失败 %s: %v", job.ID, err)
+	}
+}
+
+// failJob 将任务标记为失败并停止跟踪
+func (s *UpgradeService) failJob(jobID, bid string, result *int, message string) {
+	if bid != "" {
+		s.mutex.Lock()
+		delete(s.active, bid)
+		delete(s.lastEvent, bid)
+		s.mutex.Unlock()
+	}
+
+	now := time.Now().UnixMilli()
+	_, err := s.db.Exec(`UPDATE upgrade_jobs SET status = ?, result = COALESCE(?, result), error_message = ?, updated_at = ?,
+		finished_at = ? WHERE id = ?`, UpgradeStatusFailed, result, message, now, now, jobID)
+	if err != nil {
+		log.Printf("更新升级任务失败 %s: %v", jobID, err)
+	}
+}
+
+// handleEvent 处理 ota_progress 进度事件
+func (s *UpgradeService) handleEvent(sn string, msg *models.DJIMessage) {
+	if msg.Method != "ota_progress" {
+		return
+	}
+
+	s.mutex.Lock()
+	jobID, ok := s.active[msg.BID]
+	if ok {
+		s.lastEvent[msg.BID] = time.Now()
+	}
+	s.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	var event serviceProgressEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		log.Printf("解析升级进度失败 %s: %v", sn, err)
+		return
+	}
+	var output upgradeProgressOutput
+	json.Unmarshal(event.Output, &output)
+
+	currentStep := ""
+	if output.Progress.CurrentStep != nil {
+		currentStep = fmt.Sprint(output.Progress.CurrentStep)
+	}
+
+	status := UpgradeStatusInProgress
+	switch progressJobStatus(event.Result, output.Status) {
+	case ServiceJobSucceeded:
+		status = UpgradeStatusSucceeded
+	case ServiceJobFailed:
+		status = UpgradeStatusFailed
+	}
+
+	now := time.Now().UnixMilli()
+	var finishedAt interface{}
+	if status != UpgradeStatusInProgress {
+		finishedAt = now
+		s.mutex.Lock()
+		delete(s.active, msg.BID)
+		delete(s.lastEvent, msg.BID)
+		s.mutex.Unlock()
+	}
+
+	_, err := s.db.Exec(`UPDATE upgrade_jobs SET status = ?, progress = ?, current_step = ?, result = ?, error_message = ?,
+		updated_at = ?, finished_at = COALESCE(?, finished_at) WHERE id = ?`,
+		status, output.Progress.Percent, currentStep, event.Result, event.ErrorMessage, now, finishedAt, jobID)
+	if err != nil {
+		log.Printf("更新升级进度失败 %s: %v", jobID, err)
+	}
+}
+
+// checkTimeouts 长时间无进度上报的任务标记为失败
+func (s *UpgradeService) checkTimeouts() {
+	now := time.Now()
+
+	s.mutex.Lock()
+	expired := make(map[string]string)
+	for bid, last := range s.lastEvent {
+		if now.Sub(last) > upgradeProgressTimeout {
+			expired[bid] = s.active[bid]
+		}
+	}
+	s.mutex.Unlock()
+
+	for bid, jobID := range expired {
+		s.failJob(jobID, bid, nil, "升级进度上报超时")
+	}
+}
+
+const upgradeJobColumns = `id, device_sn, gateway_sn, package_id, product_version, firmware_upgrade_type, status, tid, bid,
+	progress, current_step, result, error_message, operator, created_at, updated_at, finished_at`
+
+// scanUpgradeJob 扫描升级任务记录
+func scanUpgradeJob(scanner interface{ Scan(...interface{}) error }) (models.UpgradeJob, error) {
+	var job models.UpgradeJob
+	var result, finishedAt sql.NullInt64
+
+	err := scanner.Scan(&job.ID, &job.DeviceSN, &job.GatewaySN, &job.PackageID, &job.ProductVersion,
+		&job.FirmwareUpgradeType, &job.Status, &job.TID, &job.BID, &job.Progress, &job.CurrentStep, &result,
+		&job.ErrorMessage, &job.Operator, &job.CreatedAt, &job.UpdatedAt, &finishedAt)
+	if err != nil {
+		return job, err
+	}
+
+	if result.Valid {
+		value := int(result.Int64)
+		job.Result = &value
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Int64
+	}
+	return job, nil
+}
+
+// 获取升级任务列表
+func (s *UpgradeService) GetJobs(query *models.UpgradeJobQuery) (*models.APIResponse, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if query.SN != "" {
+		conditions = append(conditions, "(device_sn = ? OR gateway_sn = ?)")
+		args = append(args, query.SN, query.SN)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, query.Status)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM upgrade_jobs "+where, args...).Scan(&total); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取升级任务失败: %v", err),
+		}, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	rows, err := s.db.Query("SELECT "+upgradeJobColumns+" FROM upgrade_jobs "+where+
+		" ORDER BY created_at DESC LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取升级任务失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	jobs := []models.UpgradeJob{}
+	for rows.Next() {
+		job, err := scanUpgradeJob(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描升级任务失败: %v", err),
+			}, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+			"items":    jobs,
+		},
+	}, nil
+}
+
+// 获取升级任务详情
+func (s *UpgradeService) GetJob(jobID string) (*models.APIResponse, error) {
+	job, err := scanUpgradeJob(s.db.QueryRow("SELECT "+upgradeJobColumns+" FROM upgrade_jobs WHERE id = ?", jobID))
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "升级任务不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取升级任务失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    job,
+	}, nil
+}
@@ -0,0 +1,967 @@
+package services
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"drone-patrol-backend/internal/database"
+	"drone-patrol-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	FlightTaskStatusCreated   = "created"
+	FlightTaskStatusPrepared  = "prepared"
+	FlightTaskStatusExecuting = "executing"
+	FlightTaskStatusSucceeded = "succeeded"
+	FlightTaskStatusFailed    = "failed"
+	FlightTaskStatusCanceled  = "canceled"
+	// 任务中途中断，仅完成部分航点
+	FlightTaskStatusPartiallyDone = "partially_done"
+)
+
+const (
+	// 设备当前无法支持该操作
+	errorCodeFlightTaskBusy = 314000
+	// 航线文件格式不兼容
+	errorCodeWaylineIncompatible = 314003
+)
+
+const (
+	// WaylineMaxFileSize 航线文件大小上限
+	WaylineMaxFileSize = 50 << 20
+
+	waylineCheckInterval      = 5 * time.Second
+	waylineProgressTimeout    = 10 * time.Minute
+	waylineDefaultRthAltitude = 100
+)
+
+// flightTaskProgressOutput flighttask_progress 事件的 output
+type flightTaskProgressOutput struct {
+	Status   string `json:"status"`
+	Progress struct {
+		Percent     int         `json:"percent"`
+		CurrentStep interface{} `json:"current_step"`
+	} `json:"progress"`
+	Ext struct {
+		FlightID             string `json:"flight_id"`
+		CurrentWaypointIndex int    `json:"current_waypoint_index"`
+		MediaCount           int    `json:"media_count"`
+		WaylineMissionState  int    `json:"wayline_mission_state"`
+	} `json:"ext"`
+}
+
+// WaylineService 航线文件管理与航线任务下发
+type WaylineService struct {
+	db               *database.DB
+	ingestService    *IngestService
+	commandService   *CommandService
+	errorCodeService *ErrorCodeService
+	dir              string
+	baseURL          string
+
+	// 执行中的任务最近一次进度时间: flight_id -> 时间
+	active map[string]time.Time
+	mutex  sync.Mutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewWaylineService 创建航线服务
+func NewWaylineService(db *database.DB, ingestService *IngestService, commandService *CommandService,
+	errorCodeService *ErrorCodeService, dir, baseURL string) *WaylineService {
+	s := &WaylineService{
+		db:               db,
+		ingestService:    ingestService,
+		commandService:   commandService,
+		errorCodeService: errorCodeService,
+		dir:              dir,
+		baseURL:          strings.TrimRight(baseURL, "/"),
+		active:           make(map[string]time.Time),
+		stopCh:           make(chan struct{}),
+	}
+
+	ingestService.RegisterHandler(TopicEvents, 1, s.handleEvent)
+
+	return s
+}
+
+// Start 恢复执行中的任务并启动定时执行与超时检测
+func (s *WaylineService) Start() {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		log.Printf("创建航线目录失败: %v", err)
+	}
+
+	now := time.Now()
+
+	// 重启前尚未完成下发的任务无法恢复，直接标记失败
+	_, err := s.db.Exec("UPDATE flight_tasks SET status = ?, error_message = ?, updated_at = ?, finished_at = ? WHERE status = ?",
+		FlightTaskStatusFailed, "服务重启，任务未下发", now.UnixMilli(), now.UnixMilli(), FlightTaskStatusCreated)
+	if err != nil {
+		log.Printf("更新航线任务失败: %v", err)
+	}
+
+	rows, err := s.db.Query("SELECT id FROM flight_tasks WHERE status = ?", FlightTaskStatusExecuting)
+	if err != nil {
+		log.Printf("加载航线任务失败: %v", err)
+	} else {
+		s.mutex.Lock()
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				log.Printf("加载航线任务失败: %v", err)
+				break
+			}
+			s.active[id] = now
+		}
+		s.mutex.Unlock()
+		rows.Close()
+	}
+
+	go s.run()
+}
+
+// Stop 停止后台检测
+func (s *WaylineService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+func (s *WaylineService) run() {
+	ticker := time.NewTicker(waylineCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.executeDueTasks()
+			s.checkTimeouts()
+		}
+	}
+}
+
+// filePath 航线文件在本地的存储路径
+func (s *WaylineService) filePath(waylineID string) string {
+	return filepath.Join(s.dir, waylineID+".kmz")
+}
+
+// fileURL 设备下载航线文件的地址
+func (s *WaylineService) fileURL(waylineID string) string {
+	return s.baseURL + "/api/waylines/" + waylineID + "/file"
+}
+
+// 上传航线文件，校验结构并提取航点信息
+func (s *WaylineService) CreateWayline(name, fileName, operator string, data []byte) (*models.APIResponse, error) {
+	wayline, err := parseKMZ(data)
+	var parseErr *wpmlError
+	if errors.As(err, &parseErr) {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "航线文件校验失败: " + parseErr.Error(),
+			Data:    map[string]interface{}{"problems": parseErr.problems},
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("解析航线文件失败: %v", err),
+		}, err
+	}
+
+	sum := md5.Sum(data)
+	wayline.ID = uuid.New().String()
+	wayline.Name = strings.TrimSpace(name)
+	if wayline.Name == "" {
+		wayline.Name = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	}
+	wayline.FileName = fileName
+	wayline.FileSize = int64(len(data))
+	wayline.MD5 = hex.EncodeToString(sum[:])
+	wayline.Operator = operator
+	wayline.CreatedAt = time.Now().UnixMilli()
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("保存航线文件失败: %v", err),
+		}, err
+	}
+	if err := os.WriteFile(s.filePath(wayline.ID), data, 0644); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("保存航线文件失败: %v", err),
+		}, err
+	}
+
+	payloadKeys, _ := json.Marshal(wayline.PayloadModelKeys)
+	waypoints, _ := json.Marshal(wayline.Waypoints)
+
+	_, err = s.db.Exec(`INSERT INTO waylines (id, name, file_name, file_size, md5, template_type, drone_model_key,
+		payload_model_keys, waypoint_count, distance, duration, min_height, max_height, height_mode, finish_action,
+		waypoints, operator, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		wayline.ID, wayline.Name, wayline.FileName, wayline.FileSize, wayline.MD5, wayline.TemplateType,
+		wayline.DroneModelKey, string(payloadKeys), wayline.WaypointCount, wayline.Distance, wayline.Duration,
+		wayline.MinHeight, wayline.MaxHeight, wayline.HeightMode, wayline.FinishAction, string(waypoints),
+		wayline.Operator, wayline.CreatedAt)
+	if err != nil {
+		os.Remove(s.filePath(wayline.ID))
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("保存航线失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "航线上传成功",
+		Data:    wayline,
+	}, nil
+}
+
+const waylineColumns = `id, name, file_name, file_size, md5, template_type, drone_model_key, payload_model_keys,
+	waypoint_count, distance, duration, min_height, max_height, height_mode, finish_action, operator, created_at`
+
+// scanWayline 扫描航线记录，不含航点
+func scanWayline(scanner interface{ Scan(...interface{}) error }) (models.Wayline, error) {
+	var wayline models.Wayline
+	var payloadKeys string
+
+	err := scanner.Scan(&wayline.ID, &wayline.Name, &wayline.FileName, &wayline.FileSize, &wayline.MD5,
+		&wayline.TemplateType, &wayline.DroneModelKey, &payloadKeys, &wayline.WaypointCount, &wayline.Distance,
+		&wayline.Duration, &wayline.MinHeight, &wayline.MaxHeight, &wayline.HeightMode, &wayline.FinishAction,
+		&wayline.Operator, &wayline.CreatedAt)
+	if err != nil {
+		return wayline, err
+	}
+
+	wayline.PayloadModelKeys = []string{}
+	json.Unmarshal([]byte(payloadKeys), &wayline.PayloadModelKeys)
+	return wayline, nil
+}
+
+// getWayline 获取航线，可选加载航点
+func (s *WaylineService) getWayline(waylineID string, withWaypoints bool) (*models.Wayline, error) {
+	wayline, err := scanWayline(s.db.QueryRow("SELECT "+waylineColumns+" FROM waylines WHERE id = ?", waylineID))
+	if err != nil {
+		return nil, err
+	}
+
+	if withWaypoints {
+		var waypoints string
+		if err := s.db.QueryRow("SELECT waypoints FROM waylines WHERE id = ?", waylineID).Scan(&waypoints); err != nil {
+			return nil, err
+		}
+		wayline.Waypoints = []models.WaylineWaypoint{}
+		json.Unmarshal([]byte(waypoints), &wayline.Waypoints)
+	}
+	return &wayline, nil
+}
+
+// 获取航线列表
+func (s *WaylineService) GetWaylines(query *models.WaylineQuery) (*models.APIResponse, error) {
+	where := ""
+	args := []interface{}{}
+	if query.Q != "" {
+		where = "WHERE name LIKE ? OR file_name LIKE ?"
+		args = append(args, "%"+query.Q+"%", "%"+query.Q+"%")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM waylines "+where, args...).Scan(&total); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取航线失败: %v", err),
+		}, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	rows, err := s.db.Query("SELECT "+waylineColumns+" FROM waylines "+where+
+		" ORDER BY created_at DESC LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取航线失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	waylines := []models.Wayline{}
+	for rows.Next() {
+		wayline, err := scanWayline(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描航线失败: %v", err),
+			}, err
+		}
+		waylines = append(waylines, wayline)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+			"items":    waylines,
+		},
+	}, nil
+}
+
+// 获取航线详情，包含航点
+func (s *WaylineService) GetWayline(waylineID string) (*models.APIResponse, error) {
+	wayline, err := s.getWayline(waylineID, true)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "航线不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取航线失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    wayline,
+	}, nil
+}
+
+// 删除航线，存在未结束的任务时拒绝
+func (s *WaylineService) DeleteWayline(waylineID string) (*models.APIResponse, error) {
+	var active int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM flight_tasks WHERE wayline_id = ? AND status IN (?, ?, ?)",
+		waylineID, FlightTaskStatusCreated, FlightTaskStatusPrepared, FlightTaskStatusExecuting).Scan(&active)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("删除航线失败: %v", err),
+		}, err
+	}
+	if active > 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "航线存在未结束的任务，无法删除",
+		}, nil
+	}
+
+	result, err := s.db.Exec("DELETE FROM waylines WHERE id = ?", waylineID)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("删除航线失败: %v", err),
+		}, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "航线不存在",
+		}, nil
+	}
+
+	if err := os.Remove(s.filePath(waylineID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("删除航线文件失败 %s: %v", waylineID, err)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "航线删除成功",
+	}, nil
+}
+
+// GetWaylineFile 获取航线文件的本地路径与文件名，不存在时返回错误响应
+func (s *WaylineService) GetWaylineFile(waylineID string) (string, string, *models.APIResponse, error) {
+	wayline, err := s.getWayline(waylineID, false)
+	if err == sql.ErrNoRows {
+		return "", "", &models.APIResponse{
+			Code:    1,
+			Message: "航线不存在",
+		}, nil
+	}
+	if err != nil {
+		return "", "", &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取航线失败: %v", err),
+		}, err
+	}
+	return s.filePath(wayline.ID), wayline.FileName, nil, nil
+}
+
+// checkDockBusy 检查机场能否执行航线任务，不能执行时返回对应错误码
+func (s *WaylineService) checkDockBusy(dockSN string) (int, error) {
+	var executing int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM flight_tasks WHERE dock_sn = ? AND status = ?",
+		dockSN, FlightTaskStatusExecuting).Scan(&executing)
+	if err != nil {
+		return 0, err
+	}
+	if executing > 0 {
+		return errorCodeFlightTaskBusy, nil
+	}
+
+	freshAfter := time.Now().Add(-upgradeSnapshotMaxAge).UnixMilli()
+	if snapshot, ok := s.ingestService.GetSnapshot(dockSN); ok && snapshot.Dock != nil && snapshot.OSDUpdatedAt >= freshAfter {
+		if snapshot.Dock.ModeCode == dockModeUpgrading || dockBusyModeCodes[snapshot.Dock.ModeCode] {
+			return errorCodeFlightTaskBusy, nil
+		}
+	}
+	return 0, nil
+}
+
+// 创建航线任务，下发 flighttask_prepare 后立即或按计划时间执行
+func (s *WaylineService) CreateTask(payload *models.FlightTaskPayload) (*models.APIResponse, error) {
+	wayline, err := s.getWayline(payload.WaylineID, false)
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "航线不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取航线失败: %v", err),
+		}, err
+	}
+
+	if !s.ingestService.IsConnected() {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "后端MQTT未连接",
+		}, nil
+	}
+
+	var exists int
+	err = s.db.QueryRow("SELECT COUNT(*) FROM devices WHERE sn = ?", payload.DockSN).Scan(&exists)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("查询设备失败: %v", err),
+		}, err
+	}
+	if exists == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "机场未登记",
+		}, nil
+	}
+
+	now := time.Now().UnixMilli()
+	executeTime := payload.ExecuteTime
+	if payload.TaskType == 0 {
+		executeTime = now
+	} else if executeTime <= now {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "定时任务的执行时间必须晚于当前时间",
+		}, nil
+	}
+
+	code, err := s.checkDockBusy(payload.DockSN)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("检查机场状态失败: %v", err),
+		}, err
+	}
+	if code != 0 && payload.TaskType == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: s.errorCodeService.FormatMessage(code, payload.DockSN),
+			Data:    map[string]interface{}{"result": code},
+		}, nil
+	}
+
+	rthAltitude := payload.RthAltitude
+	if rthAltitude == 0 {
+		rthAltitude = waylineDefaultRthAltitude
+	}
+
+	task := models.FlightTask{
+		ID:                    uuid.New().String(),
+		WaylineID:             wayline.ID,
+		DockSN:                payload.DockSN,
+		TaskType:              payload.TaskType,
+		ExecuteTime:           executeTime,
+		RthAltitude:           rthAltitude,
+		OutOfControlAction:    payload.OutOfControlAction,
+		ExitWaylineWhenRcLost: payload.ExitWaylineWhenRcLost,
+		Status:                FlightTaskStatusCreated,
+		Operator:              payload.Operator,
+		CreatedAt:             now,
+		UpdatedAt:             now,
+	}
+
+	_, err = s.db.Exec(`INSERT INTO flight_tasks (id, wayline_id, dock_sn, task_type, execute_time, rth_altitude,
+		out_of_control_action, exit_wayline_when_rc_lost, status, operator, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		task.ID, task.WaylineID, task.DockSN, task.TaskType, task.ExecuteTime, task.RthAltitude,
+		task.OutOfControlAction, task.ExitWaylineWhenRcLost, task.Status, task.Operator, task.CreatedAt, task.UpdatedAt)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("创建航线任务失败: %v", err),
+		}, err
+	}
+
+	go s.prepareTask(task, wayline)
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "航线任务已创建",
+		Data:    task,
+	}, nil
+}
+
+// prepareTask 下发 flighttask_prepare，立即任务在准备成功后直接执行
+func (s *WaylineService) prepareTask(task models.FlightTask, wayline *models.Wayline) {
+	data, err := json.Marshal(map[string]interface{}{
+		"flight_id":    task.ID,
+		"execute_time": task.ExecuteTime,
+		"task_type":    task.TaskType,
+		"wayline_type": 0,
+		"file": map[string]interface{}{
+			"url":         s.fileURL(wayline.ID),
+			"fingerprint": wayline.MD5,
+		},
+		"rth_altitude":              task.RthAltitude,
+		"out_of_control_action":     task.OutOfControlAction,
+		"exit_wayline_when_rc_lost": task.ExitWaylineWhenRcLost,
+	})
+	if err != nil {
+		s.failTask(task.ID, nil, fmt.Sprintf("构造任务指令失败: %v", err))
+		return
+	}
+
+	msg := NewServiceMessage("flighttask_prepare", data)
+	_, err = s.db.Exec("UPDATE flight_tasks SET tid = ?, bid = ?, updated_at = ? WHERE id = ?",
+		msg.TID, msg.BID, time.Now().UnixMilli(), task.ID)
+	if err != nil {
+		log.Printf("更新航线任务失败 %s: %v", task.ID, err)
+	}
+
+	reply, err := s.commandService.Send(task.DockSN, msg, 0)
+	if err != nil {
+		s.failTask(task.ID, nil, fmt.Sprintf("下发任务失败: %v", err))
+		return
+	}
+	if reply.Result != 0 {
+		result := reply.Result
+		s.failTask(task.ID, &result, reply.ErrorMessage)
+		return
+	}
+
+	_, err = s.db.Exec("UPDATE flight_tasks SET status = ?, result = 0, updated_at = ? WHERE id = ? AND status = ?",
+		FlightTaskStatusPrepared, time.Now().UnixMilli(), task.ID, FlightTaskStatusCreated)
+	if err != nil {
+		log.Printf("更新航线任务失败 %s: %v", task.ID, err)
+		return
+	}
+
+	if task.TaskType == 0 {
+		s.executeTask(task.ID, task.DockSN)
+	}
+}
+
+// executeTask 下发 flighttask_execute，同一任务只会被执行一次
+func (s *WaylineService) executeTask(taskID, dockSN string) {
+	result, err := s.db.Exec("UPDATE flight_tasks SET status = ?, updated_at = ? WHERE id = ? AND status = ?",
+		FlightTaskStatusExecuting, time.Now().UnixMilli(), taskID, FlightTaskStatusPrepared)
+	if err != nil {
+		log.Printf("更新航线任务失败 %s: %v", taskID, err)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return
+	}
+
+	// 先登记任务，避免进度事件先于回复处理时丢失
+	s.mutex.Lock()
+	s.active[taskID] = time.Now()
+	s.mutex.Unlock()
+
+	data, _ := json.Marshal(map[string]interface{}{"flight_id": taskID})
+	reply, err := s.commandService.Call(dockSN, "flighttask_execute", data, 0)
+	if err != nil {
+		s.failTask(taskID, nil, fmt.Sprintf("下发执行指令失败: %v", err))
+		return
+	}
+	if reply.Result != 0 {
+		result := reply.Result
+		s.failTask(taskID, &result, reply.ErrorMessage)
+	}
+}
+
+// executeDueTasks 执行到达计划时间的定时任务
+func (s *WaylineService) executeDueTasks() {
+	rows, err := s.db.Query("SELECT id, dock_sn FROM flight_tasks WHERE status = ? AND task_type = 1 AND execute_time <= ?",
+		FlightTaskStatusPrepared, time.Now().UnixMilli())
+	if err != nil {
+		log.Printf("查询定时航线任务失败: %v", err)
+		return
+	}
+
+	type dueTask struct{ id, dockSN string }
+	due := []dueTask{}
+	for rows.Next() {
+		var task dueTask
+		if err := rows.Scan(&task.id, &task.dockSN); err != nil {
+			log.Printf("查询定时航线任务失败: %v", err)
+			break
+		}
+		due = append(due, task)
+	}
+	rows.Close()
+
+	for _, task := range due {
+		go s.executeTask(task.id, task.dockSN)
+	}
+}
+
+// failureMessage 生成任务失败原因，航线不兼容时附加航线适用的机型与负载
+func (s *WaylineService) failureMessage(taskID string, result *int, message string) string {
+	if result == nil || *result != errorCodeWaylineIncompatible {
+		return message
+	}
+
+	var waylineID, dockSN string
+	if err := s.db.QueryRow("SELECT wayline_id, dock_sn FROM flight_tasks WHERE id = ?", taskID).Scan(&waylineID, &dockSN); err != nil {
+		return message
+	}
+
+	message = s.errorCodeService.FormatMessage(errorCodeWaylineIncompatible, dockSN)
+	wayline, err := s.getWayline(waylineID, false)
+	if err != nil {
+		return message
+	}
+
+	payloads := []string{}
+	for _, key := range wayline.PayloadModelKeys {
+		payloads = append(payloads, payloadModelName(key))
+	}
+	if len(payloads) == 0 {
+		payloads = append(payloads, "未指定")
+	}
+
+	return fmt.Sprintf("%s（航线适用机型: %s，负载: %s，模板: %s，请确认机场内飞行器与负载型号一致）",
+		message, droneModelName(wayline.DroneModelKey), strings.Join(payloads, "、"), wayline.TemplateType)
+}
+
+// failTask 将任务标记为失败并停止跟踪
+func (s *WaylineService) failTask(taskID string, result *int, message string) {
+	s.mutex.Lock()
+	delete(s.active, taskID)
+	s.mutex.Unlock()
+
+	message = s.failureMessage(taskID, result, message)
+
+	now := time.Now().UnixMilli()
+	_, err := s.db.Exec(`UPDATE flight_tasks SET status = ?, result = COALESCE(?, result), error_message = ?, updated_at = ?,
+		finished_at = ? WHERE id = ? AND status IN (?, ?, ?)`, FlightTaskStatusFailed, result, message, now, now, taskID,
+		FlightTaskStatusCreated, FlightTaskStatusPrepared, FlightTaskStatusExecuting)
+	if err != nil {
+		log.Printf("更新航线任务失败 %s: %v", taskID, err)
+	}
+}
+
+// handleEvent 处理 flighttask_progress 进度事件
+func (s *WaylineService) handleEvent(sn string, msg *models.DJIMessage) {
+	if msg.Method != "flighttask_progress" {
+		return
+	}
+
+	var event serviceProgressEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		log.Printf("解析航线任务进度失败 %s: %v", sn, err)
+		return
+	}
+	var output flightTaskProgressOutput
+	json.Unmarshal(event.Output, &output)
+
+	taskID := output.Ext.FlightID
+	s.mutex.Lock()
+	_, ok := s.active[taskID]
+	if ok {
+		s.active[taskID] = time.Now()
+	}
+	s.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	status := FlightTaskStatusExecuting
+	var message interface{}
+	switch {
+	case output.Status == "partially_done":
+		status = FlightTaskStatusPartiallyDone
+		message = "航线任务中断，仅完成部分航点"
+		if event.Result != 0 {
+			message = fmt.Sprintf("航线任务中断，仅完成部分航点: %s", s.errorCodeService.FormatMessage(event.Result, sn))
+		}
+	case output.Status == "canceled":
+		status = FlightTaskStatusCanceled
+	default:
+		switch progressJobStatus(event.Result, output.Status) {
+		case ServiceJobSucceeded:
+			status = FlightTaskStatusSucceeded
+		case ServiceJobFailed:
+			status = FlightTaskStatusFailed
+		}
+	}
+
+	if status == FlightTaskStatusFailed {
+		result := event.Result
+		if result == 0 {
+			s.failTask(taskID, nil, "航线任务执行失败: "+output.Status)
+			return
+		}
+		message := event.ErrorMessage
+		if message == "" {
+			message = s.errorCodeService.FormatMessage(result, sn)
+		}
+		s.failTask(taskID, &result, message)
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	var finishedAt interface{}
+	if status != FlightTaskStatusExecuting {
+		finishedAt = now
+		s.mutex.Lock()
+		delete(s.active, taskID)
+		s.mutex.Unlock()
+	}
+
+	_, err := s.db.Exec(`UPDATE flight_tasks SET status = ?, progress = ?, current_waypoint_index = ?, media_count = ?,
+		result = ?, error_message = COALESCE(?, error_message), updated_at = ?, finished_at = COALESCE(?, finished_at)
+		WHERE id = ?`,
+		status, output.Progress.Percent, output.Ext.CurrentWaypointIndex, output.Ext.MediaCount, event.Result,
+		message, now, finishedAt, taskID)
+	if err != nil {
+		log.Printf("更新航线任务进度失败 %s: %v", taskID, err)
+	}
+}
+
+// checkTimeouts 长时间无进度上报的任务标记为失败
+func (s *WaylineService) checkTimeouts() {
+	now := time.Now()
+
+	s.mutex.Lock()
+	expired := []string{}
+	for taskID, last := range s.active {
+		if now.Sub(last) > waylineProgressTimeout {
+			expired = append(expired, taskID)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, taskID := range expired {
+		s.failTask(taskID, nil, "航线任务进度上报超时")
+	}
+}
+
+// 取消尚未执行的航线任务，已下发的任务通过 flighttask_undo 撤销
+func (s *WaylineService) CancelTask(taskID string) (*models.APIResponse, error) {
+	task, err := scanFlightTask(s.db.QueryRow("SELECT "+flightTaskColumns+" FROM flight_tasks WHERE id = ?", taskID))
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "航线任务不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取航线任务失败: %v", err),
+		}, err
+	}
+	if task.Status != FlightTaskStatusPrepared {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "只能取消已下发且尚未执行的任务",
+		}, nil
+	}
+
+	data, _ := json.Marshal(map[string]interface{}{"flight_ids": []string{task.ID}})
+	reply, err := s.commandService.Call(task.DockSN, "flighttask_undo", data, 0)
+	if err == ErrServiceReplyTimeout {
+		return &models.APIResponse{
+			Code:    1,
+			Message: err.Error(),
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("取消航线任务失败: %v", err),
+		}, err
+	}
+	if reply.Result != 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: reply.ErrorMessage,
+			Data:    reply,
+		}, nil
+	}
+
+	now := time.Now().UnixMilli()
+	result, err := s.db.Exec("UPDATE flight_tasks SET status = ?, updated_at = ?, finished_at = ? WHERE id = ? AND status = ?",
+		FlightTaskStatusCanceled, now, now, task.ID, FlightTaskStatusPrepared)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("取消航线任务失败: %v", err),
+		}, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "任务已开始执行，无法取消",
+		}, nil
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "航线任务已取消",
+	}, nil
+}
+
+const flightTaskColumns = `id, wayline_id, dock_sn, task_type, execute_time, rth_altitude, out_of_control_action,
+	exit_wayline_when_rc_lost, status, tid, bid, progress, current_waypoint_index, media_count, result, error_message,
+	operator, created_at, updated_at, finished_at`
+
+// scanFlightTask 扫描航线任务记录
+func scanFlightTask(scanner interface{ Scan(...interface{}) error }) (models.FlightTask, error) {
+	var task models.FlightTask
+	var result, finishedAt sql.NullInt64
+
+	err := scanner.Scan(&task.ID, &task.WaylineID, &task.DockSN, &task.TaskType, &task.ExecuteTime, &task.RthAltitude,
+		&task.OutOfControlAction, &task.ExitWaylineWhenRcLost, &task.Status, &task.TID, &task.BID, &task.Progress,
+		&task.CurrentWaypointIndex, &task.MediaCount, &result, &task.ErrorMessage, &task.Operator, &task.CreatedAt,
+		&task.UpdatedAt, &finishedAt)
+	if err != nil {
+		return task, err
+	}
+
+	if result.Valid {
+		value := int(result.Int64)
+		task.Result = &value
+	}
+	if finishedAt.Valid {
+		task.FinishedAt = &finishedAt.Int64
+	}
+	return task, nil
+}
+
+// 获取航线任务列表
+func (s *WaylineService) GetTasks(query *models.FlightTaskQuery) (*models.APIResponse, error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if query.SN != "" {
+		conditions = append(conditions, "dock_sn = ?")
+		args = append(args, query.SN)
+	}
+	if query.WaylineID != "" {
+		conditions = append(conditions, "wayline_id = ?")
+		args = append(args, query.WaylineID)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, query.Status)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM flight_tasks "+where, args...).Scan(&total); err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取航线任务失败: %v", err),
+		}, err
+	}
+
+	page, pageSize := normalizePage(query.Page, query.PageSize)
+	args = append(args, pageSize, (page-1)*pageSize)
+
+	rows, err := s.db.Query("SELECT "+flightTaskColumns+" FROM flight_tasks "+where+
+		" ORDER BY created_at DESC LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取航线任务失败: %v", err),
+		}, err
+	}
+	defer rows.Close()
+
+	tasks := []models.FlightTask{}
+	for rows.Next() {
+		task, err := scanFlightTask(rows)
+		if err != nil {
+			return &models.APIResponse{
+				Code:    1,
+				Message: fmt.Sprintf("扫描航线任务失败: %v", err),
+			}, err
+		}
+		tasks = append(tasks, task)
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data: map[string]interface{}{
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+			"items":    tasks,
+		},
+	}, nil
+}
+
+// 获取航线任务详情
+func (s *WaylineService) GetTask(taskID string) (*models.APIResponse, error) {
+	task, err := scanFlightTask(s.db.QueryRow("SELECT "+flightTaskColumns+" FROM flight_tasks WHERE id = ?", taskID))
+	if err == sql.ErrNoRows {
+		return &models.APIResponse{
+			Code:    1,
+			Message: "航线任务不存在",
+		}, nil
+	}
+	if err != nil {
+		return &models.APIResponse{
+			Code:    1,
+			Message: fmt.Sprintf("获取航线任务失败: %v", err),
+		}, err
+	}
+
+	return &models.APIResponse{
+		Code:    0,
+		Message: "ok",
+		Data:    task,
+	}, nil
+}
@@ -0,0 +1,329 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"drone-patrol-backend/internal/models"
+)
+
+const (
+	wpmzTemplatePath = "wpmz/template.kml"
+	wpmzWaylinesPath = "wpmz/waylines.wpml"
+	// 单个文件解压上限，防止异常压缩包占用过多内存
+	wpmzMaxEntrySize = 32 << 20
+	// 起飞与降落等航线外耗时的粗略估计（秒）
+	wpmzOverheadSeconds = 120
+)
+
+// wpmlMissionConfig 航线文件中的 wpml:missionConfig
+type wpmlMissionConfig struct {
+	FlyToWaylineMode        string  `xml:"flyToWaylineMode"`
+	FinishAction            string  `xml:"finishAction"`
+	ExitOnRCLost            string  `xml:"exitOnRCLost"`
+	ExecuteRCLostAction     string  `xml:"executeRCLostAction"`
+	TakeOffSecurityHeight   float64 `xml:"takeOffSecurityHeight"`
+	GlobalTransitionalSpeed float64 `xml:"globalTransitionalSpeed"`
+	DroneInfo               *struct {
+		DroneEnumValue    string `xml:"droneEnumValue"`
+		DroneSubEnumValue string `xml:"droneSubEnumValue"`
+	} `xml:"droneInfo"`
+	PayloadInfo []struct {
+		PayloadEnumValue     string `xml:"payloadEnumValue"`
+		PayloadSubEnumValue  string `xml:"payloadSubEnumValue"`
+		PayloadPositionIndex string `xml:"payloadPositionIndex"`
+	} `xml:"payloadInfo"`
+}
+
+type wpmlPlacemark struct {
+	Coordinates   string   `xml:"Point>coordinates"`
+	Index         *int     `xml:"index"`
+	ExecuteHeight *float64 `xml:"executeHeight"`
+	WaypointSpeed float64  `xml:"waypointSpeed"`
+}
+
+type wpmlFolder struct {
+	TemplateID        string          `xml:"templateId"`
+	WaylineID         string          `xml:"waylineId"`
+	ExecuteHeightMode string          `xml:"executeHeightMode"`
+	Distance          float64         `xml:"distance"`
+	Duration          float64         `xml:"duration"`
+	AutoFlightSpeed   float64         `xml:"autoFlightSpeed"`
+	Placemarks        []wpmlPlacemark `xml:"Placemark"`
+}
+
+type wpmlDocument struct {
+	XMLName       xml.Name           `xml:"kml"`
+	MissionConfig *wpmlMissionConfig `xml:"Document>missionConfig"`
+	Folders       []wpmlFolder       `xml:"Document>Folder"`
+}
+
+type wpmlTemplate struct {
+	XMLName xml.Name `xml:"kml"`
+	Folders []struct {
+		TemplateType string `xml:"templateType"`
+	} `xml:"Document>Folder"`
+}
+
+// droneModelNames 常见飞行器 domain-type-sub_type 对应的型号
+var droneModelNames = map[string]string{
+	"0-60-0":  "Matrice 300 RTK",
+	"0-67-0":  "Matrice 30",
+	"0-67-1":  "Matrice 30T",
+	"0-77-0":  "Mavic 3E",
+	"0-77-1":  "Mavic 3T",
+	"0-77-2":  "Mavic 3M",
+	"0-89-0":  "Matrice 350 RTK",
+	"0-91-0":  "Matrice 3D",
+	"0-91-1":  "Matrice 3TD",
+	"0-99-0":  "Matrice 4E",
+	"0-99-1":  "Matrice 4T",
+	"0-100-0": "Matrice 4D",
+	"0-100-1": "Matrice 4TD",
+}
+
+// payloadModelNames 常见负载枚举值对应的型号
+var payloadModelNames = map[string]string{
+	"42":    "H20",
+	"43":    "H20T",
+	"52":    "M30 Camera",
+	"53":    "M30T Camera",
+	"61":    "H20N",
+	"66":    "Mavic 3E Camera",
+	"67":    "Mavic 3T Camera",
+	"68":    "Mavic 3M Camera",
+	"80":    "Matrice 3D Camera",
+	"81":    "Matrice 3TD Camera",
+	"65534": "PSDK",
+}
+
+// droneModelName 返回飞行器型号名称，未知型号返回原始枚举
+func droneModelName(key string) string {
+	if name, ok := droneModelNames[key]; ok {
+		return name + " (" + key + ")"
+	}
+	return key
+}
+
+// payloadModelName 返回负载型号名称，未知型号返回原始枚举
+func payloadModelName(key string) string {
+	if name, ok := payloadModelNames[strings.SplitN(key, "-", 2)[0]]; ok {
+		return name + " (" + key + ")"
+	}
+	return key
+}
+
+// wpmlError 航线文件结构校验失败
+type wpmlError struct {
+	problems []string
+}
+
+func (e *wpmlError) Error() string {
+	return strings.Join(e.problems, "；")
+}
+
+// parseKMZ 校验 KMZ 航线文件结构并提取航点、高度与预计时长
+func parseKMZ(data []byte) (*models.Wayline, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, &wpmlError{[]string{"文件不是有效的KMZ压缩包"}}
+	}
+
+	entries := make(map[string]*zip.File)
+	for _, file := range reader.File {
+		entries[strings.TrimPrefix(file.Name, "/")] = file
+	}
+
+	waylinesFile, ok := entries[wpmzWaylinesPath]
+	if !ok {
+		return nil, &wpmlError{[]string{"缺少 " + wpmzWaylinesPath + "，航线文件无法被机场执行"}}
+	}
+
+	waylinesData, err := readZipEntry(waylinesFile)
+	if err != nil {
+		return nil, &wpmlError{[]string{fmt.Sprintf("读取 %s 失败: %v", wpmzWaylinesPath, err)}}
+	}
+
+	var document wpmlDocument
+	if err := xml.Unmarshal(waylinesData, &document); err != nil {
+		return nil, &wpmlError{[]string{fmt.Sprintf("%s 不是有效的WPML: %v", wpmzWaylinesPath, err)}}
+	}
+
+	wayline := &models.Wayline{
+		TemplateType:     "waypoint",
+		PayloadModelKeys: []string{},
+		Waypoints:        []models.WaylineWaypoint{},
+	}
+	problems := []string{}
+
+	config := document.MissionConfig
+	if config == nil {
+		problems = append(problems, "缺少 wpml:missionConfig")
+	} else {
+		wayline.FinishAction = config.FinishAction
+		if config.DroneInfo == nil || config.DroneInfo.DroneEnumValue == "" {
+			problems = append(problems, "缺少 wpml:droneInfo 飞行器机型")
+		} else {
+			subEnum := config.DroneInfo.DroneSubEnumValue
+			if subEnum == "" {
+				subEnum = "0"
+			}
+			wayline.DroneModelKey = fmt.Sprintf("0-%s-%s", config.DroneInfo.DroneEnumValue, subEnum)
+		}
+		for _, payload := range config.PayloadInfo {
+			if payload.PayloadEnumValue == "" {
+				continue
+			}
+			key := payload.PayloadEnumValue
+			if payload.PayloadSubEnumValue != "" {
+				key += "-" + payload.PayloadSubEnumValue
+			}
+			wayline.PayloadModelKeys = append(wayline.PayloadModelKeys, key)
+		}
+	}
+
+	if len(document.Folders) == 0 {
+		problems = append(problems, "缺少航线 Folder")
+	}
+
+	var distance, duration float64
+	durationReported := len(document.Folders) > 0
+	minHeight, maxHeight := math.Inf(1), math.Inf(-1)
+
+	for folderIndex, folder := range document.Folders {
+		if wayline.HeightMode == "" {
+			wayline.HeightMode = folder.ExecuteHeightMode
+		}
+		if len(folder.Placemarks) < 2 {
+			problems = append(problems, fmt.Sprintf("航线 %d 航点数量不足2个", folderIndex))
+			continue
+		}
+
+		speed := folder.AutoFlightSpeed
+		if speed <= 0 && config != nil {
+			speed = config.GlobalTransitionalSpeed
+		}
+
+		var folderDistance, folderDuration float64
+		seen := make(map[int]bool)
+		var previous *models.WaylineWaypoint
+
+		for placemarkIndex, placemark := range folder.Placemarks {
+			waypoint, err := parsePlacemark(placemark, placemarkIndex)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("航线 %d 航点 %d: %v", folderIndex, placemarkIndex, err))
+				continue
+			}
+			if seen[waypoint.Index] {
+				problems = append(problems, fmt.Sprintf("航线 %d 航点序号 %d 重复", folderIndex, waypoint.Index))
+			}
+			seen[waypoint.Index] = true
+
+			minHeight = math.Min(minHeight, waypoint.Height)
+			maxHeight = math.Max(maxHeight, waypoint.Height)
+
+			if previous != nil {
+				horizontal := haversineMeters(previous.Latitude, previous.Longitude, waypoint.Latitude, waypoint.Longitude)
+				segment := math.Hypot(horizontal, waypoint.Height-previous.Height)
+				folderDistance += segment
+
+				segmentSpeed := speed
+				if previous.Speed > 0 {
+					segmentSpeed = previous.Speed
+				}
+				if segmentSpeed > 0 {
+					folderDuration += segment / segmentSpeed
+				}
+			}
+
+			wayline.Waypoints = append(wayline.Waypoints, waypoint)
+			previous = &wayline.Waypoints[len(wayline.Waypoints)-1]
+		}
+
+		// 优先使用航线文件中的距离与时长，缺失时按航点估算
+		if folder.Distance > 0 {
+			folderDistance = folder.Distance
+		}
+		if folder.Duration > 0 {
+			folderDuration = folder.Duration
+		} else {
+			durationReported = false
+		}
+		distance += folderDistance
+		duration += folderDuration
+	}
+
+	if templateFile, ok := entries[wpmzTemplatePath]; ok {
+		if templateData, err := readZipEntry(templateFile); err == nil {
+			var template wpmlTemplate
+			if err := xml.Unmarshal(templateData, &template); err != nil {
+				problems = append(problems, fmt.Sprintf("%s 不是有效的KML: %v", wpmzTemplatePath, err))
+			} else if len(template.Folders) > 0 && template.Folders[0].TemplateType != "" {
+				wayline.TemplateType = template.Folders[0].TemplateType
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &wpmlError{problems}
+	}
+
+	if !durationReported {
+		duration += wpmzOverheadSeconds
+	}
+
+	wayline.WaypointCount = len(wayline.Waypoints)
+	wayline.Distance = math.Round(distance*10) / 10
+	wayline.Duration = math.Round(duration)
+	wayline.MinHeight = minHeight
+	wayline.MaxHeight = maxHeight
+	return wayline, nil
+}
+
+// parsePlacemark 解析单个航点
+func parsePlacemark(placemark wpmlPlacemark, position int) (models.WaylineWaypoint, error) {
+	waypoint := models.WaylineWaypoint{Index: position, Speed: placemark.WaypointSpeed}
+
+	parts := strings.Split(strings.TrimSpace(placemark.Coordinates), ",")
+	if len(parts) < 2 {
+		return waypoint, fmt.Errorf("坐标格式错误")
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return waypoint, fmt.Errorf("经度无效")
+	}
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return waypoint, fmt.Errorf("纬度无效")
+	}
+	waypoint.Longitude = longitude
+	waypoint.Latitude = latitude
+
+	if placemark.Index != nil {
+		waypoint.Index = *placemark.Index
+	}
+	if placemark.ExecuteHeight == nil {
+		return waypoint, fmt.Errorf("缺少 wpml:executeHeight")
+	}
+	waypoint.Height = *placemark.ExecuteHeight
+	return waypoint, nil
+}
+
+// readZipEntry 读取压缩包内的单个文件
+func readZipEntry(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > wpmzMaxEntrySize {
+		return nil, fmt.Errorf("文件过大")
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, wpmzMaxEntrySize))
+}
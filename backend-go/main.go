@@ -26,11 +26,45 @@ func main() {
 
 	// 初始化服务
 	deviceService := services.NewDeviceService(db)
-	mqttService := services.NewMQTTService(db)
+	embeddedBroker := services.NewEmbeddedBroker(db, cfg.EmbeddedBrokerEnabled, cfg.EmbeddedBrokerListen)
+	mqttService := services.NewMQTTService(db, embeddedBroker)
 	redisService := services.NewRedisService()
-	errorCodeService := services.NewErrorCodeService()
-	mqttProxy := services.NewMQTTProxyService()
+	errorCodeService := services.NewErrorCodeService(cfg.ErrorCodesPath, deviceService)
+	proxyACLService := services.NewProxyACLService(db, cfg.WSProxyAuth, cfg.WSProxyAdminToken)
+	mqttProxy := services.NewMQTTProxyService(errorCodeService, embeddedBroker, mqttService, proxyACLService, services.MQTTProxyOptions{
+		QueueSize:            cfg.WSProxyQueueSize,
+		DropPolicy:           cfg.WSProxyDropPolicy,
+		DroppableTopics:      cfg.WSProxyDroppableTopics,
+		Reconnect:            cfg.WSProxyReconnect,
+		ReconnectMinInterval: cfg.WSProxyReconnectMinInterval,
+		ReconnectMaxInterval: cfg.WSProxyReconnectMaxInterval,
+		PersistentSession:    cfg.WSProxyPersistentSession,
+		StoreDir:             cfg.WSProxyStoreDir,
+		BufferSize:           cfg.WSProxyBufferSize,
+	})
 	cameraService := services.NewCameraService(db.DB)
+	ingestService := services.NewIngestService(db, mqttService, errorCodeService, embeddedBroker,
+		cfg.MQTTSharedGroup, cfg.MQTTSharedTopics)
+	deviceStatusService := services.NewDeviceStatusService(deviceService, ingestService, cfg.DeviceOfflineTimeout)
+	telemetryService := services.NewTelemetryService(db, ingestService, cfg.TelemetryRetentionDays, cfg.TelemetryRollupRetentionDays)
+	flightService := services.NewFlightService(db, ingestService, telemetryService)
+	hmsService := services.NewHmsService(db, ingestService, cfg.DocsDir)
+	commandService := services.NewCommandService(db, ingestService)
+	upgradeService := services.NewUpgradeService(db, ingestService, commandService, deviceService, errorCodeService)
+	objectStorage := services.NewObjectStorage(cfg.StorageDir, cfg.StorageListen, cfg.StorageEndpoint, cfg.StorageBucket, cfg.StorageRegion)
+	logService := services.NewLogService(db, ingestService, commandService, deviceService, objectStorage)
+	waylineService := services.NewWaylineService(db, ingestService, commandService, errorCodeService, cfg.WaylineDir, cfg.PublicBaseURL)
+	patrolService := services.NewPatrolService(db, ingestService, waylineService)
+	drcService := services.NewDrcService(db, ingestService, commandService, mqttService,
+		cfg.DRCBrokerAddress, cfg.DRCBrokerUsername, cfg.DRCBrokerPassword, cfg.DRCBrokerTLS)
+	remoteDebugService := services.NewRemoteDebugService(db, ingestService, commandService)
+	propertyService := services.NewPropertyService(db, ingestService, errorCodeService)
+	captureService := services.NewCaptureService(db, ingestService, mqttService, embeddedBroker, mqttProxy, cfg.CaptureDir)
+
+	// 注册设备 requests 内置处理
+	ingestService.RegisterRequestHandler("config", services.NewConfigRequestHandler(cfg.DJIAppID, cfg.DJIAppKey,
+		cfg.DJIAppLicense, cfg.NTPServerHost, cfg.NTPServerPort))
+	ingestService.RegisterRequestHandler("storage_config_get", services.NewStorageConfigRequestHandler(objectStorage))
 
 	// 初始化摄像头表
 	if err := cameraService.CreateCameraTable(); err != nil {
@@ -42,8 +76,49 @@ func main() {
 		log.Printf("Failed to insert default cameras: %v", err)
 	}
 
+	// 启动错误码文件变更检测
+	errorCodeService.Start()
+	defer errorCodeService.Stop()
+
+	// 启动内置MQTT broker，需在后端MQTT消费服务之前
+	if err := embeddedBroker.Start(); err != nil {
+		log.Printf("Failed to start embedded MQTT broker: %v", err)
+	}
+	defer embeddedBroker.Stop()
+
+	// 启动后端MQTT消费服务
+	hmsService.Start()
+	ingestService.Start()
+	defer ingestService.Stop()
+	deviceStatusService.Start()
+	defer deviceStatusService.Stop()
+	telemetryService.Start()
+	defer telemetryService.Stop()
+	flightService.Start()
+	defer flightService.Stop()
+	commandService.Start()
+	defer commandService.Stop()
+	upgradeService.Start()
+	defer upgradeService.Stop()
+	logService.Start()
+	defer logService.Stop()
+	waylineService.Start()
+	defer waylineService.Stop()
+	patrolService.Start()
+	defer patrolService.Stop()
+	propertyService.Start()
+	defer drcService.Stop()
+	captureService.Start()
+	defer captureService.Stop()
+
+	// 启动日志上传使用的本地对象存储
+	if err := objectStorage.Start(); err != nil {
+		log.Printf("Failed to start object storage: %v", err)
+	}
+	defer objectStorage.Stop()
+
 	// 初始化处理器
-	handlers := handlers.NewHandlers(deviceService, mqttService, redisService, errorCodeService, mqttProxy, cameraService)
+	handlers := handlers.NewHandlers(deviceService, mqttService, redisService, errorCodeService, mqttProxy, cameraService, ingestService, telemetryService, flightService, hmsService, commandService, upgradeService, logService, waylineService, patrolService, drcService, remoteDebugService, propertyService, embeddedBroker, proxyACLService, captureService)
 
 	// 设置Gin模式
 	if cfg.Environment == "production" {